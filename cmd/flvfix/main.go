@@ -0,0 +1,73 @@
+// flvfix 检查FLV文件中的问题, 并可选地写出修复后的文件
+//
+// 用法:
+//
+//	flvfix [-o repaired.flv] [-max-jump 5s] input.flv
+//
+// 时间戳回退总是视为问题并修正; 向前的跳跃(暂停, 稀疏的音频等)默认保留, 指定 -max-jump 时超过阈值的跳跃也视为问题并修正为连续
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nextpkg/goav/container/flv"
+)
+
+func main() {
+	output := flag.String("o", "", "write repaired flv to this file")
+	maxJump := flag.Duration("max-jump", 0, "treat forward timestamp gaps larger than this as problems and close them (0 keeps all gaps)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-o repaired.flv] [-max-jump 5s] input.flv\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	problems, err := run(flag.Arg(0), *output, *maxJump)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+
+	if len(problems) > 0 && *output == "" {
+		os.Exit(1)
+	}
+}
+
+// run 检查输入文件, output不为空时写出修复后的文件
+func run(input, output string, maxJump time.Duration) ([]flv.Problem, error) {
+	in, err := os.Open(input)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	if output == "" {
+		return flv.Validate(bufio.NewReader(in), maxJump)
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return nil, err
+	}
+
+	problems, err := flv.Repair(in, out, maxJump)
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+
+	return problems, out.Close()
+}
@@ -0,0 +1,72 @@
+// tsanalyze 按照 ETSI TR 101 290 检查TS文件中第一优先级和第二优先级的错误, 并输出每个PID的码率
+//
+// 用法:
+//
+//	tsanalyze [-cbr] input.ts
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nextpkg/goav/container/ts/analyze"
+)
+
+func main() {
+	cbr := flag.Bool("cbr", false, "constant bitrate stream, enable PCR accuracy checks")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-cbr] input.ts\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	r, err := run(flag.Arg(0), *cbr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	printReport(r)
+
+	if len(r.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+// run 分析输入文件
+func run(input string, pcrAccuracy bool) (*analyze.Report, error) {
+	in, err := os.Open(input)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	a := analyze.NewAnalyzer()
+	a.SetPcrAccuracy(pcrAccuracy)
+
+	return a.Analyze(in)
+}
+
+// printReport 输出错误, 按类型汇总的错误数以及PID的统计
+func printReport(r *analyze.Report) {
+	for _, e := range r.Errors {
+		fmt.Println(e)
+	}
+
+	fmt.Printf("\n%d packets, %v, %d bit/s\n\n", r.Packets, r.Duration, r.Bitrate)
+
+	for k := analyze.SyncLoss; k <= analyze.CatError; k++ {
+		fmt.Printf("%-5s %-34s %d\n", k.Indicator(), k, r.Count(k))
+	}
+
+	fmt.Printf("\n%-7s %-14s %10s %12s\n", "PID", "TYPE", "PACKETS", "BIT/S")
+	for _, s := range r.Pids {
+		fmt.Printf("0x%04x  %-14s %10d %12d\n", s.PID, s.Type, s.Packets, s.Bitrate)
+	}
+}
@@ -31,6 +31,51 @@ const (
 	KeyFrame = iota + 1
 	// InterFrame 分片帧(2)
 	InterFrame
+	// DisposableInterFrame 可丢弃的分片帧(3)
+	DisposableInterFrame
+	// GeneratedKeyFrame 服务端生成的关键帧(4)
+	GeneratedKeyFrame
+	// CommandFrame 视频信息/命令帧(5)
+	CommandFrame
+)
+
+// Enhanced RTMP 视频包类型(VideoPacketType)
+const (
+	// ExVideoSeqStart 序列头(0)
+	ExVideoSeqStart = iota
+	// ExVideoCodedFrames 带CompositionTime的视频帧(1)
+	ExVideoCodedFrames
+	// ExVideoSeqEnd 序列尾(2)
+	ExVideoSeqEnd
+	// ExVideoCodedFramesX 不带CompositionTime的视频帧, CompositionTime为0(3)
+	ExVideoCodedFramesX
+	// ExVideoMetadata AMF编码的视频元数据, 例如HDR信息(4)
+	ExVideoMetadata
+	// ExVideoMPEG2TSSeqStart MPEG2-TS格式的序列头(5)
+	ExVideoMPEG2TSSeqStart
+	// ExVideoMultitrack 多轨道(6)
+	ExVideoMultitrack
+	// ExVideoModEx 扩展修饰(7)
+	ExVideoModEx
+)
+
+// Enhanced RTMP 视频命令(VideoCommand), 命令帧中使用
+const (
+	// VideoCommandStartSeek 开始seek(0)
+	VideoCommandStartSeek = iota
+	// VideoCommandEndSeek 结束seek(1)
+	VideoCommandEndSeek
+)
+
+// ExHeader Enhanced RTMP 扩展头标志位
+const ExHeader = 0x80
+
+// Enhanced RTMP 视频FourCC
+const (
+	FourCCAvc  = 0x61766331 // avc1
+	FourCCHevc = 0x68766331 // hvc1
+	FourCCAv1  = 0x61763031 // av01
+	FourCCVp9  = 0x76703039 // vp09
 )
 
 // Meta Data
@@ -62,6 +107,45 @@ const (
 	SoundDeviceSpecificSound = 15
 )
 
+// SoundExHeader Enhanced RTMP 扩展音频头, 占用保留的SoundFormat(9)
+const SoundExHeader = SoundReserved
+
+// Enhanced RTMP 音频包类型(AudioPacketType)
+const (
+	// ExAudioSeqStart 序列头(0)
+	ExAudioSeqStart = 0
+	// ExAudioCodedFrames 音频帧(1)
+	ExAudioCodedFrames = 1
+	// ExAudioSeqEnd 序列尾(2)
+	ExAudioSeqEnd = 2
+	// ExAudioMultichannelConfig 多声道配置(4)
+	ExAudioMultichannelConfig = 4
+	// ExAudioMultitrack 多轨道(5)
+	ExAudioMultitrack = 5
+	// ExAudioModEx 扩展修饰(7)
+	ExAudioModEx = 7
+)
+
+// Enhanced RTMP 多轨道类型(AvMultitrackType)
+const (
+	// MultitrackOneTrack 单轨道
+	MultitrackOneTrack = iota
+	// MultitrackManyTracks 多轨道, 所有轨道使用同一个FourCC
+	MultitrackManyTracks
+	// MultitrackManyTracksManyCodecs 多轨道, 每个轨道有自己的FourCC
+	MultitrackManyTracksManyCodecs
+)
+
+// Enhanced RTMP 音频FourCC
+const (
+	FourCCOpus = 0x4f707573 // Opus
+	FourCCFlac = 0x664c6143 // fLaC
+	FourCCAc3  = 0x61632d33 // ac-3
+	FourCCEac3 = 0x65632d33 // ec-3
+	FourCCAac  = 0x6d703461 // mp4a
+	FourCCMp3  = 0x2e6d7033 // .mp3
+)
+
 // SoundRate
 const (
 	SoundRate5500Hz = iota
@@ -25,7 +25,33 @@ func (d *Demuxer) Demux(p *packet.Packet) error {
 	}
 
 	p.Header = &tag
-	p.Media = p.Data[n:]
+	p.Media = tag.mediaData(p.Data, n)
 
 	return nil
 }
+
+// DemuxTracks flv解复用, 并将 Enhanced RTMP 的多轨道音频包拆分为每个轨道一个包
+// 非多轨道的包只返回p自身
+func (d *Demuxer) DemuxTracks(p *packet.Packet) ([]*packet.Packet, error) {
+	err := d.Demux(p)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := p.Header.(*Tag)
+	if !tag.IsMultitrack() {
+		return []*packet.Packet{p}, nil
+	}
+
+	tags := tag.splitTracks()
+	ret := make([]*packet.Packet, 0, len(tags))
+	for _, t := range tags {
+		q := *p
+		q.Header = t
+		q.Media = t.mediaData(p.Data, 0)
+
+		ret = append(ret, &q)
+	}
+
+	return ret, nil
+}
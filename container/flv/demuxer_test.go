@@ -120,3 +120,42 @@ func TestDemuxer_Demux(t *testing.T) {
 	at.Nil(d.Demux(&p))
 	at.Len(p.Media, 255)
 }
+
+func TestDemuxer_DemuxTracks(t *testing.T) {
+	at := assert.New(t)
+	d := NewDemuxer()
+
+	// 多轨道多编码: 轨道1为Opus, 轨道2为ec-3
+	p := &packet.Packet{
+		Type: packet.PktAudio,
+		Data: []byte{
+			0x95, 0x21,
+			0x4f, 0x70, 0x75, 0x73, 0x01, 0x00, 0x00, 0x02, 0xaa, 0xbb,
+			0x65, 0x63, 0x2d, 0x33, 0x02, 0x00, 0x00, 0x01, 0xcc,
+		},
+	}
+
+	ps, err := d.DemuxTracks(p)
+	at.Nil(err)
+	at.Len(ps, 2)
+	at.Equal([]byte{0xaa, 0xbb}, p.Media)
+
+	ah := ps[0].Header.(packet.AudioPacketHeader)
+	at.Equal(uint8(1), ah.TrackID())
+	at.Equal(uint32(FourCCOpus), ah.FourCC())
+	at.Equal([]byte{0xaa, 0xbb}, ps[0].Media)
+
+	ah = ps[1].Header.(packet.AudioPacketHeader)
+	at.Equal(uint8(2), ah.TrackID())
+	at.Equal(uint32(FourCCEac3), ah.FourCC())
+	at.Equal([]byte{0xcc}, ps[1].Media)
+
+	// 非多轨道
+	p = &packet.Packet{
+		Type: packet.PktAudio,
+		Data: []byte{0xaf, 0x01, 0x21},
+	}
+	ps, err = d.DemuxTracks(p)
+	at.Nil(err)
+	at.Equal([]*packet.Packet{p}, ps)
+}
@@ -0,0 +1,146 @@
+package flv
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/nextpkg/goav/amf"
+	"github.com/nextpkg/goav/packet"
+)
+
+// onMetaData 中索引相关的属性
+const (
+	metaDuration      = "duration"
+	metaFileSize      = "filesize"
+	metaKeyframes     = "keyframes"
+	metaTimes         = "times"
+	metaFilePositions = "filepositions"
+	metaPadding       = "padding" // 填充预留空间的属性, 值为空格组成的字符串
+)
+
+// maxKeyframeReserve 预留的关键帧数量上限, 保证填充的字符串不超过AMF0短字符串的长度(65535)
+const maxKeyframeReserve = 3600
+
+// keyframeIndex 关键帧索引, 在复用过程中记录关键帧的时间和在文件中的字节偏移
+type keyframeIndex struct {
+	reserve   int       // onMetaData中预留的关键帧数量
+	times     []float64 // 关键帧时间, 秒
+	positions []float64 // 关键帧Tag在文件中的偏移, 字节
+	offset    int64     // 已写入文件的字节数
+	lastTs    uint32    // 最后一个Tag的时间戳, 毫秒
+	mdPos     int64     // onMetaData Tag在文件中的偏移
+	mdLen     int       // onMetaData Tag的长度(含PreviousTagSize)
+}
+
+// newKeyframeIndex 关键帧索引, reserve超过 maxKeyframeReserve 时取 maxKeyframeReserve
+func newKeyframeIndex(reserve int) *keyframeIndex {
+	if reserve > maxKeyframeReserve {
+		reserve = maxKeyframeReserve
+	}
+
+	return &keyframeIndex{
+		reserve: reserve,
+	}
+}
+
+// reset 复位索引, 开始记录新的文件
+func (k *keyframeIndex) reset() {
+	k.times = k.times[:0]
+	k.positions = k.positions[:0]
+	k.offset = 0
+	k.lastTs = 0
+	k.mdPos = 0
+	k.mdLen = 0
+}
+
+// write 记录一个已写入文件的Tag, n为Tag的总长度(含PreviousTagSize)
+func (k *keyframeIndex) write(p *packet.Packet, pts uint32, n int) {
+	if p.Type == packet.PktVideo {
+		vh, ok := p.Header.(packet.VideoPacketHeader)
+		if ok && vh.IsKeyFrame() && !vh.IsSeqHdr() {
+			k.times = append(k.times, float64(pts)/1000)
+			k.positions = append(k.positions, float64(k.offset))
+		}
+	}
+
+	if pts > k.lastTs {
+		k.lastTs = pts
+	}
+
+	k.offset += int64(n)
+}
+
+// inject 向元数据中填充duration, filesize和keyframes, 保证每次生成的onMetaData长度一致, 以便原地改写:
+// 关键帧数量超出reserve时均匀抽样, 没有抽中的关键帧不在索引中; 没有关键帧时keyframes为空对象;
+// 索引不足reserve个关键帧时, 剩余的空间由padding属性填充; 需要完整的索引时使用 Mixer.Rewrite 重新生成文件
+func (k *keyframeIndex) inject(md amf.Object) amf.Object {
+	n := len(k.times)
+	if n > k.reserve {
+		n = k.reserve
+	}
+
+	times := make([]float64, n)
+	positions := make([]float64, n)
+	for i := range times {
+		j := i
+		if len(k.times) > k.reserve {
+			j = i * len(k.times) / k.reserve
+		}
+
+		times[i] = k.times[j]
+		positions[i] = k.positions[j]
+	}
+
+	// 与预留reserve个关键帧时的长度之差用padding补齐
+	full := k.object(md, make([]float64, k.reserve), make([]float64, k.reserve), k.offset)
+	full[metaPadding] = ""
+
+	ret := k.object(md, times, positions, k.offset)
+	if n == 0 {
+		ret[metaKeyframes] = amf.Object{}
+	}
+	ret[metaPadding] = ""
+
+	ed := amf.NewEnDecAMF0()
+	want, err := ed.Encode(ioutil.Discard, full)
+	if err != nil {
+		return ret
+	}
+	got, err := ed.Encode(ioutil.Discard, ret)
+	if err != nil {
+		return ret
+	}
+	if want > got {
+		ret[metaPadding] = strings.Repeat(" ", want-got)
+	}
+
+	return ret
+}
+
+// full 向元数据中填充包含所有关键帧的索引, 关键帧的偏移和文件大小加上shift(onMetaData长度的变化)
+func (k *keyframeIndex) full(md amf.Object, shift int64) amf.Object {
+	times := append([]float64(nil), k.times...)
+	positions := make([]float64, len(k.positions))
+	for i, pos := range k.positions {
+		positions[i] = pos + float64(shift)
+	}
+
+	return k.object(md, times, positions, k.offset+shift)
+}
+
+// object 复制元数据, 并填充duration, filesize和keyframes
+func (k *keyframeIndex) object(md amf.Object, times, positions []float64, size int64) amf.Object {
+	ret := make(amf.Object, len(md)+3)
+	for key, v := range md {
+		ret[key] = v
+	}
+
+	ret[metaDuration] = float64(k.lastTs) / 1000
+	ret[metaFileSize] = float64(size)
+	ret[metaKeyframes] = amf.Object{
+		metaTimes:         times,
+		metaFilePositions: positions,
+	}
+
+	return ret
+}
@@ -0,0 +1,223 @@
+package flv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/nextpkg/goav/amf"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// seekBuffer 内存中的io.WriteSeeker
+type seekBuffer struct {
+	buf []byte
+	pos int
+}
+
+func (s *seekBuffer) Write(p []byte) (int, error) {
+	if need := s.pos + len(p); need > len(s.buf) {
+		s.buf = append(s.buf, make([]byte, need-len(s.buf))...)
+	}
+	copy(s.buf[s.pos:], p)
+	s.pos += len(p)
+
+	return len(p), nil
+}
+
+func (s *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		s.pos = int(offset)
+	case io.SeekCurrent:
+		s.pos += int(offset)
+	case io.SeekEnd:
+		s.pos = len(s.buf) + int(offset)
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	return int64(s.pos), nil
+}
+
+func TestMixer_Finalize(t *testing.T) {
+	at := assert.New(t)
+
+	w := &seekBuffer{}
+	m := NewMixer(w)
+
+	// 未开启索引
+	at.NotNil(m.Finalize(w))
+
+	m.EnableKeyframeIndex(2)
+	at.Nil(m.SaveMetadata(amf.Object{"Provider": "test provider"}))
+	at.Nil(m.SaveAVCHeader(&packet.Packet{
+		Type: packet.PktVideo,
+		Data: []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x4d, 0x00, 0x1e},
+	}))
+	at.Nil(m.SetFlvHeader())
+
+	// 3个GOP, 每个GOP一个关键帧和一个普通帧
+	var d Demuxer
+	for i := 0; i < 3; i++ {
+		for j, flag := range []byte{0x17, 0x27} {
+			p := &packet.Packet{
+				Type: packet.PktVideo,
+				Data: []byte{flag, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x41},
+			}
+			at.Nil(d.Demux(p))
+			at.Nil(m.Mux(p, uint32(i*2000+j*40)))
+		}
+	}
+	at.Nil(m.Finalize(w))
+
+	// 读回数据, 校验索引
+	var positions []float64
+	var md amf.Object
+
+	r := NewReader(bytes.NewReader(w.buf))
+	for {
+		offset := r.Offset()
+
+		var p packet.Packet
+		err := r.Read(&p)
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+
+		switch p.Type {
+		case packet.PktMetadata:
+			v, err := amf.NewEnDecAMF0().DecodeBatch(bytes.NewReader(p.Media))
+			at.Nil(err)
+			at.Len(v, 2)
+			md = v[1].(amf.Object)
+		case packet.PktVideo:
+			vh := p.Header.(packet.VideoPacketHeader)
+			if vh.IsKeyFrame() && !vh.IsSeqHdr() {
+				positions = append(positions, float64(offset))
+			}
+		}
+	}
+
+	at.Len(positions, 3)
+	at.Equal("test provider", md["Provider"])
+	at.Equal(4.04, md[metaDuration])
+	at.Equal(float64(len(w.buf)), md[metaFileSize])
+
+	// 关键帧超出预留数量时抽样
+	kf := md[metaKeyframes].(amf.Object)
+	at.Equal(amf.Array{0.0, 2.0}, kf[metaTimes])
+	at.Equal(amf.Array{positions[0], positions[1]}, kf[metaFilePositions])
+}
+
+func TestKeyframeIndex_Inject(t *testing.T) {
+	at := assert.New(t)
+
+	ed := amf.NewEnDecAMF0()
+	size := func(md amf.Object) int {
+		n, err := ed.Encode(ioutil.Discard, md)
+		at.Nil(err)
+		return n
+	}
+
+	// 没有关键帧时keyframes为空, 预留的空间由padding填充
+	k := newKeyframeIndex(3)
+	md := k.inject(nil)
+	at.Equal(amf.Object{}, md[metaKeyframes])
+	reserved := size(md)
+
+	// 关键帧不足预留数量时, 只有实际的关键帧, 长度不变
+	k.times = []float64{0, 2}
+	k.positions = []float64{100, 200}
+	md = k.inject(nil)
+	kf := md[metaKeyframes].(amf.Object)
+	at.Equal([]float64{0, 2}, kf[metaTimes])
+	at.Equal([]float64{100, 200}, kf[metaFilePositions])
+	at.Equal(reserved, size(md))
+
+	// 关键帧超出预留数量时抽样, 不再需要填充
+	k.times = []float64{0, 2, 4, 6, 8, 10}
+	k.positions = []float64{100, 200, 300, 400, 500, 600}
+	md = k.inject(nil)
+	kf = md[metaKeyframes].(amf.Object)
+	at.Equal([]float64{0, 4, 8}, kf[metaTimes])
+	at.Equal([]float64{100, 300, 500}, kf[metaFilePositions])
+	at.Equal("", md[metaPadding])
+	at.Equal(reserved, size(md))
+
+	// 预留数量有上限
+	at.Equal(maxKeyframeReserve, newKeyframeIndex(100000).reserve)
+}
+
+func TestMixer_Rewrite(t *testing.T) {
+	at := assert.New(t)
+
+	w := &seekBuffer{}
+	m := NewMixer(w)
+
+	// 未开启索引
+	at.NotNil(m.Rewrite(bytes.NewReader(nil), bytes.NewBuffer(nil)))
+
+	m.EnableKeyframeIndex(2)
+	at.Nil(m.SaveMetadata(amf.Object{"Provider": "test provider"}))
+	at.Nil(m.SaveAVCHeader(&packet.Packet{
+		Type: packet.PktVideo,
+		Data: []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x4d, 0x00, 0x1e},
+	}))
+	at.Nil(m.SetFlvHeader())
+
+	// 5个关键帧, 超出预留数量
+	var d Demuxer
+	for i := 0; i < 5; i++ {
+		p := &packet.Packet{
+			Type: packet.PktVideo,
+			Data: []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x65},
+		}
+		at.Nil(d.Demux(p))
+		at.Nil(m.Mux(p, uint32(i*1000)))
+	}
+
+	out := bytes.NewBuffer(nil)
+	at.Nil(m.Rewrite(bytes.NewReader(w.buf), out))
+
+	// 完整的索引指向新文件中每个关键帧的位置
+	var positions []float64
+	var md amf.Object
+
+	r := NewReader(bytes.NewReader(out.Bytes()))
+	for {
+		offset := r.Offset()
+
+		var p packet.Packet
+		err := r.Read(&p)
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+
+		switch p.Type {
+		case packet.PktMetadata:
+			v, err := amf.NewEnDecAMF0().DecodeBatch(bytes.NewReader(p.Media))
+			at.Nil(err)
+			md = v[1].(amf.Object)
+		case packet.PktVideo:
+			vh := p.Header.(packet.VideoPacketHeader)
+			if vh.IsKeyFrame() && !vh.IsSeqHdr() {
+				positions = append(positions, float64(offset))
+			}
+		}
+	}
+
+	at.Len(positions, 5)
+	at.True(out.Len() > len(w.buf))
+	at.Equal("test provider", md["Provider"])
+	at.Equal(float64(out.Len()), md[metaFileSize])
+
+	kf := md[metaKeyframes].(amf.Object)
+	at.Equal(amf.Array{0.0, 1.0, 2.0, 3.0, 4.0}, kf[metaTimes])
+	at.Equal(amf.Array{positions[0], positions[1], positions[2], positions[3], positions[4]}, kf[metaFilePositions])
+}
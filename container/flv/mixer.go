@@ -2,6 +2,8 @@ package flv
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 
 	"github.com/nextpkg/goav/amf"
@@ -9,6 +11,7 @@ import (
 )
 
 type cache struct {
+	md        amf.Object    // 用户自定义元数据
 	metadata  *bytes.Buffer // 用户自定义头部信息
 	avcSeqHdr *bytes.Buffer // 用来缓存AVC的序列头
 	aacSeqHdr *bytes.Buffer // 用来缓存AAC的序列头
@@ -20,6 +23,7 @@ type Mixer struct {
 	flv   io.Writer
 	muxer *muxer
 	cache *cache
+	index *keyframeIndex // 关键帧索引, 为nil时不记录
 }
 
 // NewMixer flv音视频混合器
@@ -41,14 +45,110 @@ func (m *Mixer) SetWriter(w io.Writer) {
 	m.flv = w
 }
 
+// EnableKeyframeIndex 开启关键帧索引, reserve为onMetaData中预留的关键帧数量(最多3600个);
+// 需要在 SetFlvHeader 之前调用, 写完数据后调用 Finalize 将索引写回文件;
+// 关键帧超过reserve时 Finalize 写回的是均匀抽样的索引, 不足时剩余的空间由padding属性填充, 完整的索引需要使用 Rewrite
+func (m *Mixer) EnableKeyframeIndex(reserve int) {
+	m.index = newKeyframeIndex(reserve)
+}
+
 // Mux 将数据转换为FLV格式
 func (m *Mixer) Mux(p *packet.Packet, pts uint32) error {
-	return m.muxer.mux(p, pts, m.flv)
+	err := m.muxer.mux(p, pts, m.flv)
+	if err != nil {
+		return err
+	}
+
+	// 记录关键帧位置(mux之后p.Data为实际写入的数据)
+	if m.index != nil {
+		m.index.write(p, pts, tagHdrLen+len(p.Data)+prevTagLen)
+	}
+
+	return nil
+}
+
+// Finalize 将duration, filesize和keyframes写回onMetaData
+// ws是 SetFlvHeader 之后的输出, 偏移以 SetFlvHeader 写入的位置为起点
+func (m *Mixer) Finalize(ws io.WriteSeeker) error {
+	if m.index == nil || m.index.mdLen == 0 {
+		return errors.New("keyframe index is not enabled or flv header is not written")
+	}
+
+	mdPkt, err := m.muxer.metadata(m.index.inject(m.cache.md))
+	if err != nil {
+		return err
+	}
+
+	// 新的onMetaData必须与预留的长度一致
+	if len(mdPkt) != m.index.mdLen {
+		return fmt.Errorf("metadata size changed: %d, want %d", len(mdPkt), m.index.mdLen)
+	}
+
+	_, err = ws.Seek(m.index.mdPos, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = ws.Write(mdPkt)
+	if err != nil {
+		return err
+	}
+
+	_, err = ws.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Rewrite 第二遍写入: 将 SetFlvHeader 之后的输出src复制到dst, 同时把onMetaData替换为包含所有关键帧的索引
+// 不受预留数量的限制, onMetaData之后所有Tag的偏移随onMetaData的长度变化调整
+func (m *Mixer) Rewrite(src io.ReadSeeker, dst io.Writer) error {
+	if m.index == nil || m.index.mdLen == 0 {
+		return errors.New("keyframe index is not enabled or flv header is not written")
+	}
+
+	// AMF0的数值固定为8字节, 关键帧的偏移不影响onMetaData的长度
+	mdPkt, err := m.muxer.metadata(m.index.full(m.cache.md, 0))
+	if err != nil {
+		return err
+	}
+
+	shift := int64(len(mdPkt) - m.index.mdLen)
+	mdPkt, err = m.muxer.metadata(m.index.full(m.cache.md, shift))
+	if err != nil {
+		return err
+	}
+
+	_, err = src.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(dst, src, m.index.mdPos)
+	if err != nil {
+		return err
+	}
+
+	_, err = dst.Write(mdPkt)
+	if err != nil {
+		return err
+	}
+
+	_, err = src.Seek(m.index.mdPos+int64(m.index.mdLen), io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	return err
 }
 
 // SaveMetadata 保存元数据
 func (m *Mixer) SaveMetadata(md amf.Object) error {
 	m.cache.metadata.Reset()
+	m.cache.md = md
 
 	mdPkt, err := m.muxer.metadata(md)
 	if err != nil {
@@ -72,6 +172,28 @@ func (m *Mixer) SaveAVCHeader(p *packet.Packet) error {
 	return m.muxer.mux(p, 0, m.cache.avcSeqHdr)
 }
 
+// SaveExVideoHeader 保存 Enhanced RTMP 视频序列头(HEVC, AV1, VP9等), config为解码器配置
+// 例如 HEVCDecoderConfigurationRecord, 与AVC序列头共用缓存
+func (m *Mixer) SaveExVideoHeader(fourCC uint32, config []byte) error {
+	p, err := m.muxer.exVideo(KeyFrame, ExVideoSeqStart, fourCC, 0, config)
+	if err != nil {
+		return err
+	}
+
+	return m.SaveAVCHeader(p)
+}
+
+// MuxExVideo 使用 Enhanced RTMP 扩展视频头封装一帧视频并转换为FLV格式, data为不含视频头的编码数据
+// cts只在AVC和HEVC中写入; 命令帧的data为1字节的VideoCommand
+func (m *Mixer) MuxExVideo(frameType, packetType uint8, fourCC uint32, cts int32, data []byte, pts uint32) error {
+	p, err := m.muxer.exVideo(frameType, packetType, fourCC, cts, data)
+	if err != nil {
+		return err
+	}
+
+	return m.Mux(p, pts)
+}
+
 // SaveAACHeader 保存AAC序列头
 func (m *Mixer) SaveAACHeader(p *packet.Packet) error {
 	m.cache.types.IsAudio()
@@ -99,6 +221,22 @@ func (m *Mixer) SetFlvHeader() error {
 	avcCache := m.cache.avcSeqHdr
 	aacCache := m.cache.aacSeqHdr
 
+	// 开启索引时, 使用带有占位索引的元数据
+	if m.index != nil {
+		m.index.reset()
+		m.index.offset = int64(len(header))
+
+		mdPkt, err := m.muxer.metadata(m.index.inject(m.cache.md))
+		if err != nil {
+			return err
+		}
+
+		metadata = bytes.NewBuffer(mdPkt)
+		m.index.mdPos = m.index.offset
+		m.index.mdLen = len(mdPkt)
+		m.index.offset += int64(len(mdPkt) + avcCache.Len() + aacCache.Len())
+	}
+
 	// 元数据
 	if metadata.Len() > 0 {
 		_, err = m.flv.Write(metadata.Bytes())
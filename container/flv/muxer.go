@@ -103,6 +103,15 @@ func (m *muxer) mux(p *packet.Packet, pts uint32, w io.Writer) error {
 	case packet.PktAudio:
 		typeID = packet.TagAudio
 	case packet.PktMetadata:
+		// AMF3编码以及无法解析的脚本数据原样写出
+		if tag, ok := p.Header.(*Tag); ok && (tag.isAMF3Script() || tag.script == nil) {
+			typeID = packet.TagScriptDataAMF0
+			if tag.isAMF3Script() {
+				typeID = packet.TagScriptDataAMF3
+			}
+			break
+		}
+
 		// 默认metadata都是AMF0协议
 		typeID = packet.TagScriptDataAMF0
 
@@ -152,3 +161,43 @@ func (m *muxer) mux(p *packet.Packet, pts uint32, w io.Writer) error {
 
 	return nil
 }
+
+// ExVideoTagHeader 生成 Enhanced RTMP 的扩展视频头(ExVideoTagHeader), 作为视频Tag数据的前缀
+// cts只在AVC和HEVC的CodedFrames中写入, 其余包类型忽略; 命令帧没有FourCC, 调用方在其后追加1字节的VideoCommand
+func ExVideoTagHeader(frameType, packetType uint8, fourCC uint32, cts int32) []byte {
+	b := make([]byte, 5, 8)
+
+	/* 1字节, [0]IsExHeader, [1:3]FrameType, [4:7]VideoPacketType */
+	b[0] = ExHeader | (frameType&0x7)<<4 | packetType&0xf
+
+	// 命令帧只有1字节的VideoCommand
+	if frameType == CommandFrame && packetType != ExVideoMetadata {
+		return b[:1]
+	}
+
+	/* 4字节, FourCC */
+	binary.BigEndian.PutUint32(b[1:5], fourCC)
+
+	/* 3字节, SI24 CompositionTime */
+	if packetType == ExVideoCodedFrames && (fourCC == FourCCAvc || fourCC == FourCCHevc) {
+		b = append(b, byte(cts>>16), byte(cts>>8), byte(cts))
+	}
+
+	return b
+}
+
+// exVideo 使用 Enhanced RTMP 扩展视频头封装视频数据(不含视频头), 并解析出视频头
+func (m *muxer) exVideo(frameType, packetType uint8, fourCC uint32, cts int32, data []byte) (*packet.Packet, error) {
+	hdr := ExVideoTagHeader(frameType, packetType, fourCC, cts)
+	p := &packet.Packet{
+		Type: packet.PktVideo,
+		Data: append(hdr, data...),
+	}
+
+	err := NewDemuxer().Demux(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
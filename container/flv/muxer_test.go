@@ -2,6 +2,7 @@ package flv
 
 import (
 	"bytes"
+	"io"
 	"testing"
 
 	"github.com/nextpkg/goav/amf"
@@ -38,3 +39,60 @@ func TestMuxer_Mux(t *testing.T) {
 		0x0, 0x0, 0x9, 0x0, 0x0, 0x0, 0x36,
 	}, buf.Bytes())
 }
+
+func TestExVideoTagHeader(t *testing.T) {
+	at := assert.New(t)
+
+	b := ExVideoTagHeader(KeyFrame, ExVideoCodedFrames, FourCCHevc, -40)
+	at.Equal([]byte{0x91, 0x68, 0x76, 0x63, 0x31, 0xff, 0xff, 0xd8}, b)
+
+	var tag Tag
+	n, err := tag.ParseMediaTagHeader(b, packet.PktVideo)
+	at.Nil(err)
+	at.Equal(len(b), n)
+	at.True(tag.IsKeyFrame())
+	at.Equal(int32(-40), tag.CompositionTime())
+
+	b = ExVideoTagHeader(KeyFrame, ExVideoSeqStart, FourCCAv1, 0)
+	at.Equal([]byte{0x90, 0x61, 0x76, 0x30, 0x31}, b)
+
+	// 命令帧没有FourCC
+	b = ExVideoTagHeader(CommandFrame, ExVideoCodedFrames, FourCCHevc, 0)
+	at.Equal([]byte{0xd1}, b)
+}
+
+func TestMixer_MuxExVideo(t *testing.T) {
+	at := assert.New(t)
+
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+
+	at.Nil(m.SaveExVideoHeader(FourCCHevc, []byte{0x01, 0x02}))
+	at.Nil(m.SetFlvHeader())
+	at.Nil(m.MuxExVideo(KeyFrame, ExVideoCodedFrames, FourCCHevc, 40, []byte{0x03}, 40))
+	at.Nil(m.MuxExVideo(CommandFrame, ExVideoCodedFrames, 0, 0, []byte{VideoCommandStartSeek}, 80))
+
+	// 读回每个视频Tag
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	var tags []*Tag
+	for {
+		var p packet.Packet
+		err := r.Read(&p)
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+		if p.Type == packet.PktVideo {
+			tags = append(tags, p.Header.(*Tag))
+		}
+	}
+
+	at.Len(tags, 3)
+	at.True(tags[0].IsCodecHevc())
+	at.True(tags[0].IsSeqHdr())
+	at.True(tags[1].IsCodecHevc())
+	at.True(tags[1].IsKeyFrame())
+	at.Equal(int32(40), tags[1].CompositionTime())
+	at.True(tags[2].IsCommandFrame())
+	at.Equal(uint8(VideoCommandStartSeek), tags[2].VideoCommand())
+}
@@ -0,0 +1,226 @@
+// Package flv 从io.Reader中读取flv文件或者http-flv字节流
+package flv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nextpkg/goav/packet"
+)
+
+const (
+	flvHdrLen     = 9 // FLV头长度(不含PreviousTagSize0)
+	prevTagLen    = 4 // PreviousTagSize长度
+	flvVersion    = 1 // FLV版本号
+	flvFlagAudio  = 0x04
+	flvFlagVideo  = 0x01
+	tagFilterMask = 0x20 // 加密标志位
+	tagTypeMask   = 0x1f
+)
+
+// Reader FLV读取器, 从io.Reader中逐个读出FLV Tag, 并填充到 packet.Packet 中
+type Reader struct {
+	r      io.Reader
+	tagHdr []byte
+	prev   []byte
+
+	gotHdr   bool  // 是否已解析FLV头
+	hasVideo bool  // FLV头中的视频标志位
+	hasAudio bool  // FLV头中的音频标志位
+	offset   int64 // 已读取的字节数
+}
+
+// NewReader FLV读取器
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r:      r,
+		tagHdr: make([]byte, tagHdrLen),
+		prev:   make([]byte, prevTagLen),
+	}
+}
+
+// HasVideo FLV头中是否声明了视频
+func (r *Reader) HasVideo() bool {
+	return r.hasVideo
+}
+
+// HasAudio FLV头中是否声明了音频
+func (r *Reader) HasAudio() bool {
+	return r.hasAudio
+}
+
+// Offset 已读取的字节数, 即下一个Tag在流中的起始位置
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// readFull 读取len(b)个字节, 并累加偏移量; 数据不完整时返回 io.ErrUnexpectedEOF
+func (r *Reader) readFull(b []byte) error {
+	n, err := io.ReadFull(r.r, b)
+	r.offset += int64(n)
+
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// ReadHeader 读取并校验9字节的FLV头以及PreviousTagSize0, 重复调用不会重复读取
+func (r *Reader) ReadHeader() error {
+	if r.gotHdr {
+		return nil
+	}
+
+	hdr := make([]byte, flvHdrLen)
+	err := r.readFull(hdr)
+	if err != nil {
+		return err
+	}
+
+	// [0:3] 签名 "FLV"
+	if hdr[0] != 'F' || hdr[1] != 'L' || hdr[2] != 'V' {
+		return errors.New("invalid flv signature")
+	}
+
+	// [3] 版本号
+	if hdr[3] != flvVersion {
+		return fmt.Errorf("unexpected flv version number: %d", hdr[3])
+	}
+
+	// [4] 音视频标志位
+	r.hasAudio = hdr[4]&flvFlagAudio != 0
+	r.hasVideo = hdr[4]&flvFlagVideo != 0
+
+	// [5:9] 头长度, 跳过扩展字段
+	dataOffset := binary.BigEndian.Uint32(hdr[5:9])
+	if dataOffset < flvHdrLen {
+		return fmt.Errorf("invalid flv data offset: %d", dataOffset)
+	}
+	if dataOffset > flvHdrLen {
+		err = r.readFull(make([]byte, dataOffset-flvHdrLen))
+		if err != nil {
+			return err
+		}
+	}
+
+	// PreviousTagSize0, 总是0
+	err = r.readFull(r.prev)
+	if err != nil {
+		return err
+	}
+
+	r.gotHdr = true
+
+	return nil
+}
+
+// Read 读取下一个Tag, 填充 Type/TimeStamp/StreamID/Header/Data/Media; 数据流正常结束时返回 io.EOF
+func (r *Reader) Read(p *packet.Packet) error {
+	err := r.ReadHeader()
+	if err != nil {
+		return err
+	}
+
+	for {
+		var tag Tag
+
+		data, prevTagSize, err := r.readTag(&tag)
+		if err != nil {
+			return err
+		}
+
+		// 校验 PreviousTagSize
+		if prevTagSize != tagHdrLen+tag.flv.dataSize {
+			return fmt.Errorf("unexpected previous tag size: %d, want %d", prevTagSize, tagHdrLen+tag.flv.dataSize)
+		}
+
+		// 未知类型直接跳过
+		err = tag.fill(p, data)
+		if err == errUnknownTag {
+			continue
+		}
+
+		return err
+	}
+}
+
+// readTag 读取一个完整的Tag(Tag头, Tag数据和PreviousTagSize), 填充Tag头, 返回Tag数据和PreviousTagSize
+// 在Tag边界处结束时返回 io.EOF, Tag不完整时返回 io.ErrUnexpectedEOF
+func (r *Reader) readTag(tag *Tag) ([]byte, uint32, error) {
+	// 读取Tag头, 在Tag边界处结束是正常结束
+	n, err := io.ReadFull(r.r, r.tagHdr)
+	r.offset += int64(n)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	b := r.tagHdr
+
+	/* 1字节, [0:1]Reserved, [2]Filter, [3:7]TagType */
+	tag.flv.fType = b[0] & (tagTypeMask | tagFilterMask)
+
+	/* 3字节, DataSize */
+	tag.flv.dataSize = uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+
+	/* 3字节, 时间戳低24位; 1字节, 时间戳高8位 */
+	tag.flv.timeStamp = uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6]) | uint32(b[7])<<24
+
+	/* 3字节, stream id, always 0 */
+	tag.flv.streamID = uint32(b[8])<<16 | uint32(b[9])<<8 | uint32(b[10])
+
+	// 读取Tag数据
+	data := make([]byte, tag.flv.dataSize)
+	err = r.readFull(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 读取 PreviousTagSize
+	err = r.readFull(r.prev)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return data, binary.BigEndian.Uint32(r.prev), nil
+}
+
+// errUnknownTag 未知的Tag类型
+var errUnknownTag = errors.New("unknown flv tag type")
+
+// fill 解析Tag数据的媒体头, 并填充到p中; 未知Tag类型返回 errUnknownTag
+func (tag *Tag) fill(p *packet.Packet, data []byte) error {
+	if tag.flv.fType&tagFilterMask != 0 {
+		return errors.New("encrypted flv tag is not supported")
+	}
+
+	// Tag类型转换为包类型
+	var mediaType int
+	switch tag.flv.fType {
+	case packet.TagVideo:
+		mediaType = packet.PktVideo
+	case packet.TagAudio:
+		mediaType = packet.PktAudio
+	case packet.TagScriptDataAMF0, packet.TagScriptDataAMF3:
+		mediaType = packet.PktMetadata
+	default:
+		return errUnknownTag
+	}
+
+	// 解析出 Tag Data 的媒体头
+	n, err := tag.ParseMediaTagHeader(data, mediaType)
+	if err != nil {
+		return err
+	}
+
+	p.Type = mediaType
+	p.TimeStamp = tag.flv.timeStamp
+	p.StreamID = tag.flv.streamID
+	p.Header = tag
+	p.Data = data
+	p.Media = tag.mediaData(data, n)
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package flv
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/nextpkg/goav/amf"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReader_Read(t *testing.T) {
+	at := assert.New(t)
+
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+
+	// 生成FLV: 头, 元数据, 视频序列头, 音频序列头, 视频帧
+	at.Nil(m.SaveMetadata(amf.Object{"Provider": "test provider"}))
+	at.Nil(m.SaveAVCHeader(&packet.Packet{
+		Type: packet.PktVideo,
+		Data: []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x4d, 0x00, 0x1e},
+	}))
+	at.Nil(m.SaveAACHeader(&packet.Packet{
+		Type: packet.PktAudio,
+		Data: []byte{0xaf, 0x00, 0x12, 0x10},
+	}))
+	at.Nil(m.SetFlvHeader())
+	at.Nil(m.Mux(&packet.Packet{
+		Type: packet.PktVideo,
+		Data: []byte{0x27, 0x01, 0x00, 0x00, 0x28, 0x00, 0x00, 0x00, 0x01, 0x41},
+	}, 0x01020304))
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+
+	// case1: 元数据
+	var p packet.Packet
+	at.Nil(r.Read(&p))
+	at.True(r.HasVideo())
+	at.True(r.HasAudio())
+	at.Equal(packet.PktMetadata, p.Type)
+	v, err := amf.NewEnDecAMF0().DecodeBatch(bytes.NewReader(p.Media))
+	at.Nil(err)
+	at.Equal([]interface{}{amf.OnMetaData, amf.Object{"Provider": "test provider"}}, v)
+
+	// case2: 视频序列头
+	p = packet.Packet{}
+	at.Nil(r.Read(&p))
+	at.Equal(packet.PktVideo, p.Type)
+	vh := p.Header.(packet.VideoPacketHeader)
+	at.True(vh.IsSeqHdr())
+	at.Equal([]byte{0x01, 0x4d, 0x00, 0x1e}, p.Media)
+
+	// case3: 音频序列头
+	p = packet.Packet{}
+	at.Nil(r.Read(&p))
+	at.Equal(packet.PktAudio, p.Type)
+	ah := p.Header.(packet.AudioPacketHeader)
+	at.True(ah.IsAACSeqHdr())
+	at.Equal([]byte{0x12, 0x10}, p.Media)
+
+	// case4: 视频帧, 时间戳包含扩展字节
+	p = packet.Packet{}
+	at.Nil(r.Read(&p))
+	at.Equal(packet.PktVideo, p.Type)
+	at.Equal(uint32(0x01020304), p.TimeStamp)
+	vh = p.Header.(packet.VideoPacketHeader)
+	at.False(vh.IsKeyFrame())
+	at.Equal(int32(0x28), vh.CompositionTime())
+	at.Equal([]byte{0x00, 0x00, 0x00, 0x01, 0x41}, p.Media)
+	at.Equal(int64(buf.Len()), r.Offset())
+
+	// case5: 正常结束
+	at.Equal(io.EOF, r.Read(&p))
+
+	// case6: 截断的Tag
+	r = NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-6]))
+	for i := 0; i < 3; i++ {
+		at.Nil(r.Read(&p))
+	}
+	at.Equal(io.ErrUnexpectedEOF, r.Read(&p))
+
+	// case7: 非法签名
+	r = NewReader(bytes.NewReader([]byte{'F', 'L', 'X', 0x01, 0x05, 0x00, 0x00, 0x00, 0x09, 0, 0, 0, 0}))
+	at.NotNil(r.Read(&p))
+}
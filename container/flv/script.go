@@ -0,0 +1,412 @@
+package flv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/nextpkg/goav/amf"
+	"github.com/nextpkg/goav/packet"
+)
+
+// 脚本事件名
+const (
+	OnMetaData = amf.OnMetaData
+	OnCuePoint = "onCuePoint"
+	OnTextData = "onTextData"
+	OnCaption  = "onCaption"
+)
+
+// amf3FormatSelector AMF3数据消息(类型15)的第一个字节, 之后是可以切换到AMF3的AMF0数据
+const amf3FormatSelector = 0x00
+
+// onMetaData 中的字段
+const (
+	metaWidth           = "width"
+	metaHeight          = "height"
+	metaFrameRate       = "framerate"
+	metaVideoCodecID    = "videocodecid"
+	metaVideoDataRate   = "videodatarate"
+	metaAudioCodecID    = "audiocodecid"
+	metaAudioDataRate   = "audiodatarate"
+	metaAudioSampleRate = "audiosamplerate"
+	metaAudioSampleSize = "audiosamplesize"
+	metaStereo          = "stereo"
+	metaEncoder         = "encoder"
+)
+
+// ScriptData 脚本数据Tag, 由事件名和参数组成
+type ScriptData struct {
+	Name   string        // 事件名, 如 onMetaData, onCuePoint
+	Values []interface{} // 事件名之后的参数
+	AMF3   bool          // 是否为AMF3编码
+}
+
+// NewScriptData 脚本数据Tag
+func NewScriptData(name string, values ...interface{}) *ScriptData {
+	return &ScriptData{
+		Name:   name,
+		Values: values,
+	}
+}
+
+// ParseScriptData 解析AMF0脚本数据Tag(类型18)的内容, RTMP的@setDataFrame会被剔除
+func ParseScriptData(b []byte) (*ScriptData, error) {
+	return parseScriptData(b, false)
+}
+
+// ParseAMF3ScriptData 解析AMF3脚本数据Tag(类型15, 与RTMP的AMF3数据消息相同)的内容
+// 以格式选择字节开始, 之后是事件名以及可以切换到AMF3编码的参数
+func ParseAMF3ScriptData(b []byte) (*ScriptData, error) {
+	if len(b) == 0 || b[0] != amf3FormatSelector {
+		return nil, errors.New("missing amf3 format selector")
+	}
+
+	return parseScriptData(b[1:], true)
+}
+
+// parseScriptData 按AMF0解析脚本数据, 参数中的AMF3标记切换到AMF3解析
+func parseScriptData(b []byte, amf3 bool) (*ScriptData, error) {
+	vs, err := amf.NewEnDecAMF0().DecodeBatch(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(vs) > 0 && vs[0] == amf.SetDataFrame {
+		vs = vs[1:]
+	}
+	if len(vs) == 0 {
+		return nil, errors.New("empty script data")
+	}
+
+	name, ok := vs[0].(string)
+	if !ok {
+		return nil, errors.New("script data name is not a string")
+	}
+
+	return &ScriptData{
+		Name:   name,
+		Values: vs[1:],
+		AMF3:   amf3,
+	}, nil
+}
+
+// Encode 编码脚本数据, 作为脚本数据Tag的内容
+// AMF3编码时以格式选择字节开始, 事件名使用AMF0编码, 参数切换到AMF3编码
+func (s *ScriptData) Encode() ([]byte, error) {
+	w := bytes.NewBuffer(make([]byte, 0, 256))
+	en := amf.NewEnDecAMF0()
+
+	if s.AMF3 {
+		w.WriteByte(amf3FormatSelector)
+	}
+
+	_, err := en.Encode(w, s.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range s.Values {
+		if s.AMF3 {
+			_, err = en.EncodeWithAMF3(w, v)
+		} else {
+			_, err = en.Encode(w, v)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return w.Bytes(), nil
+}
+
+// Packet 编码脚本数据并生成脚本数据包, p.Header 中记录了Tag类型(AMF0或者AMF3)
+func (s *ScriptData) Packet(ts uint32) (*packet.Packet, error) {
+	data, err := s.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	tag := &Tag{script: s}
+	tag.flv.fType = packet.TagScriptDataAMF0
+	if s.AMF3 {
+		tag.flv.fType = packet.TagScriptDataAMF3
+	}
+
+	return &packet.Packet{
+		Type:      packet.PktMetadata,
+		TimeStamp: ts,
+		Header:    tag,
+		Data:      data,
+		Media:     data,
+	}, nil
+}
+
+// Object 返回第一个对象类型的参数, 没有时返回nil
+func (s *ScriptData) Object() amf.Object {
+	for _, v := range s.Values {
+		if obj, ok := v.(amf.Object); ok {
+			return obj
+		}
+	}
+
+	return nil
+}
+
+// Event 将脚本数据转换为具体的事件
+// 返回 *Metadata, *CuePoint, *TextData, *Caption, 未知的事件或者没有对象参数时返回 *ScriptData 自身
+func (s *ScriptData) Event() interface{} {
+	obj := s.Object()
+	if obj == nil {
+		return s
+	}
+
+	switch s.Name {
+	case OnMetaData:
+		return NewMetadata(obj)
+	case OnCuePoint:
+		return NewCuePoint(obj)
+	case OnTextData:
+		return NewTextData(obj)
+	case OnCaption:
+		return NewCaption(obj)
+	}
+
+	return s
+}
+
+// Metadata onMetaData 事件
+type Metadata struct {
+	Width           int
+	Height          int
+	FrameRate       float64
+	VideoCodecID    uint32  // FLV的CodecID, Enhanced RTMP 为FourCC
+	VideoDataRate   float64 // kbps
+	AudioCodecID    uint32  // FLV的SoundFormat, Enhanced RTMP 为FourCC
+	AudioDataRate   float64 // kbps
+	AudioSampleRate int
+	AudioSampleSize int
+	Stereo          bool
+	Duration        float64 // 秒
+	FileSize        int64
+	Encoder         string
+	Extra           amf.Object // 其他字段, 如 keyframes
+}
+
+// NewMetadata 从元数据对象中解析 onMetaData 事件
+func NewMetadata(obj amf.Object) *Metadata {
+	m := &Metadata{
+		Extra: make(amf.Object),
+	}
+
+	for k, v := range obj {
+		switch k {
+		case metaWidth:
+			m.Width = int(number(v))
+		case metaHeight:
+			m.Height = int(number(v))
+		case metaFrameRate:
+			m.FrameRate = number(v)
+		case metaVideoCodecID:
+			m.VideoCodecID = codecID(v)
+		case metaVideoDataRate:
+			m.VideoDataRate = number(v)
+		case metaAudioCodecID:
+			m.AudioCodecID = codecID(v)
+		case metaAudioDataRate:
+			m.AudioDataRate = number(v)
+		case metaAudioSampleRate:
+			m.AudioSampleRate = int(number(v))
+		case metaAudioSampleSize:
+			m.AudioSampleSize = int(number(v))
+		case metaStereo:
+			m.Stereo, _ = v.(bool)
+		case metaDuration:
+			m.Duration = number(v)
+		case metaFileSize:
+			m.FileSize = int64(number(v))
+		case metaEncoder:
+			m.Encoder, _ = v.(string)
+		default:
+			m.Extra[k] = v
+		}
+	}
+
+	return m
+}
+
+// Object 转换为元数据对象, 值为0的字段不写入
+func (m *Metadata) Object() amf.Object {
+	obj := make(amf.Object, len(m.Extra)+13)
+	for k, v := range m.Extra {
+		obj[k] = v
+	}
+
+	setNumber := func(key string, v float64) {
+		if v != 0 {
+			obj[key] = v
+		}
+	}
+
+	setNumber(metaWidth, float64(m.Width))
+	setNumber(metaHeight, float64(m.Height))
+	setNumber(metaFrameRate, m.FrameRate)
+	setNumber(metaVideoCodecID, float64(m.VideoCodecID))
+	setNumber(metaVideoDataRate, m.VideoDataRate)
+	setNumber(metaAudioCodecID, float64(m.AudioCodecID))
+	setNumber(metaAudioDataRate, m.AudioDataRate)
+	setNumber(metaAudioSampleRate, float64(m.AudioSampleRate))
+	setNumber(metaAudioSampleSize, float64(m.AudioSampleSize))
+	setNumber(metaDuration, m.Duration)
+	setNumber(metaFileSize, float64(m.FileSize))
+
+	// 有音频信息时才写入声道
+	if m.Stereo || m.AudioSampleRate != 0 || m.AudioCodecID != 0 {
+		obj[metaStereo] = m.Stereo
+	}
+	if m.Encoder != "" {
+		obj[metaEncoder] = m.Encoder
+	}
+
+	return obj
+}
+
+// CuePoint onCuePoint 事件
+type CuePoint struct {
+	Name       string
+	Time       float64 // 秒
+	Type       string  // event 或者 navigation
+	Parameters amf.Object
+}
+
+// NewCuePoint 从对象中解析 onCuePoint 事件
+func NewCuePoint(obj amf.Object) *CuePoint {
+	c := &CuePoint{}
+	c.Name, _ = obj["name"].(string)
+	c.Time = number(obj["time"])
+	c.Type, _ = obj["type"].(string)
+	c.Parameters, _ = obj["parameters"].(amf.Object)
+
+	return c
+}
+
+// Object 转换为对象
+func (c *CuePoint) Object() amf.Object {
+	obj := amf.Object{
+		"name": c.Name,
+		"time": c.Time,
+		"type": c.Type,
+	}
+	if c.Parameters != nil {
+		obj["parameters"] = c.Parameters
+	}
+
+	return obj
+}
+
+// TextData onTextData 事件(字幕文本)
+type TextData struct {
+	Text         string
+	LanguageCode string
+	TrackID      int
+}
+
+// NewTextData 从对象中解析 onTextData 事件
+func NewTextData(obj amf.Object) *TextData {
+	t := &TextData{}
+	t.Text, _ = obj["text"].(string)
+	t.LanguageCode, _ = obj["languageCode"].(string)
+	t.TrackID = int(number(obj["trackid"]))
+
+	return t
+}
+
+// Object 转换为对象
+func (t *TextData) Object() amf.Object {
+	return amf.Object{
+		"text":         t.Text,
+		"languageCode": t.LanguageCode,
+		"trackid":      float64(t.TrackID),
+	}
+}
+
+// Caption onCaption 事件(CEA-608/708隐藏字幕)
+type Caption struct {
+	Type string // 字幕类型, 如 708
+	Data string // base64编码的字幕数据
+}
+
+// NewCaption 从对象中解析 onCaption 事件
+func NewCaption(obj amf.Object) *Caption {
+	c := &Caption{}
+	c.Type, _ = obj["type"].(string)
+	c.Data, _ = obj["data"].(string)
+
+	return c
+}
+
+// Object 转换为对象
+func (c *Caption) Object() amf.Object {
+	return amf.Object{
+		"type": c.Type,
+		"data": c.Data,
+	}
+}
+
+// number AMF数值转换为float64, AMF3的整数解码为uint32
+func number(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case uint32:
+		return float64(n)
+	case int:
+		return float64(n)
+	}
+
+	return 0
+}
+
+// codecID 解析编码ID, 部分编码器使用字符串形式的FourCC
+func codecID(v interface{}) uint32 {
+	if s, ok := v.(string); ok && len(s) == 4 {
+		return binary.BigEndian.Uint32([]byte(s))
+	}
+
+	return uint32(number(v))
+}
+
+// isOnMetaData 判断脚本数据包是否是 onMetaData, 未解析的脚本数据包按 onMetaData 处理
+func isOnMetaData(p *packet.Packet) bool {
+	sh, ok := p.Header.(packet.ScriptPacketHeader)
+	return !ok || sh.ScriptName() == OnMetaData
+}
+
+// decodeMetadata 从元数据包中解析出元数据对象, 优先使用解复用时解析的脚本数据
+func decodeMetadata(p *packet.Packet) (amf.Object, error) {
+	s, err := ParsePacketScript(p)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := s.Object()
+	if obj == nil {
+		return nil, errors.New("no metadata object")
+	}
+
+	return obj, nil
+}
+
+// ParsePacketScript 返回脚本数据包中的脚本数据, 优先使用解复用时按Tag类型解析的结果, 没有FLV头时按AMF0解析
+func ParsePacketScript(p *packet.Packet) (*ScriptData, error) {
+	tag, ok := p.Header.(*Tag)
+	if !ok {
+		return ParseScriptData(p.Data)
+	}
+
+	if tag.script == nil {
+		return nil, errors.New("unparsed script data")
+	}
+
+	return tag.script, nil
+}
@@ -0,0 +1,122 @@
+package flv
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nextpkg/goav/amf"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScriptData(t *testing.T) {
+	at := assert.New(t)
+
+	// RTMP的[@setDataFrame, onMetaData, object]
+	w := bytes.NewBuffer(nil)
+	at.Nil(amf.NewEnDecAMF0().EncodeBatch(w, amf.SetDataFrame, amf.OnMetaData, amf.Object{
+		"width":        1280.0,
+		"height":       720.0,
+		"framerate":    25.0,
+		"videocodecid": "hvc1",
+		"audiocodecid": 10.0,
+		"stereo":       true,
+		"encoder":      "test encoder",
+		"custom":       "value",
+	}))
+
+	s, err := ParseScriptData(w.Bytes())
+	at.Nil(err)
+	at.Equal(OnMetaData, s.Name)
+	at.False(s.AMF3)
+
+	md, ok := s.Event().(*Metadata)
+	at.True(ok)
+	at.Equal(1280, md.Width)
+	at.Equal(720, md.Height)
+	at.Equal(25.0, md.FrameRate)
+	at.Equal(uint32(FourCCHevc), md.VideoCodecID)
+	at.Equal(uint32(SoundAAC), md.AudioCodecID)
+	at.True(md.Stereo)
+	at.Equal("test encoder", md.Encoder)
+	at.Equal(amf.Object{"custom": "value"}, md.Extra)
+
+	// 转换回对象, 编码ID统一为数值
+	obj := md.Object()
+	at.Equal(float64(FourCCHevc), obj["videocodecid"])
+	at.Equal("value", obj["custom"])
+	at.Equal(md, NewMetadata(obj))
+
+	// 空数据, 事件名不是字符串
+	_, err = ParseScriptData(nil)
+	at.NotNil(err)
+	_, err = ParseScriptData([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	at.NotNil(err)
+}
+
+func TestScriptData_AMF3(t *testing.T) {
+	at := assert.New(t)
+
+	cue := &CuePoint{Name: "ad", Time: 1.5, Type: "event", Parameters: amf.Object{"id": "break-1"}}
+	s := NewScriptData(OnCuePoint, cue.Object())
+	s.AMF3 = true
+
+	p, err := s.Packet(1500)
+	at.Nil(err)
+	at.Equal(byte(amf3FormatSelector), p.Data[0])
+
+	// AMF3的脚本数据写出为AMF3类型的Tag
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+	at.Nil(m.SetFlvHeader())
+	at.Nil(m.Mux(p, 1500))
+	at.Equal(byte(packet.TagScriptDataAMF3), buf.Bytes()[flvHdrLen+prevTagLen])
+
+	// 按Tag类型解析后得到typed事件
+	q := &packet.Packet{}
+	at.Nil(NewReader(bytes.NewReader(buf.Bytes())).Read(q))
+
+	sh := q.Header.(packet.ScriptPacketHeader)
+	at.Equal(OnCuePoint, sh.ScriptName())
+	at.Equal(cue, sh.ScriptEvent())
+	at.True(q.Header.(*Tag).ScriptData().AMF3)
+
+	// AMF0的undefined(0x06)不会被当作AMF3
+	w := bytes.NewBuffer(nil)
+	at.Nil(amf.NewEnDecAMF0().EncodeBatch(w, OnCuePoint, cue.Object()))
+	b := append([]byte{0x06}, w.Bytes()...)
+	q = &packet.Packet{Type: packet.PktMetadata, Data: b}
+	at.Nil(NewDemuxer().Demux(q))
+	at.Equal("", q.Header.(packet.ScriptPacketHeader).ScriptName())
+
+	buf.Reset()
+	at.Nil(newMuxer().mux(q, 0, buf))
+	at.Equal(byte(packet.TagScriptDataAMF0), buf.Bytes()[0])
+	at.Equal(b, buf.Bytes()[tagHdrLen:tagHdrLen+len(b)])
+
+	// 其他事件
+	for _, ev := range []interface{}{
+		&TextData{Text: "hello", LanguageCode: "eng", TrackID: 1},
+		&Caption{Type: "708", Data: "AAEC"},
+	} {
+		var obj amf.Object
+		var name string
+		switch e := ev.(type) {
+		case *TextData:
+			name, obj = OnTextData, e.Object()
+		case *Caption:
+			name, obj = OnCaption, e.Object()
+		}
+
+		b, err := NewScriptData(name, obj).Encode()
+		at.Nil(err)
+
+		s, err = ParseScriptData(b)
+		at.Nil(err)
+		at.Equal(ev, s.Event())
+	}
+
+	// 未知事件返回ScriptData自身
+	s = NewScriptData("onFI", amf.Object{"sd": "16-10-2026"})
+	at.Equal(s, s.Event())
+}
@@ -0,0 +1,300 @@
+package flv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/nextpkg/goav/packet"
+)
+
+// Splitter FLV切分器, 按时长在视频关键帧处切分FLV流
+// 每个新文件通过 Mixer.SetWriter 切换输出, 并重新写入FLV头, 元数据和缓存的序列头, 时间戳从0开始
+type Splitter struct {
+	mixer    *Mixer
+	duration int64                              // 每个文件的时长, 毫秒
+	next     func(index int) (io.Writer, error) // 获取第index个文件的输出
+	w        io.Writer                          // 当前文件的输出, 为nil时还未开始
+	index    int                                // 当前文件序号
+	norm     *Normalizer                        // 时间戳规整, 长时间录制时32位时间戳会回绕
+	start    int64                              // 当前文件第一个包的时间戳
+	hasVideo bool                               // 是否有视频, 有视频时只在关键帧处切分
+}
+
+// NewSplitter FLV切分器, duration为每个文件的时长, next返回第index(从0开始)个文件的输出
+// 切换文件时, 如果上一个输出实现了 io.Closer 则关闭它
+func NewSplitter(duration time.Duration, next func(index int) (io.Writer, error)) *Splitter {
+	return &Splitter{
+		mixer:    NewMixer(nil),
+		duration: int64(duration / time.Millisecond),
+		next:     next,
+		norm:     NewNormalizer(),
+	}
+}
+
+// Write 写入已解复用的数据包(p.Header不能为空)
+func (s *Splitter) Write(p *packet.Packet) error {
+	var cut bool
+
+	dts := s.norm.Normalize(p).DTS
+
+	switch p.Type {
+	case packet.PktMetadata:
+		// 其他脚本事件(onCuePoint等)按原位置写出
+		if !isOnMetaData(p) {
+			if s.w == nil {
+				return nil
+			}
+			return s.mixer.Mux(p, s.timestamp(dts))
+		}
+
+		// 元数据在每个文件的开头写入
+		md, err := decodeMetadata(p)
+		if err != nil {
+			return err
+		}
+		return s.mixer.SaveMetadata(md)
+	case packet.PktVideo:
+		s.hasVideo = true
+
+		vh := p.Header.(packet.VideoPacketHeader)
+		if vh.IsSeqHdr() {
+			err := s.mixer.SaveAVCHeader(p)
+			if err != nil || s.w == nil {
+				return err
+			}
+			// 文件中途编码参数变化
+			return s.mixer.Mux(p, s.timestamp(dts))
+		}
+
+		cut = vh.IsKeyFrame()
+	case packet.PktAudio:
+		ah := p.Header.(packet.AudioPacketHeader)
+		if ah.IsSoundSeqHdr() {
+			err := s.mixer.SaveAACHeader(p)
+			if err != nil || s.w == nil {
+				return err
+			}
+			return s.mixer.Mux(p, s.timestamp(dts))
+		}
+
+		// 纯音频时任意音频帧都可以切分
+		cut = !s.hasVideo
+	default:
+		return errors.New("unexpected packet type")
+	}
+
+	// 到达时长后在可切分的位置切换文件
+	if cut && (s.w == nil || dts-s.start >= s.duration) {
+		err := s.rotate(dts)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 第一个文件开始之前的数据无法解码, 直接丢弃
+	if s.w == nil {
+		return nil
+	}
+
+	return s.mixer.Mux(p, s.timestamp(dts))
+}
+
+// rotate 切换到下一个文件
+func (s *Splitter) rotate(ts int64) error {
+	err := s.Close()
+	if err != nil {
+		return err
+	}
+
+	w, err := s.next(s.index)
+	if err != nil {
+		return err
+	}
+
+	s.w = w
+	s.index++
+	s.start = ts
+	s.mixer.SetWriter(w)
+
+	return s.mixer.SetFlvHeader()
+}
+
+// timestamp 计算相对于当前文件开始的时间戳
+func (s *Splitter) timestamp(dts int64) uint32 {
+	if dts < s.start {
+		return 0
+	}
+
+	return uint32(dts - s.start)
+}
+
+// Close 关闭当前文件
+func (s *Splitter) Close() error {
+	if s.w == nil {
+		return nil
+	}
+
+	w := s.w
+	s.w = nil
+
+	if c, ok := w.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// Concat 按顺序拼接多个FLV流并写入w
+// 后一个流的时间戳接在前一个流之后, 保持连续; 重复的元数据和序列头被丢弃, 只有编码参数变化时才写入新的序列头
+// 每个流的时间戳先经过 Normalizer 规整, 流内的32位回绕和回退不会产生负的时间戳
+func Concat(w io.Writer, rs ...io.Reader) error {
+	c := &concatenator{
+		mixer: NewMixer(w),
+	}
+
+	for _, r := range rs {
+		err := c.concat(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 所有输入都没有媒体数据时, 依然写出FLV头
+	return c.writeHeader()
+}
+
+// concatenator FLV拼接器
+type concatenator struct {
+	mixer   *Mixer
+	gotHdr  bool   // 是否已写出FLV头
+	videoSq []byte // 最后写出的视频序列头
+	audioSq []byte // 最后写出的音频序列头
+	gotMd   bool   // 是否已有元数据
+
+	norm    *Normalizer // 当前流的时间戳规整器
+	offset  int64       // 当前流的时间戳修正量
+	base    int64       // 当前流第一个包规整后的时间戳, -1表示还未得到
+	lastOut int64       // 已写出的最大时间戳
+	lastTs  [2]int64    // 按包类型(视频,音频)记录的上一个规整后的时间戳
+	gap     int64       // 最近的帧间隔, 用于连接两个流
+}
+
+// writeHeader 写出FLV头, 元数据和序列头(只写一次)
+func (c *concatenator) writeHeader() error {
+	if c.gotHdr {
+		return nil
+	}
+
+	c.gotHdr = true
+
+	return c.mixer.SetFlvHeader()
+}
+
+// concat 拼接一个FLV流
+func (c *concatenator) concat(r io.Reader) error {
+	rd := NewReader(r)
+
+	// 新的流接在已写出的数据之后, 时间戳单独规整
+	c.norm = NewNormalizer()
+	c.base = -1
+	c.lastTs = [2]int64{-1, -1}
+	if c.gotHdr {
+		gap := c.gap
+		if gap <= 0 {
+			gap = 1
+		}
+		c.offset = c.lastOut + gap
+	}
+
+	for {
+		var p packet.Packet
+		err := rd.Read(&p)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = c.write(&p)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// write 写出一个数据包
+func (c *concatenator) write(p *packet.Packet) error {
+	switch p.Type {
+	case packet.PktMetadata:
+		if !isOnMetaData(p) {
+			break
+		}
+
+		// 只保留第一个流在FLV头之前的元数据
+		if c.gotHdr || c.gotMd {
+			return nil
+		}
+
+		md, err := decodeMetadata(p)
+		if err != nil {
+			return err
+		}
+		c.gotMd = true
+
+		return c.mixer.SaveMetadata(md)
+	case packet.PktVideo:
+		if p.Header.(packet.VideoPacketHeader).IsSeqHdr() {
+			if bytes.Equal(p.Data, c.videoSq) {
+				return nil
+			}
+			c.videoSq = p.Data
+
+			if !c.gotHdr {
+				return c.mixer.SaveAVCHeader(p)
+			}
+		}
+	case packet.PktAudio:
+		if p.Header.(packet.AudioPacketHeader).IsSoundSeqHdr() {
+			if bytes.Equal(p.Data, c.audioSq) {
+				return nil
+			}
+			c.audioSq = p.Data
+
+			if !c.gotHdr {
+				return c.mixer.SaveAACHeader(p)
+			}
+		}
+	}
+
+	// 第一个媒体数据之前写出FLV头
+	err := c.writeHeader()
+	if err != nil {
+		return err
+	}
+
+	ts := c.norm.Normalize(p).DTS
+	if c.base < 0 {
+		c.base = ts
+	}
+
+	// 记录帧间隔
+	if p.Type == packet.PktVideo || p.Type == packet.PktAudio {
+		if last := c.lastTs[p.Type]; last >= 0 && ts > last {
+			c.gap = ts - last
+		}
+		c.lastTs[p.Type] = ts
+	}
+
+	out := ts - c.base + c.offset
+	if out < 0 {
+		out = 0
+	}
+	if out > c.lastOut {
+		c.lastOut = out
+	}
+
+	return c.mixer.Mux(p, uint32(out))
+}
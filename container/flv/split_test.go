@@ -0,0 +1,120 @@
+package flv
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// readAll 读出FLV流中的所有数据包
+func readAll(at *assert.Assertions, b []byte) []*packet.Packet {
+	var ps []*packet.Packet
+
+	r := NewReader(bytes.NewReader(b))
+	for {
+		p := &packet.Packet{}
+		err := r.Read(p)
+		if err == io.EOF {
+			return ps
+		}
+		at.Nil(err)
+
+		ps = append(ps, p)
+	}
+}
+
+func TestSplitter_Write(t *testing.T) {
+	at := assert.New(t)
+
+	var files []*bytes.Buffer
+	s := NewSplitter(2*time.Second, func(index int) (io.Writer, error) {
+		at.Equal(len(files), index)
+		files = append(files, bytes.NewBuffer(nil))
+		return files[index], nil
+	})
+
+	var d Demuxer
+	write := func(ts uint32, data ...byte) {
+		p := &packet.Packet{Type: packet.PktVideo, TimeStamp: ts, Data: data}
+		at.Nil(d.Demux(p))
+		at.Nil(s.Write(p))
+	}
+
+	// 序列头, 第一个关键帧之前的普通帧被丢弃
+	write(0, 0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x4d, 0x00, 0x1e)
+	write(0, 0x27, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x41)
+
+	// 关键帧间隔1秒, 每2秒切分一次
+	for i := uint32(0); i < 5; i++ {
+		write(1000+i*1000, 0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x65)
+		write(1000+i*1000+40, 0x27, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x41)
+	}
+	at.Nil(s.Close())
+
+	at.Len(files, 3)
+	for i, n := range []int{5, 5, 3} {
+		ps := readAll(at, files[i].Bytes())
+		at.Len(ps, n)
+
+		// 每个文件都以序列头开始, 时间戳从0开始
+		at.True(ps[0].Header.(packet.VideoPacketHeader).IsSeqHdr())
+		at.True(ps[1].Header.(packet.VideoPacketHeader).IsKeyFrame())
+		at.Equal(uint32(0), ps[1].TimeStamp)
+		at.Equal(uint32(40), ps[2].TimeStamp)
+	}
+
+	// 拼接: 第二个文件接在第一个文件最后一帧之后(间隔为最近的帧间隔), 重复的序列头被丢弃
+	w := bytes.NewBuffer(nil)
+	at.Nil(Concat(w, bytes.NewReader(files[0].Bytes()), bytes.NewReader(files[1].Bytes())))
+
+	ps := readAll(at, w.Bytes())
+	at.Len(ps, 9)
+	at.True(ps[0].Header.(packet.VideoPacketHeader).IsSeqHdr())
+
+	var ts []uint32
+	for _, p := range ps[1:] {
+		ts = append(ts, p.TimeStamp)
+	}
+	at.Equal([]uint32{0, 40, 1000, 1040, 1080, 1120, 2080, 2120}, ts)
+}
+
+func TestConcat_Normalize(t *testing.T) {
+	at := assert.New(t)
+
+	// 生成只有视频的FLV流
+	newFlv := func(ts ...uint32) io.Reader {
+		w := bytes.NewBuffer(nil)
+		m := NewMixer(w)
+
+		var d Demuxer
+		hdr := &packet.Packet{Type: packet.PktVideo, Data: []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x4d, 0x00, 0x1e}}
+		at.Nil(d.Demux(hdr))
+		at.Nil(m.SaveAVCHeader(hdr))
+		at.Nil(m.SetFlvHeader())
+
+		for _, v := range ts {
+			p := &packet.Packet{Type: packet.PktVideo, Data: []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x41}}
+			at.Nil(d.Demux(p))
+			at.Nil(m.Mux(p, v))
+		}
+
+		return bytes.NewReader(w.Bytes())
+	}
+
+	// 第二个流在32位处回绕, 之后的单个包回退30ms: 回绕被展开, 回退的包与上一帧对齐
+	w := bytes.NewBuffer(nil)
+	at.Nil(Concat(w, newFlv(0, 40, 80), newFlv(0xffffffb0, 0xffffffd8, 0, 40, 10, 50)))
+
+	ps := readAll(at, w.Bytes())
+	at.Len(ps, 10)
+
+	var ts []uint32
+	for _, p := range ps[1:] {
+		ts = append(ts, p.TimeStamp)
+	}
+	at.Equal([]uint32{0, 40, 80, 120, 160, 200, 240, 240, 250}, ts)
+}
@@ -2,6 +2,7 @@
 package flv
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 
@@ -103,24 +104,83 @@ type mediaTag struct {
 	avcType uint8
 
 	compositionTime int32
+
+	/*
+		Enhanced RTMP
+		IsExHeader: UB[1], 为1时FrameType为UB[3], 低4位是VideoPacketType
+	*/
+	isExHeader bool
+
+	/*
+		Enhanced RTMP, VideoPacketType: UB[4]
+		0: SequenceStart
+		1: CodedFrames
+		2: SequenceEnd
+		3: CodedFramesX
+		4: Metadata
+		5: MPEG2TSSequenceStart
+		6: Multitrack
+		7: ModEx
+	*/
+	packetType uint8
+
+	/*
+		Enhanced RTMP, FourCC: UI32
+		视频: avc1, hvc1, av01, vp09
+		音频: Opus, fLaC, ac-3, ec-3, mp4a, .mp3
+	*/
+	fourCC uint32
+
+	/*
+		Enhanced RTMP, VideoCommand: UI8, 只在命令帧中出现, 此时没有FourCC
+		0: StartSeek
+		1: EndSeek
+	*/
+	videoCommand uint8
+
+	/*
+		Enhanced RTMP, AvMultitrackType: UB[4]
+		0: OneTrack
+		1: ManyTracks
+		2: ManyTracksManyCodecs
+	*/
+	multitrackType uint8
+
+	// Enhanced RTMP, 多轨道时第一个轨道的ID
+	trackID uint8
+}
+
+// track 多轨道中的单个轨道, offset和size是轨道数据在Tag Data中的位置
+type track struct {
+	id     uint8
+	fourCC uint32
+	offset int
+	size   int
 }
 
 // Tag Flv Body
 type Tag struct {
-	flv   flvTag
-	media mediaTag
+	flv    flvTag
+	media  mediaTag
+	tracks []track     // Enhanced RTMP 多轨道, 非多轨道时为空
+	script *ScriptData // 脚本数据, 非脚本数据Tag时为空
 }
 
 // parseVideoHeader [视频]解析 Flv包体 内的 Tag数据头部, 将 Tag数据头部 赋值给 Tag媒体结构, 并返回已处理的字节数
 func (tag *Tag) parseVideoHeader(b []byte) (int, error) {
+	// Enhanced RTMP 扩展视频头, 命令帧只有2字节
+	if len(b) > 0 && b[0]&ExHeader != 0 {
+		return tag.parseExVideoHeader(b)
+	}
+
 	if len(b) < 5 {
 		return 0, errors.New("incomplete video header, len(b) < 5")
 	}
 
 	var n int
+	flags := b[0]
 
 	// [1] 帧类型 和 编码ID
-	flags := b[0]
 	tag.media.frameType = flags >> 4
 	tag.media.codecID = flags & 0xf
 	n++
@@ -130,16 +190,115 @@ func (tag *Tag) parseVideoHeader(b []byte) (int, error) {
 		// [2] H264包类型
 		tag.media.avcType = b[1]
 
-		// [3:5] 时间戳
-		for i := 2; i < 5; i++ {
-			tag.media.compositionTime = tag.media.compositionTime<<8 + int32(b[i])
-		}
+		// [3:5] CompositionTime, SI24, B帧编码时可能为负数
+		tag.media.compositionTime = si24(b[2:])
 		n += 4
 	}
 
 	return n, nil
 }
 
+// parseExVideoHeader [视频]解析 Enhanced RTMP 的 ExVideoTagHeader, 并返回已处理的字节数
+func (tag *Tag) parseExVideoHeader(b []byte) (int, error) {
+	var n int
+
+	// [1] 扩展头标志, 帧类型 和 包类型
+	tag.media.isExHeader = true
+	tag.media.frameType = (b[0] >> 4) & 0x7
+	tag.media.packetType = b[0] & 0xf
+	n++
+
+	// 跳过 ModEx 扩展数据, 直到得到真正的包类型
+	if tag.media.packetType == ExVideoModEx {
+		var err error
+		n, tag.media.packetType, err = skipModEx(b, n)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if tag.media.packetType == ExVideoMultitrack {
+		return 0, errors.New("unsupported video multitrack packet")
+	}
+
+	// 命令帧, 只有1字节的VideoCommand, 没有FourCC和视频数据
+	if tag.media.frameType == CommandFrame && tag.media.packetType != ExVideoMetadata {
+		if len(b) < n+1 {
+			return 0, errors.New("incomplete video command")
+		}
+		tag.media.videoCommand = b[n]
+		return n + 1, nil
+	}
+
+	// [4] FourCC
+	if len(b) < n+4 {
+		return 0, errors.New("incomplete video fourcc")
+	}
+	tag.media.fourCC = binary.BigEndian.Uint32(b[n:])
+	n += 4
+
+	switch tag.media.fourCC {
+	case FourCCAvc, FourCCHevc, FourCCAv1, FourCCVp9:
+	default:
+		return 0, fmt.Errorf("unexpected video fourcc: %#x", tag.media.fourCC)
+	}
+
+	// [3] AVC和HEVC的CodedFrames带有SI24的CompositionTime
+	if tag.media.packetType == ExVideoCodedFrames &&
+		(tag.media.fourCC == FourCCAvc || tag.media.fourCC == FourCCHevc) {
+		if len(b) < n+3 {
+			return 0, errors.New("incomplete video composition time")
+		}
+		tag.media.compositionTime = si24(b[n:])
+		n += 3
+	}
+
+	return n, nil
+}
+
+// skipModEx 跳过 Enhanced RTMP 的 ModEx 扩展数据, 返回新的偏移以及真正的包类型
+func skipModEx(b []byte, n int) (int, uint8, error) {
+	for {
+		if len(b) < n+1 {
+			return 0, 0, errors.New("incomplete mod ex header")
+		}
+
+		// [1] 数据长度减1, 为255时使用[2]
+		size := int(b[n]) + 1
+		n++
+		if size == 256 {
+			if len(b) < n+2 {
+				return 0, 0, errors.New("incomplete mod ex header")
+			}
+			size = int(binary.BigEndian.Uint16(b[n:])) + 1
+			n += 2
+		}
+		n += size
+
+		// [0:3]ModExType, [4:7]PacketType
+		if len(b) < n+1 {
+			return 0, 0, errors.New("incomplete mod ex data")
+		}
+		packetType := b[n] & 0xf
+		n++
+
+		// 音频和视频的ModEx包类型均为7
+		if packetType != ExVideoModEx {
+			return n, packetType, nil
+		}
+	}
+}
+
+// si24 读取3字节的有符号整数
+func si24(b []byte) int32 {
+	v := int32(b[0])<<16 | int32(b[1])<<8 | int32(b[2])
+	if v&0x800000 != 0 {
+		v -= 0x1000000
+	}
+
+	return v
+}
+
 // parseAudioHeader [音频]解析 Flv包体 内的 Tag数据头部, 将 Tag数据头部 赋值给 Tag媒体结构, 并返回已处理的字节数
 func (tag *Tag) parseAudioHeader(b []byte) (int, error) {
 	if len(b) < 2 {
@@ -158,11 +317,17 @@ func (tag *Tag) parseAudioHeader(b []byte) (int, error) {
 
 	// 根据音频格式做不同解析
 	switch tag.media.soundFormat {
+	case SoundExHeader:
+		return tag.parseExAudioHeader(b)
 	case SoundAAC:
 		// [2] aac包类型
 		tag.media.aacType = b[1]
 		n++
-	case SoundMP3:
+	case SoundMP3, SoundMP38KHz:
+	case SoundLinearPcmPlatformEndian, SoundADPCM, SoundLinearPcmLittleEndian,
+		SoundNellymoser16KHzMono, SoundNellymoser8KHzMono, SoundNellymoser,
+		SoundG711ALawLogarithmicPCM, SoundG711MuLawLogarithmicPCM, SoundSpeex:
+		// 无封装的音频数据, 直接透传
 	default:
 		return 0, fmt.Errorf("unexpected sound format number: %d", tag.media.soundFormat)
 	}
@@ -170,6 +335,178 @@ func (tag *Tag) parseAudioHeader(b []byte) (int, error) {
 	return n, nil
 }
 
+// parseExAudioHeader [音频]解析 Enhanced RTMP 的 ExAudioTagHeader, 并返回已处理的字节数
+// 多轨道时返回第一个轨道数据的起始位置
+func (tag *Tag) parseExAudioHeader(b []byte) (int, error) {
+	var n int
+	var err error
+
+	// [1] SoundFormat(9) 和 包类型
+	tag.media.isExHeader = true
+	tag.media.packetType = b[0] & 0xf
+	n++
+
+	// 跳过 ModEx 扩展数据, 直到得到真正的包类型
+	if tag.media.packetType == ExAudioModEx {
+		n, tag.media.packetType, err = skipModEx(b, n)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if tag.media.packetType == ExAudioMultitrack {
+		n, err = tag.parseAudioTracks(b, n)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		// [4] FourCC
+		if len(b) < n+4 {
+			return 0, errors.New("incomplete audio fourcc")
+		}
+		tag.media.fourCC = binary.BigEndian.Uint32(b[n:])
+		n += 4
+	}
+
+	for _, v := range tag.tracks {
+		if !isAudioFourCC(v.fourCC) {
+			return 0, fmt.Errorf("unexpected audio fourcc: %#x", v.fourCC)
+		}
+	}
+	if !isAudioFourCC(tag.media.fourCC) {
+		return 0, fmt.Errorf("unexpected audio fourcc: %#x", tag.media.fourCC)
+	}
+
+	tag.setExAACType()
+
+	return n, nil
+}
+
+// setExAACType [音频]mp4a 与传统AAC的包类型保持一致, 以便复用AAC解析器
+func (tag *Tag) setExAACType() {
+	if tag.media.fourCC != FourCCAac {
+		return
+	}
+
+	tag.media.aacType = AacRaw
+	if tag.media.packetType == ExAudioSeqStart {
+		tag.media.aacType = AacSeqHdr
+	}
+}
+
+// mediaData 返回Tag Data中的媒体数据, n是媒体头长度; 多轨道时只返回第一个轨道的数据
+func (tag *Tag) mediaData(b []byte, n int) []byte {
+	if len(tag.tracks) > 0 {
+		t := tag.tracks[0]
+		return b[t.offset : t.offset+t.size]
+	}
+
+	return b[n:]
+}
+
+// splitTracks 将多轨道Tag拆分为每个轨道一个Tag
+func (tag *Tag) splitTracks() []*Tag {
+	ret := make([]*Tag, 0, len(tag.tracks))
+	for _, t := range tag.tracks {
+		tt := *tag
+		tt.tracks = []track{t}
+		tt.media.fourCC = t.fourCC
+		tt.media.trackID = t.id
+		tt.setExAACType()
+
+		ret = append(ret, &tt)
+	}
+
+	return ret
+}
+
+// parseAudioTracks [音频]解析多轨道, 填充 Tag.tracks, 返回第一个轨道数据的起始位置
+func (tag *Tag) parseAudioTracks(b []byte, n int) (int, error) {
+	// [1] [0:3]AvMultitrackType, [4:7]AudioPacketType
+	if len(b) < n+1 {
+		return 0, errors.New("incomplete audio multitrack header")
+	}
+	tag.media.multitrackType = b[n] >> 4
+	tag.media.packetType = b[n] & 0xf
+	n++
+
+	if tag.media.packetType == ExAudioMultitrack {
+		return 0, errors.New("nested audio multitrack packet")
+	}
+
+	// [4] 所有轨道共用的FourCC
+	var fourCC uint32
+	if tag.media.multitrackType != MultitrackManyTracksManyCodecs {
+		if len(b) < n+4 {
+			return 0, errors.New("incomplete audio fourcc")
+		}
+		fourCC = binary.BigEndian.Uint32(b[n:])
+		n += 4
+	}
+
+	for n < len(b) {
+		// [4] 轨道自己的FourCC
+		if tag.media.multitrackType == MultitrackManyTracksManyCodecs {
+			if len(b) < n+4 {
+				return 0, errors.New("incomplete audio track fourcc")
+			}
+			fourCC = binary.BigEndian.Uint32(b[n:])
+			n += 4
+		}
+
+		// [1] 轨道ID
+		if len(b) < n+1 {
+			return 0, errors.New("incomplete audio track id")
+		}
+		t := track{id: b[n], fourCC: fourCC}
+		n++
+
+		// [3] 轨道数据长度, 单轨道时数据直到Tag结尾
+		t.size = len(b) - n
+		if tag.media.multitrackType != MultitrackOneTrack {
+			if len(b) < n+3 {
+				return 0, errors.New("incomplete audio track size")
+			}
+			t.size = int(b[n])<<16 | int(b[n+1])<<8 | int(b[n+2])
+			n += 3
+		}
+
+		if len(b) < n+t.size {
+			return 0, errors.New("incomplete audio track data")
+		}
+		t.offset = n
+		n += t.size
+
+		tag.tracks = append(tag.tracks, t)
+
+		if tag.media.multitrackType == MultitrackOneTrack {
+			break
+		}
+	}
+
+	if len(tag.tracks) == 0 {
+		return 0, errors.New("audio multitrack packet without track")
+	}
+
+	tag.media.fourCC = tag.tracks[0].fourCC
+	tag.media.trackID = tag.tracks[0].id
+
+	return tag.tracks[0].offset, nil
+}
+
+// isAudioFourCC 判断是否是支持的音频FourCC
+func isAudioFourCC(fourCC uint32) bool {
+	switch fourCC {
+	case FourCCOpus, FourCCFlac, FourCCAc3, FourCCEac3, FourCCAac, FourCCMp3:
+		return true
+	}
+
+	return false
+}
+
+// soundRates SoundRate对应的采样率
+var soundRates = []int{5512, 11025, 22050, 44100}
+
 // SoundFormat [音频]返回音频格式
 func (tag *Tag) SoundFormat() uint8 {
 	return tag.media.soundFormat
@@ -177,12 +514,117 @@ func (tag *Tag) SoundFormat() uint8 {
 
 // IsSoundAAC [音频:aac]判断音频格式是否是aac
 func (tag *Tag) IsSoundAAC() bool {
+	if tag.media.isExHeader {
+		return tag.media.fourCC == FourCCAac
+	}
 	return tag.media.soundFormat == SoundAAC
 }
 
 // IsSoundMP3 [音频:mp3]判断音频格式是否是mp3
 func (tag *Tag) IsSoundMP3() bool {
-	return tag.media.soundFormat == SoundMP3
+	if tag.media.isExHeader {
+		return tag.media.fourCC == FourCCMp3
+	}
+	return tag.media.soundFormat == SoundMP3 || tag.media.soundFormat == SoundMP38KHz
+}
+
+// IsSoundOpus [音频:opus]判断音频格式是否是Opus(只有扩展音频头)
+func (tag *Tag) IsSoundOpus() bool {
+	return tag.media.isExHeader && tag.media.fourCC == FourCCOpus
+}
+
+// IsSoundAC3 [音频:ac-3]判断音频格式是否是AC-3(只有扩展音频头)
+func (tag *Tag) IsSoundAC3() bool {
+	return tag.media.isExHeader && tag.media.fourCC == FourCCAc3
+}
+
+// IsSoundEAC3 [音频:e-ac-3]判断音频格式是否是E-AC-3(只有扩展音频头)
+func (tag *Tag) IsSoundEAC3() bool {
+	return tag.media.isExHeader && tag.media.fourCC == FourCCEac3
+}
+
+// IsSoundG711ALaw [音频:g.711]判断音频格式是否是G.711 A-law
+func (tag *Tag) IsSoundG711ALaw() bool {
+	return !tag.media.isExHeader && tag.media.soundFormat == SoundG711ALawLogarithmicPCM
+}
+
+// IsSoundG711MuLaw [音频:g.711]判断音频格式是否是G.711 µ-law
+func (tag *Tag) IsSoundG711MuLaw() bool {
+	return !tag.media.isExHeader && tag.media.soundFormat == SoundG711MuLawLogarithmicPCM
+}
+
+// IsSoundPassthrough [音频]判断音频格式是否是无需解析的原始音频(PCM, ADPCM, G.711, Speex, Nellymoser)
+func (tag *Tag) IsSoundPassthrough() bool {
+	if tag.media.isExHeader {
+		return false
+	}
+
+	switch tag.media.soundFormat {
+	case SoundLinearPcmPlatformEndian, SoundADPCM, SoundLinearPcmLittleEndian,
+		SoundNellymoser16KHzMono, SoundNellymoser8KHzMono, SoundNellymoser,
+		SoundG711ALawLogarithmicPCM, SoundG711MuLawLogarithmicPCM, SoundSpeex:
+		return true
+	}
+
+	return false
+}
+
+// SampleRate [音频]根据Tag标志位返回采样率(Hz), 部分格式的采样率是固定的; 扩展音频头返回0
+func (tag *Tag) SampleRate() int {
+	if tag.media.isExHeader {
+		return 0
+	}
+
+	switch tag.media.soundFormat {
+	case SoundG711ALawLogarithmicPCM, SoundG711MuLawLogarithmicPCM, SoundNellymoser8KHzMono, SoundMP38KHz:
+		return 8000
+	case SoundNellymoser16KHzMono, SoundSpeex:
+		return 16000
+	}
+
+	return soundRates[tag.media.soundRate&0x3]
+}
+
+// SampleSize [音频]根据Tag标志位返回采样位数(8或16), 只对未压缩格式有意义; 扩展音频头返回0
+func (tag *Tag) SampleSize() int {
+	if tag.media.isExHeader {
+		return 0
+	}
+
+	if tag.media.soundSize == SoundSize8BitSamples {
+		return 8
+	}
+	return 16
+}
+
+// Channels [音频]根据Tag标志位返回声道数; 扩展音频头返回0
+func (tag *Tag) Channels() int {
+	if tag.media.isExHeader {
+		return 0
+	}
+
+	if tag.media.soundType == SoundTypeStereo {
+		return 2
+	}
+	return 1
+}
+
+// IsSoundSeqHdr [音频]判断音频包是否是序列头(AAC序列头或者扩展音频头的SequenceStart)
+func (tag *Tag) IsSoundSeqHdr() bool {
+	if tag.media.isExHeader {
+		return tag.media.packetType == ExAudioSeqStart
+	}
+	return tag.media.soundFormat == SoundAAC && tag.media.aacType == AacSeqHdr
+}
+
+// IsMultitrack [音频]判断是否是 Enhanced RTMP 的多轨道包
+func (tag *Tag) IsMultitrack() bool {
+	return len(tag.tracks) > 0
+}
+
+// TrackID [音频]返回轨道ID, 多轨道时返回第一个轨道的ID, 非多轨道返回0
+func (tag *Tag) TrackID() uint8 {
+	return tag.media.trackID
 }
 
 // AACType [音频:aac]返回aac的包类型
@@ -195,11 +637,49 @@ func (tag *Tag) IsAACSeqHdr() bool {
 	return tag.media.aacType == AacSeqHdr
 }
 
+// IsExHeader [音视频]判断是否是 Enhanced RTMP 的扩展头
+func (tag *Tag) IsExHeader() bool {
+	return tag.media.isExHeader
+}
+
+// FourCC [音视频]返回 Enhanced RTMP 的 FourCC, 传统头返回0
+func (tag *Tag) FourCC() uint32 {
+	return tag.media.fourCC
+}
+
+// IsCommandFrame [视频]判断是否是 Enhanced RTMP 的命令帧
+func (tag *Tag) IsCommandFrame() bool {
+	return tag.media.isExHeader && tag.media.frameType == CommandFrame && tag.media.packetType != ExVideoMetadata
+}
+
+// VideoCommand [视频]返回命令帧的 VideoCommand
+func (tag *Tag) VideoCommand() uint8 {
+	return tag.media.videoCommand
+}
+
 // IsCodecAvc [视频:h264]判断解码器是不是H264
 func (tag *Tag) IsCodecAvc() bool {
+	if tag.media.isExHeader {
+		return tag.media.fourCC == FourCCAvc
+	}
 	return tag.media.codecID == AvcH264
 }
 
+// IsCodecHevc [视频:h265]判断解码器是不是H265
+func (tag *Tag) IsCodecHevc() bool {
+	return tag.media.isExHeader && tag.media.fourCC == FourCCHevc
+}
+
+// IsCodecAv1 [视频:av1]判断解码器是不是AV1
+func (tag *Tag) IsCodecAv1() bool {
+	return tag.media.isExHeader && tag.media.fourCC == FourCCAv1
+}
+
+// IsCodecVp9 [视频:vp9]判断解码器是不是VP9
+func (tag *Tag) IsCodecVp9() bool {
+	return tag.media.isExHeader && tag.media.fourCC == FourCCVp9
+}
+
 // IsKeyFrame [视频:h264]判断数据是否是关键帧
 func (tag *Tag) IsKeyFrame() bool {
 	return tag.media.frameType == KeyFrame
@@ -212,27 +692,76 @@ func (tag *Tag) IsInterFrame() bool {
 
 // IsSeqHdr [视频:h264]判断数据是否是关键帧同时还是包序列的头
 func (tag *Tag) IsSeqHdr() bool {
+	if tag.media.isExHeader {
+		return tag.media.packetType == ExVideoSeqStart || tag.media.packetType == ExVideoMPEG2TSSeqStart
+	}
 	return tag.media.frameType == KeyFrame && tag.media.avcType == AvcSeqHdr
 }
 
 // IsEndOfSeq [视频:h264]判断数据是否是关键帧同时还是包序列的尾
 func (tag *Tag) IsEndOfSeq() bool {
+	if tag.media.isExHeader {
+		return tag.media.packetType == ExVideoSeqEnd
+	}
 	return tag.media.frameType == KeyFrame && tag.media.avcType == AvcEndOfSeq
 }
 
-// CodecID [视频]返回 CodecID
+// CodecID [视频]返回 CodecID, 扩展视频头使用 FourCC, 返回0
 func (tag *Tag) CodecID() uint8 {
 	return tag.media.codecID
 }
 
 // CompositionTime [视频:h264]返回 CompositionTime
 func (tag *Tag) CompositionTime() int32 {
-	if tag.media.avcType == AvcNalu {
+	if tag.media.isExHeader || tag.media.avcType == AvcNalu {
 		return tag.media.compositionTime
 	}
 	return 0
 }
 
+// parseScriptData [脚本]解析脚本数据, 脚本数据没有头部, 返回0
+// 按Tag类型选择AMF0或者AMF3(没有Tag头时为AMF0); 无法解析时保留原始数据, tag.script为空
+func (tag *Tag) parseScriptData(b []byte) (int, error) {
+	var s *ScriptData
+	var err error
+	if tag.isAMF3Script() {
+		s, err = ParseAMF3ScriptData(b)
+	} else {
+		s, err = ParseScriptData(b)
+	}
+	if err == nil {
+		tag.script = s
+	}
+
+	return 0, nil
+}
+
+// isAMF3Script [脚本]判断是否是AMF3脚本数据Tag
+func (tag *Tag) isAMF3Script() bool {
+	return tag.flv.fType == packet.TagScriptDataAMF3 || (tag.script != nil && tag.script.AMF3)
+}
+
+// ScriptName [脚本]返回脚本事件名
+func (tag *Tag) ScriptName() string {
+	if tag.script == nil {
+		return ""
+	}
+	return tag.script.Name
+}
+
+// ScriptData [脚本]返回解析后的脚本数据
+func (tag *Tag) ScriptData() *ScriptData {
+	return tag.script
+}
+
+// ScriptEvent [脚本]返回脚本事件, 参见 ScriptData.Event
+func (tag *Tag) ScriptEvent() interface{} {
+	if tag.script == nil {
+		return nil
+	}
+	return tag.script.Event()
+}
+
 // ParseMediaTagHeader [音视频]解析视频, 音频中的头部数据, 将数据填充到 Tag.mediat; b是Tag Data
 func (tag *Tag) ParseMediaTagHeader(b []byte, mediaType int) (int, error) {
 	// 根据媒体类型做不同解析，这里是解析器封装，错误直接透传
@@ -242,7 +771,7 @@ func (tag *Tag) ParseMediaTagHeader(b []byte, mediaType int) (int, error) {
 	case packet.PktAudio:
 		return tag.parseAudioHeader(b)
 	case packet.PktMetadata:
-		return 0, nil
+		return tag.parseScriptData(b)
 	}
 
 	return 0, errors.New("unexpected media type")
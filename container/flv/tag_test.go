@@ -44,6 +44,16 @@ func TestTag_ParseVideo(t *testing.T) {
 	at.False(tag.IsEndOfSeq())
 	at.Equal(byte(7), tag.CodecID())
 	at.Equal(int32(0), tag.CompositionTime())
+
+	// case3: 负的CompositionTime
+	tag = Tag{}
+	v = []byte{
+		0x27, 0x01, 0xff, 0xff, 0xd8,
+	}
+
+	_, err = tag.ParseMediaTagHeader(v, packet.PktVideo)
+	at.Nil(err)
+	at.Equal(int32(-40), tag.CompositionTime())
 }
 
 func TestTag_ParseAudio(t *testing.T) {
@@ -66,3 +76,188 @@ func TestTag_ParseAudio(t *testing.T) {
 	at.False(tag.IsAACSeqHdr())
 	at.Equal(byte(1), tag.AACType())
 }
+
+func TestTag_ParseExVideo(t *testing.T) {
+	at := assert.New(t)
+
+	// case1: HEVC序列头
+	var tag Tag
+
+	v := []byte{
+		0x90, 0x68, 0x76, 0x63, 0x31, 0x01,
+	}
+
+	n, err := tag.ParseMediaTagHeader(v, packet.PktVideo)
+	at.Nil(err)
+	at.Equal(5, n)
+
+	at.True(tag.IsExHeader())
+	at.True(tag.IsCodecHevc())
+	at.False(tag.IsCodecAvc())
+	at.True(tag.IsKeyFrame())
+	at.True(tag.IsSeqHdr())
+	at.Equal(uint32(FourCCHevc), tag.FourCC())
+
+	// case2: HEVC CodedFrames, 负的CompositionTime
+	tag = Tag{}
+	v = []byte{
+		0xa1, 0x68, 0x76, 0x63, 0x31, 0xff, 0xff, 0xd8, 0x00,
+	}
+
+	n, err = tag.ParseMediaTagHeader(v, packet.PktVideo)
+	at.Nil(err)
+	at.Equal(8, n)
+
+	at.True(tag.IsInterFrame())
+	at.False(tag.IsSeqHdr())
+	at.Equal(int32(-40), tag.CompositionTime())
+
+	// case3: AV1 CodedFramesX, 带ModEx
+	tag = Tag{}
+	v = []byte{
+		0x97, 0x02, 0x00, 0x00, 0x00, 0x03, 0x61, 0x76, 0x30, 0x31, 0x0a,
+	}
+
+	n, err = tag.ParseMediaTagHeader(v, packet.PktVideo)
+	at.Nil(err)
+	at.Equal(10, n)
+
+	at.True(tag.IsCodecAv1())
+	at.True(tag.IsKeyFrame())
+	at.Equal(int32(0), tag.CompositionTime())
+
+	// case4: VP9序列尾
+	tag = Tag{}
+	v = []byte{
+		0x92, 0x76, 0x70, 0x30, 0x39,
+	}
+
+	_, err = tag.ParseMediaTagHeader(v, packet.PktVideo)
+	at.Nil(err)
+	at.True(tag.IsCodecVp9())
+	at.True(tag.IsEndOfSeq())
+
+	// case5: 未知FourCC
+	tag = Tag{}
+	v = []byte{
+		0x91, 0x61, 0x62, 0x63, 0x64, 0x00, 0x00, 0x00,
+	}
+
+	_, err = tag.ParseMediaTagHeader(v, packet.PktVideo)
+	at.NotNil(err)
+
+	// case6: 命令帧, 没有FourCC, 只有1字节的VideoCommand
+	tag = Tag{}
+	v = []byte{
+		0xd1, 0x01,
+	}
+
+	n, err = tag.ParseMediaTagHeader(v, packet.PktVideo)
+	at.Nil(err)
+	at.Equal(2, n)
+	at.True(tag.IsCommandFrame())
+	at.Equal(uint8(VideoCommandEndSeek), tag.VideoCommand())
+	at.Equal(uint32(0), tag.FourCC())
+
+	// case7: 不完整的命令帧
+	tag = Tag{}
+	_, err = tag.ParseMediaTagHeader([]byte{0xd1}, packet.PktVideo)
+	at.NotNil(err)
+}
+
+func TestTag_ParseExAudio(t *testing.T) {
+	at := assert.New(t)
+
+	// case1: Opus序列头
+	var tag Tag
+
+	v := []byte{
+		0x90, 0x4f, 0x70, 0x75, 0x73, 0x4f, 0x70, 0x75, 0x73,
+	}
+
+	n, err := tag.ParseMediaTagHeader(v, packet.PktAudio)
+	at.Nil(err)
+	at.Equal(5, n)
+
+	at.True(tag.IsExHeader())
+	at.True(tag.IsSoundSeqHdr())
+	at.False(tag.IsSoundAAC())
+	at.True(tag.IsSoundOpus())
+	at.False(tag.IsMultitrack())
+	at.Equal(uint8(SoundExHeader), tag.SoundFormat())
+	at.Equal(uint32(FourCCOpus), tag.FourCC())
+
+	// case2: mp4a数据帧, 与AAC兼容
+	tag = Tag{}
+	v = []byte{
+		0x91, 0x6d, 0x70, 0x34, 0x61, 0x21,
+	}
+
+	_, err = tag.ParseMediaTagHeader(v, packet.PktAudio)
+	at.Nil(err)
+	at.True(tag.IsSoundAAC())
+	at.False(tag.IsSoundSeqHdr())
+	at.Equal(uint8(AacRaw), tag.AACType())
+
+	// case3: 单轨道
+	tag = Tag{}
+	v = []byte{
+		0x95, 0x01, 0x61, 0x63, 0x2d, 0x33, 0x02, 0x0b, 0x77,
+	}
+
+	n, err = tag.ParseMediaTagHeader(v, packet.PktAudio)
+	at.Nil(err)
+	at.Equal(7, n)
+	at.True(tag.IsMultitrack())
+	at.Equal(uint8(2), tag.TrackID())
+	at.Equal(uint32(FourCCAc3), tag.FourCC())
+	at.True(tag.IsSoundAC3())
+	at.False(tag.IsSoundEAC3())
+
+	// case4: 未知FourCC
+	tag = Tag{}
+	v = []byte{
+		0x91, 0x61, 0x62, 0x63, 0x64, 0x00,
+	}
+
+	_, err = tag.ParseMediaTagHeader(v, packet.PktAudio)
+	at.NotNil(err)
+}
+
+func TestTag_ParseLegacyAudio(t *testing.T) {
+	at := assert.New(t)
+
+	// case1: G.711 mu-law, 标志位中的采样率不可信, 固定为8kHz
+	var tag Tag
+
+	n, err := tag.ParseMediaTagHeader([]byte{0x82, 0xff}, packet.PktAudio)
+	at.Nil(err)
+	at.Equal(1, n)
+
+	at.True(tag.IsSoundPassthrough())
+	at.True(tag.IsSoundG711MuLaw())
+	at.False(tag.IsSoundG711ALaw())
+	at.False(tag.IsSoundSeqHdr())
+	at.Equal(8000, tag.SampleRate())
+	at.Equal(16, tag.SampleSize())
+	at.Equal(1, tag.Channels())
+
+	// case2: PCM little endian, 44.1kHz, 16bit, stereo
+	tag = Tag{}
+	_, err = tag.ParseMediaTagHeader([]byte{0x3f, 0x00}, packet.PktAudio)
+	at.Nil(err)
+	at.True(tag.IsSoundPassthrough())
+	at.Equal(44100, tag.SampleRate())
+	at.Equal(2, tag.Channels())
+
+	// case3: Speex
+	tag = Tag{}
+	_, err = tag.ParseMediaTagHeader([]byte{0xb2, 0x00}, packet.PktAudio)
+	at.Nil(err)
+	at.Equal(16000, tag.SampleRate())
+
+	// case4: 设备相关的格式不支持
+	tag = Tag{}
+	_, err = tag.ParseMediaTagHeader([]byte{0xf2, 0x00}, packet.PktAudio)
+	at.NotNil(err)
+}
@@ -0,0 +1,127 @@
+package flv
+
+import (
+	"time"
+
+	"github.com/nextpkg/goav/packet"
+)
+
+// Timestamp 规整后的时间戳, 毫秒
+type Timestamp struct {
+	DTS      int64
+	PTS      int64 // DTS + CompositionTime
+	Rollover bool  // 在此包处发生了32位时间戳回绕
+	Jump     bool  // 在此包处时间戳回退(或者跳跃超过 SetMaxJump 的阈值), 已修正为与上一个同类型的包连续
+}
+
+// Normalizer FLV时间戳规整器
+// FLV的时间戳只有32位(约49.7天回绕一次), 编码器重启等情况下还会回退; 规整器展开回绕,
+// 修正回退, 输出64位单调递增的DTS/PTS, 适用于长时间运行的直播流
+type Normalizer struct {
+	got     bool     // 是否已收到音视频包
+	last    int64    // 上一个音视频包展开后的时间戳(未修正)
+	offset  int64    // 时间戳回退的修正量
+	lastOut [2]int64 // 按包类型(视频,音频)记录的上一个输出时间戳
+	gotOut  [2]bool
+	maxJump int64 // 同类型的包时间戳向前跳跃超过该值(ms)时也修正为连续, 为0时保留跳跃
+
+	// 回退之后的第一个包按原修正量与同类型的上一个包连续时, 认为回退的只是单个异常包, 恢复修正量
+	pending    bool
+	prevOffset int64 // 回退前的修正量
+}
+
+// NewNormalizer FLV时间戳规整器
+func NewNormalizer() *Normalizer {
+	return &Normalizer{}
+}
+
+// SetMaxJump 同类型的包时间戳向前跳跃超过d时, 与回退一样修正为与上一个包连续; 默认(d<=0)保留跳跃
+// 暂停, 稀疏的音频等合法的间隔也会被去掉, 只在确定输入没有这类间隔时使用
+func (n *Normalizer) SetMaxJump(d time.Duration) {
+	n.maxJump = 0
+	if d > 0 {
+		n.maxJump = d.Milliseconds()
+	}
+}
+
+// isJump 判断相对于同类型上一个输出时间戳是否需要修正
+func (n *Normalizer) isJump(dts, last int64) bool {
+	return dts < last || n.maxJump > 0 && dts-last > n.maxJump
+}
+
+// Normalize 规整数据包的时间戳, 视频包的PTS依赖于 p.Header
+// 元数据包, 序列头, 序列尾和命令帧只做回绕展开, 不参与回退检测
+func (n *Normalizer) Normalize(p *packet.Packet) Timestamp {
+	var ret Timestamp
+
+	media := p.Type == packet.PktVideo || p.Type == packet.PktAudio
+	detect := media && !isControl(p)
+
+	// 展开32位时间戳: 取与上一个时间戳距离最近的值, 音视频交错时的小幅回退不会被当作回绕
+	ts := int64(p.TimeStamp)
+	if n.got {
+		ts = n.last + int64(int32(p.TimeStamp-uint32(n.last)))
+		ret.Rollover = detect && ts>>32 > n.last>>32
+	}
+
+	if detect {
+		// 回退之后的第一个包仍在回退前的时间线上
+		if n.pending && n.gotOut[p.Type] && !n.isJump(ts+n.prevOffset, n.lastOut[p.Type]) {
+			n.offset = n.prevOffset
+		}
+		n.pending = false
+
+		n.got = true
+		n.last = ts
+	}
+
+	dts := ts + n.offset
+	if dts < 0 {
+		dts = 0
+	}
+
+	// 同类型的包时间戳回退(或者跳跃超过阈值)时, 调整修正量使其与上一个包连续
+	if detect {
+		last := n.lastOut[p.Type]
+		if n.gotOut[p.Type] && n.isJump(dts, last) {
+			n.pending = true
+			n.prevOffset = n.offset
+
+			n.offset += last - dts
+			dts = last
+			ret.Jump = true
+		}
+
+		n.gotOut[p.Type] = true
+		n.lastOut[p.Type] = dts
+	} else if media && n.gotOut[p.Type] && dts < n.lastOut[p.Type] {
+		// 重发的序列头等不回退
+		dts = n.lastOut[p.Type]
+	}
+
+	ret.DTS = dts
+	ret.PTS = dts
+
+	if vh, ok := p.Header.(packet.VideoPacketHeader); ok && p.Type == packet.PktVideo {
+		ret.PTS += int64(vh.CompositionTime())
+	}
+
+	return ret
+}
+
+// isControl 判断是否是序列头, 序列尾或者命令帧, 编码器可能以任意时间戳重发这些包
+func isControl(p *packet.Packet) bool {
+	tag, ok := p.Header.(*Tag)
+	if !ok {
+		return false
+	}
+
+	switch p.Type {
+	case packet.PktVideo:
+		return tag.IsSeqHdr() || tag.IsEndOfSeq() || tag.IsCommandFrame()
+	case packet.PktAudio:
+		return tag.IsSoundSeqHdr()
+	}
+
+	return false
+}
@@ -0,0 +1,111 @@
+package flv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizer_Normalize(t *testing.T) {
+	at := assert.New(t)
+
+	n := NewNormalizer()
+	var d Demuxer
+
+	normalize := func(typ int, ts uint32, data ...byte) Timestamp {
+		p := &packet.Packet{Type: typ, TimeStamp: ts, Data: data}
+		at.Nil(d.Demux(p))
+		return n.Normalize(p)
+	}
+	video := func(ts uint32) Timestamp {
+		// CompositionTime: -40
+		return normalize(packet.PktVideo, ts, 0x27, 0x01, 0xff, 0xff, 0xd8, 0x00, 0x00, 0x00, 0x01, 0x41)
+	}
+	audio := func(ts uint32) Timestamp {
+		return normalize(packet.PktAudio, ts, 0xaf, 0x01, 0x21)
+	}
+
+	// 负的CompositionTime
+	ret := video(0xffffff00)
+	at.Equal(Timestamp{DTS: 0xffffff00, PTS: 0xffffff00 - 40}, ret)
+
+	// 音视频交错的小幅回退不是回绕
+	ret = audio(0xfffffef0)
+	at.Equal(int64(0xfffffef0), ret.DTS)
+	at.False(ret.Rollover)
+	at.False(ret.Jump)
+
+	// 32位回绕
+	ret = video(0x40)
+	at.Equal(int64(1<<32+0x40), ret.DTS)
+	at.True(ret.Rollover)
+	at.False(ret.Jump)
+
+	ret = audio(0x10)
+	at.Equal(int64(1<<32+0x10), ret.DTS)
+	at.False(ret.Rollover)
+
+	// 时间戳回退(编码器重启), 修正为与上一个包连续
+	ret = video(0x08)
+	at.Equal(int64(1<<32+0x40), ret.DTS)
+	at.True(ret.Jump)
+
+	ret = video(0x30)
+	at.Equal(int64(1<<32+0x40+0x28), ret.DTS)
+	at.False(ret.Jump)
+
+	// 元数据不参与回退检测
+	ret = normalize(packet.PktMetadata, 0, 0x02, 0x00, 0x0a, 'o', 'n', 'M', 'e', 't', 'a', 'D', 'a', 't', 'a')
+	at.False(ret.Jump)
+	at.Equal(ret.DTS, ret.PTS)
+
+	// 重发的序列头不参与回退检测
+	n = NewNormalizer()
+	seqHdr := func(ts uint32) Timestamp {
+		return normalize(packet.PktVideo, ts, 0x17, 0x00, 0x00, 0x00, 0x00, 0x01)
+	}
+
+	at.Equal(int64(0), seqHdr(0).DTS)
+	at.Equal(int64(60000), video(60000).DTS)
+	ret = seqHdr(0)
+	at.False(ret.Jump)
+	at.Equal(int64(60000), ret.DTS)
+	ret = audio(60010)
+	at.Equal(int64(60010), ret.DTS)
+	ret = video(60040)
+	at.False(ret.Jump)
+	at.Equal(int64(60040), ret.DTS)
+
+	// 单个异常包回退后回到原来的时间线, 恢复修正量
+	ret = video(0)
+	at.True(ret.Jump)
+	at.Equal(int64(60040), ret.DTS)
+	ret = audio(60050)
+	at.False(ret.Jump)
+	at.Equal(int64(60050), ret.DTS)
+	ret = video(60080)
+	at.Equal(int64(60080), ret.DTS)
+
+	// 默认保留向前的跳跃
+	n = NewNormalizer()
+	at.Equal(int64(1000), video(1000).DTS)
+	ret = video(9000)
+	at.False(ret.Jump)
+	at.Equal(int64(9000), ret.DTS)
+
+	// 超过阈值的跳跃修正为连续, 同时跳跃的音频随之平移
+	n = NewNormalizer()
+	n.SetMaxJump(5 * time.Second)
+	at.Equal(int64(1000), video(1000).DTS)
+	at.Equal(int64(1010), audio(1010).DTS)
+	at.Equal(int64(4000), video(4000).DTS)
+	ret = video(12000)
+	at.True(ret.Jump)
+	at.Equal(int64(4000), ret.DTS)
+	ret = audio(12010)
+	at.False(ret.Jump)
+	at.Equal(int64(4010), ret.DTS)
+	at.Equal(int64(4040), video(12040).DTS)
+}
@@ -0,0 +1,318 @@
+package flv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nextpkg/goav/packet"
+)
+
+// ProblemKind FLV流中的问题类型
+type ProblemKind int
+
+// Problem kind
+const (
+	// ProblemTruncated Tag不完整(一般是文件最后一个Tag被截断)
+	ProblemTruncated ProblemKind = iota
+	// ProblemPrevTagSize PreviousTagSize与Tag长度不一致
+	ProblemPrevTagSize
+	// ProblemTimestamp 时间戳回退或者跳跃超过阈值
+	ProblemTimestamp
+	// ProblemNoSeqHdr 数据帧之前缺少序列头
+	ProblemNoSeqHdr
+	// ProblemBadTag 无法解析的Tag
+	ProblemBadTag
+)
+
+// String 问题类型的名称
+func (k ProblemKind) String() string {
+	switch k {
+	case ProblemTruncated:
+		return "truncated tag"
+	case ProblemPrevTagSize:
+		return "previous tag size"
+	case ProblemTimestamp:
+		return "timestamp"
+	case ProblemNoSeqHdr:
+		return "missing sequence header"
+	case ProblemBadTag:
+		return "bad tag"
+	}
+
+	return fmt.Sprintf("unknown problem %d", int(k))
+}
+
+// Problem FLV流中的问题
+type Problem struct {
+	Offset int64       // 出问题的Tag在流中的偏移
+	Kind   ProblemKind // 问题类型
+	Detail string      // 问题描述
+}
+
+// String 问题描述
+func (p Problem) String() string {
+	return fmt.Sprintf("offset %d: %s: %s", p.Offset, p.Kind, p.Detail)
+}
+
+// validator 逐个检查Tag, 记录问题以及修复时需要的序列头和元数据
+type validator struct {
+	problems []Problem
+
+	norm    *Normalizer    // 时间戳回绕展开和回退(跳跃)检测
+	lastTs  [2]uint32      // 按包类型(视频,音频)记录的上一个时间戳
+	videoSq *packet.Packet // 第一个视频序列头
+	audioSq *packet.Packet // 第一个音频序列头
+	md      *packet.Packet // 第一个元数据
+}
+
+// newValidator 检查FLV流, maxJump: 同类型Tag时间戳向前跳跃的阈值, 小于等于0时只检查回退
+func newValidator(maxJump time.Duration) *validator {
+	v := &validator{
+		norm: NewNormalizer(),
+	}
+	v.norm.SetMaxJump(maxJump)
+
+	return v
+}
+
+// add 记录问题
+func (v *validator) add(offset int64, kind ProblemKind, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{
+		Offset: offset,
+		Kind:   kind,
+		Detail: fmt.Sprintf(format, args...),
+	})
+}
+
+// scan 遍历FLV流, 检查每个Tag, 可以正常解析的Tag交给fn处理
+func (v *validator) scan(r io.Reader, fn func(p *packet.Packet) error) error {
+	rd := NewReader(r)
+
+	err := rd.ReadHeader()
+	if err != nil {
+		return err
+	}
+
+	for {
+		offset := rd.Offset()
+
+		var tag Tag
+		data, prevTagSize, err := rd.readTag(&tag)
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			v.add(offset, ProblemTruncated, "tag ends after %d bytes", rd.Offset()-offset)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if prevTagSize != tagHdrLen+tag.flv.dataSize {
+			v.add(offset, ProblemPrevTagSize, "got %d, want %d", prevTagSize, tagHdrLen+tag.flv.dataSize)
+		}
+
+		p := &packet.Packet{}
+		err = tag.fill(p, data)
+		if err != nil {
+			v.add(offset, ProblemBadTag, "tag type %d: %s", tag.flv.fType, err)
+			continue
+		}
+
+		v.check(p, offset)
+
+		if fn != nil {
+			err = fn(p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// check 检查时间戳和序列头
+func (v *validator) check(p *packet.Packet, offset int64) {
+	switch p.Type {
+	case packet.PktMetadata:
+		if v.md == nil && isOnMetaData(p) {
+			v.md = p
+		}
+		return
+	case packet.PktVideo:
+		vh := p.Header.(packet.VideoPacketHeader)
+		if vh.IsSeqHdr() {
+			if v.videoSq == nil {
+				v.videoSq = p
+			}
+			return
+		}
+		if (vh.IsCodecAvc() || vh.IsExHeader()) && v.videoSq == nil && !vh.IsEndOfSeq() {
+			v.add(offset, ProblemNoSeqHdr, "video frame before video sequence header")
+		}
+	case packet.PktAudio:
+		ah := p.Header.(packet.AudioPacketHeader)
+		if ah.IsSoundSeqHdr() {
+			if v.audioSq == nil {
+				v.audioSq = p
+			}
+			return
+		}
+		if (ah.IsSoundAAC() || ah.IsExHeader()) && v.audioSq == nil {
+			v.add(offset, ProblemNoSeqHdr, "audio frame before audio sequence header")
+		}
+	}
+
+	// 同类型Tag的时间戳应单调递增(允许32位回绕), 且不应有超过阈值的跳跃
+	ts := p.TimeStamp
+	if v.norm.Normalize(p).Jump {
+		last := v.lastTs[p.Type]
+		if int32(ts-last) < 0 {
+			v.add(offset, ProblemTimestamp, "timestamp goes backwards from %d to %d", last, ts)
+		} else {
+			v.add(offset, ProblemTimestamp, "timestamp jumps from %d to %d", last, ts)
+		}
+	}
+	v.lastTs[p.Type] = ts
+}
+
+// Validate 检查FLV流, 返回发现的所有问题; FLV头错误或者读取失败时返回error
+// maxJump: 同类型Tag的时间戳向前跳跃超过该值时视为问题, 小于等于0时只检查回退(暂停, 稀疏的音频等间隔是合法的)
+func Validate(r io.Reader, maxJump time.Duration) ([]Problem, error) {
+	v := newValidator(maxJump)
+
+	err := v.scan(r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.problems, nil
+}
+
+// Repair 检查FLV流, 并通过 Mixer 写出修复后的FLV流, 返回发现的所有问题
+// 修复内容: 丢弃不完整和无法解析的Tag, 重新计算PreviousTagSize, 在文件头写入第一个序列头,
+// 丢弃第一个关键帧之前的视频帧, 通过 Normalizer 修正时间戳使之单调递增;
+// maxJump大于0时, 同类型Tag的时间戳向前跳跃超过该值也修正为连续
+func Repair(r io.ReadSeeker, w io.Writer, maxJump time.Duration) ([]Problem, error) {
+	v := newValidator(maxJump)
+
+	// 第一遍: 检查问题, 找到序列头和元数据
+	err := v.scan(r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewMixer(w)
+
+	// 无法解析的元数据不写入文件头, 按原位置写出
+	if v.md != nil {
+		md, err := decodeMetadata(v.md)
+		if err != nil || m.SaveMetadata(md) != nil {
+			v.md = nil
+		}
+	}
+	if v.videoSq != nil {
+		err = m.SaveAVCHeader(v.videoSq)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if v.audioSq != nil {
+		err = m.SaveAACHeader(v.audioSq)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = m.SetFlvHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	// 第二遍: 写出修复后的数据
+	fix := newRepairer(m, v.videoSq, v.audioSq, v.md, maxJump)
+
+	err = newValidator(maxJump).scan(r, fix.write)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.problems, nil
+}
+
+// repairer 写出修复后的数据
+type repairer struct {
+	m       *Mixer
+	videoSq *packet.Packet // 已写入文件头的视频序列头
+	audioSq *packet.Packet // 已写入文件头的音频序列头
+	md      *packet.Packet // 已写入文件头的元数据
+
+	hasVideo bool
+	gotKey   bool        // 是否已写出视频关键帧
+	norm     *Normalizer // 时间戳修正
+}
+
+// newRepairer 写出修复后的数据, maxJump: 修正为连续的向前跳跃的阈值, 小于等于0时只修正回退
+func newRepairer(m *Mixer, videoSq, audioSq, md *packet.Packet, maxJump time.Duration) *repairer {
+	f := &repairer{
+		m:        m,
+		videoSq:  videoSq,
+		audioSq:  audioSq,
+		md:       md,
+		hasVideo: videoSq != nil,
+		norm:     NewNormalizer(),
+	}
+	f.norm.SetMaxJump(maxJump)
+
+	return f
+}
+
+// write 修复时间戳后写出数据包
+func (f *repairer) write(p *packet.Packet) error {
+	switch p.Type {
+	case packet.PktMetadata:
+		// 第一个元数据已在文件头写出
+		if p == f.md || f.md != nil && bytes.Equal(p.Data, f.md.Data) {
+			return nil
+		}
+	case packet.PktVideo:
+		vh := p.Header.(packet.VideoPacketHeader)
+		if vh.IsSeqHdr() {
+			// 与文件头相同的序列头不再重复写出
+			if f.videoSq != nil && bytes.Equal(p.Data, f.videoSq.Data) {
+				return nil
+			}
+			f.videoSq = p
+		} else if !f.gotKey {
+			// 第一个关键帧之前的视频帧无法解码
+			if !vh.IsKeyFrame() {
+				return nil
+			}
+			f.gotKey = true
+		}
+	case packet.PktAudio:
+		ah := p.Header.(packet.AudioPacketHeader)
+		if ah.IsSoundSeqHdr() {
+			if f.audioSq != nil && bytes.Equal(p.Data, f.audioSq.Data) {
+				return nil
+			}
+			f.audioSq = p
+		} else if f.hasVideo && !f.gotKey {
+			return nil
+		}
+	}
+
+	return f.m.Mux(p, f.timestamp(p))
+}
+
+// timestamp 修正后的时间戳, 超过32位时按FLV的时间戳回绕
+func (f *repairer) timestamp(p *packet.Packet) uint32 {
+	return uint32(f.norm.Normalize(p).DTS)
+}
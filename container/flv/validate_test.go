@@ -0,0 +1,152 @@
+package flv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// newBrokenFlv 生成有问题的FLV: 序列头之前有关键帧, 时间戳回退, PreviousTagSize错误, 最后一个Tag被截断
+func newBrokenFlv(at *assert.Assertions) ([]byte, []int64) {
+	buf := bytes.NewBuffer(nil)
+	mux := newMuxer()
+
+	hdr, err := mux.header(packet.PktVideo)
+	at.Nil(err)
+	buf.Write(hdr)
+
+	var offsets []int64
+	write := func(pts uint32, data ...byte) {
+		offsets = append(offsets, int64(buf.Len()))
+		at.Nil(mux.mux(&packet.Packet{Type: packet.PktVideo, Data: data}, pts, buf))
+	}
+
+	// 0: 序列头之前的关键帧
+	write(0, 0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x65)
+	// 1: 序列头
+	write(0, 0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x4d, 0x00, 0x1e)
+	// 2: 关键帧
+	write(1000, 0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x65)
+	// 3: 时间戳回退
+	write(500, 0x27, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x41)
+	// 4: 时间戳正常, PreviousTagSize错误
+	write(540, 0x27, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x41)
+	b := buf.Bytes()
+	binary.BigEndian.PutUint32(b[len(b)-4:], 1)
+	// 5: 截断
+	write(580, 0x27, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x41)
+
+	return buf.Bytes()[:buf.Len()-6], offsets
+}
+
+func TestValidate(t *testing.T) {
+	at := assert.New(t)
+
+	data, offsets := newBrokenFlv(at)
+
+	problems, err := Validate(bytes.NewReader(data), 0)
+	at.Nil(err)
+	at.Equal([]Problem{
+		{Offset: offsets[0], Kind: ProblemNoSeqHdr, Detail: "video frame before video sequence header"},
+		{Offset: offsets[3], Kind: ProblemTimestamp, Detail: "timestamp goes backwards from 1000 to 500"},
+		{Offset: offsets[4], Kind: ProblemPrevTagSize, Detail: "got 1, want 21"},
+		{Offset: offsets[5], Kind: ProblemTruncated, Detail: "tag ends after 19 bytes"},
+	}, problems)
+
+	// FLV头错误
+	_, err = Validate(bytes.NewReader([]byte{'F', 'L', 'V'}), 0)
+	at.NotNil(err)
+}
+
+func TestRepair(t *testing.T) {
+	at := assert.New(t)
+
+	data, _ := newBrokenFlv(at)
+
+	w := bytes.NewBuffer(nil)
+	problems, err := Repair(bytes.NewReader(data), w, 0)
+	at.Nil(err)
+	at.Len(problems, 4)
+
+	// 修复后的文件没有问题
+	problems, err = Validate(bytes.NewReader(w.Bytes()), 0)
+	at.Nil(err)
+	at.Empty(problems)
+
+	// 序列头, 关键帧(0), 关键帧(1000), 普通帧(1000), 普通帧(1040)
+	r := NewReader(bytes.NewReader(w.Bytes()))
+	var ts []uint32
+	for {
+		var p packet.Packet
+		err = r.Read(&p)
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+		ts = append(ts, p.TimeStamp)
+	}
+	at.Equal([]uint32{0, 0, 1000, 1000, 1040}, ts)
+}
+
+func TestRepair_MaxJump(t *testing.T) {
+	at := assert.New(t)
+
+	buf := bytes.NewBuffer(nil)
+	mux := newMuxer()
+
+	hdr, err := mux.header(packet.PktVideo)
+	at.Nil(err)
+	buf.Write(hdr)
+
+	var offsets []int64
+	write := func(pts uint32, data ...byte) {
+		offsets = append(offsets, int64(buf.Len()))
+		at.Nil(mux.mux(&packet.Packet{Type: packet.PktVideo, Data: data}, pts, buf))
+	}
+
+	// 序列头, 关键帧(0), 暂停8秒后的关键帧(8000)和普通帧(8040)
+	write(0, 0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x4d, 0x00, 0x1e)
+	write(0, 0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x65)
+	write(8000, 0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x65)
+	write(8040, 0x27, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x41)
+
+	timestamps := func(b []byte) []uint32 {
+		var ts []uint32
+		r := NewReader(bytes.NewReader(b))
+		for {
+			var p packet.Packet
+			err := r.Read(&p)
+			if err == io.EOF {
+				return ts
+			}
+			at.Nil(err)
+			ts = append(ts, p.TimeStamp)
+		}
+	}
+
+	// 默认保留暂停
+	problems, err := Validate(bytes.NewReader(buf.Bytes()), 0)
+	at.Nil(err)
+	at.Empty(problems)
+
+	w := bytes.NewBuffer(nil)
+	problems, err = Repair(bytes.NewReader(buf.Bytes()), w, 0)
+	at.Nil(err)
+	at.Empty(problems)
+	at.Equal([]uint32{0, 0, 8000, 8040}, timestamps(w.Bytes()))
+
+	// 指定阈值时超过阈值的跳跃是问题, 修正为连续
+	problems, err = Validate(bytes.NewReader(buf.Bytes()), 5*time.Second)
+	at.Nil(err)
+	at.Equal([]Problem{{Offset: offsets[2], Kind: ProblemTimestamp, Detail: "timestamp jumps from 0 to 8000"}}, problems)
+
+	w.Reset()
+	_, err = Repair(bytes.NewReader(buf.Bytes()), w, 5*time.Second)
+	at.Nil(err)
+	at.Equal([]uint32{0, 0, 0, 40}, timestamps(w.Bytes()))
+}
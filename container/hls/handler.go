@@ -0,0 +1,90 @@
+package hls
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 阻塞刷新的超时时间相对于目标时长的倍数
+const blockTimeout = 3
+
+// Handler 播放列表的HTTP处理器
+// 开启LL-HLS时支持阻塞刷新: 带有 _HLS_msn(和 _HLS_part)的请求等到播放列表包含指定的分片(部分分片)之后才返回
+func (s *Segmenter) Handler() http.Handler {
+	return http.HandlerFunc(s.servePlaylist)
+}
+
+// servePlaylist 输出播放列表
+func (s *Segmenter) servePlaylist(w http.ResponseWriter, r *http.Request) {
+	// _HLS_part 必须和 _HLS_msn 一起使用
+	msn, part, ok := blockingRequest(r)
+	if !ok || (msn < 0 && part >= 0) {
+		http.Error(w, "invalid _HLS_msn or _HLS_part", http.StatusBadRequest)
+		return
+	}
+
+	// 没有开启LL-HLS时忽略阻塞刷新的参数
+	if !s.playlist.CanBlockReload {
+		msn = -1
+	}
+
+	timer := time.NewTimer(blockTimeout * s.target)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		pub, update := s.published, s.update
+		s.mu.Unlock()
+
+		// 请求的分片超过最后一个分片加2时立即返回
+		if msn > pub.next+1 {
+			http.Error(w, "_HLS_msn is too far in the future", http.StatusBadRequest)
+			return
+		}
+
+		if pub.ended || msn < 0 || msn < pub.next || (msn == pub.next && part >= 0 && part < pub.parts) {
+			if pub.data == nil {
+				http.NotFound(w, r)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			_, _ = w.Write(pub.data)
+			return
+		}
+
+		select {
+		case <-update:
+		case <-timer.C:
+			http.Error(w, "playlist update timeout", http.StatusServiceUnavailable)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// blockingRequest 解析 _HLS_msn 和 _HLS_part, 没有时为-1
+func blockingRequest(r *http.Request) (int64, int, bool) {
+	msn, part := int64(-1), -1
+	q := r.URL.Query()
+
+	if v := q.Get("_HLS_msn"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		msn = n
+	}
+
+	if v := q.Get("_HLS_part"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		part = n
+	}
+
+	return msn, part, true
+}
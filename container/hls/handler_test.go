@@ -0,0 +1,109 @@
+package hls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// get 请求播放列表, 返回状态码和内容
+func get(h http.Handler, query string) (int, string) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/live.m3u8"+query, nil))
+
+	return w.Code, w.Body.String()
+}
+
+func TestSegmenter_Handler(t *testing.T) {
+	at := assert.New(t)
+
+	ms := NewMemoryStorage()
+	s := NewSegmenter(ms, "live", 2*time.Second)
+	h := s.Handler()
+
+	code, _ := get(h, "")
+	at.Equal(http.StatusNotFound, code)
+
+	ts := newTestStream(at, s, true)
+	ts.run(0, 2500, true)
+
+	// 没有开启LL-HLS时不阻塞
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/live.m3u8?_HLS_msn=1", nil))
+	b, _ := ms.Get("live.m3u8")
+	at.Equal(http.StatusOK, w.Code)
+	at.Equal("application/vnd.apple.mpegurl", w.Header().Get("Content-Type"))
+	at.Equal(string(b), w.Body.String())
+}
+
+func TestSegmenter_BlockingReload(t *testing.T) {
+	at := assert.New(t)
+
+	ms := NewMemoryStorage()
+	s := NewSegmenter(ms, "live", 2*time.Second)
+	s.EnableLowLatency(200 * time.Millisecond)
+	h := s.Handler()
+
+	// 第0个分片已完成, 第1个分片有2个部分分片
+	ts := newTestStream(at, s, true)
+	ts.run(0, 2600, true)
+
+	for _, query := range []string{"", "?_HLS_msn=0", "?_HLS_msn=1&_HLS_part=1"} {
+		code, body := get(h, query)
+		at.Equal(http.StatusOK, code, query)
+		at.Contains(body, `URI="live1.1.ts"`, query)
+	}
+
+	for _, query := range []string{"?_HLS_part=1", "?_HLS_msn=a", "?_HLS_msn=1&_HLS_part=-1", "?_HLS_msn=3"} {
+		code, _ := get(h, query)
+		at.Equal(http.StatusBadRequest, code, query)
+	}
+
+	// 等到部分分片生成之后才返回
+	part := make(chan string)
+	go func() {
+		_, body := get(h, "?_HLS_msn=1&_HLS_part=2")
+		part <- body
+	}()
+	segment := make(chan string)
+	go func() {
+		_, body := get(h, "?_HLS_msn=1")
+		segment <- body
+	}()
+
+	select {
+	case <-part:
+		t.Fatal("blocking reload returned before the part exists")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	ts.run(2600, 2800, true)
+	at.Contains(<-part, `URI="live1.2.ts"`)
+
+	ts.run(2800, 4200, true)
+	body := <-segment
+	at.Contains(body, "#EXTINF:2.000,\nlive1.ts\n")
+	at.Contains(body, `#EXT-X-PRELOAD-HINT:TYPE=PART,URI="live2.0.ts"`)
+
+	// 结束后不再阻塞
+	at.Nil(s.Close())
+	code, body := get(h, "?_HLS_msn=3")
+	at.Equal(http.StatusOK, code)
+	at.Contains(body, "#EXT-X-ENDLIST\n")
+}
+
+func TestSegmenter_BlockingTimeout(t *testing.T) {
+	at := assert.New(t)
+
+	s := NewSegmenter(NewMemoryStorage(), "live", 100*time.Millisecond)
+	s.EnableLowLatency(20 * time.Millisecond)
+
+	// 超过3个目标时长没有更新
+	start := time.Now()
+	code, _ := get(s.Handler(), "?_HLS_msn=0")
+	at.Equal(http.StatusServiceUnavailable, code)
+	at.True(time.Since(start) >= 300*time.Millisecond)
+}
@@ -0,0 +1,142 @@
+package hls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// KeyMethod 分片的加密方式(EXT-X-KEY的METHOD)
+type KeyMethod int
+
+// Key method
+const (
+	// KeyNone 不加密
+	KeyNone KeyMethod = iota
+	// KeyAES128 整个分片使用AES-128-CBC加密, PKCS7填充
+	KeyAES128
+	// KeySampleAES 只加密H264和AAC的部分媒体数据, TS的结构保持明文
+	KeySampleAES
+)
+
+// String 加密方式的名称
+func (m KeyMethod) String() string {
+	switch m {
+	case KeyNone:
+		return "NONE"
+	case KeyAES128:
+		return "AES-128"
+	case KeySampleAES:
+		return "SAMPLE-AES"
+	}
+
+	return fmt.Sprintf("unknown key method %d", int(m))
+}
+
+// Key 分片的密钥
+type Key struct {
+	Method KeyMethod
+	URI    string // 获取密钥的地址
+	Key    []byte // 16字节
+	IV     []byte // 16字节, 为空时使用分片的序号(不输出IV属性)
+}
+
+// KeyFunc 返回序号为seq的分片的密钥, 在每个分片开始时调用
+// 返回的密钥(URI或IV)与上一个分片不同时, 播放列表中输出新的 EXT-X-KEY, 用于轮换密钥
+type KeyFunc func(seq int64) (*Key, error)
+
+// iv 分片使用的IV, 没有指定时为分片序号的大端表示
+func (k *Key) iv(seq int64) []byte {
+	if len(k.IV) > 0 {
+		return k.IV
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(seq))
+	return iv
+}
+
+// equal 判断两个密钥在播放列表中是否相同
+func (k *Key) equal(o *Key) bool {
+	if k == nil || o == nil {
+		return k == o
+	}
+
+	return k.Method == o.Method && k.URI == o.URI && bytes.Equal(k.IV, o.IV)
+}
+
+// tag EXT-X-KEY标签, k为nil时为 METHOD=NONE
+func (k *Key) tag() string {
+	if k == nil || k.Method == KeyNone {
+		return "#EXT-X-KEY:METHOD=NONE"
+	}
+
+	tag := fmt.Sprintf("#EXT-X-KEY:METHOD=%s,URI=\"%s\"", k.Method, k.URI)
+	if len(k.IV) > 0 {
+		tag += fmt.Sprintf(",IV=0x%x", k.IV)
+	}
+
+	return tag
+}
+
+// cbcWriter AES-128-CBC加密的输出, 关闭时使用PKCS7填充最后一个块
+type cbcWriter struct {
+	w   io.WriteCloser
+	enc cipher.BlockMode
+	buf []byte // 不足一个块的数据
+}
+
+// newCBCWriter AES-128-CBC加密的输出
+func newCBCWriter(w io.WriteCloser, key, iv []byte) (*cbcWriter, error) {
+	if len(key) != aes.BlockSize || len(iv) != aes.BlockSize {
+		return nil, errors.New("aes-128 key and iv must be 16 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cbcWriter{
+		w:   w,
+		enc: cipher.NewCBCEncrypter(block, iv),
+	}, nil
+}
+
+// Write 加密完整的块并写出
+func (c *cbcWriter) Write(b []byte) (int, error) {
+	c.buf = append(c.buf, b...)
+
+	n := len(c.buf) - len(c.buf)%aes.BlockSize
+	if n > 0 {
+		c.enc.CryptBlocks(c.buf[:n], c.buf[:n])
+		_, err := c.w.Write(c.buf[:n])
+		if err != nil {
+			return 0, err
+		}
+		c.buf = append(c.buf[:0], c.buf[n:]...)
+	}
+
+	return len(b), nil
+}
+
+// Close 填充并加密最后一个块, 关闭输出
+func (c *cbcWriter) Close() error {
+	pad := aes.BlockSize - len(c.buf)
+	for i := 0; i < pad; i++ {
+		c.buf = append(c.buf, byte(pad))
+	}
+	c.enc.CryptBlocks(c.buf, c.buf)
+
+	_, err := c.w.Write(c.buf)
+	if err != nil {
+		_ = c.w.Close()
+		return err
+	}
+
+	return c.w.Close()
+}
@@ -0,0 +1,75 @@
+package hls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testKey = []byte("0123456789abcdef")
+
+// decrypt AES-128-CBC解密并去除PKCS7填充
+func decrypt(at *assert.Assertions, b, key, iv []byte) []byte {
+	at.Equal(0, len(b)%aes.BlockSize)
+
+	block, err := aes.NewCipher(key)
+	at.Nil(err)
+	out := make([]byte, len(b))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, b)
+
+	pad := int(out[len(out)-1])
+	at.True(pad > 0 && pad <= aes.BlockSize)
+	return out[:len(out)-pad]
+}
+
+func TestCbcWriter(t *testing.T) {
+	at := assert.New(t)
+
+	ms := NewMemoryStorage()
+	iv := (&Key{}).iv(5)
+	at.Equal([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 5}, iv)
+
+	for _, n := range []int{0, 15, 16, 188 * 3} {
+		f, _ := ms.Create("a.ts")
+		w, err := newCBCWriter(f, testKey, iv)
+		at.Nil(err)
+
+		// 分多次写入
+		src := bytes.Repeat([]byte{0x47}, n)
+		_, err = w.Write(src[:n/2])
+		at.Nil(err)
+		_, err = w.Write(src[n/2:])
+		at.Nil(err)
+		at.Nil(w.Close())
+
+		b, _ := ms.Get("a.ts")
+		at.Equal(n/aes.BlockSize*aes.BlockSize+aes.BlockSize, len(b))
+		at.Equal(src, decrypt(at, b, testKey, iv))
+	}
+
+	f, _ := ms.Create("b.ts")
+	_, err := newCBCWriter(f, testKey[:8], iv)
+	at.NotNil(err)
+}
+
+func TestKey_Tag(t *testing.T) {
+	at := assert.New(t)
+
+	k := &Key{Method: KeyAES128, URI: "key0.bin", Key: testKey}
+	at.Equal(`#EXT-X-KEY:METHOD=AES-128,URI="key0.bin"`, k.tag())
+
+	k = &Key{Method: KeySampleAES, URI: "key1.bin", IV: []byte{0x00, 0x01, 0xfe, 0xff}}
+	at.Equal(`#EXT-X-KEY:METHOD=SAMPLE-AES,URI="key1.bin",IV=0x0001feff`, k.tag())
+
+	var none *Key
+	at.Equal("#EXT-X-KEY:METHOD=NONE", none.tag())
+
+	// 播放列表中只比较方式, URI和IV
+	at.True(k.equal(&Key{Method: KeySampleAES, URI: "key1.bin", Key: testKey, IV: []byte{0x00, 0x01, 0xfe, 0xff}}))
+	at.False(k.equal(&Key{Method: KeySampleAES, URI: "key2.bin", IV: []byte{0x00, 0x01, 0xfe, 0xff}}))
+	at.False(k.equal(nil))
+	at.True(none.equal(nil))
+}
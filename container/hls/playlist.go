@@ -0,0 +1,203 @@
+// Package hls HLS的分片和播放列表, 使用 ts.Mixer 生成TS分片
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+)
+
+// 播放列表中的时间格式(ISO 8601, 毫秒)
+const programDateTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// PlaylistType 播放列表类型
+type PlaylistType int
+
+// Playlist type
+const (
+	// PlaylistLive 直播, 只保留最近的分片(滑动窗口)
+	PlaylistLive PlaylistType = iota
+	// PlaylistEvent 事件, 保留所有分片, 分片只能追加
+	PlaylistEvent
+	// PlaylistVOD 点播, 结束时一次性写出完整的播放列表
+	PlaylistVOD
+)
+
+// String 播放列表类型的名称(EXT-X-PLAYLIST-TYPE)
+func (t PlaylistType) String() string {
+	switch t {
+	case PlaylistLive:
+		return "LIVE"
+	case PlaylistEvent:
+		return "EVENT"
+	case PlaylistVOD:
+		return "VOD"
+	}
+
+	return fmt.Sprintf("unknown playlist type %d", int(t))
+}
+
+// Part LL-HLS的部分分片(EXT-X-PART)
+type Part struct {
+	URI         string
+	Duration    time.Duration
+	Independent bool // 是否以独立帧(视频关键帧, 纯音频时的任意帧)开始
+}
+
+// Segment 播放列表中的分片
+type Segment struct {
+	URI             string
+	Duration        time.Duration
+	Discontinuity   bool      // 分片之前是否有 EXT-X-DISCONTINUITY
+	ProgramDateTime time.Time // 分片第一帧的时间, 为零值时不输出 EXT-X-PROGRAM-DATE-TIME
+	Parts           []Part    // 组成分片的部分分片, 只有最近的分片才会输出
+	Key             *Key      // 分片的密钥, 为nil时不加密
+}
+
+// MediaPlaylist 媒体播放列表
+type MediaPlaylist struct {
+	Type                  PlaylistType
+	TargetDuration        time.Duration // 分片的目标时长, 实际输出时不小于最长的分片; 直播时 Segmenter 保证它只增不减
+	MediaSequence         int64         // 第一个分片的序号
+	DiscontinuitySequence int64         // 第一个分片之前(已移出播放列表)的不连续点个数
+	Segments              []Segment
+	Ended                 bool // 是否输出 EXT-X-ENDLIST
+
+	// LL-HLS, PartTarget为0时不输出部分分片
+	PartTarget     time.Duration // EXT-X-PART-INF的PART-TARGET, 每个部分分片的时长都不能超过它
+	CanBlockReload bool          // 是否支持 _HLS_msn/_HLS_part 阻塞刷新
+	Parts          []Part        // 正在生成的分片中已完成的部分分片
+	PreloadHint    string        // 下一个部分分片(EXT-X-PRELOAD-HINT), 为空时不输出
+	Key            *Key          // 正在生成的分片的密钥, 用于部分分片
+}
+
+// 部分分片的保留时长和 PART-HOLD-BACK 相对于目标时长的倍数
+const (
+	partWindow   = 3
+	partHoldBack = 3
+)
+
+// targetDuration EXT-X-TARGETDURATION: 每个分片的时长四舍五入后都不能超过它
+func (pl *MediaPlaylist) targetDuration() int {
+	target := int(math.Ceil(pl.TargetDuration.Seconds()))
+	for _, s := range pl.Segments {
+		if d := int(math.Round(s.Duration.Seconds())); d > target {
+			target = d
+		}
+	}
+
+	return target
+}
+
+// partsFrom 第一个需要输出部分分片的分片, 距离播放列表末尾超过3个目标时长的部分分片不再输出
+func (pl *MediaPlaylist) partsFrom() int {
+	var d time.Duration
+	for _, p := range pl.Parts {
+		d += p.Duration
+	}
+
+	limit := partWindow * time.Duration(pl.targetDuration()) * time.Second
+	i := len(pl.Segments)
+	for i > 0 && d+pl.Segments[i-1].Duration <= limit {
+		i--
+		d += pl.Segments[i].Duration
+	}
+
+	return i
+}
+
+// writeParts 输出部分分片
+func writeParts(b *bytes.Buffer, parts []Part) {
+	for _, p := range parts {
+		fmt.Fprintf(b, "#EXT-X-PART:DURATION=%.3f,URI=\"%s\"", p.Duration.Seconds(), p.URI)
+		if p.Independent {
+			b.WriteString(",INDEPENDENT=YES")
+		}
+		b.WriteByte('\n')
+	}
+}
+
+// Encode 生成m3u8
+func (pl *MediaPlaylist) Encode() []byte {
+	b := bytes.NewBuffer(make([]byte, 0, 256+64*len(pl.Segments)))
+
+	b.WriteString("#EXTM3U\n")
+	// EXT-X-PART等LL-HLS的标签需要版本6
+	if pl.PartTarget > 0 {
+		b.WriteString("#EXT-X-VERSION:6\n")
+	} else {
+		b.WriteString("#EXT-X-VERSION:3\n")
+	}
+	fmt.Fprintf(b, "#EXT-X-TARGETDURATION:%d\n", pl.targetDuration())
+	if pl.CanBlockReload || pl.PartTarget > 0 {
+		b.WriteString("#EXT-X-SERVER-CONTROL:")
+		if pl.CanBlockReload {
+			b.WriteString("CAN-BLOCK-RELOAD=YES")
+		}
+		if pl.PartTarget > 0 {
+			if pl.CanBlockReload {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, "PART-HOLD-BACK=%.3f", (partHoldBack * pl.PartTarget).Seconds())
+		}
+		b.WriteByte('\n')
+	}
+	if pl.PartTarget > 0 {
+		fmt.Fprintf(b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", pl.PartTarget.Seconds())
+	}
+	fmt.Fprintf(b, "#EXT-X-MEDIA-SEQUENCE:%d\n", pl.MediaSequence)
+	if pl.DiscontinuitySequence > 0 {
+		fmt.Fprintf(b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", pl.DiscontinuitySequence)
+	}
+	if pl.Type != PlaylistLive {
+		fmt.Fprintf(b, "#EXT-X-PLAYLIST-TYPE:%s\n", pl.Type)
+	}
+
+	partsFrom := len(pl.Segments)
+	if pl.PartTarget > 0 {
+		partsFrom = pl.partsFrom()
+	}
+
+	// 密钥变化时输出 EXT-X-KEY
+	var key *Key
+	writeKey := func(k *Key) {
+		if !k.equal(key) {
+			b.WriteString(k.tag())
+			b.WriteByte('\n')
+			key = k
+		}
+	}
+
+	for i, s := range pl.Segments {
+		if s.Discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		writeKey(s.Key)
+		if !s.ProgramDateTime.IsZero() {
+			fmt.Fprintf(b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", s.ProgramDateTime.UTC().Format(programDateTimeFormat))
+		}
+		if i >= partsFrom {
+			writeParts(b, s.Parts)
+		}
+		fmt.Fprintf(b, "#EXTINF:%.3f,\n", s.Duration.Seconds())
+		b.WriteString(s.URI)
+		b.WriteByte('\n')
+	}
+
+	if pl.PartTarget > 0 {
+		if len(pl.Parts) > 0 {
+			writeKey(pl.Key)
+		}
+		writeParts(b, pl.Parts)
+		if pl.PreloadHint != "" {
+			fmt.Fprintf(b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", pl.PreloadHint)
+		}
+	}
+
+	if pl.Ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+
+	return b.Bytes()
+}
@@ -0,0 +1,132 @@
+package hls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMediaPlaylist_Encode(t *testing.T) {
+	at := assert.New(t)
+
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	pl := &MediaPlaylist{
+		Type:                  PlaylistLive,
+		TargetDuration:        2 * time.Second,
+		MediaSequence:         10,
+		DiscontinuitySequence: 1,
+		Segments: []Segment{
+			{URI: "a10.ts", Duration: 2000 * time.Millisecond, ProgramDateTime: start},
+			{URI: "a11.ts", Duration: 2600 * time.Millisecond, Discontinuity: true},
+		},
+	}
+
+	// 分片时长四舍五入后超过目标时长
+	at.Equal(`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:3
+#EXT-X-MEDIA-SEQUENCE:10
+#EXT-X-DISCONTINUITY-SEQUENCE:1
+#EXT-X-PROGRAM-DATE-TIME:2020-01-02T03:04:05.000Z
+#EXTINF:2.000,
+a10.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:2.600,
+a11.ts
+`, string(pl.Encode()))
+
+	pl = &MediaPlaylist{
+		Type:           PlaylistVOD,
+		TargetDuration: 1500 * time.Millisecond,
+		Segments:       []Segment{{URI: "b0.ts", Duration: 1200 * time.Millisecond}},
+		Ended:          true,
+	}
+	at.Equal(`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXTINF:1.200,
+b0.ts
+#EXT-X-ENDLIST
+`, string(pl.Encode()))
+
+	at.Equal("EVENT", PlaylistEvent.String())
+}
+
+func TestMediaPlaylist_EncodeParts(t *testing.T) {
+	at := assert.New(t)
+
+	part := func(uri string, independent bool) Part {
+		return Part{URI: uri, Duration: 500 * time.Millisecond, Independent: independent}
+	}
+
+	pl := &MediaPlaylist{
+		TargetDuration: time.Second,
+		MediaSequence:  7,
+		Segments: []Segment{
+			{URI: "a7.ts", Duration: time.Second, Parts: []Part{part("a7.0.ts", true), part("a7.1.ts", false)}},
+			{URI: "a8.ts", Duration: time.Second, Parts: []Part{part("a8.0.ts", true), part("a8.1.ts", false)}},
+			{URI: "a9.ts", Duration: time.Second, Parts: []Part{part("a9.0.ts", true), part("a9.1.ts", false)}},
+		},
+		PartTarget:  500 * time.Millisecond,
+		Parts:       []Part{part("a10.0.ts", true)},
+		PreloadHint: "a10.1.ts",
+	}
+
+	// 只输出最近3秒内的部分分片, 没有阻塞刷新时只有 PART-HOLD-BACK
+	at.Equal(`#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-TARGETDURATION:1
+#EXT-X-SERVER-CONTROL:PART-HOLD-BACK=1.500
+#EXT-X-PART-INF:PART-TARGET=0.500
+#EXT-X-MEDIA-SEQUENCE:7
+#EXTINF:1.000,
+a7.ts
+#EXT-X-PART:DURATION=0.500,URI="a8.0.ts",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.500,URI="a8.1.ts"
+#EXTINF:1.000,
+a8.ts
+#EXT-X-PART:DURATION=0.500,URI="a9.0.ts",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.500,URI="a9.1.ts"
+#EXTINF:1.000,
+a9.ts
+#EXT-X-PART:DURATION=0.500,URI="a10.0.ts",INDEPENDENT=YES
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="a10.1.ts"
+`, string(pl.Encode()))
+}
+
+func TestMediaPlaylist_EncodeKey(t *testing.T) {
+	at := assert.New(t)
+
+	k0 := &Key{Method: KeyAES128, URI: "k0"}
+	k1 := &Key{Method: KeyAES128, URI: "k1"}
+	pl := &MediaPlaylist{
+		TargetDuration: time.Second,
+		Segments: []Segment{
+			{URI: "a0.ts", Duration: time.Second, Key: k0},
+			{URI: "a1.ts", Duration: time.Second, Key: &Key{Method: KeyAES128, URI: "k0"}},
+			{URI: "a2.ts", Duration: time.Second, Key: k1},
+			{URI: "a3.ts", Duration: time.Second},
+		},
+	}
+
+	// 只在密钥变化时输出 EXT-X-KEY
+	at.Equal(`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:1
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-KEY:METHOD=AES-128,URI="k0"
+#EXTINF:1.000,
+a0.ts
+#EXTINF:1.000,
+a1.ts
+#EXT-X-KEY:METHOD=AES-128,URI="k1"
+#EXTINF:1.000,
+a2.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:1.000,
+a3.ts
+`, string(pl.Encode()))
+}
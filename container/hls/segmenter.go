@@ -0,0 +1,546 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nextpkg/goav/container/ts"
+	"github.com/nextpkg/goav/container/ts/sampleaes"
+	"github.com/nextpkg/goav/packet"
+)
+
+// 默认的配置
+const (
+	defaultWindow = 5 // 直播播放列表中的分片数
+)
+
+// Segmenter HLS分片器, 按目标时长在视频关键帧处切分TS流(纯音频时任意音频帧都可以切分)
+// 每个分片以SDT, PAT和PMT开始, 视频关键帧带有缓存的SPS/PPS, 分片完成后更新播放列表;
+// 直播播放列表只保留最近的分片, 移出播放列表的分片在一段时间后通过 Storage 删除;
+// 开启LL-HLS时同时输出部分分片, 每完成一个部分分片就更新播放列表
+type Segmenter struct {
+	storage  Storage
+	name     string // 播放列表为 name.m3u8, 分片为 name<序号>.ts
+	mixer    *ts.Mixer
+	playlist *MediaPlaylist
+	window   int
+	target   time.Duration // 分片的目标时长, 用于切分; 播放列表中的目标时长只增不减
+
+	w        io.WriteCloser // 当前分片的输出, 为nil时还未开始
+	seq      int64          // 当前分片的序号
+	start    int64          // 当前分片第一个包的DTS, 毫秒
+	last     int64          // 最近一个包的DTS, 毫秒
+	frames   [2]int64       // 按包类型(视频,音频)记录的上一个DTS
+	gap      int64          // 最近的帧间隔, 用于计算最后一个分片的时长
+	hasVideo bool
+	hasAudio bool
+
+	discontinuity bool      // 下一个分片之前是否有不连续点
+	segDisc       bool      // 当前分片之前是否有不连续点
+	dateTime      time.Time // 下一个分片第一帧的时间, 为零值时不输出
+	expired       []Segment // 已移出播放列表, 等待删除的分片
+
+	// LL-HLS的部分分片
+	part        io.WriteCloser // 当前部分分片的输出, 为nil时没有开启LL-HLS
+	partSeq     int            // 当前部分分片在分片中的序号
+	partStart   int64          // 当前部分分片第一个包的DTS, 毫秒
+	independent bool           // 当前部分分片是否以独立帧开始
+
+	// 加密
+	method KeyMethod
+	keys   KeyFunc
+	key    *Key                 // 当前分片的密钥
+	sample *sampleaes.Encrypter // SAMPLE-AES加密, 每个分片开始时设置密钥
+
+	// 已发布的播放列表, 供HTTP处理器并发读取
+	mu        sync.Mutex
+	published published
+	update    chan struct{} // 发布新的播放列表时关闭
+}
+
+// published 已发布的播放列表
+type published struct {
+	data  []byte
+	next  int64 // 正在生成的分片的序号
+	parts int   // 正在生成的分片中已完成的部分分片数
+	ended bool
+}
+
+// NewSegmenter HLS分片器, 默认为直播播放列表, 窗口为5个分片
+// name: 播放列表和分片的文件名前缀; target: 分片的目标时长
+func NewSegmenter(storage Storage, name string, target time.Duration) *Segmenter {
+	return &Segmenter{
+		storage: storage,
+		name:    name,
+		mixer:   ts.NewMixer(nil),
+		playlist: &MediaPlaylist{
+			Type:           PlaylistLive,
+			TargetDuration: target,
+		},
+		window: defaultWindow,
+		target: target,
+		frames: [2]int64{-1, -1},
+		update: make(chan struct{}),
+	}
+}
+
+// Mixer TS混合器, 可以在写入数据包之前设置节目, ID3和固定码率等
+func (s *Segmenter) Mixer() *ts.Mixer {
+	return s.mixer
+}
+
+// SetPlaylistType 设置播放列表类型, 需要在写入数据包之前设置
+func (s *Segmenter) SetPlaylistType(t PlaylistType) {
+	s.playlist.Type = t
+}
+
+// SetWindow 设置直播播放列表中的分片数
+func (s *Segmenter) SetWindow(n int) {
+	if n > 0 {
+		s.window = n
+	}
+}
+
+// EnableLowLatency 开启LL-HLS, 每隔partTarget输出一个部分分片(EXT-X-PART), 播放列表支持阻塞刷新
+// 需要在写入数据包之前设置, 适用于直播和事件播放列表
+func (s *Segmenter) EnableLowLatency(partTarget time.Duration) {
+	s.playlist.PartTarget = partTarget
+	s.playlist.CanBlockReload = true
+}
+
+// SetEncryption 设置分片的加密方式和密钥, 需要在写入数据包之前设置
+// AES-128加密整个分片(开启LL-HLS时部分分片单独加密); SAMPLE-AES只加密H264和AAC的媒体数据, 其它编码保持明文
+func (s *Segmenter) SetEncryption(method KeyMethod, keys KeyFunc) error {
+	if method != KeyNone && keys == nil {
+		return errors.New("no key func for encryption")
+	}
+
+	s.method, s.keys = method, keys
+	switch method {
+	case KeyNone, KeyAES128:
+		s.sample = nil
+		s.mixer.EnableSampleAES(nil)
+	case KeySampleAES:
+		// 第一个分片开始时设置密钥, 之前的数据不会被复用
+		s.sample = &sampleaes.Encrypter{}
+		s.mixer.EnableSampleAES(s.sample)
+	default:
+		return fmt.Errorf("unsupported key method %d", int(method))
+	}
+
+	return nil
+}
+
+// SetProgramDateTime 设置下一个分片第一帧的时间, 之后的分片按照分片时长累加, 输出 EXT-X-PROGRAM-DATE-TIME
+func (s *Segmenter) SetProgramDateTime(t time.Time) {
+	s.dateTime = t
+}
+
+// Discontinuity 标记不连续点(例如时间戳重置, 编码参数变化), 在下一个可切分的位置开始新的分片
+func (s *Segmenter) Discontinuity() {
+	if s.w != nil {
+		s.discontinuity = true
+	}
+}
+
+// Write 写入已解复用的数据包(p.Header不能为空), 数据包不会被修改
+func (s *Segmenter) Write(p *packet.Packet) error {
+	var cut bool
+
+	switch p.Type {
+	case packet.PktMetadata:
+		// 开启ID3时转换为ID3元数据
+		if s.w == nil {
+			return nil
+		}
+	case packet.PktVideo:
+		vh, ok := p.Header.(packet.VideoPacketHeader)
+		if !ok {
+			return errors.New("unexpected video packet header")
+		}
+
+		if vh.IsSeqHdr() {
+			s.hasVideo = true
+			return s.mixer.SaveAVCHeader(p)
+		}
+		// 序列头之前的视频无法解码, 直接丢弃
+		if !s.hasVideo {
+			return nil
+		}
+
+		cut = vh.IsKeyFrame()
+	case packet.PktAudio:
+		ah, ok := p.Header.(packet.AudioPacketHeader)
+		if !ok {
+			return errors.New("unexpected audio packet header")
+		}
+
+		// 有序列头的编码使用序列头, MP3等没有序列头的编码使用第一个音频包
+		if ah.IsSoundSeqHdr() || !s.hasAudio {
+			s.hasAudio = true
+			err := s.mixer.SaveAudioHeader(p)
+			if err != nil || ah.IsSoundSeqHdr() {
+				return err
+			}
+		}
+
+		cut = !s.hasVideo
+	default:
+		return fmt.Errorf("unexpected packet type %d", p.Type)
+	}
+
+	dts := int64(p.TimeStamp)
+	if cut && (s.w == nil || s.discontinuity || dts-s.start >= s.target.Milliseconds()) {
+		err := s.rotate(dts)
+		if err != nil {
+			return err
+		}
+	} else if s.part != nil && (p.Type == packet.PktVideo || cut) && s.partDue(p.Type, dts) {
+		// 部分分片在视频帧(纯音频时为音频帧)处切分
+		err := s.cutPart(dts, cut)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 第一个分片开始之前的数据无法解码, 直接丢弃
+	if s.w == nil {
+		return nil
+	}
+
+	// 记录帧间隔和最后的时间戳
+	if p.Type == packet.PktVideo || p.Type == packet.PktAudio {
+		if last := s.frames[p.Type]; last >= 0 && dts > last {
+			s.gap = dts - last
+		}
+		s.frames[p.Type] = dts
+	}
+	if dts > s.last {
+		s.last = dts
+	}
+
+	var cts uint32
+	if vh, ok := p.Header.(packet.VideoPacketHeader); ok {
+		cts = uint32(vh.CompositionTime())
+	}
+
+	// Mux会修改p.Media, 使用副本
+	q := *p
+	err := s.mixer.Update(&q, q.TimeStamp, cts)
+	if err != nil {
+		return err
+	}
+
+	return s.mixer.Mux(&q)
+}
+
+// rotate 结束当前分片, 开始下一个分片
+func (s *Segmenter) rotate(dts int64) error {
+	if s.w != nil {
+		// 不连续时时间戳可能重置, 使用最后一帧计算时长
+		end := dts
+		if s.discontinuity {
+			end = s.last + s.gap
+		}
+
+		if s.part != nil {
+			err := s.finishPart(end)
+			if err != nil {
+				return err
+			}
+			s.playlist.PreloadHint = s.partName(s.seq+1, 0)
+		}
+
+		err := s.finish(end)
+		if err != nil {
+			return err
+		}
+		s.seq++
+	}
+
+	err := s.rotateKey()
+	if err != nil {
+		return err
+	}
+
+	w, err := s.create(s.segmentName(s.seq))
+	if err != nil {
+		return err
+	}
+
+	s.w = w
+	s.start, s.last = dts, dts
+	s.segDisc, s.discontinuity = s.discontinuity, false
+	s.frames = [2]int64{-1, -1}
+	s.mixer.SetWriter(w)
+
+	if s.playlist.PartTarget > 0 {
+		s.partSeq = 0
+		err = s.openPart(dts, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.mixer.SetTsHeader()
+}
+
+// rotateKey 获取当前分片的密钥
+func (s *Segmenter) rotateKey() error {
+	if s.method == KeyNone {
+		return nil
+	}
+
+	key, err := s.keys(s.seq)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return fmt.Errorf("no key for segment %d", s.seq)
+	}
+
+	k := *key
+	k.Method = s.method
+	s.key = &k
+	s.playlist.Key = s.key
+
+	if s.sample != nil {
+		return s.sample.SetKey(k.Key, k.iv(s.seq))
+	}
+
+	return nil
+}
+
+// create 创建分片或部分分片, AES-128时加密整个输出
+func (s *Segmenter) create(name string) (io.WriteCloser, error) {
+	w, err := s.storage.Create(name)
+	if err != nil || s.method != KeyAES128 {
+		return w, err
+	}
+
+	cw, err := newCBCWriter(w, s.key.Key, s.key.iv(s.seq))
+	if err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+
+	return cw, nil
+}
+
+// partDue 判断是否需要切分部分分片: 加上这一帧之后部分分片的时长会超过 PART-TARGET
+func (s *Segmenter) partDue(t int, dts int64) bool {
+	if dts <= s.partStart {
+		return false
+	}
+
+	var gap int64
+	if last := s.frames[t]; last >= 0 && dts > last {
+		gap = dts - last
+	}
+
+	return dts-s.partStart+gap > s.playlist.PartTarget.Milliseconds()
+}
+
+// cutPart 结束当前部分分片, 开始下一个部分分片并发布播放列表
+func (s *Segmenter) cutPart(dts int64, independent bool) error {
+	err := s.finishPart(dts)
+	if err != nil {
+		return err
+	}
+
+	err = s.openPart(dts, independent)
+	if err != nil {
+		return err
+	}
+
+	return s.writePlaylist()
+}
+
+// openPart 开始新的部分分片, 数据同时写入分片和部分分片
+func (s *Segmenter) openPart(dts int64, independent bool) error {
+	w, err := s.create(s.partName(s.seq, s.partSeq))
+	if err != nil {
+		return err
+	}
+
+	s.part = w
+	s.partStart = dts
+	s.independent = independent
+	s.playlist.PreloadHint = s.partName(s.seq, s.partSeq)
+	s.mixer.SetWriter(io.MultiWriter(s.w, w))
+
+	return nil
+}
+
+// finishPart 关闭当前部分分片, 加入正在生成的分片
+func (s *Segmenter) finishPart(end int64) error {
+	w := s.part
+	s.part = nil
+
+	err := w.Close()
+	if err != nil {
+		return err
+	}
+
+	part := Part{
+		URI:         s.partName(s.seq, s.partSeq),
+		Duration:    time.Duration(end-s.partStart) * time.Millisecond,
+		Independent: s.independent,
+	}
+	if part.Duration < 0 {
+		part.Duration = 0
+	}
+
+	s.playlist.Parts = append(s.playlist.Parts, part)
+	s.partSeq++
+
+	return nil
+}
+
+// finish 关闭当前分片, 加入播放列表并更新播放列表
+func (s *Segmenter) finish(end int64) error {
+	w := s.w
+	s.w = nil
+
+	err := w.Close()
+	if err != nil {
+		return err
+	}
+
+	seg := Segment{
+		URI:             s.segmentName(s.seq),
+		Duration:        time.Duration(end-s.start) * time.Millisecond,
+		Discontinuity:   s.segDisc,
+		ProgramDateTime: s.dateTime,
+		Parts:           s.playlist.Parts,
+		Key:             s.key,
+	}
+	s.playlist.Parts = nil
+	if seg.Duration < 0 {
+		seg.Duration = 0
+	}
+
+	if !s.dateTime.IsZero() {
+		s.dateTime = s.dateTime.Add(seg.Duration)
+	}
+
+	pl := s.playlist
+	pl.Segments = append(pl.Segments, seg)
+
+	// EXT-X-TARGETDURATION不能变小(RFC 8216 6.2.1), 超长的分片移出窗口之后仍然保持
+	if d := time.Duration(math.Round(seg.Duration.Seconds())) * time.Second; d > pl.TargetDuration {
+		pl.TargetDuration = d
+	}
+
+	// 直播只保留最近的分片
+	if pl.Type == PlaylistLive {
+		for len(pl.Segments) > s.window {
+			if pl.Segments[0].Discontinuity {
+				pl.DiscontinuitySequence++
+			}
+			s.expired = append(s.expired, pl.Segments[0])
+			pl.Segments = pl.Segments[1:]
+			pl.MediaSequence++
+		}
+
+		err = s.removeExpired()
+		if err != nil {
+			return err
+		}
+	}
+
+	// 点播的播放列表在结束时写出
+	if pl.Type == PlaylistVOD {
+		return nil
+	}
+
+	return s.writePlaylist()
+}
+
+// removeExpired 删除移出播放列表的分片和它的部分分片
+// 分片移出播放列表后需要保留一段时间(播放列表的时长加上分片的时长), 这里保留与窗口相同个数的分片
+func (s *Segmenter) removeExpired() error {
+	for len(s.expired) > s.window {
+		seg := s.expired[0]
+		for _, p := range seg.Parts {
+			err := s.storage.Remove(p.URI)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := s.storage.Remove(seg.URI)
+		if err != nil {
+			return err
+		}
+		s.expired = s.expired[1:]
+	}
+
+	return nil
+}
+
+// writePlaylist 写出并发布播放列表, 唤醒等待中的阻塞请求
+func (s *Segmenter) writePlaylist() error {
+	pl := s.playlist
+	b := pl.Encode()
+
+	s.mu.Lock()
+	s.published = published{
+		data:  b,
+		next:  pl.MediaSequence + int64(len(pl.Segments)),
+		parts: len(pl.Parts),
+		ended: pl.Ended,
+	}
+	close(s.update)
+	s.update = make(chan struct{})
+	s.mu.Unlock()
+
+	w, err := s.storage.Create(s.name + ".m3u8")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	if err != nil {
+		_ = w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// segmentName 分片的文件名
+func (s *Segmenter) segmentName(seq int64) string {
+	return fmt.Sprintf("%s%d.ts", s.name, seq)
+}
+
+// partName 部分分片的文件名
+func (s *Segmenter) partName(seq int64, part int) string {
+	return fmt.Sprintf("%s%d.%d.ts", s.name, seq, part)
+}
+
+// Close 结束最后一个分片, 写出带有 EXT-X-ENDLIST 的播放列表
+func (s *Segmenter) Close() error {
+	if s.w != nil {
+		if s.part != nil {
+			err := s.finishPart(s.last + s.gap)
+			if err != nil {
+				return err
+			}
+			s.playlist.PreloadHint = ""
+		}
+
+		err := s.finish(s.last + s.gap)
+		if err != nil {
+			return err
+		}
+		s.seq++
+	}
+
+	s.playlist.Ended = true
+	return s.writePlaylist()
+}
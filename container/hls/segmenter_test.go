@@ -0,0 +1,376 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/container/ts"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// testStream 生成FLV数据包的辅助结构, 视频25fps, 每秒一个关键帧, 音频每23ms一帧
+type testStream struct {
+	at *assert.Assertions
+	d  *flv.Demuxer
+	s  *Segmenter
+}
+
+func newTestStream(at *assert.Assertions, s *Segmenter, video bool) *testStream {
+	ts := &testStream{at: at, d: flv.NewDemuxer(), s: s}
+
+	if video {
+		// AVCDecoderConfigurationRecord: SPS + PPS
+		sps := []byte{0x67, 0x4d, 0x00, 0x1e, 0x9a, 0x66, 0x02, 0x80}
+		pps := []byte{0x68, 0xee, 0x3c, 0x80}
+		b := []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, sps[1], sps[2], sps[3], 0xff, 0xe1, 0x00, byte(len(sps))}
+		b = append(b, sps...)
+		b = append(b, 0x01, 0x00, byte(len(pps)))
+		b = append(b, pps...)
+		ts.write(&packet.Packet{Type: packet.PktVideo, Data: b})
+	}
+	ts.write(&packet.Packet{Type: packet.PktAudio, Data: []byte{0xaf, 0x00, 0x12, 0x10}})
+
+	return ts
+}
+
+func (ts *testStream) write(p *packet.Packet) {
+	ts.at.Nil(ts.d.Demux(p))
+	ts.at.Nil(ts.s.Write(p))
+}
+
+// run 按时间顺序写入[from, to)毫秒的音视频帧, 整秒的视频帧为关键帧
+func (ts *testStream) run(from, to uint32, video bool) {
+	v, a := from, from
+	for v < to || a < to {
+		if video && v <= a {
+			flag, nalu, size := byte(0x27), byte(0x41), 100
+			if v%1000 == 0 {
+				flag, nalu, size = 0x17, 0x65, 400
+			}
+			b := []byte{flag, 0x01, 0x00, 0x00, 0x00, byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size), nalu}
+			for i := 1; i < size; i++ {
+				b = append(b, byte(i))
+			}
+			ts.write(&packet.Packet{Type: packet.PktVideo, TimeStamp: v, Data: b})
+			v += 40
+			continue
+		}
+
+		if a >= to {
+			v = to
+			continue
+		}
+		audio := make([]byte, 202)
+		audio[0], audio[1] = 0xaf, 0x01
+		ts.write(&packet.Packet{Type: packet.PktAudio, TimeStamp: a, Data: audio})
+		a += 23
+	}
+}
+
+// readSegment 解复用分片, 返回数据包和节目数
+func readSegment(at *assert.Assertions, b []byte) ([]*packet.Packet, int) {
+	dmx := ts.NewDemuxer(bytes.NewReader(b))
+
+	var pkts []*packet.Packet
+	for {
+		p := &packet.Packet{}
+		err := dmx.Read(p)
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+		pkts = append(pkts, p)
+	}
+	at.Equal(0, dmx.ContinuityErrors())
+
+	return pkts, len(dmx.Programs())
+}
+
+func TestSegmenter_Live(t *testing.T) {
+	at := assert.New(t)
+
+	ms := NewMemoryStorage()
+	s := NewSegmenter(ms, "live", 2*time.Second)
+	s.SetWindow(2)
+
+	ts := newTestStream(at, s, true)
+	ts.run(0, 10000, true)
+	at.Nil(s.Close())
+
+	// 0,2,4,6,8秒的关键帧处切分, 最早的分片已经删除
+	at.Equal([]string{"live.m3u8", "live1.ts", "live2.ts", "live3.ts", "live4.ts"}, ms.Names())
+
+	pl := s.playlist
+	at.Equal(int64(3), pl.MediaSequence)
+	at.Len(pl.Segments, 2)
+	at.Equal(2*time.Second, pl.Segments[0].Duration)
+	at.InDelta(float64(2*time.Second), float64(pl.Segments[1].Duration), float64(40*time.Millisecond))
+
+	b, _ := ms.Get("live.m3u8")
+	at.True(strings.HasPrefix(string(b), "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:3\n#EXTINF:2.000,\nlive3.ts\n"))
+	at.True(strings.HasSuffix(string(b), "live4.ts\n#EXT-X-ENDLIST\n"))
+
+	// 每个分片都可以独立解码: 带有PAT/PMT, 以带有SPS/PPS的关键帧开始
+	for i := 1; i <= 4; i++ {
+		b, _ := ms.Get(fmt.Sprintf("live%d.ts", i))
+		at.Equal(byte(0x47), b[0])
+
+		pkts, programs := readSegment(at, b)
+		at.Equal(1, programs)
+
+		var video []*packet.Packet
+		for _, p := range pkts {
+			if p.Type == packet.PktVideo {
+				video = append(video, p)
+			}
+		}
+		at.True(video[0].Header.(packet.VideoPacketHeader).IsSeqHdr())
+		at.Equal(uint32(i*2000), video[1].TimeStamp)
+		at.True(video[1].Header.(packet.VideoPacketHeader).IsKeyFrame())
+		at.True(bytes.Contains(video[1].Media, []byte{0x00, 0x00, 0x00, 0x01, 0x67}))
+	}
+}
+
+func TestSegmenter_TargetDuration(t *testing.T) {
+	at := assert.New(t)
+
+	ms := NewMemoryStorage()
+	s := NewSegmenter(ms, "live", time.Second)
+	s.SetWindow(2)
+
+	// 缺少1秒处的关键帧, 第一个分片为2秒, 之后的分片为1秒
+	ts := newTestStream(at, s, true)
+	ts.run(0, 1000, true)
+	ts.run(1040, 6000, true)
+	at.Nil(s.Close())
+
+	pl := s.playlist
+	at.Equal(int64(3), pl.MediaSequence)
+	for _, seg := range pl.Segments {
+		at.InDelta(float64(time.Second), float64(seg.Duration), float64(40*time.Millisecond))
+	}
+
+	// 2秒的分片移出窗口之后, EXT-X-TARGETDURATION不变小, 仍然按1秒切分
+	b, _ := ms.Get("live.m3u8")
+	at.True(strings.HasPrefix(string(b), "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:3\n"))
+	at.Equal(time.Second, s.target)
+}
+
+func TestSegmenter_Discontinuity(t *testing.T) {
+	at := assert.New(t)
+
+	ms := NewMemoryStorage()
+	s := NewSegmenter(ms, "audio", time.Second)
+	s.SetPlaylistType(PlaylistEvent)
+
+	// 纯音频在任意音频帧切分, 时间戳重置之后开始新的分片
+	ts := newTestStream(at, s, false)
+	ts.run(0, 1500, false)
+	s.Discontinuity()
+	ts.run(0, 1500, false)
+	at.Nil(s.Close())
+
+	pl := s.playlist
+	at.Len(pl.Segments, 4)
+	at.Equal(int64(0), pl.MediaSequence)
+	at.Equal([]bool{false, false, true, false}, []bool{pl.Segments[0].Discontinuity, pl.Segments[1].Discontinuity, pl.Segments[2].Discontinuity, pl.Segments[3].Discontinuity})
+	at.Equal(1012*time.Millisecond, pl.Segments[0].Duration)
+	at.Equal(506*time.Millisecond, pl.Segments[1].Duration)
+
+	b, _ := ms.Get("audio.m3u8")
+	at.Contains(string(b), "#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	at.Contains(string(b), "audio1.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:1.012,\naudio2.ts\n")
+
+	// 纯音频的分片以PCR开始
+	for _, name := range []string{"audio0.ts", "audio2.ts"} {
+		b, _ := ms.Get(name)
+		pkts, programs := readSegment(at, b)
+		at.Equal(1, programs)
+		at.Equal(packet.PktAudio, pkts[0].Type)
+		at.Equal(uint32(0), pkts[1].TimeStamp)
+	}
+}
+
+func TestSegmenter_VOD(t *testing.T) {
+	at := assert.New(t)
+
+	ms := NewMemoryStorage()
+	s := NewSegmenter(ms, "vod", 2*time.Second)
+	s.SetPlaylistType(PlaylistVOD)
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.SetProgramDateTime(start)
+
+	ts := newTestStream(at, s, true)
+	ts.run(0, 5000, true)
+
+	// 点播的播放列表在结束时写出
+	_, ok := ms.Get("vod.m3u8")
+	at.False(ok)
+
+	at.Nil(s.Close())
+	b, ok := ms.Get("vod.m3u8")
+	at.True(ok)
+
+	at.Equal(`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-PROGRAM-DATE-TIME:2020-01-02T03:04:05.000Z
+#EXTINF:2.000,
+vod0.ts
+#EXT-X-PROGRAM-DATE-TIME:2020-01-02T03:04:07.000Z
+#EXTINF:2.000,
+vod1.ts
+#EXT-X-PROGRAM-DATE-TIME:2020-01-02T03:04:09.000Z
+#EXTINF:1.014,
+vod2.ts
+#EXT-X-ENDLIST
+`, string(b))
+}
+
+func TestSegmenter_LowLatency(t *testing.T) {
+	at := assert.New(t)
+
+	ms := NewMemoryStorage()
+	s := NewSegmenter(ms, "ll", 2*time.Second)
+	s.SetWindow(3)
+	s.EnableLowLatency(200 * time.Millisecond)
+
+	ts := newTestStream(at, s, true)
+	ts.run(0, 14500, true)
+
+	// 每个分片10个部分分片, 以关键帧开始的部分分片是独立的
+	pl := s.playlist
+	at.Equal(int64(4), pl.MediaSequence)
+	at.Len(pl.Segments, 3)
+	at.Len(pl.Segments[0].Parts, 10)
+	for i, p := range pl.Segments[0].Parts {
+		at.Equal(fmt.Sprintf("ll4.%d.ts", i), p.URI)
+		at.Equal(200*time.Millisecond, p.Duration)
+		at.Equal(i%5 == 0, p.Independent)
+	}
+	at.Len(pl.Parts, 2)
+
+	// 第4个分片距离末尾超过3个目标时长, 不再输出部分分片
+	b, _ := ms.Get("ll.m3u8")
+	m3u8 := string(b)
+	at.True(strings.HasPrefix(m3u8, `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-TARGETDURATION:2
+#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=0.600
+#EXT-X-PART-INF:PART-TARGET=0.200
+#EXT-X-MEDIA-SEQUENCE:4
+#EXTINF:2.000,
+ll4.ts
+#EXT-X-PART:DURATION=0.200,URI="ll5.0.ts",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.200,URI="ll5.1.ts"
+`))
+	at.True(strings.HasSuffix(m3u8, `ll6.ts
+#EXT-X-PART:DURATION=0.200,URI="ll7.0.ts",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.200,URI="ll7.1.ts"
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="ll7.2.ts"
+`))
+
+	// 部分分片依次拼接就是完整的分片
+	var parts []byte
+	for i := 0; i < 10; i++ {
+		b, ok := ms.Get(fmt.Sprintf("ll6.%d.ts", i))
+		at.True(ok)
+		parts = append(parts, b...)
+	}
+	seg, _ := ms.Get("ll6.ts")
+	at.Equal(seg, parts)
+
+	// 过期的分片连同部分分片一起删除
+	for _, name := range []string{"ll0.ts", "ll0.0.ts", "ll0.9.ts"} {
+		_, ok := ms.Get(name)
+		at.False(ok, name)
+	}
+	_, ok := ms.Get("ll1.9.ts")
+	at.True(ok)
+
+	at.Nil(s.Close())
+	b, _ = ms.Get("ll.m3u8")
+	at.True(strings.HasSuffix(string(b), `#EXT-X-PART:DURATION=0.113,URI="ll7.2.ts"
+#EXTINF:0.513,
+ll7.ts
+#EXT-X-ENDLIST
+`))
+}
+
+func TestSegmenter_AES128(t *testing.T) {
+	at := assert.New(t)
+
+	ms := NewMemoryStorage()
+	s := NewSegmenter(ms, "enc", 2*time.Second)
+
+	// 每两个分片轮换一次密钥
+	var calls []int64
+	at.NotNil(s.SetEncryption(KeyAES128, nil))
+	at.Nil(s.SetEncryption(KeyAES128, func(seq int64) (*Key, error) {
+		calls = append(calls, seq)
+		key := append([]byte(nil), testKey...)
+		key[0] += byte(seq / 2)
+		return &Key{URI: fmt.Sprintf("key%d.bin", seq/2), Key: key}, nil
+	}))
+
+	ts := newTestStream(at, s, true)
+	ts.run(0, 6000, true)
+	at.Nil(s.Close())
+	at.Equal([]int64{0, 1, 2}, calls)
+
+	b, _ := ms.Get("enc.m3u8")
+	at.Contains(string(b), "#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-KEY:METHOD=AES-128,URI=\"key0.bin\"\n#EXTINF:2.000,\nenc0.ts\n#EXTINF:2.000,\nenc1.ts\n#EXT-X-KEY:METHOD=AES-128,URI=\"key1.bin\"\n")
+
+	// 使用分片序号作为IV解密后是完整的TS
+	for i := int64(0); i < 3; i++ {
+		key := append([]byte(nil), testKey...)
+		key[0] += byte(i / 2)
+
+		b, _ := ms.Get(fmt.Sprintf("enc%d.ts", i))
+		at.NotEqual(byte(0x47), b[0])
+
+		plain := decrypt(at, b, key, (&Key{}).iv(i))
+		at.Equal(0, len(plain)%188)
+		at.Equal(byte(0x47), plain[0])
+
+		pkts, programs := readSegment(at, plain)
+		at.Equal(1, programs)
+		at.True(len(pkts) > 50)
+	}
+}
+
+func TestSegmenter_SampleAES(t *testing.T) {
+	at := assert.New(t)
+
+	ms := NewMemoryStorage()
+	s := NewSegmenter(ms, "sample", 2*time.Second)
+	s.EnableLowLatency(500 * time.Millisecond)
+
+	iv := []byte("fedcba9876543210")
+	at.Nil(s.SetEncryption(KeySampleAES, func(seq int64) (*Key, error) {
+		return &Key{URI: "key.bin", Key: testKey, IV: iv}, nil
+	}))
+
+	ts := newTestStream(at, s, true)
+	ts.run(0, 2600, true)
+
+	// 密钥没有变化, 只输出一次
+	b, _ := ms.Get("sample.m3u8")
+	tag := "#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"key.bin\",IV=0x66656463626139383736353433323130\n"
+	at.Equal(1, strings.Count(string(b), tag))
+	at.Contains(string(b), "#EXT-X-MEDIA-SEQUENCE:0\n"+tag+"#EXT-X-PART:DURATION=0.480,URI=\"sample0.0.ts\",INDEPENDENT=YES\n")
+
+	// TS结构保持明文, 数据包无法按照H264和AAC解析
+	seg, _ := ms.Get("sample0.ts")
+	pkts, programs := readSegment(at, seg)
+	at.Equal(1, programs)
+	at.Empty(pkts)
+}
@@ -0,0 +1,141 @@
+package hls
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Storage 分片和播放列表的存储
+// Create 返回的输出在 Close 之后才对外可见, 读取方不会读到写了一半的文件
+type Storage interface {
+	Create(name string) (io.WriteCloser, error)
+	Remove(name string) error
+}
+
+// FileStorage 文件系统存储, 先写入同一目录下的临时文件, 关闭时重命名
+type FileStorage struct {
+	dir string
+}
+
+// NewFileStorage 文件系统存储, dir为输出目录
+func NewFileStorage(dir string) *FileStorage {
+	return &FileStorage{
+		dir: dir,
+	}
+}
+
+// Create 创建文件
+func (fs *FileStorage) Create(name string) (io.WriteCloser, error) {
+	f, err := ioutil.TempFile(fs.dir, "."+name+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	return &tempFile{
+		File: f,
+		name: filepath.Join(fs.dir, name),
+	}, nil
+}
+
+// Remove 删除文件, 文件不存在时不返回错误
+func (fs *FileStorage) Remove(name string) error {
+	err := os.Remove(filepath.Join(fs.dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// tempFile 关闭时重命名为目标文件
+type tempFile struct {
+	*os.File
+	name string
+}
+
+// Close 关闭并重命名, 失败时删除临时文件
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	if err == nil {
+		err = os.Rename(f.File.Name(), f.name)
+	}
+	if err != nil {
+		_ = os.Remove(f.File.Name())
+	}
+
+	return err
+}
+
+// MemoryStorage 内存存储, 可以并发访问
+type MemoryStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// NewMemoryStorage 内存存储
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		files: make(map[string][]byte),
+	}
+}
+
+// Create 创建文件
+func (ms *MemoryStorage) Create(name string) (io.WriteCloser, error) {
+	return &memoryFile{
+		storage: ms,
+		name:    name,
+	}, nil
+}
+
+// Remove 删除文件
+func (ms *MemoryStorage) Remove(name string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.files, name)
+	return nil
+}
+
+// Get 文件的内容
+func (ms *MemoryStorage) Get(name string) ([]byte, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	b, ok := ms.files[name]
+	return b, ok
+}
+
+// Names 所有文件名(已排序)
+func (ms *MemoryStorage) Names() []string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	names := make([]string, 0, len(ms.files))
+	for name := range ms.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// memoryFile 关闭时写入内存存储
+type memoryFile struct {
+	bytes.Buffer
+	storage *MemoryStorage
+	name    string
+}
+
+// Close 写入内存存储
+func (f *memoryFile) Close() error {
+	f.storage.mu.Lock()
+	defer f.storage.mu.Unlock()
+
+	f.storage.files[f.name] = f.Bytes()
+	return nil
+}
@@ -0,0 +1,68 @@
+package hls
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStorage(t *testing.T) {
+	at := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "hls")
+	at.Nil(err)
+	defer os.RemoveAll(dir)
+
+	fs := NewFileStorage(dir)
+	w, err := fs.Create("a.m3u8")
+	at.Nil(err)
+	_, err = w.Write([]byte("#EXTM3U\n"))
+	at.Nil(err)
+
+	// 关闭之前目标文件不存在
+	_, err = os.Stat(filepath.Join(dir, "a.m3u8"))
+	at.True(os.IsNotExist(err))
+
+	at.Nil(w.Close())
+	b, err := ioutil.ReadFile(filepath.Join(dir, "a.m3u8"))
+	at.Nil(err)
+	at.Equal("#EXTM3U\n", string(b))
+
+	// 没有遗留的临时文件
+	files, err := ioutil.ReadDir(dir)
+	at.Nil(err)
+	at.Len(files, 1)
+
+	at.Nil(fs.Remove("a.m3u8"))
+	at.Nil(fs.Remove("a.m3u8"))
+	_, err = os.Stat(filepath.Join(dir, "a.m3u8"))
+	at.True(os.IsNotExist(err))
+}
+
+func TestMemoryStorage(t *testing.T) {
+	at := assert.New(t)
+
+	ms := NewMemoryStorage()
+	w, err := ms.Create("b.ts")
+	at.Nil(err)
+	_, err = w.Write([]byte{0x47})
+	at.Nil(err)
+
+	_, ok := ms.Get("b.ts")
+	at.False(ok)
+
+	at.Nil(w.Close())
+	b, ok := ms.Get("b.ts")
+	at.True(ok)
+	at.Equal([]byte{0x47}, b)
+
+	w, _ = ms.Create("a.ts")
+	at.Nil(w.Close())
+	at.Equal([]string{"a.ts", "b.ts"}, ms.Names())
+
+	at.Nil(ms.Remove("b.ts"))
+	at.Equal([]string{"a.ts"}, ms.Names())
+}
@@ -0,0 +1,340 @@
+// Package id3 ID3v2标签的生成和解析, 用于HLS的定时元数据(TS中的ID3元数据流)
+package id3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// 标签和帧的固定长度
+const (
+	headerLen      = 10
+	frameHeaderLen = 10
+	maxSize        = 1<<28 - 1 // 同步安全整数的最大值
+)
+
+// 标签头中的标识
+const (
+	flagUnsync         = 0x80
+	flagExtendedHeader = 0x40
+	flagFooter         = 0x10
+)
+
+// ID3v2.4帧头中的标识
+const (
+	frameFlagDataLen     = 0x01
+	frameFlagUnsync      = 0x02
+	frameFlagEncryption  = 0x04
+	frameFlagCompression = 0x08
+)
+
+// 文本编码
+const (
+	encodingLatin1  = 0x00
+	encodingUTF16   = 0x01
+	encodingUTF16BE = 0x02
+	encodingUTF8    = 0x03
+)
+
+// 常用的帧ID
+const (
+	FrameTitle    = "TIT2"
+	FrameArtist   = "TPE1"
+	FrameAlbum    = "TALB"
+	FrameUserText = "TXXX"
+	FramePrivate  = "PRIV"
+)
+
+// Frame ID3v2的帧
+type Frame struct {
+	ID   string // 4个字符, 例如 TIT2, TXXX, PRIV
+	Data []byte // 帧的内容(帧头之后的数据)
+}
+
+// TextFrame 文本帧(UTF-8编码), 例如 TIT2, TPE1
+func TextFrame(id, text string) Frame {
+	return Frame{
+		ID:   id,
+		Data: append([]byte{encodingUTF8}, text...),
+	}
+}
+
+// UserTextFrame 自定义文本帧(TXXX), 由描述和值组成
+func UserTextFrame(desc, value string) Frame {
+	b := append([]byte{encodingUTF8}, desc...)
+	b = append(b, 0x00)
+
+	return Frame{
+		ID:   FrameUserText,
+		Data: append(b, value...),
+	}
+}
+
+// PrivateFrame 私有帧(PRIV), 由所有者标识和二进制数据组成
+func PrivateFrame(owner string, data []byte) Frame {
+	b := append([]byte(owner), 0x00)
+
+	return Frame{
+		ID:   FramePrivate,
+		Data: append(b, data...),
+	}
+}
+
+// Text [文本帧]返回文本, 有多个值时返回第一个
+func (f *Frame) Text() (string, error) {
+	if len(f.Data) == 0 {
+		return "", errors.New("empty text frame")
+	}
+
+	text, _, err := decodeText(f.Data[0], f.Data[1:])
+	return text, err
+}
+
+// UserText [TXXX]返回描述和值
+func (f *Frame) UserText() (string, string, error) {
+	if len(f.Data) == 0 {
+		return "", "", errors.New("empty user text frame")
+	}
+
+	desc, n, err := decodeText(f.Data[0], f.Data[1:])
+	if err != nil {
+		return "", "", err
+	}
+
+	value, _, err := decodeText(f.Data[0], f.Data[1+n:])
+	return desc, value, err
+}
+
+// Private [PRIV]返回所有者标识和数据
+func (f *Frame) Private() (string, []byte, error) {
+	i := bytes.IndexByte(f.Data, 0x00)
+	if i < 0 {
+		return "", nil, errors.New("private frame without owner")
+	}
+
+	return string(f.Data[:i]), f.Data[i+1:], nil
+}
+
+// Tag ID3v2标签
+type Tag struct {
+	Frames []Frame
+}
+
+// NewTag ID3v2标签
+func NewTag(frames ...Frame) *Tag {
+	return &Tag{
+		Frames: frames,
+	}
+}
+
+// Frame 返回第一个指定ID的帧, 不存在时返回nil
+func (t *Tag) Frame(id string) *Frame {
+	for i := range t.Frames {
+		if t.Frames[i].ID == id {
+			return &t.Frames[i]
+		}
+	}
+
+	return nil
+}
+
+// Encode 生成ID3v2.4标签(不使用非同步化, 没有扩展头和填充)
+func (t *Tag) Encode() ([]byte, error) {
+	var body bytes.Buffer
+	for _, f := range t.Frames {
+		if len(f.ID) != 4 {
+			return nil, fmt.Errorf("invalid id3 frame id %q", f.ID)
+		}
+		if len(f.Data) > maxSize {
+			return nil, fmt.Errorf("id3 frame %s is too long(%d)", f.ID, len(f.Data))
+		}
+
+		body.WriteString(f.ID)
+		body.Write(syncsafe(len(f.Data)))
+		body.Write([]byte{0x00, 0x00})
+		body.Write(f.Data)
+	}
+
+	if body.Len() > maxSize {
+		return nil, fmt.Errorf("id3 tag is too long(%d)", body.Len())
+	}
+
+	b := make([]byte, 0, headerLen+body.Len())
+	b = append(b, 'I', 'D', '3', 0x04, 0x00, 0x00)
+	b = append(b, syncsafe(body.Len())...)
+
+	return append(b, body.Bytes()...), nil
+}
+
+// Parse 解析ID3v2.3或者ID3v2.4标签, 返回标签和标签的总长度
+// 压缩和加密的帧被忽略
+func Parse(b []byte) (*Tag, int, error) {
+	if len(b) < headerLen || string(b[:3]) != "ID3" {
+		return nil, 0, errors.New("not an id3v2 tag")
+	}
+
+	version, flags := b[3], b[5]
+	if version != 3 && version != 4 {
+		return nil, 0, fmt.Errorf("unsupported id3v2 version 2.%d", version)
+	}
+
+	size := unsyncsafe(b[6:10])
+	n := headerLen + size
+	if flags&flagFooter != 0 {
+		n += headerLen
+	}
+	if len(b) < headerLen+size {
+		return nil, 0, fmt.Errorf("incomplete id3 tag, got %d bytes, want %d", len(b), headerLen+size)
+	}
+
+	body := b[headerLen : headerLen+size]
+	if flags&flagUnsync != 0 && version == 3 {
+		body = resync(body)
+	}
+
+	// 跳过扩展头: ID3v2.3的长度不包括长度字段本身, ID3v2.4为同步安全整数且包括长度字段
+	if flags&flagExtendedHeader != 0 {
+		if len(body) < 4 {
+			return nil, 0, errors.New("incomplete id3 extended header")
+		}
+
+		ext := int(body[0])<<24 | int(body[1])<<16 | int(body[2])<<8 | int(body[3]) + 4
+		if version == 4 {
+			ext = unsyncsafe(body[:4])
+		}
+		if ext > len(body) {
+			return nil, 0, errors.New("invalid id3 extended header")
+		}
+		body = body[ext:]
+	}
+
+	t := &Tag{}
+	for len(body) >= frameHeaderLen && body[0] != 0x00 {
+		id := string(body[:4])
+		fsize := int(body[4])<<24 | int(body[5])<<16 | int(body[6])<<8 | int(body[7])
+		if version == 4 {
+			fsize = unsyncsafe(body[4:8])
+		}
+		fflags := body[9]
+		body = body[frameHeaderLen:]
+
+		if fsize > len(body) {
+			return nil, 0, fmt.Errorf("incomplete id3 frame %s", id)
+		}
+		data := body[:fsize]
+		body = body[fsize:]
+
+		// ID3v2.3的压缩和加密标识位置不同
+		if version == 3 {
+			if fflags&0xc0 != 0 {
+				continue
+			}
+			t.Frames = append(t.Frames, Frame{ID: id, Data: data})
+			continue
+		}
+
+		if fflags&(frameFlagCompression|frameFlagEncryption) != 0 {
+			continue
+		}
+		if fflags&frameFlagDataLen != 0 {
+			if len(data) < 4 {
+				return nil, 0, fmt.Errorf("incomplete id3 frame %s data length", id)
+			}
+			data = data[4:]
+		}
+		if fflags&frameFlagUnsync != 0 || flags&flagUnsync != 0 {
+			data = resync(data)
+		}
+
+		t.Frames = append(t.Frames, Frame{ID: id, Data: data})
+	}
+
+	return t, n, nil
+}
+
+// syncsafe 编码为4字节的同步安全整数(每字节7位)
+func syncsafe(n int) []byte {
+	return []byte{byte(n>>21) & 0x7f, byte(n>>14) & 0x7f, byte(n>>7) & 0x7f, byte(n) & 0x7f}
+}
+
+// unsyncsafe 解码4字节的同步安全整数
+func unsyncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// resync 去除非同步化插入的字节(0xff之后的0x00)
+func resync(b []byte) []byte {
+	ret := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		ret = append(ret, b[i])
+		if b[i] == 0xff && i+1 < len(b) && b[i+1] == 0x00 {
+			i++
+		}
+	}
+
+	return ret
+}
+
+// decodeText 按照编码解码一个以结束符结尾(或者到数据末尾)的字符串, 返回字符串和包括结束符在内的长度
+func decodeText(encoding byte, b []byte) (string, int, error) {
+	switch encoding {
+	case encodingLatin1, encodingUTF8:
+		n := bytes.IndexByte(b, 0x00)
+		if n < 0 {
+			n = len(b)
+		}
+
+		if encoding == encodingUTF8 {
+			return string(b[:n]), min(n+1, len(b)), nil
+		}
+
+		runes := make([]rune, n)
+		for i, c := range b[:n] {
+			runes[i] = rune(c)
+		}
+		return string(runes), min(n+1, len(b)), nil
+	case encodingUTF16, encodingUTF16BE:
+		// 结束符为两个0x00
+		n := 0
+		for n+1 < len(b) && (b[n] != 0x00 || b[n+1] != 0x00) {
+			n += 2
+		}
+		if n+1 >= len(b) {
+			n = len(b) &^ 1
+		}
+
+		s := b[:n]
+		bigEndian := true
+		if encoding == encodingUTF16 && len(s) >= 2 {
+			switch {
+			case s[0] == 0xff && s[1] == 0xfe:
+				bigEndian = false
+				s = s[2:]
+			case s[0] == 0xfe && s[1] == 0xff:
+				s = s[2:]
+			}
+		}
+
+		units := make([]uint16, len(s)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = uint16(s[2*i])<<8 | uint16(s[2*i+1])
+			} else {
+				units[i] = uint16(s[2*i+1])<<8 | uint16(s[2*i])
+			}
+		}
+		return string(utf16.Decode(units)), min(n+2, len(b)), nil
+	}
+
+	return "", 0, fmt.Errorf("unsupported id3 text encoding %d", encoding)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
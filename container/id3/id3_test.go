@@ -0,0 +1,92 @@
+package id3
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTag_Encode(t *testing.T) {
+	at := assert.New(t)
+
+	tag := NewTag(
+		TextFrame(FrameTitle, "直播"),
+		UserTextFrame("onTextData", "hello"),
+		PrivateFrame("com.apple.streaming.transportStreamTimestamp", []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x5a}),
+	)
+
+	b, err := tag.Encode()
+	at.Nil(err)
+	at.Equal([]byte{'I', 'D', '3', 0x04, 0x00, 0x00}, b[:6])
+	at.Equal(len(b)-headerLen, unsyncsafe(b[6:10]))
+
+	got, n, err := Parse(append(b, 0xaa, 0xbb))
+	at.Nil(err)
+	at.Equal(len(b), n)
+	at.Equal(tag, got)
+
+	title, err := got.Frame(FrameTitle).Text()
+	at.Nil(err)
+	at.Equal("直播", title)
+
+	desc, value, err := got.Frame(FrameUserText).UserText()
+	at.Nil(err)
+	at.Equal("onTextData", desc)
+	at.Equal("hello", value)
+
+	owner, data, err := got.Frame(FramePrivate).Private()
+	at.Nil(err)
+	at.Equal("com.apple.streaming.transportStreamTimestamp", owner)
+	at.Equal(byte(0x5a), data[7])
+
+	at.Nil(got.Frame(FrameAlbum))
+
+	// 错误的帧ID
+	_, err = NewTag(Frame{ID: "TIT"}).Encode()
+	at.NotNil(err)
+
+	// 不完整的标签
+	_, _, err = Parse(b[:len(b)-1])
+	at.NotNil(err)
+}
+
+func TestParse(t *testing.T) {
+	at := assert.New(t)
+
+	// ID3v2.3: UTF-16(带BOM)的TPE1, 之后是填充
+	b := []byte{
+		'I', 'D', '3', 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x19,
+		'T', 'P', 'E', '1', 0x00, 0x00, 0x00, 0x09, 0x00, 0x00,
+		0x01, 0xff, 0xfe, 'a', 0x00, 'b', 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	tag, n, err := Parse(b)
+	at.Nil(err)
+	at.Equal(len(b), n)
+	at.Len(tag.Frames, 1)
+
+	artist, err := tag.Frames[0].Text()
+	at.Nil(err)
+	at.Equal("ab", artist)
+
+	// ID3v2.4: 带数据长度和非同步化标识的Latin-1文本帧, 以及被忽略的压缩帧
+	b = []byte{
+		'I', 'D', '3', 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20,
+		'T', 'A', 'L', 'B', 0x00, 0x00, 0x00, 0x09, 0x00, 0x03,
+		0x00, 0x00, 0x00, 0x03, 0x00, 0xe9, 0xff, 0x00, 'x',
+		'T', 'I', 'T', '2', 0x00, 0x00, 0x00, 0x03, 0x00, 0x08,
+		0x03, 'z', 'z',
+	}
+	tag, _, err = Parse(b)
+	at.Nil(err)
+	at.Len(tag.Frames, 1)
+	at.Equal([]byte{0x00, 0xe9, 0xff, 'x'}, tag.Frames[0].Data)
+
+	album, err := tag.Frames[0].Text()
+	at.Nil(err)
+	at.Equal("éÿx", album)
+
+	// 不支持的版本
+	_, _, err = Parse([]byte{'I', 'D', '3', 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	at.NotNil(err)
+}
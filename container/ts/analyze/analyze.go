@@ -0,0 +1,758 @@
+// Package analyze TS流分析, 按照 ETSI TR 101 290 检查第一优先级和第二优先级的错误, 并统计每个PID的码率
+//
+// TR 101 290 中的时间以PCR为准: 第一个带有PCR的PID作为参考时钟, 两个PCR之间按照字节位置线性插值;
+// 参考时钟出现之前不检查PAT, PMT和PID的间隔
+package analyze
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/nextpkg/goav/container/ts/table"
+)
+
+const (
+	tsPacketLen = 188
+	syncByte    = 0x47
+
+	// 同步: 连续5个同步字节为同步, 连续2个错误的同步字节为失步
+	syncAcquire = 5
+	syncLose    = 2
+)
+
+// 固定的PID
+const (
+	patPID  = 0x0000
+	catPID  = 0x0001
+	nitPID  = 0x0010
+	sdtPID  = 0x0011
+	eitPID  = 0x0012
+	nullPID = 0x1fff
+)
+
+const tableCat = 0x01
+
+// 时钟
+const (
+	pcrHZ   = 27000000 // PCR的时钟频率
+	ptsHZ   = 90000    // PTS的时钟频率
+	pcrWrap = 1 << 33 * 300
+	ptsWrap = 1 << 33
+)
+
+// TR 101 290 中的时间限制
+const (
+	maxPatInterval   = 500 * time.Millisecond
+	maxPmtInterval   = 500 * time.Millisecond
+	maxPidInterval   = 5 * time.Second
+	maxPcrInterval   = 40 * time.Millisecond
+	maxPcrJump       = 100 * time.Millisecond
+	maxPcrInaccuracy = 500 * time.Nanosecond
+	maxPtsInterval   = 700 * time.Millisecond
+)
+
+// Kind TR 101 290 中的错误类型
+type Kind int
+
+// 第一优先级(1.x)和第二优先级(2.x)的错误
+const (
+	// SyncLoss 1.1 TS_sync_loss, 连续2个以上的TS包同步字节错误
+	SyncLoss Kind = iota
+	// SyncByte 1.2 Sync_byte_error, 同步字节不是0x47
+	SyncByte
+	// PatError 1.3 PAT_error, PAT的间隔超过0.5秒, PID 0上的table_id不是0或者被加扰
+	PatError
+	// ContinuityError 1.4 Continuity_count_error, 包顺序错误, 丢包或者重复超过一次
+	ContinuityError
+	// PmtError 1.5 PMT_error, PMT的间隔超过0.5秒或者被加扰
+	PmtError
+	// PidError 1.6 PID_error, PMT中的基本流超过5秒没有出现
+	PidError
+	// TransportError 2.1 Transport_error, transport_error_indicator为1
+	TransportError
+	// CrcError 2.2 CRC_error, PSI和SI的section校验错误
+	CrcError
+	// PcrRepetitionError 2.3a PCR_repetition_error, 相邻PCR的间隔超过40ms
+	PcrRepetitionError
+	// PcrDiscontinuityError 2.3b PCR_discontinuity_indicator_error, 没有不连续标识时PCR回退或者跳跃超过100ms
+	PcrDiscontinuityError
+	// PcrAccuracyError 2.4 PCR_accuracy_error, PCR的误差超过±500ns(固定码率)
+	PcrAccuracyError
+	// PtsError 2.5 PTS_error, PTS的间隔超过700ms
+	PtsError
+	// CatError 2.6 CAT_error, 有加扰的包但没有CAT, 或者PID 1上的table_id不是1
+	CatError
+)
+
+var kindNames = []struct {
+	indicator string
+	name      string
+}{
+	{"1.1", "TS_sync_loss"},
+	{"1.2", "Sync_byte_error"},
+	{"1.3", "PAT_error"},
+	{"1.4", "Continuity_count_error"},
+	{"1.5", "PMT_error"},
+	{"1.6", "PID_error"},
+	{"2.1", "Transport_error"},
+	{"2.2", "CRC_error"},
+	{"2.3a", "PCR_repetition_error"},
+	{"2.3b", "PCR_discontinuity_indicator_error"},
+	{"2.4", "PCR_accuracy_error"},
+	{"2.5", "PTS_error"},
+	{"2.6", "CAT_error"},
+}
+
+// String TR 101 290 中的错误名称
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("unknown error %d", int(k))
+	}
+
+	return kindNames[k].name
+}
+
+// Indicator TR 101 290 中的编号, 例如 1.4
+func (k Kind) Indicator() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return ""
+	}
+
+	return kindNames[k].indicator
+}
+
+// Priority 优先级, 1或者2
+func (k Kind) Priority() int {
+	if k < TransportError {
+		return 1
+	}
+
+	return 2
+}
+
+// Error TS流中的错误
+type Error struct {
+	Offset int64 // 出错的TS包在流中的偏移
+	PID    int   // 出错的PID, 与PID无关时为-1
+	Kind   Kind
+	Detail string
+}
+
+// String 错误描述
+func (e Error) String() string {
+	if e.PID < 0 {
+		return fmt.Sprintf("offset %d: %s %s: %s", e.Offset, e.Kind.Indicator(), e.Kind, e.Detail)
+	}
+
+	return fmt.Sprintf("offset %d: %s %s: pid %#04x: %s", e.Offset, e.Kind.Indicator(), e.Kind, e.PID, e.Detail)
+}
+
+// PidStats PID的统计
+type PidStats struct {
+	PID        uint16
+	StreamType uint8  // PMT中的stream_type, 不是基本流时为0
+	Type       string // 类型描述, 例如 PAT, PMT, H.264, AAC
+	Packets    int64
+	Bitrate    int64 // bit/s, 没有PCR时为0
+}
+
+// Report 分析结果
+type Report struct {
+	Packets  int64
+	Duration time.Duration // 参考时钟的时长, 没有PCR时为0
+	Bitrate  int64         // bit/s, 没有PCR时为0
+	Errors   []Error
+	Pids     []PidStats // 按PID排序
+}
+
+// Count 指定类型的错误数
+func (r *Report) Count(kind Kind) int {
+	n := 0
+	for _, e := range r.Errors {
+		if e.Kind == kind {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Analyzer TS流分析器
+type Analyzer struct {
+	pcrAccuracy bool
+}
+
+// NewAnalyzer TS流分析器, 默认不检查PCR的精度
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{}
+}
+
+// SetPcrAccuracy 是否检查PCR的精度(2.4), 该检查假定为固定码率, 只对固定码率的流开启
+func (a *Analyzer) SetPcrAccuracy(enabled bool) {
+	a.pcrAccuracy = enabled
+}
+
+// Analyze 分析TS流, 流结束时返回分析结果, 最后一个不完整的TS包被忽略
+func (a *Analyzer) Analyze(r io.Reader) (*Report, error) {
+	s := newAnalysis(a.pcrAccuracy)
+
+	err := s.scan(bufio.NewReaderSize(r, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.report(), nil
+}
+
+// analysis 一次分析的状态
+type analysis struct {
+	pcrAccuracy bool
+
+	offset  int64 // 当前TS包的偏移
+	packets int64
+	errors  []Error
+
+	pids  map[uint16]*pidState
+	clock clock
+	first int64 // 第一个有时间的TS包的时间(27MHz), 小于0表示没有
+	now   int64 // 当前TS包的时间(27MHz), 小于0表示没有
+
+	patTime int64 // 最近一次PAT的时间
+	catSeen bool
+}
+
+// pidState PID的状态
+type pidState struct {
+	pid        uint16
+	streamType uint8
+	isPmt      bool
+	psi        *table.SectionAssembler // PSI的PID, 其它PID为nil
+	packets    int64
+
+	// 连续计数器
+	cc    byte
+	hasCC bool
+	dup   int
+
+	// PCR
+	pcr, pcrPos         int64
+	prevPcr, prevPcrPos int64
+	pcrs                int // 连续(没有不连续标识和错误)的PCR个数
+
+	// PAT中的PMT和PMT中的基本流的最近出现时间, 小于0表示还没有参考时钟
+	referenced bool
+	seen       int64
+
+	pts    int64
+	hasPts bool
+
+	catReported bool
+}
+
+func newAnalysis(pcrAccuracy bool) *analysis {
+	return &analysis{
+		pcrAccuracy: pcrAccuracy,
+		pids:        make(map[uint16]*pidState),
+		clock:       clock{pid: -1},
+		first:       -1,
+		now:         -1,
+		patTime:     -1,
+	}
+}
+
+// add 记录错误
+func (s *analysis) add(pid int, kind Kind, format string, args ...interface{}) {
+	s.errors = append(s.errors, Error{
+		Offset: s.offset,
+		PID:    pid,
+		Kind:   kind,
+		Detail: fmt.Sprintf(format, args...),
+	})
+}
+
+// pid 返回PID的状态, 不存在时新建
+func (s *analysis) pid(pid uint16) *pidState {
+	st, ok := s.pids[pid]
+	if !ok {
+		st = &pidState{pid: pid, seen: -1}
+		switch pid {
+		case patPID, catPID, nitPID, sdtPID, eitPID:
+			st.psi = &table.SectionAssembler{}
+		}
+		s.pids[pid] = st
+	}
+
+	return st
+}
+
+// scan 按照同步字节读取TS包
+func (s *analysis) scan(r *bufio.Reader) error {
+	synced := false
+	bad := 0
+
+	for {
+		if !synced {
+			err := s.acquire(r)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			synced, bad = true, 0
+		}
+
+		b, err := r.Peek(tsPacketLen)
+		if len(b) < tsPacketLen {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if b[0] != syncByte {
+			s.add(-1, SyncByte, "got %#02x", b[0])
+			bad++
+			if bad >= syncLose {
+				s.add(-1, SyncLoss, "%d consecutive sync byte errors", bad)
+				synced = false
+				continue
+			}
+		} else {
+			bad = 0
+			s.packet(b)
+		}
+
+		_, _ = r.Discard(tsPacketLen)
+		s.offset += tsPacketLen
+	}
+}
+
+// acquire 查找连续5个间隔为TS包长度的同步字节(流结束前不足5个时, 剩余的都是同步字节也可以), 丢弃之前的数据
+func (s *analysis) acquire(r *bufio.Reader) error {
+	for {
+		b, err := r.Peek(syncAcquire * tsPacketLen)
+		if len(b) < tsPacketLen {
+			return err
+		}
+
+		i := bytes.IndexByte(b, syncByte)
+		if i < 0 {
+			i = len(b)
+		}
+		if i == 0 {
+			ok := true
+			for k := tsPacketLen; k < len(b); k += tsPacketLen {
+				if b[k] != syncByte {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				return nil
+			}
+			i = 1
+		}
+
+		n, err := r.Discard(i)
+		s.offset += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// packet 检查一个TS包
+func (s *analysis) packet(b []byte) {
+	s.packets++
+
+	pid := uint16(b[1]&0x1f)<<8 | uint16(b[2])
+	st := s.pid(pid)
+	st.packets++
+
+	// transport_error_indicator, 包已损坏
+	if b[1]&0x80 != 0 {
+		s.add(int(pid), TransportError, "transport_error_indicator is set")
+		return
+	}
+
+	if pid == nullPID {
+		return
+	}
+
+	pusi := b[1]&0x40 != 0
+	scrambling := b[3] >> 6
+	afc := (b[3] >> 4) & 0x03
+	cc := b[3] & 0x0f
+
+	// 自适应域
+	payload := b[4:]
+	var discontinuity bool
+	pcr := int64(-1)
+	if afc&0x02 != 0 {
+		n := int(payload[0])
+		if 1+n > len(payload) {
+			return
+		}
+
+		if n > 0 {
+			discontinuity = payload[1]&0x80 != 0
+			if payload[1]&0x10 != 0 && n >= 7 {
+				pcr = readPcr(payload[2:])
+			}
+		}
+		payload = payload[1+n:]
+	}
+
+	if pcr >= 0 {
+		if s.clock.pid < 0 {
+			s.clock.pid = int(pid)
+		}
+		if s.clock.pid == int(pid) {
+			s.clock.update(pcr, s.offset, discontinuity)
+		}
+	}
+
+	s.now = s.clock.at(s.offset)
+	if s.now >= 0 && s.first < 0 {
+		s.first = s.now
+	}
+
+	// PMT的间隔以PMT的section为准, 而不是任意TS包
+	if !st.isPmt {
+		st.seen = s.now
+	}
+
+	s.checkCC(st, cc, afc&0x01 != 0, discontinuity)
+	if pcr >= 0 {
+		s.checkPcr(st, pcr, discontinuity)
+	}
+	s.checkIntervals()
+
+	if afc&0x01 == 0 {
+		return
+	}
+
+	// 加扰的包无法解析
+	if scrambling != 0 {
+		switch {
+		case pid == patPID:
+			s.add(int(pid), PatError, "scrambled pat")
+		case st.isPmt:
+			s.add(int(pid), PmtError, "scrambled pmt")
+		case !s.catSeen && !st.catReported:
+			st.catReported = true
+			s.add(int(pid), CatError, "scrambled packet without cat")
+		}
+		return
+	}
+
+	if st.psi != nil {
+		for _, section := range st.psi.Push(pusi, payload) {
+			s.section(st, section)
+		}
+		return
+	}
+
+	if pusi && st.streamType != 0 {
+		s.pes(st, payload)
+	}
+}
+
+// checkCC 检查连续计数器, 没有负载的包不增加计数器, 允许重复发送一次
+func (s *analysis) checkCC(st *pidState, cc byte, hasPayload, discontinuity bool) {
+	if !hasPayload {
+		return
+	}
+
+	if !st.hasCC || discontinuity {
+		st.cc, st.hasCC, st.dup = cc, true, 0
+		return
+	}
+
+	if cc == st.cc {
+		st.dup++
+		if st.dup > 1 {
+			s.add(int(st.pid), ContinuityError, "packet repeated %d times", st.dup+1)
+		}
+		return
+	}
+
+	if want := (st.cc + 1) & 0x0f; cc != want {
+		s.add(int(st.pid), ContinuityError, "got %d, want %d", cc, want)
+	}
+	st.cc, st.dup = cc, 0
+}
+
+// checkPcr 检查PCR的间隔, 跳跃和精度
+func (s *analysis) checkPcr(st *pidState, pcr int64, discontinuity bool) {
+	if discontinuity || st.pcrs == 0 {
+		st.pcr, st.pcrPos, st.pcrs = pcr, s.offset, 1
+		return
+	}
+
+	d := diff(pcr, st.pcr, pcrWrap)
+	switch {
+	case d < 0 || d > ticks(maxPcrJump, pcrHZ):
+		s.add(int(st.pid), PcrDiscontinuityError, "pcr changed by %v without discontinuity indicator", duration(d, pcrHZ))
+		st.pcr, st.pcrPos, st.pcrs = pcr, s.offset, 1
+		return
+	case d > ticks(maxPcrInterval, pcrHZ):
+		s.add(int(st.pid), PcrRepetitionError, "pcr interval %v", duration(d, pcrHZ))
+	}
+
+	// 按照前两个PCR之间的码率推算当前PCR
+	if s.pcrAccuracy && st.pcrs >= 2 && st.pcrPos > st.prevPcrPos {
+		expected := st.pcr + (s.offset-st.pcrPos)*diff(st.pcr, st.prevPcr, pcrWrap)/(st.pcrPos-st.prevPcrPos)
+		if jitter := diff(pcr, expected, pcrWrap); abs(jitter) > ticks(maxPcrInaccuracy, pcrHZ) {
+			s.add(int(st.pid), PcrAccuracyError, "pcr is off by %v", duration(jitter, pcrHZ))
+		}
+	}
+
+	st.prevPcr, st.prevPcrPos = st.pcr, st.pcrPos
+	st.pcr, st.pcrPos = pcr, s.offset
+	st.pcrs++
+}
+
+// checkIntervals 按照参考时钟检查PAT, PMT和基本流的间隔, 超时后重新计时
+func (s *analysis) checkIntervals() {
+	if s.now < 0 {
+		return
+	}
+
+	if s.patTime < 0 {
+		s.patTime = s.now
+	}
+	if d := s.now - s.patTime; d > ticks(maxPatInterval, pcrHZ) {
+		s.add(patPID, PatError, "no pat for %v", duration(d, pcrHZ))
+		s.patTime = s.now
+	}
+
+	for _, st := range s.pids {
+		if !st.referenced {
+			continue
+		}
+		if st.seen < 0 {
+			st.seen = s.now
+		}
+
+		d := s.now - st.seen
+		switch {
+		case st.isPmt && d > ticks(maxPmtInterval, pcrHZ):
+			s.add(int(st.pid), PmtError, "no pmt for %v", duration(d, pcrHZ))
+			st.seen = s.now
+		case !st.isPmt && !isSparse(st.streamType) && d > ticks(maxPidInterval, pcrHZ):
+			s.add(int(st.pid), PidError, "no packet for %v", duration(d, pcrHZ))
+			st.seen = s.now
+		}
+	}
+}
+
+// section 检查PSI的section, 解析PAT和PMT
+func (s *analysis) section(st *pidState, section []byte) {
+	// 长格式section带有CRC32, 包含CRC32在内的CRC32结果为0
+	if section[1]&0x80 != 0 && table.Crc32(section) != 0 {
+		s.add(int(st.pid), CrcError, "table_id %#02x", section[0])
+		return
+	}
+
+	switch {
+	case st.pid == patPID:
+		if section[0] != table.TablePat {
+			s.add(int(st.pid), PatError, "table_id %#02x on pat pid", section[0])
+			return
+		}
+
+		pat, err := table.ParsePat(section)
+		if err != nil {
+			return
+		}
+		s.patTime = s.now
+
+		for _, prog := range pat.Programs {
+			if prog.Number == 0 {
+				continue
+			}
+
+			pmt := s.pid(prog.PID)
+			if !pmt.isPmt {
+				pmt.isPmt, pmt.referenced = true, true
+				pmt.psi = &table.SectionAssembler{}
+				pmt.seen = s.now
+			}
+		}
+	case st.pid == catPID:
+		if section[0] != tableCat {
+			s.add(int(st.pid), CatError, "table_id %#02x on cat pid", section[0])
+			return
+		}
+		s.catSeen = true
+	case st.isPmt && section[0] == table.TablePmt:
+		pmt, err := table.ParsePmt(section)
+		if err != nil {
+			return
+		}
+
+		st.seen = s.now
+		for _, es := range pmt.Streams {
+			ps := s.pid(es.PID)
+			if !ps.referenced {
+				ps.referenced = true
+				ps.seen = s.now
+			}
+			ps.streamType = es.StreamType
+		}
+	}
+}
+
+// pes 检查PES头中的PTS间隔(稀疏的流除外)
+func (s *analysis) pes(st *pidState, payload []byte) {
+	if isSparse(st.streamType) || st.streamType == table.StreamTypeScte35 {
+		return
+	}
+
+	info, err := table.ParsePes(payload)
+	if err != nil || !info.HasPTS {
+		return
+	}
+
+	if st.hasPts {
+		if d := diff(info.PTS, st.pts, ptsWrap); d > ticks(maxPtsInterval, ptsHZ) {
+			s.add(int(st.pid), PtsError, "pts interval %v", duration(d, ptsHZ))
+		}
+	}
+	st.pts, st.hasPts = info.PTS, true
+}
+
+// report 生成分析结果
+func (s *analysis) report() *Report {
+	r := &Report{
+		Packets: s.packets,
+		Errors:  s.errors,
+	}
+
+	var ticks int64
+	if s.first >= 0 && s.now > s.first {
+		ticks = s.now - s.first
+		r.Duration = duration(ticks, pcrHZ)
+		r.Bitrate = bitrate(s.packets, ticks)
+	}
+
+	for _, st := range s.pids {
+		r.Pids = append(r.Pids, PidStats{
+			PID:        st.pid,
+			StreamType: st.streamType,
+			Type:       st.describe(s.clock.pid),
+			Packets:    st.packets,
+			Bitrate:    bitrate(st.packets, ticks),
+		})
+	}
+	sort.Slice(r.Pids, func(i, j int) bool {
+		return r.Pids[i].PID < r.Pids[j].PID
+	})
+
+	return r
+}
+
+// 流类型的描述
+var streamTypeNames = map[uint8]string{
+	table.StreamTypeMpeg1Audio:  "MPEG-1 audio",
+	table.StreamTypeMpeg2Audio:  "MPEG-2 audio",
+	table.StreamTypePrivateData: "private data",
+	table.StreamTypeAac:         "AAC",
+	table.StreamTypeMetadata:    "ID3 metadata",
+	table.StreamTypeAvc:         "H.264",
+	table.StreamTypeHevc:        "H.265",
+	table.StreamTypeAc3:         "AC-3",
+	table.StreamTypeScte35:      "SCTE-35",
+	table.StreamTypeEac3:        "E-AC-3",
+
+	table.StreamTypeAacSampleAES: "AAC SAMPLE-AES",
+	table.StreamTypeAvcSampleAES: "H.264 SAMPLE-AES",
+}
+
+// describe PID的类型描述
+func (st *pidState) describe(pcrPID int) string {
+	switch {
+	case st.pid == patPID:
+		return "PAT"
+	case st.pid == catPID:
+		return "CAT"
+	case st.pid == nitPID:
+		return "NIT"
+	case st.pid == sdtPID:
+		return "SDT"
+	case st.pid == eitPID:
+		return "EIT"
+	case st.pid == nullPID:
+		return "null"
+	case st.isPmt:
+		return "PMT"
+	case st.streamType != 0:
+		if name, ok := streamTypeNames[st.streamType]; ok {
+			return name
+		}
+		return fmt.Sprintf("stream type %#02x", st.streamType)
+	case int(st.pid) == pcrPID:
+		return "PCR"
+	}
+
+	return "unknown"
+}
+
+// isSparse 数据不连续的流(ID3, SCTE-35), 不检查PID和PTS的间隔
+func isSparse(streamType uint8) bool {
+	return streamType == table.StreamTypeMetadata || streamType == table.StreamTypeScte35
+}
+
+// readPcr 读取自适应域中的PCR(27MHz), b从program_clock_reference_base开始
+func readPcr(b []byte) int64 {
+	base := int64(b[0])<<25 | int64(b[1])<<17 | int64(b[2])<<9 | int64(b[3])<<1 | int64(b[4])>>7
+	ext := int64(b[4]&0x01)<<8 | int64(b[5])
+
+	return base*300 + ext
+}
+
+// diff 考虑回绕的时间差a-b
+func diff(a, b, wrap int64) int64 {
+	d := (a - b) % wrap
+	switch {
+	case d > wrap/2:
+		d -= wrap
+	case d < -wrap/2:
+		d += wrap
+	}
+
+	return d
+}
+
+// ticks 时长转换为时钟计数
+func ticks(d time.Duration, hz int64) int64 {
+	return int64(d) * hz / int64(time.Second)
+}
+
+// duration 时钟计数转换为时长
+func duration(n, hz int64) time.Duration {
+	return time.Duration(n/hz*int64(time.Second) + n%hz*int64(time.Second)/hz)
+}
+
+// bitrate 计算码率(bit/s)
+func bitrate(packets, ticks int64) int64 {
+	if ticks <= 0 {
+		return 0
+	}
+
+	return int64(float64(packets*tsPacketLen*8) * pcrHZ / float64(ticks))
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
@@ -0,0 +1,291 @@
+package analyze
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/container/ts"
+	"github.com/nextpkg/goav/container/ts/table"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// tsPacket 生成TS包, pcr(27MHz)小于0时不带PCR, 负载不足时使用自适应域填充
+func tsPacket(pid uint16, cc byte, pusi bool, pcr int64, payload []byte) []byte {
+	b := make([]byte, 4, tsPacketLen)
+	b[0], b[1], b[2] = syncByte, byte(pid>>8)&0x1f, byte(pid)
+	if pusi {
+		b[1] |= 0x40
+	}
+
+	afc := byte(0x10)
+	if len(payload) == 0 {
+		afc = 0x20
+	}
+
+	if pcr >= 0 || len(payload) < tsPacketLen-4 {
+		afc |= 0x20
+		n := tsPacketLen - 5 - len(payload)
+		b = append(b, byte(n))
+		if n > 0 {
+			flags := byte(0)
+			if pcr >= 0 {
+				flags = 0x10
+			}
+			b = append(b, flags)
+		}
+		if pcr >= 0 {
+			base, ext := pcr/300, pcr%300
+			b = append(b, byte(base>>25), byte(base>>17), byte(base>>9), byte(base>>1), byte(base<<7)|0x7e|byte(ext>>8), byte(ext))
+		}
+		for len(b) < tsPacketLen-len(payload) {
+			b = append(b, 0xff)
+		}
+	}
+	b[3] = afc | cc&0x0f
+
+	return append(b, payload...)
+}
+
+// pesPacket 带有PTS(90kHz)的音频PES, 只有一个TS包
+func pesPacket(pid uint16, cc byte, pts int64) []byte {
+	pes := table.NewPes()
+	n := pes.GeneratePesHeader(packet.PktAudio, 16, pts, pts)
+
+	return tsPacket(pid, cc, true, -1, append(pes.PesHeader[:n:n], make([]byte, 16)...))
+}
+
+// mixerStream 以1Mbps的固定码率复用2秒钟的AAC
+func mixerStream(at *assert.Assertions) []byte {
+	buf := bytes.NewBuffer(nil)
+	m := ts.NewMixer(buf)
+	m.SetMuxRate(1000000)
+	// PCR在间隔到期后的下一个TS包(或者PSI之后)发送, 需要留出余量才能不超过40ms
+	m.SetInterval(100*time.Millisecond, 30*time.Millisecond)
+	d := flv.NewDemuxer()
+
+	aacSeqHdr := &packet.Packet{Type: packet.PktAudio, Data: []byte{0xaf, 0x00, 0x12, 0x10}}
+	at.Nil(d.Demux(aacSeqHdr))
+	at.Nil(m.SaveAACHeader(aacSeqHdr))
+	at.Nil(m.SetTsHeader())
+
+	for ts := uint32(0); ts < 2000; ts += 23 {
+		audio := make([]byte, 202)
+		audio[0], audio[1] = 0xaf, 0x01
+		p := &packet.Packet{Type: packet.PktAudio, TimeStamp: ts, Data: audio}
+		at.Nil(d.Demux(p))
+		at.Nil(m.Update(p, ts, 0))
+		at.Nil(m.Mux(p))
+	}
+
+	return buf.Bytes()
+}
+
+// findPacket 返回指定PID的第n个TS包的偏移
+func findPacket(b []byte, pid uint16, n int) int {
+	for i := 0; i+tsPacketLen <= len(b); i += tsPacketLen {
+		if uint16(b[i+1]&0x1f)<<8|uint16(b[i+2]) == pid {
+			if n == 0 {
+				return i
+			}
+			n--
+		}
+	}
+
+	return -1
+}
+
+func analyze(at *assert.Assertions, b []byte, pcrAccuracy bool) *Report {
+	a := NewAnalyzer()
+	a.SetPcrAccuracy(pcrAccuracy)
+
+	r, err := a.Analyze(bytes.NewReader(b))
+	at.Nil(err)
+
+	return r
+}
+
+func TestAnalyze_Mixer(t *testing.T) {
+	at := assert.New(t)
+
+	b := mixerStream(at)
+	// 固定码率的PCR精确到27MHz, 同时检查PCR的精度
+	r := analyze(at, b, true)
+	at.Empty(r.Errors)
+
+	at.Equal(int64(len(b)/tsPacketLen), r.Packets)
+	at.InDelta(1000000, r.Bitrate, 10000)
+	at.InDelta(float64(2*time.Second), float64(r.Duration), float64(100*time.Millisecond))
+
+	var types []string
+	var pids []uint16
+	for _, s := range r.Pids {
+		pids = append(pids, s.PID)
+		types = append(types, s.Type)
+	}
+	at.Equal([]uint16{0x0000, 0x0011, 0x0101, 0x1000, 0x1001, 0x1fff}, pids)
+	at.Equal([]string{"PAT", "SDT", "AAC", "PCR", "PMT", "null"}, types)
+	at.Equal(uint8(table.StreamTypeAac), r.Pids[2].StreamType)
+	at.True(r.Pids[5].Bitrate > r.Pids[2].Bitrate)
+}
+
+func TestAnalyze_Corrupted(t *testing.T) {
+	at := assert.New(t)
+
+	clean := mixerStream(at)
+	audio := findPacket(clean, 0x0101, 10)
+	pat := findPacket(clean, 0x0000, 3)
+
+	cases := []struct {
+		name   string
+		modify func(b []byte) []byte
+		kind   Kind
+		count  int
+	}{
+		{"transport error", func(b []byte) []byte {
+			b[audio+1] |= 0x80
+			return b
+		}, TransportError, 1},
+		{"continuity", func(b []byte) []byte {
+			b[audio+3] = b[audio+3]&0xf0 | (b[audio+3]+5)&0x0f
+			return b
+		}, ContinuityError, 2},
+		{"crc", func(b []byte) []byte {
+			b[pat+4+1+8] ^= 0x01
+			return b
+		}, CrcError, 1},
+		{"pat table id", func(b []byte) []byte {
+			b[pat+4+1] = 0x02
+			return b
+		}, CrcError, 1},
+		{"scrambled", func(b []byte) []byte {
+			b[audio+3] |= 0x80
+			b[findPacket(b, 0x0101, 20)+3] |= 0x80
+			return b
+		}, CatError, 1},
+		{"sync byte", func(b []byte) []byte {
+			b[audio] = 0x46
+			return b
+		}, SyncByte, 1},
+		{"sync loss", func(b []byte) []byte {
+			return append(b[:audio:audio], append([]byte{0x00}, b[audio:]...)...)
+		}, SyncLoss, 1},
+	}
+
+	for _, c := range cases {
+		b := c.modify(append([]byte(nil), clean...))
+		r := analyze(at, b, false)
+		at.Equal(c.count, r.Count(c.kind), c.name)
+		if c.kind == TransportError {
+			at.Equal(Error{Offset: int64(audio), PID: 0x0101, Kind: TransportError, Detail: "transport_error_indicator is set"}, r.Errors[0])
+		}
+	}
+}
+
+func TestAnalyze_Pcr(t *testing.T) {
+	at := assert.New(t)
+
+	ms := int64(pcrHZ / 1000)
+	var b []byte
+	var cc byte
+	add := func(pcr int64, discontinuity bool) {
+		pkt := tsPacket(0x0100, cc, false, pcr, nil)
+		if discontinuity {
+			pkt[5] |= 0x80
+		}
+		b = append(b, pkt...)
+	}
+
+	// 间隔50ms, 跳跃290ms, 回退20ms
+	for _, pcr := range []int64{0, 30 * ms, 60 * ms, 110 * ms, 400 * ms, 380 * ms} {
+		add(pcr, false)
+	}
+
+	// 不连续标识之后重新开始, 最后一个PCR偏差1us
+	add(0, true)
+	for _, pcr := range []int64{30 * ms, 60 * ms, 90 * ms, 120*ms + 27} {
+		add(pcr, false)
+	}
+
+	// 间隔50ms的PCR同时偏离了固定码率的推算
+	r := analyze(at, b, true)
+	at.Equal(1, r.Count(PcrRepetitionError))
+	at.Equal(2, r.Count(PcrDiscontinuityError))
+	at.Equal(2, r.Count(PcrAccuracyError))
+	at.Equal(int64(len(b)-tsPacketLen), r.Errors[len(r.Errors)-1].Offset)
+
+	r = analyze(at, b, false)
+	at.Equal(0, r.Count(PcrAccuracyError))
+}
+
+func TestAnalyze_Intervals(t *testing.T) {
+	at := assert.New(t)
+
+	prog := ts.NewProgram(1, 0x1001)
+	prog.AddStream(0x0100, table.StreamTypeAvc, packet.PktVideo)
+	prog.AddStream(0x0101, table.StreamTypeAac, packet.PktAudio)
+	prog.AddStream(0x0102, table.StreamTypeAac, packet.PktAudio)
+	prog.AddStream(0x0103, table.StreamTypeScte35, packet.PktMetadata)
+	muxer := ts.NewMuxerWithPrograms(1, prog)
+
+	// PAT和PMT只发送一次, 之后每20ms一个PCR; 0x101的PTS间隔800ms, 0x102和0x103没有数据
+	b := append(muxer.PAT(), muxer.PMT()...)
+	var cc byte
+	for i := int64(0); i <= 300; i++ {
+		b = append(b, tsPacket(0x0100, 0, false, i*20*pcrHZ/1000, nil)...)
+
+		switch i {
+		case 0, 25, 65:
+			b = append(b, pesPacket(0x0101, cc, i*20*ptsHZ/1000)...)
+			cc++
+		}
+	}
+
+	// PES打乱了PCR之间的字节间隔, 不检查PCR的精度
+	r := analyze(at, b, false)
+	at.Equal(11, r.Count(PatError))
+	at.Equal(11, r.Count(PmtError))
+	at.Equal(1, r.Count(PtsError))
+	at.Equal(1, r.Count(PidError))
+	at.Equal(0, r.Count(ContinuityError))
+	at.Equal(len(r.Errors), r.Count(PatError)+r.Count(PmtError)+2)
+
+	// 默认不检查PCR的精度
+	d, err := NewAnalyzer().Analyze(bytes.NewReader(b))
+	at.Nil(err)
+	at.Equal(r.Errors, d.Errors)
+	at.Equal(0, d.Count(PcrAccuracyError))
+	at.NotZero(analyze(at, b, true).Count(PcrAccuracyError))
+
+	for _, e := range r.Errors {
+		switch e.Kind {
+		case PidError:
+			at.Equal(0x0102, e.PID)
+		case PtsError:
+			at.Equal(0x0101, e.PID)
+			at.Equal("pts interval 800ms", e.Detail)
+		}
+	}
+
+	types := map[uint16]string{}
+	for _, s := range r.Pids {
+		types[s.PID] = s.Type
+	}
+	at.Equal(map[uint16]string{0x0000: "PAT", 0x0100: "H.264", 0x0101: "AAC", 0x0102: "AAC", 0x0103: "SCTE-35", 0x1001: "PMT"}, types)
+}
+
+func TestKind(t *testing.T) {
+	at := assert.New(t)
+
+	at.Equal("Continuity_count_error", ContinuityError.String())
+	at.Equal("1.4", ContinuityError.Indicator())
+	at.Equal(1, PidError.Priority())
+	at.Equal(2, TransportError.Priority())
+	at.Equal("2.3b", PcrDiscontinuityError.Indicator())
+	at.Equal(2, CatError.Priority())
+
+	e := Error{Offset: 376, PID: 0x100, Kind: PcrRepetitionError, Detail: "pcr interval 50ms"}
+	at.Equal("offset 376: 2.3a PCR_repetition_error: pid 0x0100: pcr interval 50ms", e.String())
+}
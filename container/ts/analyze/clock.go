@@ -0,0 +1,41 @@
+package analyze
+
+// clock 参考时钟, 使用一个PID上的PCR, 两个PCR之间按照字节位置线性插值
+// 时间线是连续的: PCR回绕时继续累加, 不连续标识之后从推算的时间继续
+type clock struct {
+	pid int // 参考PCR的PID, 小于0表示还没有PCR
+
+	raw              int64 // 最近的PCR的原始值
+	pcr, pos         int64 // 最近的PCR在时间线上的时间(27MHz)及其位置
+	prevPcr, prevPos int64
+	n                int // 连续的PCR个数
+}
+
+// update 记录参考PID上的PCR
+func (c *clock) update(pcr, pos int64, discontinuity bool) {
+	t := pcr
+	if c.n > 0 {
+		t = c.pcr + diff(pcr, c.raw, pcrWrap)
+		if discontinuity {
+			t = c.at(pos)
+			c.n = 0
+		}
+	}
+
+	c.prevPcr, c.prevPos = c.pcr, c.pos
+	c.raw, c.pcr, c.pos = pcr, t, pos
+	c.n++
+}
+
+// at 返回指定位置的时间(27MHz), 还没有PCR时返回-1
+// 只有一个PCR时使用该PCR, 之后按照最近两个PCR之间的码率推算
+func (c *clock) at(pos int64) int64 {
+	if c.n == 0 {
+		return -1
+	}
+	if c.n == 1 || c.pos <= c.prevPos {
+		return c.pcr
+	}
+
+	return c.pcr + (pos-c.pos)*(c.pcr-c.prevPcr)/(c.pos-c.prevPos)
+}
@@ -0,0 +1,111 @@
+package ts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nextpkg/goav/container/ts/table"
+)
+
+// defaultMuxDelay 固定码率时数据包先于DTS发送的时间(解码器的缓冲时间)
+const defaultMuxDelay = 700 * time.Millisecond
+
+// ErrMuxRateOverflow 媒体数据超过了固定码率, 数据包在DTS之后才能发送完(数据仍然会输出)
+var ErrMuxRateOverflow = errors.New("media exceeds the mux rate")
+
+// cbr 固定码率输出, 根据已输出的TS包数计算时间, 并将TS包中的PCR改写为该时间(27MHz)
+type cbr struct {
+	w       io.Writer
+	rate    int64 // 码率, bit/s
+	delay   int64 // 数据包先于DTS发送的时间(90kHz)
+	start   int64 // 第一个TS包的时间
+	packets int64 // 已输出的TS包数
+	started bool
+
+	buf [tsPacketLen]byte
+}
+
+// newCbr 固定码率输出, rate: 码率(bit/s)
+func newCbr(w io.Writer, rate int) *cbr {
+	return &cbr{
+		w:     w,
+		rate:  int64(rate),
+		delay: defaultMuxDelay.Milliseconds() * avcHZ,
+	}
+}
+
+// clock 下一个TS包的时间(90kHz)
+func (c *cbr) clock() int64 {
+	return c.pcr() / 300
+}
+
+// pcr 下一个TS包的PCR(27MHz), 精确到码率下单个比特的时间
+func (c *cbr) pcr() int64 {
+	const hz = avcHZ * 1000 * 300
+
+	bits := c.packets * tsPacketLen * 8
+	return c.start*300 + bits/c.rate*hz + bits%c.rate*hz/c.rate
+}
+
+// shift 时间线跳变时时钟随之跳变(90kHz), 已经缓冲的时间保持不变
+func (c *cbr) shift(d int64) {
+	if c.started {
+		c.start += d
+	}
+}
+
+// begin 以第一个数据包的DTS确定时钟的起点, 之前输出的TS包(PSI)排在它之前
+func (c *cbr) begin(dts int64) {
+	if c.started {
+		return
+	}
+
+	c.started = true
+	c.start = 0
+	c.start = dts - c.clock()
+}
+
+// Write 输出TS包(b的长度必须是TS包长度的整数倍), 带有PCR的TS包改写PCR
+func (c *cbr) Write(b []byte) (int, error) {
+	if len(b)%tsPacketLen != 0 {
+		return 0, fmt.Errorf("cbr output must be whole ts packets(len=%d)", len(b))
+	}
+
+	for i := 0; i < len(b); i += tsPacketLen {
+		pkt := b[i : i+tsPacketLen]
+		if hasPcr(pkt) {
+			copy(c.buf[:], pkt)
+			table.NewPes().WritePcr27M(c.buf[6:], c.pcr())
+			pkt = c.buf[:]
+		}
+
+		_, err := c.w.Write(pkt)
+		if err != nil {
+			return i, err
+		}
+		c.packets++
+	}
+
+	return len(b), nil
+}
+
+// hasPcr 判断TS包的自适应域中是否带有PCR
+func hasPcr(b []byte) bool {
+	return b[3]&0x20 != 0 && b[4] >= 7 && b[5]&0x10 != 0
+}
+
+// nullPacket 空包(PID 0x1FFF), 用于填充码率
+func nullPacket() []byte {
+	b := make([]byte, tsPacketLen)
+	b[0] = syncByte
+	b[1] = byte(nullPID >> 8)
+	b[2] = byte(nullPID & 0xff)
+	b[3] = 0x10
+	for i := 4; i < tsPacketLen; i++ {
+		b[i] = 0xff
+	}
+
+	return b
+}
@@ -0,0 +1,179 @@
+package ts
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCbr_Clock(t *testing.T) {
+	at := assert.New(t)
+
+	// 每毫秒一个TS包
+	buf := bytes.NewBuffer(nil)
+	c := newCbr(buf, tsPacketLen*8*1000)
+
+	_, err := c.Write(nullPacket())
+	at.Nil(err)
+	c.begin(1000 * avcHZ)
+	at.Equal(int64(1000*avcHZ), c.clock())
+
+	// 时钟的起点只确定一次
+	c.begin(0)
+	at.Equal(int64(1000*avcHZ), c.clock())
+
+	// PCR被改写为TS包的时间
+	pcr := NewMuxer().PCR(newDefaultProgram(), 0)
+	n, err := c.Write(append(append([]byte(nil), pcr...), pcr...))
+	at.Nil(err)
+	at.Equal(2*tsPacketLen, n)
+	at.Equal(int64(1002*avcHZ), c.clock())
+
+	data := buf.Bytes()
+	at.Equal(3*tsPacketLen, len(data))
+	at.Equal(int64(1000*avcHZ), readPcr(data[tsPacketLen:]))
+	at.Equal(int64(1001*avcHZ), readPcr(data[2*tsPacketLen:]))
+
+	// 输出必须是完整的TS包
+	_, err = c.Write(pcr[:100])
+	at.NotNil(err)
+
+	// 时间线跳变时时钟随之跳变
+	c.shift(-500 * avcHZ)
+	at.Equal(int64(502*avcHZ), c.clock())
+
+	// 不是整数个90kHz周期时, PCR的extension为27MHz下的余数
+	buf.Reset()
+	c = newCbr(buf, 1000000)
+	c.begin(0)
+	_, err = c.Write(append(append([]byte(nil), pcr...), pcr...))
+	at.Nil(err)
+	at.Equal(int64(2*tsPacketLen*8*27), c.pcr())
+	data = buf.Bytes()
+	at.Equal(int64(tsPacketLen*8*27/300), readPcr(data[tsPacketLen:]))
+	at.Equal(byte(tsPacketLen*8*27%300), data[tsPacketLen+11])
+}
+
+// readPcr 读取TS包中的PCR(90kHz)
+func readPcr(b []byte) int64 {
+	return int64(b[6])<<25 | int64(b[7])<<17 | int64(b[8])<<9 | int64(b[9])<<1 | int64(b[10])>>7
+}
+
+// muxCbr 以固定码率复用1秒钟的H264和AAC, 返回TS数据和最后一个错误
+func muxCbr(at *assert.Assertions, rate, videoSize int) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+	m.SetMuxRate(rate)
+	d := flv.NewDemuxer()
+
+	sps := []byte{0x67, 0x4d, 0x00, 0x1e, 0x9a, 0x66, 0x02, 0x80}
+	pps := []byte{0x68, 0xee, 0x3c, 0x80}
+
+	avcSeqHdr := &packet.Packet{Type: packet.PktVideo, Data: append([]byte{0x17, 0x00, 0x00, 0x00, 0x00}, avcConfig(sps, pps)...)}
+	at.Nil(d.Demux(avcSeqHdr))
+	at.Nil(m.SaveAVCHeader(avcSeqHdr))
+
+	aacSeqHdr := &packet.Packet{Type: packet.PktAudio, Data: []byte{0xaf, 0x00, 0x12, 0x10}}
+	at.Nil(d.Demux(aacSeqHdr))
+	at.Nil(m.SaveAACHeader(aacSeqHdr))
+	at.Nil(m.SetTsHeader())
+
+	audio := make([]byte, 202)
+	audio[0], audio[1] = 0xaf, 0x01
+
+	var frames []*packet.Packet
+	for ts := uint32(0); ts < 1000; ts += 40 {
+		flag, nalu := byte(0x27), byte(0x41)
+		if ts%500 == 0 {
+			flag, nalu = 0x17, 0x65
+		}
+
+		frames = append(frames,
+			&packet.Packet{Type: packet.PktVideo, TimeStamp: ts, Data: avccFrame(flag, 0, nalu, videoSize)},
+			&packet.Packet{Type: packet.PktAudio, TimeStamp: ts, Data: append([]byte(nil), audio...)},
+		)
+	}
+
+	var last error
+	for _, p := range frames {
+		at.Nil(d.Demux(p))
+		at.Nil(m.Update(p, p.TimeStamp, 0))
+
+		err := m.Mux(p)
+		if err != nil {
+			last = err
+		}
+	}
+
+	return buf.Bytes(), last
+}
+
+func TestMixer_Cbr(t *testing.T) {
+	at := assert.New(t)
+
+	const rate = 1000000
+	data, err := muxCbr(at, rate, 1000)
+	at.Nil(err)
+	at.Equal(0, len(data)%tsPacketLen)
+
+	// 空包填充到最后一帧(960ms)之后才输出该帧
+	packets := len(data) / tsPacketLen
+	at.True(packets > 960*rate/1000/(tsPacketLen*8), "packets=%d", packets)
+
+	// 空包填充码率; PCR与TS包的位置一致
+	var nulls int
+	first, firstPcr, lastPcr := -1, int64(0), int64(-1)
+	for i := 0; i < packets; i++ {
+		b := data[i*tsPacketLen:]
+		pid := uint16(b[1]&0x1f)<<8 | uint16(b[2])
+		if pid == nullPID {
+			nulls++
+			continue
+		}
+
+		if !hasPcr(b) {
+			continue
+		}
+
+		pcr := readPcr(b)
+		if first < 0 {
+			first, firstPcr = i, pcr
+		}
+
+		want := firstPcr + int64(i-first)*tsPacketLen*8*90000/rate
+		at.InDelta(want, pcr, 1, "packet %d", i)
+
+		// 间隔在TS包之间检查, 允许多出一帧视频的发送时间
+		if lastPcr >= 0 {
+			at.True(pcr-lastPcr <= 40*avcHZ+8*tsPacketLen*8*90000/rate, "pcr gap=%d", pcr-lastPcr)
+		}
+		lastPcr = pcr
+	}
+	at.True(nulls > packets/2, "nulls=%d", nulls)
+
+	// 空包和PCR不影响解复用
+	dmx := NewDemuxer(bytes.NewReader(data))
+	var n int
+	for {
+		err := dmx.Read(&packet.Packet{})
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+		n++
+	}
+	at.Equal(52, n)
+	at.Equal(0, dmx.ContinuityErrors())
+}
+
+func TestMixer_CbrOverflow(t *testing.T) {
+	at := assert.New(t)
+
+	// 每帧20000字节, 25帧/秒, 超过1Mbps
+	_, err := muxCbr(at, 1000000, 20000)
+	at.Equal(ErrMuxRateOverflow, err)
+}
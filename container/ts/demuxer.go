@@ -0,0 +1,397 @@
+package ts
+
+import (
+	"bytes"
+	"io"
+	"sort"
+
+	"github.com/nextpkg/goav/container/ts/table"
+	"github.com/nextpkg/goav/packet"
+)
+
+const syncByte = 0x47
+
+// 固定的PID
+const (
+	patPID  = 0x0000
+	sdtPID  = 0x0011
+	nullPID = 0x1fff
+)
+
+// Demuxer TS解复用器, 从TS流中解析出音视频数据包, 实现 packet.Reader
+// 输出的数据包与FLV解复用后的数据包一致(p.Data 为FLV的Tag Data, p.Header 为FLV的Tag), 可以直接交给FLV和GOP缓存处理;
+// p.Media 为Annex-B格式的H264/H265或者ADTS格式的AAC(EnableTagMedia 时与FLV解复用一致), 编码参数变化时先输出序列头;
+// SCTE-35转换为 onCuePoint 脚本数据包
+type Demuxer struct {
+	r   io.Reader
+	buf [tsPacketLen]byte
+
+	pmts    map[uint16]*table.PmtSection // PMT的PID -> PMT, 收到PAT但还未收到PMT时为nil
+	sdt     *table.SdtSection
+	streams map[uint16]*esStream               // 基本流的PID -> 基本流
+	psi     map[uint16]*table.SectionAssembler // PSI的PID -> 正在组装的section
+	cc      map[uint16]byte                    // PID -> 上一个连续计数器
+
+	queue  []*packet.Packet // 已解析, 待输出的数据包
+	lastTs uint32           // 最近输出的音视频时间戳, 用作没有时间的SCTE-35的时间
+	eof    bool
+
+	dts    int64 // 上一个PES的DTS(90kHz, 已扩展为64位)
+	gotDts bool
+
+	ccErrors  int
+	crcErrors int
+
+	tagMedia bool
+}
+
+// NewDemuxer TS解复用器
+func NewDemuxer(r io.Reader) *Demuxer {
+	return &Demuxer{
+		r:       r,
+		pmts:    make(map[uint16]*table.PmtSection),
+		streams: make(map[uint16]*esStream),
+		psi:     make(map[uint16]*table.SectionAssembler),
+		cc:      make(map[uint16]byte),
+	}
+}
+
+// Read 读取下一个数据包, 流结束时返回io.EOF
+// 流结束时输出所有未完成的PES, 最后一个不完整的TS包被丢弃(抓包文件经常在任意位置截断)
+func (d *Demuxer) Read(p *packet.Packet) error {
+	for len(d.queue) == 0 {
+		if d.eof {
+			return io.EOF
+		}
+
+		err := d.readPacket()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			d.eof = true
+			err = d.flushAll()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	*p = *d.queue[0]
+	d.queue[0] = nil
+	d.queue = d.queue[1:]
+
+	return nil
+}
+
+// EnableTagMedia p.Media 与FLV解复用一样为 p.Data 中FLV头之后的部分(AVCC/HVCC格式的视频, 不含ADTS头的AAC), 默认为Annex-B/ADTS格式
+// 需要在读取之前设置
+func (d *Demuxer) EnableTagMedia(enabled bool) {
+	d.tagMedia = enabled
+}
+
+// Programs 已解析的节目(PMT), 按节目号排序
+func (d *Demuxer) Programs() []*table.PmtSection {
+	var ret []*table.PmtSection
+	for _, pmt := range d.pmts {
+		if pmt != nil {
+			ret = append(ret, pmt)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ProgramNumber < ret[j].ProgramNumber
+	})
+
+	return ret
+}
+
+// Services SDT中的业务信息, 没有收到SDT时返回nil
+func (d *Demuxer) Services() []table.SdtService {
+	if d.sdt == nil {
+		return nil
+	}
+
+	return d.sdt.Services
+}
+
+// ContinuityErrors 连续计数器错误的次数
+func (d *Demuxer) ContinuityErrors() int {
+	return d.ccErrors
+}
+
+// CRCErrors PSI表CRC32校验失败的次数
+func (d *Demuxer) CRCErrors() int {
+	return d.crcErrors
+}
+
+// readPacket 读取并解析一个TS包, 同步字节错误时重新同步
+func (d *Demuxer) readPacket() error {
+	_, err := io.ReadFull(d.r, d.buf[:])
+	if err != nil {
+		return err
+	}
+
+	for d.buf[0] != syncByte {
+		// 丢弃同步字节之前的数据, 补齐一个TS包
+		n := 0
+		if i := bytes.IndexByte(d.buf[1:], syncByte); i >= 0 {
+			n = copy(d.buf[:], d.buf[i+1:])
+		}
+
+		_, err = io.ReadFull(d.r, d.buf[n:])
+		if err != nil {
+			return err
+		}
+	}
+
+	return d.parse(d.buf[:])
+}
+
+// parse 解析TS包
+func (d *Demuxer) parse(b []byte) error {
+	// transport_error_indicator, 包已损坏
+	if b[1]&0x80 != 0 {
+		return nil
+	}
+
+	pusi := b[1]&0x40 != 0
+	pid := uint16(b[1]&0x1f)<<8 | uint16(b[2])
+	afc := (b[3] >> 4) & 0x03
+	cc := b[3] & 0x0f
+
+	if pid == nullPID {
+		return nil
+	}
+
+	// 自适应域
+	payload := b[4:]
+	var discontinuity bool
+	if afc&0x02 != 0 {
+		n := int(payload[0])
+		if 1+n > len(payload) {
+			return nil
+		}
+
+		discontinuity = n > 0 && payload[1]&0x80 != 0
+		payload = payload[1+n:]
+	}
+
+	// 没有负载的包不增加连续计数器
+	if afc&0x01 == 0 {
+		return nil
+	}
+
+	if !d.checkCC(pid, cc, discontinuity) {
+		return nil
+	}
+
+	_, isPsi := d.psi[pid]
+	if _, ok := d.pmts[pid]; ok || isPsi || pid == patPID || pid == sdtPID {
+		d.parsePsi(pid, pusi, payload)
+		return nil
+	}
+
+	if s, ok := d.streams[pid]; ok {
+		return d.parsePes(s, pusi, payload)
+	}
+
+	return nil
+}
+
+// checkCC 检查连续计数器, 重复的包返回false; 计数器不连续时丢弃正在组装的PES
+func (d *Demuxer) checkCC(pid uint16, cc byte, discontinuity bool) bool {
+	last, ok := d.cc[pid]
+	d.cc[pid] = cc
+
+	if !ok || discontinuity {
+		return true
+	}
+
+	// 允许重复发送一次
+	if cc == last {
+		return false
+	}
+
+	if cc != (last+1)&0x0f {
+		d.ccErrors++
+		if s, ok := d.streams[pid]; ok {
+			s.pes = nil
+		}
+		if sa, ok := d.psi[pid]; ok {
+			sa.Reset()
+		}
+	}
+
+	return true
+}
+
+// parsePsi 组装PSI的section(可以跨越多个TS包), 解析完整的section
+func (d *Demuxer) parsePsi(pid uint16, pusi bool, payload []byte) {
+	sa, ok := d.psi[pid]
+	if !ok {
+		sa = &table.SectionAssembler{}
+		d.psi[pid] = sa
+	}
+
+	for _, section := range sa.Push(pusi, payload) {
+		d.parseSection(pid, section)
+	}
+}
+
+// parseSection 解析PAT, PMT和SDT
+func (d *Demuxer) parseSection(pid uint16, section []byte) {
+	// 包含CRC32在内的CRC32结果为0
+	if GenerateCrc32(section) != 0 {
+		d.crcErrors++
+		return
+	}
+
+	switch {
+	case pid == patPID && section[0] == table.TablePat:
+		pat, err := table.ParsePat(section)
+		if err == nil {
+			d.updatePat(pat)
+		}
+	case pid == sdtPID && section[0] == table.TableSdt:
+		sdt, err := table.ParseSdt(section)
+		if err == nil {
+			d.sdt = sdt
+		}
+	case section[0] == table.TablePmt:
+		pmt, err := table.ParsePmt(section)
+		if err == nil {
+			d.updatePmt(pid, pmt)
+		}
+	case section[0] == table.TableSpliceInfo:
+		si, err := table.ParseSpliceInfo(section)
+		if err != nil {
+			return
+		}
+
+		p, err := cuePointPacket(si, section, d.lastTs)
+		if err == nil {
+			d.queue = append(d.queue, p)
+		}
+	}
+}
+
+// updatePat 记录PAT中的PMT
+func (d *Demuxer) updatePat(pat *table.PatSection) {
+	for _, prog := range pat.Programs {
+		if prog.Number == 0 {
+			continue
+		}
+
+		if _, ok := d.pmts[prog.PID]; !ok {
+			d.pmts[prog.PID] = nil
+		}
+	}
+}
+
+// updatePmt 记录PMT中的基本流, 流类型变化时重新创建基本流
+func (d *Demuxer) updatePmt(pid uint16, pmt *table.PmtSection) {
+	d.pmts[pid] = pmt
+
+	for _, es := range pmt.Streams {
+		// SCTE-35以section传输
+		if es.StreamType == table.StreamTypeScte35 {
+			if _, ok := d.psi[es.PID]; !ok {
+				d.psi[es.PID] = &table.SectionAssembler{}
+			}
+			continue
+		}
+
+		s, ok := d.streams[es.PID]
+		if !ok || s.streamType != es.StreamType {
+			d.streams[es.PID] = newEsStream(es.PID, es.StreamType, d.tagMedia)
+		}
+	}
+}
+
+// parsePes 组装PES, PES完整时转换为数据包
+func (d *Demuxer) parsePes(s *esStream, pusi bool, payload []byte) error {
+	if pusi {
+		// 新的PES开始, 上一个PES已完整
+		err := d.flush(s)
+		if err != nil {
+			return err
+		}
+
+		s.pes = append(make([]byte, 0, 4*tsPacketLen), payload...)
+	} else if s.pes != nil {
+		s.pes = append(s.pes, payload...)
+	} else {
+		// PES的开头已丢失
+		return nil
+	}
+
+	// PES长度已知时, 收齐后立即输出
+	if len(s.pes) >= 6 {
+		n := int(s.pes[4])<<8 | int(s.pes[5])
+		if n > 0 && len(s.pes) >= 6+n {
+			return d.flush(s)
+		}
+	}
+
+	return nil
+}
+
+// flush 将组装好的PES转换为数据包, 无法解析的PES被丢弃
+func (d *Demuxer) flush(s *esStream) error {
+	b := s.pes
+	s.pes = nil
+	if b == nil {
+		return nil
+	}
+
+	info, err := table.ParsePes(b)
+	if err != nil || !info.HasPTS {
+		return nil
+	}
+
+	// PTS和DTS扩展为64位, 跨过2^33回绕后继续递增, 输出的毫秒时间戳按2^32回绕
+	dts := info.DTS
+	if d.gotDts {
+		dts = unwrapTs(d.dts, info.DTS)
+	}
+	info.PTS = dts + tsDelta(info.PTS, info.DTS)
+	info.DTS = dts
+	d.dts, d.gotDts = dts, true
+
+	end := len(b)
+	if info.PacketLen > 0 && 6+info.PacketLen < end {
+		end = 6 + info.PacketLen
+	}
+	if info.HeaderLen > end {
+		return nil
+	}
+
+	ps, err := s.packets(info, b[info.HeaderLen:end])
+	if err != nil {
+		return err
+	}
+
+	if len(ps) > 0 {
+		d.lastTs = ps[len(ps)-1].TimeStamp
+	}
+
+	d.queue = append(d.queue, ps...)
+
+	return nil
+}
+
+// flushAll 输出所有未完成的PES(按PID排序)
+func (d *Demuxer) flushAll() error {
+	pids := make([]int, 0, len(d.streams))
+	for pid := range d.streams {
+		pids = append(pids, int(pid))
+	}
+	sort.Ints(pids)
+
+	for _, pid := range pids {
+		err := d.flush(d.streams[uint16(pid)])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,354 @@
+package ts
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nextpkg/goav/amf"
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/container/ts/table"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// avccFrame 生成FLV视频帧: 视频头 + 一个AVCC格式的NALU
+func avccFrame(flag byte, cts byte, naluType byte, size int) []byte {
+	b := []byte{flag, 0x01, 0x00, 0x00, cts, byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size), naluType}
+	for i := 1; i < size; i++ {
+		b = append(b, byte(i))
+	}
+
+	return b
+}
+
+// newTestTs 使用Mixer生成包含H264和AAC的TS流, 返回TS数据以及输入的FLV数据包
+func newTestTs(at *assert.Assertions) ([]byte, []*packet.Packet) {
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+	d := flv.NewDemuxer()
+
+	sps := []byte{0x67, 0x4d, 0x00, 0x1e, 0x9a, 0x66, 0x02, 0x80}
+	pps := []byte{0x68, 0xee, 0x3c, 0x80}
+
+	avcSeqHdr := &packet.Packet{Type: packet.PktVideo, Data: append([]byte{0x17, 0x00, 0x00, 0x00, 0x00}, avcConfig(sps, pps)...)}
+	at.Nil(d.Demux(avcSeqHdr))
+	at.Nil(m.SaveAVCHeader(avcSeqHdr))
+
+	aacSeqHdr := &packet.Packet{Type: packet.PktAudio, Data: []byte{0xaf, 0x00, 0x12, 0x10}}
+	at.Nil(d.Demux(aacSeqHdr))
+	at.Nil(m.SaveAACHeader(aacSeqHdr))
+
+	at.Nil(m.SaveMetadata(amf.Object{"Provider": "test provider", "Service": "test service"}))
+	at.Nil(m.SetTsHeader())
+
+	audio := make([]byte, 202)
+	audio[0], audio[1] = 0xaf, 0x01
+	for i := 2; i < len(audio); i++ {
+		audio[i] = byte(i)
+	}
+
+	frames := []*packet.Packet{
+		{Type: packet.PktVideo, TimeStamp: 0, Data: avccFrame(0x17, 0, 0x65, 400)},
+		{Type: packet.PktAudio, TimeStamp: 0, Data: audio},
+		{Type: packet.PktVideo, TimeStamp: 40, Data: avccFrame(0x27, 40, 0x41, 300)},
+		{Type: packet.PktAudio, TimeStamp: 23, Data: audio},
+	}
+	for _, p := range frames {
+		at.Nil(d.Demux(p))
+
+		var cts uint32
+		if p.Type == packet.PktVideo {
+			cts = uint32(p.Header.(packet.VideoPacketHeader).CompositionTime())
+		}
+
+		// Mux会修改p.Media, 使用副本
+		q := *p
+		at.Nil(m.Update(&q, q.TimeStamp, cts))
+		at.Nil(m.Mux(&q))
+	}
+
+	return buf.Bytes(), []*packet.Packet{avcSeqHdr, frames[0], aacSeqHdr, frames[1], frames[2], frames[3]}
+}
+
+func TestDemuxer_Read(t *testing.T) {
+	at := assert.New(t)
+
+	data, want := newTestTs(at)
+
+	// 同步字节之前的垃圾数据, 最后一个TS包被截断
+	garbage := []byte{0x00, 0x01, 0x02}
+	data = append(garbage, data...)
+	data = append(data, 0x47, 0x01, 0x00)
+
+	dmx := NewDemuxer(bytes.NewReader(data))
+
+	var got []*packet.Packet
+	for {
+		p := &packet.Packet{}
+		err := dmx.Read(p)
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+
+		got = append(got, p)
+	}
+
+	// 输出的FLV数据与输入一致
+	at.Len(got, len(want))
+	for i, p := range got {
+		at.Equal(want[i].Type, p.Type, "packet %d", i)
+		at.Equal(want[i].TimeStamp, p.TimeStamp, "packet %d", i)
+		at.Equal(want[i].Data, p.Data, "packet %d", i)
+	}
+
+	// Media为Annex-B格式的H264和ADTS格式的AAC
+	vh := got[1].Header.(packet.VideoPacketHeader)
+	at.True(vh.IsKeyFrame())
+	at.True(bytes.Contains(got[1].Media, []byte{0x00, 0x00, 0x00, 0x01, 0x67}))
+	at.True(bytes.Contains(got[1].Media, []byte{0x00, 0x00, 0x00, 0x01, 0x65}))
+	at.Equal(int32(40), got[4].Header.(packet.VideoPacketHeader).CompositionTime())
+	at.Equal([]byte{0xff, 0xf1}, got[3].Media[:2])
+	at.Equal(len(want[3].Data)-2+7, len(got[3].Media))
+
+	// PSI
+	programs := dmx.Programs()
+	at.Len(programs, 1)
+	at.Equal(uint16(1), programs[0].ProgramNumber)
+	at.Equal(uint16(defaultVideoPID), programs[0].PcrPID)
+	at.Len(programs[0].Streams, 2)
+
+	services := dmx.Services()
+	at.Len(services, 1)
+	at.Equal("test provider", services[0].ProviderName)
+	at.Equal("test service", services[0].ServiceName)
+
+	at.Equal(0, dmx.ContinuityErrors())
+	at.Equal(0, dmx.CRCErrors())
+}
+
+func TestDemuxer_TagMedia(t *testing.T) {
+	at := assert.New(t)
+
+	data, want := newTestTs(at)
+
+	dmx := NewDemuxer(bytes.NewReader(data))
+	dmx.EnableTagMedia(true)
+
+	var got []*packet.Packet
+	for {
+		p := &packet.Packet{}
+		err := dmx.Read(p)
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+
+		got = append(got, p)
+	}
+
+	// 与FLV解复用一样, Media为Data中FLV头之后的部分: AVCC格式的H264和不含ADTS头的AAC
+	at.Len(got, len(want))
+	for i, p := range got {
+		at.Equal(want[i].Data, p.Data, "packet %d", i)
+		at.Equal(p.Data[len(p.Data)-len(p.Media):], p.Media, "packet %d", i)
+	}
+	at.Equal(want[1].Data[5:], got[1].Media)
+	at.Equal(want[3].Data[2:], got[3].Media)
+}
+
+func TestDemuxer_Continuity(t *testing.T) {
+	at := assert.New(t)
+
+	data, _ := newTestTs(at)
+
+	// 丢弃第一个视频帧的第二个TS包(SDT, PAT, PMT之后)
+	lost := append([]byte(nil), data[:4*tsPacketLen]...)
+	lost = append(lost, data[5*tsPacketLen:]...)
+
+	// 破坏PMT的数据, CRC32校验失败
+	lost[2*tsPacketLen+20] ^= 0xff
+
+	dmx := NewDemuxer(bytes.NewReader(lost))
+	p := &packet.Packet{}
+	at.Equal(io.EOF, dmx.Read(p))
+	at.Equal(1, dmx.CRCErrors())
+	at.Empty(dmx.Programs())
+
+	// PMT正常时, 丢失数据的视频帧(以及其中的序列头)不输出
+	lost[2*tsPacketLen+20] ^= 0xff
+	dmx = NewDemuxer(bytes.NewReader(lost))
+
+	var types []int
+	for {
+		err := dmx.Read(p)
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+		types = append(types, p.Type)
+	}
+
+	at.Equal(1, dmx.ContinuityErrors())
+	at.Equal([]int{packet.PktAudio, packet.PktAudio, packet.PktVideo, packet.PktAudio}, types)
+}
+
+// hevcFrame 生成 Enhanced RTMP 的H265视频帧: 扩展视频头 + 一个HVCC格式的NALU
+func hevcFrame(frameType uint8, cts int32, header byte, size int) []byte {
+	b := flv.ExVideoTagHeader(frameType, flv.ExVideoCodedFrames, flv.FourCCHevc, cts)
+	b = append(b, byte(size>>24), byte(size>>16), byte(size>>8), byte(size), header, 0x01)
+	for i := 2; i < size; i++ {
+		b = append(b, byte(i))
+	}
+
+	return b
+}
+
+func TestDemuxer_Hevc(t *testing.T) {
+	at := assert.New(t)
+
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+	d := flv.NewDemuxer()
+
+	vps := []byte{0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x5a, 0x95, 0x98, 0x09}
+	sps := []byte{0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x5a, 0xa0, 0x05, 0x02, 0x01, 0xe1, 0x65, 0x95, 0x9a, 0x49, 0x32, 0xbc, 0x05, 0xa8}
+	pps := []byte{0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40}
+
+	// general_profile_idc: Main, general_level_idc: 90
+	config := hvcConfig(vps, sps, pps)
+	at.Equal([]byte{0x01, 0x01, 0x60, 0x00, 0x00, 0x00, 0x90, 0x00, 0x00, 0x00, 0x00, 0x00, 0x5a}, config[:13])
+	// chroma_format_idc: 4:2:0, bit_depth_luma_minus8, bit_depth_chroma_minus8: 0
+	at.Equal([]byte{0xfd, 0xf8, 0xf8}, config[16:19])
+
+	// Main 10, 4:2:2, 亮度和色度10bit
+	bits := "0000" + "000" + "1" + "00000010" + "00100000" + strings.Repeat("0", 72) + "01011101"
+	bits += expGolomb(0) + expGolomb(2) + expGolomb(1920) + expGolomb(1080) + "0" + expGolomb(2) + expGolomb(2)
+	main10 := hvcConfig(vps, append([]byte{0x42, 0x01}, bitsToBytes(bits)...), pps)
+	at.Equal([]byte{0x02, 0x20}, main10[1:3])
+	at.Equal([]byte{0xfe, 0xfa, 0xfa}, main10[16:19])
+
+	seqHdr := &packet.Packet{Type: packet.PktVideo, Data: append(flv.ExVideoTagHeader(flv.KeyFrame, flv.ExVideoSeqStart, flv.FourCCHevc, 0), config...)}
+	at.Nil(d.Demux(seqHdr))
+	at.Nil(m.SaveAVCHeader(seqHdr))
+	at.Nil(m.SetTsHeader())
+
+	frames := []*packet.Packet{
+		// IDR_W_RADL
+		{Type: packet.PktVideo, TimeStamp: 0, Data: hevcFrame(flv.KeyFrame, 0, 0x26, 400)},
+		// TRAIL_R
+		{Type: packet.PktVideo, TimeStamp: 40, Data: hevcFrame(flv.InterFrame, 40, 0x02, 300)},
+		// CRA_NUT, FLV中标记为普通帧
+		{Type: packet.PktVideo, TimeStamp: 80, Data: hevcFrame(flv.InterFrame, 0, 0x2a, 300)},
+	}
+	for _, p := range frames {
+		at.Nil(d.Demux(p))
+
+		q := *p
+		cts := uint32(p.Header.(packet.VideoPacketHeader).CompositionTime())
+		at.Nil(m.Update(&q, q.TimeStamp, cts))
+		at.Nil(m.Mux(&q))
+	}
+
+	// IRAP的第一个TS包带有PCR, 其它帧超过PCR间隔时发送只有PCR的TS包
+	data := buf.Bytes()
+	var pcrs, pcrOnly int
+	for i := 0; i < len(data); i += tsPacketLen {
+		b := data[i:]
+		if b[3]&0x20 == 0 || b[4] == 0 || b[5]&0x10 == 0 {
+			continue
+		}
+
+		if b[3]&0x10 != 0 {
+			pcrs++
+		} else {
+			pcrOnly++
+		}
+	}
+	at.Equal(2, pcrs)
+	at.Equal(1, pcrOnly)
+
+	dmx := NewDemuxer(bytes.NewReader(data))
+	var got []*packet.Packet
+	for {
+		p := &packet.Packet{}
+		err := dmx.Read(p)
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+		got = append(got, p)
+	}
+
+	// PMT中的流类型为H265
+	programs := dmx.Programs()
+	at.Len(programs, 1)
+	at.Equal(uint8(table.StreamTypeHevc), programs[0].Streams[0].StreamType)
+
+	// CRA被识别为关键帧
+	frames[2].Data[0] = flv.ExHeader | flv.KeyFrame<<4 | flv.ExVideoCodedFrames
+
+	want := []*packet.Packet{seqHdr, frames[0], frames[1], frames[2]}
+	at.Len(got, len(want))
+	for i, p := range got {
+		at.Equal(want[i].TimeStamp, p.TimeStamp, "packet %d", i)
+		at.Equal(want[i].Data, p.Data, "packet %d", i)
+	}
+
+	vh := got[1].Header.(packet.VideoPacketHeader)
+	at.True(vh.IsCodecHevc())
+	at.True(vh.IsKeyFrame())
+	at.Equal([]byte{0x00, 0x00, 0x00, 0x01, 0x46, 0x01, 0x50}, got[1].Media[:7])
+	at.Equal(int32(40), got[2].Header.(packet.VideoPacketHeader).CompositionTime())
+}
+
+func TestDemuxer_TsWrap(t *testing.T) {
+	at := assert.New(t)
+
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+	d := flv.NewDemuxer()
+
+	sps := []byte{0x67, 0x4d, 0x00, 0x1e, 0x9a, 0x66, 0x02, 0x80}
+	avcSeqHdr := &packet.Packet{Type: packet.PktVideo, Data: append([]byte{0x17, 0x00, 0x00, 0x00, 0x00}, avcConfig(sps, []byte{0x68, 0xee, 0x3c, 0x80})...)}
+	at.Nil(d.Demux(avcSeqHdr))
+	at.Nil(m.SaveAVCHeader(avcSeqHdr))
+	at.Nil(m.SetTsHeader())
+
+	// 90kHz的DTS在95443717ms附近跨过2^33, PTS先于DTS回绕
+	var want []uint32
+	for ts := uint32(95443600); ts < 95443900; ts += 40 {
+		flag := byte(0x27)
+		if len(want) == 0 {
+			flag = 0x17
+		}
+		p := &packet.Packet{Type: packet.PktVideo, TimeStamp: ts, Data: avccFrame(flag, 80, 0x41, 300)}
+		at.Nil(d.Demux(p))
+		at.Nil(m.Update(p, p.TimeStamp, 0))
+		at.Nil(m.Mux(p))
+		want = append(want, ts)
+	}
+
+	dmx := NewDemuxer(bytes.NewReader(buf.Bytes()))
+	var got []uint32
+	for {
+		p := &packet.Packet{}
+		err := dmx.Read(p)
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+
+		vh := p.Header.(packet.VideoPacketHeader)
+		if vh.IsSeqHdr() {
+			continue
+		}
+		at.Equal(int32(80), vh.CompositionTime())
+		got = append(got, p.TimeStamp)
+	}
+
+	// 毫秒时间戳跨过回绕后继续递增
+	at.Equal(want, got)
+}
@@ -0,0 +1,423 @@
+package ts
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/container/ts/table"
+	"github.com/nextpkg/goav/packet"
+	"github.com/nextpkg/goav/parser/h265"
+)
+
+// H264 NALU类型
+const (
+	nalSlice = 1
+	nalIdr   = 5
+	nalSei   = 6
+	nalSps   = 7
+	nalPps   = 8
+)
+
+// H265 NALU类型
+const (
+	hevcNalVps       = 32
+	hevcNalSps       = 33
+	hevcNalPps       = 34
+	hevcNalAud       = 35
+	hevcNalSeiPrefix = 39
+	hevcNalSeiSuffix = 40
+)
+
+// AAC
+const (
+	adtsHdrLen       = 7
+	adtsCrcLen       = 2
+	aacSamplesPerFrm = 1024
+
+	// FLV音频头: AAC, 44kHz, 16bit, 立体声(AAC的实际参数以AudioSpecificConfig为准)
+	aacFlags = flv.SoundAAC<<4 | 0x0f
+)
+
+var annexbStartCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// esStream 基本流, 缓存正在组装的PES以及最近的编码参数
+type esStream struct {
+	pid        uint16
+	streamType uint8
+	pes        []byte // 正在组装的PES, 为nil时还未收到PES的第一个TS包
+
+	vps []byte // H265: 最近的VPS
+	sps []byte // H264/H265: 最近的SPS
+	pps []byte // H264/H265: 最近的PPS
+	asc []byte // AAC: 最近的AudioSpecificConfig
+
+	tagMedia bool // p.Media 与FLV解复用一样为 p.Data 中FLV头之后的部分, 不替换为Annex-B/ADTS数据
+}
+
+// newEsStream 基本流
+func newEsStream(pid uint16, streamType uint8, tagMedia bool) *esStream {
+	return &esStream{
+		pid:        pid,
+		streamType: streamType,
+		tagMedia:   tagMedia,
+	}
+}
+
+// packets 将完整的PES转换为数据包, 不支持的流类型返回nil
+func (s *esStream) packets(info *table.PesInfo, payload []byte) ([]*packet.Packet, error) {
+	switch s.streamType {
+	case table.StreamTypeAvc:
+		return s.avcPackets(info, payload)
+	case table.StreamTypeHevc:
+		return s.hevcPackets(info, payload)
+	case table.StreamTypeAac:
+		return s.aacPackets(info, payload)
+	case table.StreamTypeMetadata:
+		return id3Packets(info, payload)
+	}
+
+	return nil, nil
+}
+
+// avcPackets 将Annex-B格式的H264转换为FLV视频包, SPS/PPS变化时先输出序列头
+// p.Data 为FLV的Tag Data(AVCC), p.Media 为使用4字节start code的Annex-B数据
+func (s *esStream) avcPackets(info *table.PesInfo, payload []byte) ([]*packet.Packet, error) {
+	var sps, pps []byte
+	var isKey bool
+
+	avcc := bytes.NewBuffer(make([]byte, 0, len(payload)+16))
+	media := bytes.NewBuffer(make([]byte, 0, len(payload)+16))
+
+	// FLV视频头占位, 最后填写
+	avcc.Write(make([]byte, 5))
+
+	for _, nalu := range splitNalus(payload) {
+		media.Write(annexbStartCode)
+		media.Write(nalu)
+
+		switch nalu[0] & 0x1f {
+		case nalSps:
+			sps = nalu
+		case nalPps:
+			pps = nalu
+		case nalIdr:
+			isKey = true
+			fallthrough
+		case nalSlice, nalSei:
+			_ = binary.Write(avcc, binary.BigEndian, uint32(len(nalu)))
+			avcc.Write(nalu)
+		}
+	}
+
+	ts := uint32(info.DTS / avcHZ)
+	var ret []*packet.Packet
+
+	// 编码参数变化时输出序列头
+	if sps != nil && pps != nil && (!bytes.Equal(sps, s.sps) || !bytes.Equal(pps, s.pps)) {
+		s.sps = append([]byte(nil), sps...)
+		s.pps = append([]byte(nil), pps...)
+
+		data := append([]byte{flv.KeyFrame<<4 | flv.AvcH264, flv.AvcSeqHdr, 0, 0, 0}, avcConfig(sps, pps)...)
+		p, err := newPacket(packet.PktVideo, ts, data, nil)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+
+	// 只有参数集的PES没有帧数据
+	if avcc.Len() == 5 {
+		return ret, nil
+	}
+
+	data := avcc.Bytes()
+	data[0] = flv.InterFrame<<4 | flv.AvcH264
+	if isKey {
+		data[0] = flv.KeyFrame<<4 | flv.AvcH264
+	}
+	data[1] = flv.AvcNalu
+
+	// CompositionTime, SI24, 毫秒
+	cts := int32((info.PTS - info.DTS) / avcHZ)
+	data[2], data[3], data[4] = byte(cts>>16), byte(cts>>8), byte(cts)
+
+	p, err := newPacket(packet.PktVideo, ts, data, s.media(media.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(ret, p), nil
+}
+
+// avcConfig 使用SPS和PPS生成 AVCDecoderConfigurationRecord
+func avcConfig(sps, pps []byte) []byte {
+	b := make([]byte, 0, 11+len(sps)+len(pps))
+
+	// configurationVersion, AVCProfileIndication, profile_compatibility, AVCLevelIndication
+	b = append(b, 0x01, sps[1], sps[2], sps[3])
+	// lengthSizeMinusOne: 3, numOfSequenceParameterSets: 1
+	b = append(b, 0xff, 0xe1, byte(len(sps)>>8), byte(len(sps)))
+	b = append(b, sps...)
+	// numOfPictureParameterSets: 1
+	b = append(b, 0x01, byte(len(pps)>>8), byte(len(pps)))
+	b = append(b, pps...)
+
+	return b
+}
+
+// hevcPackets 将Annex-B格式的H265转换为 Enhanced RTMP 的FLV视频包, VPS/SPS/PPS变化时先输出序列头
+// p.Data 为FLV的Tag Data(HVCC), p.Media 为使用4字节start code的Annex-B数据
+func (s *esStream) hevcPackets(info *table.PesInfo, payload []byte) ([]*packet.Packet, error) {
+	var vps, sps, pps []byte
+	var isKey bool
+
+	hvcc := bytes.NewBuffer(make([]byte, 0, len(payload)+16))
+	media := bytes.NewBuffer(make([]byte, 0, len(payload)+16))
+
+	for _, nalu := range splitNalus(payload) {
+		if len(nalu) < 2 {
+			continue
+		}
+
+		media.Write(annexbStartCode)
+		media.Write(nalu)
+
+		switch typ := h265.NaluType(nalu[0]); {
+		case typ == hevcNalVps:
+			vps = nalu
+		case typ == hevcNalSps:
+			sps = nalu
+		case typ == hevcNalPps:
+			pps = nalu
+		case typ < hevcNalVps || typ == hevcNalSeiPrefix || typ == hevcNalSeiSuffix:
+			// VCL NALU(0~31)以及SEI
+			isKey = isKey || h265.IsIRAP(typ)
+			_ = binary.Write(hvcc, binary.BigEndian, uint32(len(nalu)))
+			hvcc.Write(nalu)
+		}
+	}
+
+	ts := uint32(info.DTS / avcHZ)
+	var ret []*packet.Packet
+
+	// 编码参数变化时输出序列头
+	if vps != nil && sps != nil && pps != nil &&
+		(!bytes.Equal(vps, s.vps) || !bytes.Equal(sps, s.sps) || !bytes.Equal(pps, s.pps)) {
+		s.vps = append([]byte(nil), vps...)
+		s.sps = append([]byte(nil), sps...)
+		s.pps = append([]byte(nil), pps...)
+
+		data := append(flv.ExVideoTagHeader(flv.KeyFrame, flv.ExVideoSeqStart, flv.FourCCHevc, 0), hvcConfig(vps, sps, pps)...)
+		p, err := newPacket(packet.PktVideo, ts, data, nil)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+
+	// 只有参数集的PES没有帧数据
+	if hvcc.Len() == 0 {
+		return ret, nil
+	}
+
+	var frameType uint8 = flv.InterFrame
+	if isKey {
+		frameType = flv.KeyFrame
+	}
+
+	// CompositionTime, SI24, 毫秒
+	cts := int32((info.PTS - info.DTS) / avcHZ)
+	data := append(flv.ExVideoTagHeader(frameType, flv.ExVideoCodedFrames, flv.FourCCHevc, cts), hvcc.Bytes()...)
+
+	p, err := newPacket(packet.PktVideo, ts, data, s.media(media.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(ret, p), nil
+}
+
+// hvcConfig 使用VPS, SPS和PPS生成 HEVCDecoderConfigurationRecord
+// profile, tier, level, 色度格式和位深度取自SPS; SPS无法解析时按4:2:0, 8bit填写(解码器以SPS为准)
+func hvcConfig(vps, sps, pps []byte) []byte {
+	var subLayers, nesting byte = 1, 0
+	ptl := make([]byte, 12)
+	chromaFormat, lumaDepth, chromaDepth := 1, 8, 8
+	if s, err := h265.ParseSPS(sps); err == nil {
+		subLayers = byte(s.MaxSubLayers)
+		if s.TemporalIDNesting {
+			nesting = 1
+		}
+		copy(ptl, s.ProfileTierLevel)
+		chromaFormat, lumaDepth, chromaDepth = s.ChromaFormatIdc, s.BitDepthLuma, s.BitDepthChroma
+	}
+
+	b := make([]byte, 0, 23+15+len(vps)+len(sps)+len(pps))
+
+	// configurationVersion, profile_tier_level
+	b = append(b, 0x01)
+	b = append(b, ptl...)
+	// min_spatial_segmentation_idc, parallelismType, chroma_format_idc, bit_depth_luma_minus8, bit_depth_chroma_minus8, avgFrameRate
+	b = append(b, 0xf0, 0x00, 0xfc, 0xfc|byte(chromaFormat), 0xf8|byte(lumaDepth-8), 0xf8|byte(chromaDepth-8), 0x00, 0x00)
+	// constantFrameRate(2), numTemporalLayers(3), temporalIdNested(1), lengthSizeMinusOne(2): 3
+	b = append(b, subLayers<<3|nesting<<2|0x03)
+
+	// numOfArrays, 每组: array_completeness(1), reserved(1), NAL_unit_type(6), numNalus(16), nalUnitLength(16), nalUnit
+	b = append(b, 3)
+	for i, nalu := range [][]byte{vps, sps, pps} {
+		b = append(b, 0x80|byte(hevcNalVps+i), 0x00, 0x01, byte(len(nalu)>>8), byte(len(nalu)))
+		b = append(b, nalu...)
+	}
+
+	return b
+}
+
+// isRandomAccess 判断Annex-B数据是否是随机访问点(H264: IDR, H265: IRAP), 只检查第一个VCL NALU
+func isRandomAccess(streamType uint8, b []byte) bool {
+	for i := 0; i+3 < len(b); i++ {
+		if b[i] != 0 || b[i+1] != 0 || b[i+2] != 1 {
+			continue
+		}
+
+		header := b[i+3]
+		switch streamType {
+		case table.StreamTypeAvc:
+			if typ := header & 0x1f; typ >= nalSlice && typ <= nalIdr {
+				return typ == nalIdr
+			}
+		case table.StreamTypeHevc:
+			if typ := h265.NaluType(header); typ < hevcNalVps {
+				return h265.IsIRAP(typ)
+			}
+		default:
+			return false
+		}
+
+		i += 2
+	}
+
+	return false
+}
+
+// splitNalus 按start code(3字节或者4字节)拆分Annex-B数据, 返回不含start code的NALU
+func splitNalus(b []byte) [][]byte {
+	var nalus [][]byte
+
+	add := func(nalu []byte) {
+		// 去除下一个start code之前的trailing_zero_8bits
+		for len(nalu) > 0 && nalu[len(nalu)-1] == 0 {
+			nalu = nalu[:len(nalu)-1]
+		}
+		if len(nalu) > 0 {
+			nalus = append(nalus, nalu)
+		}
+	}
+
+	start := -1
+	for i := 0; i+2 < len(b); {
+		if b[i] == 0 && b[i+1] == 0 && b[i+2] == 1 {
+			if start >= 0 {
+				add(b[start:i])
+			}
+
+			i += 3
+			start = i
+			continue
+		}
+		i++
+	}
+
+	if start >= 0 {
+		add(b[start:])
+	}
+
+	return nalus
+}
+
+// aacPackets 将ADTS格式的AAC转换为FLV音频包, 一个PES可能包含多个ADTS帧; AudioSpecificConfig变化时先输出序列头
+// p.Data 为FLV的Tag Data(原始AAC帧), p.Media 为完整的ADTS帧
+func (s *esStream) aacPackets(info *table.PesInfo, payload []byte) ([]*packet.Packet, error) {
+	var ret []*packet.Packet
+
+	for i := int64(0); len(payload) >= adtsHdrLen; i++ {
+		// syncword(12), ID(1), layer(2), protection_absent(1)
+		if payload[0] != 0xff || payload[1]&0xf6 != 0xf0 {
+			break
+		}
+
+		hdrLen := adtsHdrLen
+		if payload[1]&0x01 == 0 {
+			hdrLen += adtsCrcLen
+		}
+
+		frameLen := int(payload[3]&0x03)<<11 | int(payload[4])<<3 | int(payload[5])>>5
+		if frameLen < hdrLen || frameLen > len(payload) {
+			break
+		}
+
+		// profile(2), sampling_frequency_index(4), private_bit(1), channel_configuration(3)
+		objectType := payload[2]>>6 + 1
+		rateIndex := (payload[2] >> 2) & 0x0f
+		channel := (payload[2]&0x01)<<2 | payload[3]>>6
+		if int(rateIndex) >= len(aacSampleRates) {
+			break
+		}
+
+		// 每帧1024个采样, 按采样率推算后续帧的时间戳
+		ts := uint32((info.PTS + i*aacSamplesPerFrm*avcHZ*1000/int64(aacSampleRates[rateIndex])) / avcHZ)
+
+		asc := []byte{objectType<<3 | rateIndex>>1, (rateIndex&0x01)<<7 | channel<<3}
+		if !bytes.Equal(asc, s.asc) {
+			s.asc = asc
+
+			p, err := newPacket(packet.PktAudio, ts, append([]byte{aacFlags, flv.AacSeqHdr}, asc...), nil)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, p)
+		}
+
+		data := append([]byte{aacFlags, flv.AacRaw}, payload[hdrLen:frameLen]...)
+		p, err := newPacket(packet.PktAudio, ts, data, s.media(payload[:frameLen]))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+
+		payload = payload[frameLen:]
+	}
+
+	return ret, nil
+}
+
+// media 替换 p.Media 的数据, 启用 tagMedia 时为nil
+func (s *esStream) media(b []byte) []byte {
+	if s.tagMedia {
+		return nil
+	}
+
+	return b
+}
+
+// newPacket 生成数据包, 通过FLV解复用填充 p.Header; media不为nil时替换 p.Media
+func newPacket(typ int, ts uint32, data, media []byte) (*packet.Packet, error) {
+	p := &packet.Packet{
+		Type:      typ,
+		TimeStamp: ts,
+		Data:      data,
+	}
+
+	err := flv.NewDemuxer().Demux(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if media != nil {
+		p.Media = media
+	}
+
+	return p, nil
+}
@@ -0,0 +1,139 @@
+package ts
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/nextpkg/goav/amf"
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/container/id3"
+	"github.com/nextpkg/goav/container/ts/table"
+	"github.com/nextpkg/goav/packet"
+)
+
+// onMetaData 中转换为ID3文本帧的字段
+var id3MetaFrames = []struct {
+	key string
+	id  string
+}{
+	{"title", id3.FrameTitle},
+	{"artist", id3.FrameArtist},
+	{"album", id3.FrameAlbum},
+}
+
+// EnableID3 在第一个节目中加入ID3定时元数据的基本流(流类型0x15)以及元数据描述符, 需要在 SetTsHeader 之前调用
+// 开启后 Mux 将FLV的 onMetaData 和 onTextData 脚本数据转换为ID3标签, 否则脚本数据被丢弃
+func (m *Mixer) EnableID3() error {
+	if len(m.muxer.programs) == 0 {
+		return errors.New("no program for id3")
+	}
+
+	prog := m.muxer.programs[0]
+	if prog.streamByType(table.StreamTypeMetadata) == nil {
+		desc := table.NewDescriptor()
+		err := desc.Metadata()
+		if err != nil {
+			return err
+		}
+
+		s := prog.AddStream(defaultID3PID, table.StreamTypeMetadata, packet.PktMetadata)
+		s.Descriptors = desc.GetBuffer().Bytes()
+	}
+
+	desc := table.NewDescriptor()
+	err := desc.MetadataPointer(prog.Number)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(prog.Descriptors, desc.GetBuffer().Bytes()) {
+		prog.Descriptors = append(prog.Descriptors, desc.GetBuffer().Bytes()...)
+	}
+
+	m.cache.types.IsMetadata()
+	return nil
+}
+
+// id3Stream 返回第一个节目中ID3元数据的基本流, 没有开启时返回nil
+func (m *Mixer) id3Stream() *Stream {
+	if len(m.muxer.programs) == 0 {
+		return nil
+	}
+
+	return m.muxer.programs[0].streamByType(table.StreamTypeMetadata)
+}
+
+// id3Tag 将FLV脚本数据转换为ID3标签, 不需要转换以及无法解析的脚本数据返回nil
+// onMetaData: title, artist, album 转换为 TIT2, TPE1, TALB; onTextData: text 转换为描述为 onTextData 的 TXXX
+func id3Tag(p *packet.Packet) (*id3.Tag, error) {
+	sd, err := flv.ParsePacketScript(p)
+	if err != nil {
+		return nil, nil
+	}
+
+	obj := sd.Object()
+	if obj == nil {
+		return nil, nil
+	}
+
+	tag := id3.NewTag()
+	switch sd.Name {
+	case flv.OnMetaData:
+		for _, f := range id3MetaFrames {
+			if v, ok := obj[f.key].(string); ok && v != "" {
+				tag.Frames = append(tag.Frames, id3.TextFrame(f.id, v))
+			}
+		}
+	case flv.OnTextData:
+		if text := flv.NewTextData(obj).Text; text != "" {
+			tag.Frames = append(tag.Frames, id3.UserTextFrame(flv.OnTextData, text))
+		}
+	}
+
+	if len(tag.Frames) == 0 {
+		return nil, nil
+	}
+
+	return tag, nil
+}
+
+// id3Packets 将ID3标签转换为FLV的 onTextData 或者 onMetaData 脚本数据包, 其它标签被忽略
+func id3Packets(info *table.PesInfo, payload []byte) ([]*packet.Packet, error) {
+	tag, _, err := id3.Parse(payload)
+	if err != nil {
+		return nil, nil
+	}
+
+	var sd *flv.ScriptData
+	if f := tag.Frame(id3.FrameUserText); f != nil {
+		if desc, text, err := f.UserText(); err == nil && desc == flv.OnTextData {
+			sd = flv.NewScriptData(flv.OnTextData, amf.Object{"text": text})
+		}
+	}
+
+	if sd == nil {
+		obj := amf.Object{}
+		for _, mf := range id3MetaFrames {
+			if f := tag.Frame(mf.id); f != nil {
+				if v, err := f.Text(); err == nil {
+					obj[mf.key] = v
+				}
+			}
+		}
+		if len(obj) == 0 {
+			return nil, nil
+		}
+		sd = flv.NewScriptData(flv.OnMetaData, obj)
+	}
+
+	data, err := sd.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := newPacket(packet.PktMetadata, uint32(info.PTS/avcHZ), data, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*packet.Packet{p}, nil
+}
@@ -0,0 +1,100 @@
+package ts
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/nextpkg/goav/amf"
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/container/ts/table"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMixer_ID3(t *testing.T) {
+	at := assert.New(t)
+
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+	d := flv.NewDemuxer()
+
+	script := func(ts uint32, name string, obj amf.Object) *packet.Packet {
+		data, err := flv.NewScriptData(name, obj).Encode()
+		at.Nil(err)
+
+		p := &packet.Packet{Type: packet.PktMetadata, TimeStamp: ts, Data: data}
+		at.Nil(d.Demux(p))
+		at.Nil(m.Update(p, ts, 0))
+
+		return p
+	}
+
+	// 没有开启ID3时脚本数据被丢弃
+	at.Nil(m.Mux(script(0, flv.OnTextData, amf.Object{"text": "dropped"})))
+	at.Equal(0, buf.Len())
+
+	aacSeqHdr := &packet.Packet{Type: packet.PktAudio, Data: []byte{0xaf, 0x00, 0x12, 0x10}}
+	at.Nil(d.Demux(aacSeqHdr))
+	at.Nil(m.SaveAACHeader(aacSeqHdr))
+	at.Nil(m.EnableID3())
+	at.Nil(m.EnableID3())
+	at.Nil(m.EnableScte35())
+	at.Nil(m.SetTsHeader())
+
+	at.Nil(m.Mux(script(0, flv.OnMetaData, amf.Object{"title": "news", "artist": "goav", "width": 1280.0})))
+
+	audio := make([]byte, 202)
+	audio[0], audio[1] = 0xaf, 0x01
+	p := &packet.Packet{Type: packet.PktAudio, TimeStamp: 0, Data: audio}
+	at.Nil(d.Demux(p))
+	at.Nil(m.Update(p, 0, 0))
+	at.Nil(m.Mux(p))
+
+	// 没有可以转换的字段时被丢弃
+	at.Nil(m.Mux(script(20, flv.OnMetaData, amf.Object{"width": 1280.0})))
+	at.Nil(m.Mux(script(40, flv.OnTextData, amf.Object{"text": "hello"})))
+	at.Nil(m.Mux(script(60, flv.OnCuePoint, amf.Object{"name": "cue"})))
+
+	dmx := NewDemuxer(bytes.NewReader(buf.Bytes()))
+	var names []string
+	var times []uint32
+	var events []interface{}
+	for {
+		p := &packet.Packet{}
+		err := dmx.Read(p)
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+
+		if p.Type != packet.PktMetadata {
+			continue
+		}
+
+		sh, ok := p.Header.(packet.ScriptPacketHeader)
+		at.True(ok)
+		names = append(names, sh.ScriptName())
+		times = append(times, p.TimeStamp)
+
+		sd, err := flv.ParseScriptData(p.Data)
+		at.Nil(err)
+		events = append(events, sd.Object())
+	}
+	at.Equal(0, dmx.CRCErrors())
+	at.Equal(0, dmx.ContinuityErrors())
+
+	// PMT中的ID3基本流和元数据描述符
+	programs := dmx.Programs()
+	at.Len(programs, 1)
+	at.True(bytes.HasPrefix(programs[0].Descriptors, []byte{0x25, 0x0f, 0xff, 0xff, 'I', 'D', '3', ' '}))
+	at.Len(programs[0].Streams, 3)
+	at.Equal(uint8(table.StreamTypeMetadata), programs[0].Streams[1].StreamType)
+	at.Equal(uint16(defaultID3PID), programs[0].Streams[1].PID)
+	at.Equal([]byte{0x26, 0x0d, 0xff, 0xff, 'I', 'D', '3', ' ', 0xff, 'I', 'D', '3', ' ', 0x00, 0x0f}, programs[0].Streams[1].Descriptors)
+
+	at.Equal([]string{flv.OnMetaData, flv.OnTextData}, names)
+	at.Equal([]uint32{0, 40}, times)
+	at.Equal(amf.Object{"title": "news", "artist": "goav"}, events[0])
+	at.Equal(amf.Object{"text": "hello"}, events[1])
+}
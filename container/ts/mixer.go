@@ -3,7 +3,10 @@ package ts
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"time"
 
 	"github.com/nextpkg/goav/amf"
 	"github.com/nextpkg/goav/container/ts/table"
@@ -31,6 +34,29 @@ type Mixer struct {
 	// 音视频同步
 	pts, dts int64
 	sync     *sync
+
+	// B帧: 第一个视频帧的composition time不大于0(之后的B帧可能为负数)时, 根据SPS的重排序帧数确定所有数据包PTS的整体延后;
+	// 没有composition time的H264根据POC重建PTS
+	ptsOffset   int64    // PTS的整体延后(90kHz)
+	seqDelay    int64    // 序列头中SPS的重排序帧数 * 帧间隔(90kHz), 需要延后时作为ptsOffset
+	offsetFixed bool     // 已经收到第一个视频帧, 之后不再改变ptsOffset
+	reorder     *reorder // 为nil时不重建PTS
+	hasCts      bool     // 流中出现过非0的composition time
+
+	// 64位的时间线, 跳变时下一个数据包之前发送带有 discontinuity_indicator 的PCR
+	timeline      *timeline
+	discontinuity bool
+	jump          int64 // 尚未发出的跳变(90kHz)
+
+	// PSI重复发送和PCR间隔
+	schedule *schedule
+	lastPcr  int64 // 最近一次输出的PCR, 小于0表示还没有输出过
+
+	// 固定码率输出, 为nil时不填充空包
+	cbr *cbr
+
+	// 是否开启SAMPLE-AES加密
+	sampleAES bool
 }
 
 // NewMixer ts音视频混合器
@@ -44,15 +70,73 @@ func NewMixer(w io.Writer) *Mixer {
 			media:     bytes.NewBuffer(make([]byte, 0, 512)),
 			types:     packet.NewTypes(),
 		},
-		muxer:  NewMuxer(),
-		parser: parser.NewCodecParser(),
-		sync:   newSync(10),
+		muxer:    NewMuxer(),
+		parser:   parser.NewCodecParser(),
+		sync:     newSync(10),
+		timeline: newTimeline(defaultTimelineJump),
+		schedule: newSchedule(defaultPsiInterval, defaultPcrInterval),
+		lastPcr:  -1,
 	}
 }
 
+// SetInterval 设置PSI(SDT, PAT, PMT)的重复间隔和PCR的最大间隔, 为0时不重复发送
+func (m *Mixer) SetInterval(psi, pcr time.Duration) {
+	m.schedule.setInterval(psi, pcr)
+}
+
+// SetMuxer 设置TS复用器(自定义PID和节目号), 需要在保存序列头之前设置
+func (m *Mixer) SetMuxer(muxer *Muxer) {
+	m.muxer = muxer
+}
+
 // SetWriter 设置输出
 func (m *Mixer) SetWriter(w io.Writer) {
 	m.ts = w
+	if m.cbr != nil {
+		m.cbr.w = w
+	}
+}
+
+// SetMuxRate 设置固定码率(bit/s), 需要在 SetTsHeader 之前设置, 小于等于0时关闭固定码率
+// 固定码率时使用空包填充, PCR根据TS包的位置计算, PTS和DTS整体延后一个缓冲时间(700ms);
+// 数据包超过码率(DTS之前无法发送完)时 Mux 返回 ErrMuxRateOverflow
+func (m *Mixer) SetMuxRate(rate int) {
+	m.cbr = nil
+	if rate > 0 {
+		m.cbr = newCbr(m.ts, rate)
+	}
+}
+
+// EnableSampleAES 开启SAMPLE-AES加密(H264和AAC), 需要在保存序列头之前调用, e为nil时关闭
+// PMT中的流类型改为0xdb和0xcf, 并带有私有数据标识和音频配置描述符; 其它编码的数据不加密
+func (m *Mixer) EnableSampleAES(e parser.SampleEncrypter) {
+	m.sampleAES = e != nil
+	m.parser.SetSampleEncrypter(e)
+}
+
+// SetTimelineJump 设置时间线不连续的阈值: 同类型的音视频时间戳增大超过d时视为不连续, 默认10秒, 小于等于0时只检测回退
+// 时间戳回退超过1秒总是视为不连续
+func (m *Mixer) SetTimelineJump(d time.Duration) {
+	m.timeline.jump = d.Milliseconds()
+}
+
+// EnablePocReorder 开启或关闭H264的PTS重建, 需要在保存序列头之前调用
+// 视频包没有composition time(例如Annex-b格式的输入)时, 根据SPS和slice头中的POC推算PTS;
+// 流中一旦出现非0的composition time就不再重建
+func (m *Mixer) EnablePocReorder(enabled bool) {
+	m.reorder = nil
+	if enabled {
+		m.reorder = newReorder()
+	}
+}
+
+// output 输出TS包的位置
+func (m *Mixer) output() io.Writer {
+	if m.cbr != nil {
+		return m.cbr
+	}
+
+	return m.ts
 }
 
 func (m *Mixer) parse(p *packet.Packet, cache *bytes.Buffer) error {
@@ -69,13 +153,171 @@ func (m *Mixer) parse(p *packet.Packet, cache *bytes.Buffer) error {
 }
 
 // Mux 转换为ts格式（需要使用p.Media）
+// 按照DTS重复发送PSI, 数据包本身不带PCR且距离上一个PCR超过间隔时先发送只有PCR的TS包
+// 脚本数据在开启ID3(EnableID3)时转换为ID3标签, 否则被丢弃
 func (m *Mixer) Mux(p *packet.Packet) error {
-	err := m.parse(p, m.cache.media)
+	var s *Stream
+	if p.Type == packet.PktMetadata {
+		s = m.id3Stream()
+		if s == nil {
+			return nil
+		}
+
+		tag, err := id3Tag(p)
+		if err != nil || tag == nil {
+			return err
+		}
+
+		p.Media, err = tag.Encode()
+		if err != nil {
+			return err
+		}
+	} else {
+		err := m.parse(p, m.cache.media)
+		if err != nil {
+			return err
+		}
+
+		if p.Type == packet.PktAudio {
+			err = m.advanceAudio()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var err error
+
+	if m.discontinuity {
+		err = m.writeDiscontinuity()
+		if err != nil {
+			return err
+		}
+	}
+
+	dts, pts := m.dts, m.pts
+	if m.cbr != nil {
+		// 用空包填充到数据包的DTS, 解码器在延后的DTS之前收到数据
+		m.cbr.begin(m.dts)
+		err = m.stuff(m.dts)
+		if err != nil {
+			return err
+		}
+
+		dts += m.cbr.delay
+		pts += m.cbr.delay
+	}
+
+	if m.schedule.psiDue(m.dts) {
+		err = m.writeTables()
+		if err != nil {
+			return err
+		}
+		m.schedule.tablesSent(m.dts)
+	}
+
+	now := m.clock()
+	if m.carriesPcr(p) {
+		m.lastPcr = now
+	} else if m.schedule.pcrDue(now, m.lastPcr) && len(m.muxer.programs) > 0 {
+		_, err = m.output().Write(m.muxer.PCR(m.muxer.programs[0], dts))
+		if err != nil {
+			return err
+		}
+		m.lastPcr = now
+	}
+
+	if s != nil {
+		err = m.muxer.MuxStream(s, p, dts, pts, m.output())
+	} else {
+		err = m.muxer.Mux(p, dts, pts, m.output())
+	}
 	if err != nil {
 		return err
 	}
 
-	return m.muxer.Mux(p, m.dts, m.pts, m.ts)
+	if m.cbr != nil && m.cbr.clock() > dts {
+		return ErrMuxRateOverflow
+	}
+
+	return nil
+}
+
+// advanceAudio 累计刚解析的音频帧的采样数, 采样数和采样率都来自当前帧(Opus每个包的时长可以不同, MP3的采样率可以变化)
+func (m *Mixer) advanceAudio() error {
+	sampleRate, err := m.parser.SampleRate()
+	if err != nil {
+		return err
+	}
+
+	samples, err := m.parser.FrameSamples()
+	if err != nil {
+		return err
+	}
+
+	m.sync.advance(samples, sampleRate)
+	return nil
+}
+
+// writeDiscontinuity 时间线跳变: 固定码率的时钟随之跳变, 以新的时间发送带有 discontinuity_indicator 的PCR
+func (m *Mixer) writeDiscontinuity() error {
+	if m.cbr != nil {
+		m.cbr.shift(m.jump)
+	}
+	m.discontinuity, m.jump = false, 0
+
+	if len(m.muxer.programs) == 0 {
+		return nil
+	}
+
+	now := m.clock()
+	_, err := m.output().Write(m.muxer.DiscontinuityPCR(m.muxer.programs[0], now))
+	if err != nil {
+		return err
+	}
+	m.lastPcr = now
+
+	return nil
+}
+
+// clock 当前的时间(90kHz): 固定码率时为下一个TS包的时间, 否则为数据包的DTS
+func (m *Mixer) clock() int64 {
+	if m.cbr != nil {
+		return m.cbr.clock()
+	}
+
+	return m.dts
+}
+
+// stuff 使用空包填充到指定的时间, 填充期间按照间隔发送PCR
+func (m *Mixer) stuff(until int64) error {
+	null := nullPacket()
+	for now := m.cbr.clock(); now < until; now = m.cbr.clock() {
+		b := null
+		if m.schedule.pcrDue(now, m.lastPcr) && len(m.muxer.programs) > 0 {
+			b = m.muxer.PCR(m.muxer.programs[0], now)
+			m.lastPcr = now
+		}
+
+		_, err := m.cbr.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// carriesPcr 判断数据包的第一个TS包是否带有PCR(PCR_PID上的视频关键帧)
+func (m *Mixer) carriesPcr(p *packet.Packet) bool {
+	if p.Type != packet.PktVideo || len(m.muxer.programs) == 0 {
+		return false
+	}
+
+	prog := m.muxer.programs[0]
+	s := prog.Stream(packet.PktVideo)
+
+	return s != nil && s.PID == prog.pmt(m.cache.types.ToSlice()...).PcrPID && isKeyPacket(s, p)
 }
 
 // SaveMetadata 保存元数据
@@ -101,11 +343,27 @@ func (m *Mixer) SaveMetadata(md amf.Object) error {
 	return nil
 }
 
-// SaveAVCHeader 保存AVC序列头（flv->avc sequence header）
+// SaveAVCHeader 保存视频序列头（flv->avc/hevc sequence header）, PMT中视频的流类型随编码变化
 func (m *Mixer) SaveAVCHeader(p *packet.Packet) error {
 	m.cache.types.IsVideo()
+	err := m.setVideoStreamType(p)
+	if err != nil {
+		return err
+	}
+
+	// 序列头中的SPS用于计算POC; 不重建PTS时, 根据重排序帧数计算可能需要的PTS延后
+	if vh, ok := p.Header.(packet.VideoPacketHeader); ok && vh.IsCodecAvc() {
+		if m.reorder != nil {
+			err = m.reorder.poc.SetConfig(p.Media)
+			if err != nil {
+				return err
+			}
+		} else if !m.offsetFixed {
+			m.seqDelay = reorderDelay(p.Media)
+		}
+	}
 
-	err := m.parse(p, m.cache.avcSeqHdr)
+	err = m.parse(p, m.cache.avcSeqHdr)
 	if err != nil {
 		return err
 	}
@@ -114,42 +372,195 @@ func (m *Mixer) SaveAVCHeader(p *packet.Packet) error {
 	return m.muxer.Mux(p, 0, 0, m.cache.avcSeqHdr)
 }
 
+// setVideoStreamType 根据视频编码设置第一个节目中视频流的流类型, SAMPLE-AES加密的H264带有私有数据标识描述符
+func (m *Mixer) setVideoStreamType(p *packet.Packet) error {
+	vh, ok := p.Header.(packet.VideoPacketHeader)
+	if !ok || len(m.muxer.programs) == 0 {
+		return nil
+	}
+
+	s := m.muxer.programs[0].Stream(packet.PktVideo)
+	if s == nil {
+		return nil
+	}
+
+	switch {
+	case vh.IsCodecHevc():
+		s.StreamType = table.StreamTypeHevc
+	case vh.IsCodecAvc() && m.sampleAES:
+		desc := table.NewDescriptor()
+		err := desc.PrivateDataIndicator("zavc")
+		if err != nil {
+			return err
+		}
+
+		s.StreamType = table.StreamTypeAvcSampleAES
+		s.Descriptors = desc.GetBuffer().Bytes()
+	case vh.IsCodecAvc():
+		s.StreamType = table.StreamTypeAvc
+	}
+
+	return nil
+}
+
 // SaveAACHeader 保存AAC序列头（flv->aac sequence header）
 func (m *Mixer) SaveAACHeader(p *packet.Packet) error {
+	return m.SaveAudioHeader(p)
+}
+
+// SaveAudioHeader 保存音频信息, PMT中音频的流类型和描述符随编码变化
+// AAC和Opus使用序列头; MP3, AC-3, E-AC-3和G.711没有序列头, 可以使用第一个音频包
+func (m *Mixer) SaveAudioHeader(p *packet.Packet) error {
 	m.cache.types.IsAudio()
 
-	err := m.parse(p, m.cache.aacSeqHdr)
+	ah, ok := p.Header.(packet.AudioPacketHeader)
+	if !ok {
+		return errors.New("unexpected audio packet header")
+	}
+
+	err := m.setAudioStream(ah, p.Media)
 	if err != nil {
 		return err
 	}
 
+	// 解析序列头或者第一个音频包, 得到采样率
+	err = m.parse(p, m.cache.aacSeqHdr)
+	if err != nil {
+		return err
+	}
+
+	// 只有AAC的序列头需要复用
 	m.cache.aacSeqHdr.Reset()
+	if !ah.IsSoundAAC() || !ah.IsAACSeqHdr() {
+		return nil
+	}
+
 	return m.muxer.Mux(p, 0, 0, m.cache.aacSeqHdr)
 }
 
-// SetTsHeader 封装PAT和PMT
+// setAudioStream 根据音频编码设置第一个节目中音频流的流类型和描述符
+func (m *Mixer) setAudioStream(ah packet.AudioPacketHeader, media []byte) error {
+	if len(m.muxer.programs) == 0 {
+		return nil
+	}
+
+	s := m.muxer.programs[0].Stream(packet.PktAudio)
+	if s == nil {
+		return nil
+	}
+
+	desc := table.NewDescriptor()
+	switch {
+	case ah.IsSoundAAC() && m.sampleAES:
+		// 音频配置为序列头中的 AudioSpecificConfig
+		var asc []byte
+		if ah.IsAACSeqHdr() {
+			asc = media
+		}
+
+		s.StreamType = table.StreamTypeAacSampleAES
+		if err := desc.PrivateDataIndicator("aacd"); err != nil {
+			return err
+		}
+		if err := desc.AudioSetup("zaac", 0, asc); err != nil {
+			return err
+		}
+	case ah.IsSoundAAC():
+		s.StreamType = table.StreamTypeAac
+	case ah.IsSoundMP3():
+		// 帧头中的version_id: 3为MPEG-1, 其它为MPEG-2的低采样率扩展
+		s.StreamType = table.StreamTypeMpeg1Audio
+		if len(media) >= 2 && media[0] == 0xff && (media[1]>>3)&0x3 != 0x3 {
+			s.StreamType = table.StreamTypeMpeg2Audio
+		}
+	case ah.IsSoundAC3():
+		s.StreamType = table.StreamTypeAc3
+		if err := desc.Registration("AC-3"); err != nil {
+			return err
+		}
+	case ah.IsSoundEAC3():
+		s.StreamType = table.StreamTypeEac3
+		if err := desc.Registration("EAC3"); err != nil {
+			return err
+		}
+	case ah.IsSoundOpus():
+		// OpusHead中的声道数, 默认立体声
+		channels := byte(2)
+		if ah.IsSoundSeqHdr() && len(media) > 9 {
+			channels = media[9]
+		}
+
+		s.StreamType = table.StreamTypePrivateData
+		if err := desc.Registration("Opus"); err != nil {
+			return err
+		}
+		if err := desc.OpusAudio(channels); err != nil {
+			return err
+		}
+	case ah.IsSoundG711ALaw():
+		s.StreamType = table.StreamTypeG711A
+	case ah.IsSoundG711MuLaw():
+		s.StreamType = table.StreamTypeG711U
+	default:
+		return fmt.Errorf("unsupported audio codec in ts, sound format=%d, fourcc=%#x", ah.SoundFormat(), ah.FourCC())
+	}
+
+	s.Descriptors = desc.GetBuffer().Bytes()
+	return nil
+}
+
+// SetTsHeader 封装PAT和PMT, 之后按照间隔重复发送, 下一个数据包之前发送PCR(可以用于开始新的分片)
+// 没有视频且没有指定PCR_PID时, 使用独立的PCR_PID
 func (m *Mixer) SetTsHeader() error {
+	if len(m.muxer.programs) > 0 {
+		prog := m.muxer.programs[0]
+		if prog.PcrPID == 0 && !containsType(m.cache.types.ToSlice(), packet.PktVideo) {
+			prog.PcrPID = defaultPcrPID
+		}
+	}
+
+	err := m.writeTables()
+	if err != nil {
+		return err
+	}
+
+	m.schedule.tablesSent(-1)
+	m.lastPcr = -1
+	return nil
+}
+
+// writeTables 输出SDT, PAT和PMT
+func (m *Mixer) writeTables() error {
 	mediaType := m.cache.types.ToSlice()
 	metadata := m.cache.metadata
 
-	sdt := m.muxer.SDT(metadata)
-	pat := m.muxer.PAT()
-	pmt := m.muxer.PMT(mediaType...)
+	sdt, err := m.muxer.sdt(metadata)
+	if err != nil {
+		return err
+	}
+	pat, err := m.muxer.pat()
+	if err != nil {
+		return err
+	}
+	pmt, err := m.muxer.pmt(mediaType...)
+	if err != nil {
+		return err
+	}
 
 	// 输出SDT表
-	_, err := m.ts.Write(sdt)
+	_, err = m.output().Write(sdt)
 	if err != nil {
 		return err
 	}
 
 	// 输出PAT表
-	_, err = m.ts.Write(pat)
+	_, err = m.output().Write(pat)
 	if err != nil {
 		return err
 	}
 
 	// 输出PMT表
-	_, err = m.ts.Write(pmt)
+	_, err = m.output().Write(pmt)
 	if err != nil {
 		return err
 	}
@@ -160,28 +571,74 @@ func (m *Mixer) SetTsHeader() error {
 // Update 计算音视频的pts和dts(最终是为了音视频同步)
 // 参数解释:
 // pktTs: 数据包的时间(dts)
-// avcTs: H264的时间增量
+// avcTs: H264的时间增量(有符号的composition time), 数据包带有FLV视频头时使用视频头中的 CompositionTime
 //
 // 备注:
 // 视频的PTS=DTS+时间增量
-// 音频的PTS=DTS
+// 音频和元数据的PTS=DTS
+// 第一个视频帧的时间增量不大于0时, 之后的数据包的PTS整体延后ptsOffset(由SPS的重排序帧数确定), 容纳负的时间增量; 仍然不足时视频的PTS取DTS
+// 毫秒时间戳扩展为64位, 跨过2^32回绕后继续递增, 输出时PTS和DTS按2^33取模;
+// 同类型的音视频时间戳增大超过阈值(SetTimelineJump, 默认10秒)或者回退超过1秒时视为时间线不连续, 下一个数据包之前发送带有 discontinuity_indicator 的PCR
 func (m *Mixer) Update(p *packet.Packet, pktTs, avcTs uint32) error {
-	m.dts = int64(pktTs * avcHZ)
+	var ms int64
+	switch p.Type {
+	case packet.PktVideo, packet.PktAudio:
+		var jump int64
+		ms, jump = m.timeline.update(p.Type, pktTs)
+		if jump != 0 {
+			m.discontinuity = true
+			m.jump += jump * avcHZ
+		}
+	default:
+		ms = m.timeline.extend(pktTs)
+	}
+	m.dts = ms * avcHZ
 
 	switch p.Type {
 	case packet.PktVideo:
-		m.pts = m.dts + int64(avcTs*avcHZ)
-	case packet.PktAudio:
-		// 音频采样率
-		sampleRate, err := m.parser.SampleRate()
+		cts, err := m.compositionTime(p, avcTs)
 		if err != nil {
 			return err
 		}
 
-		// 以DTS为基准, 校正音频PTS, 音频时间片换算成以视频为单位的时间片(1秒钟的音频长度/音频速率 = 流逝时间)
-		m.sync.syncAudioTs(&m.dts, sampleRate)
-		m.pts = m.dts
+		// 第一个视频帧的composition time大于0时, 编码器已经延后了PTS, 不会出现负数
+		if !m.offsetFixed {
+			m.offsetFixed = true
+			if cts <= 0 {
+				m.ptsOffset = m.seqDelay
+			}
+		}
+
+		m.pts = m.dts + cts + m.ptsOffset
+		if m.pts < m.dts {
+			m.pts = m.dts
+		}
+	case packet.PktAudio:
+		// 以DTS为基准, 校正音频PTS, 之前的帧的采样数换算成以视频为单位的时间片(1秒钟的音频长度/音频速率 = 流逝时间)
+		// 当前帧的采样数在Mux中解析之后累计
+		m.sync.syncAudioTs(&m.dts)
+		m.pts = m.dts + m.ptsOffset
+	case packet.PktMetadata:
+		m.pts = m.dts + m.ptsOffset
 	}
 
 	return nil
 }
+
+// compositionTime 视频的时间增量(90kHz), 开启POC重建且流中没有时间增量时根据H264的POC推算
+func (m *Mixer) compositionTime(p *packet.Packet, avcTs uint32) (int64, error) {
+	cts := int64(int32(avcTs))
+	vh, ok := p.Header.(packet.VideoPacketHeader)
+	if ok {
+		cts = int64(vh.CompositionTime())
+	}
+	if cts != 0 {
+		m.hasCts = true
+	}
+
+	if m.reorder == nil || m.hasCts || !ok || !vh.IsCodecAvc() {
+		return cts * avcHZ, nil
+	}
+
+	return m.reorder.cts(p.Media, m.dts)
+}
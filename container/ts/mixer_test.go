@@ -2,9 +2,11 @@ package ts
 
 import (
 	"bytes"
+	"io"
 	"testing"
 
 	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/container/ts/table"
 	"github.com/nextpkg/goav/packet"
 	"github.com/stretchr/testify/assert"
 )
@@ -142,10 +144,11 @@ func TestMixer_SetTsHeader(t *testing.T) {
 		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
 		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
 		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		// PMT: PCR_PID为携带PCR的视频流(0x100), 而不是最后一个基本流
 		0x47, 0x50, 0x1, 0x10, 0x0, 0x2, 0xb0, 0x17,
-		0x0, 0x1, 0xc1, 0x0, 0x0, 0xe1, 0x1, 0xf0,
+		0x0, 0x1, 0xc1, 0x0, 0x0, 0xe1, 0x0, 0xf0,
 		0x0, 0x1b, 0xe1, 0x0, 0xf0, 0x0, 0xf, 0xe1,
-		0x1, 0xf0, 0x0, 0xc7, 0xe0, 0xef, 0x9e, 0xff,
+		0x1, 0xf0, 0x0, 0x2f, 0x44, 0xb9, 0x9b, 0xff,
 		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
 		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
 		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
@@ -178,3 +181,218 @@ func TestMixer_SetTsHeader(t *testing.T) {
 	}, 2000, 0))
 	at.Equal(int64(180000), m.pts)
 }
+
+func TestMixer_PcrPID(t *testing.T) {
+	at := assert.New(t)
+
+	for _, withVideo := range []bool{true, false} {
+		data, _ := newTestTs(at)
+		if !withVideo {
+			buf := bytes.NewBuffer(nil)
+			m := NewMixer(buf)
+			d := flv.NewDemuxer()
+
+			aacSeqHdr := &packet.Packet{Type: packet.PktAudio, Data: []byte{0xaf, 0x00, 0x12, 0x10}}
+			at.Nil(d.Demux(aacSeqHdr))
+			at.Nil(m.SaveAACHeader(aacSeqHdr))
+			at.Nil(m.SetTsHeader())
+
+			p := &packet.Packet{Type: packet.PktAudio, Data: append([]byte{0xaf, 0x01}, make([]byte, 200)...)}
+			at.Nil(d.Demux(p))
+			at.Nil(m.Update(p, 0, 0))
+			at.Nil(m.Mux(p))
+			data = buf.Bytes()
+		}
+
+		// 带有PCR的TS包都在PMT的PCR_PID上
+		dmx := NewDemuxer(bytes.NewReader(data))
+		at.Nil(dmx.Read(&packet.Packet{}))
+		pcrPID := dmx.Programs()[0].PcrPID
+
+		var pcrs int
+		for i := 0; i < len(data); i += tsPacketLen {
+			b := data[i:]
+			if b[3]&0x20 == 0 || b[4] == 0 || b[5]&0x10 == 0 {
+				continue
+			}
+			pcrs++
+			at.Equal(pcrPID, uint16(b[1]&0x1f)<<8|uint16(b[2]), "video=%v", withVideo)
+		}
+		at.True(pcrs > 0)
+
+		if withVideo {
+			at.Equal(uint16(defaultVideoPID), pcrPID)
+		} else {
+			at.Equal(uint16(defaultPcrPID), pcrPID)
+		}
+	}
+}
+
+func TestMixer_Audio(t *testing.T) {
+	at := assert.New(t)
+
+	payload := func(hdr []byte, size int) []byte {
+		for i := len(hdr); i < size; i++ {
+			hdr = append(hdr, byte(i))
+		}
+		return hdr
+	}
+	exAudio := func(packetType byte, fourCC string, data []byte) []byte {
+		return append(append([]byte{flv.SoundExHeader<<4 | packetType}, fourCC...), data...)
+	}
+
+	opusHead := []byte{'O', 'p', 'u', 's', 'H', 'e', 'a', 'd', 0x01, 0x01, 0x38, 0x01, 0x80, 0xbb, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	tests := []struct {
+		name       string
+		header     []byte // 序列头, 为nil时使用第一个音频包
+		frame      []byte
+		streamType uint8
+		streamID   byte
+		desc       []byte
+		samples    int
+		media      []byte // PES负载的开头
+	}{
+		{
+			name:       "mp3",
+			frame:      append([]byte{0x2f}, payload([]byte{0xff, 0xfb, 0x90, 0x64}, 417)...),
+			streamType: table.StreamTypeMpeg1Audio,
+			streamID:   0xc0,
+			desc:       []byte{},
+			samples:    1152,
+			media:      []byte{0xff, 0xfb, 0x90, 0x64},
+		},
+		{
+			name:       "mpeg2 audio",
+			frame:      append([]byte{0x2f}, payload([]byte{0xff, 0xf3, 0x84, 0xc4}, 288)...),
+			streamType: table.StreamTypeMpeg2Audio,
+			streamID:   0xc0,
+			desc:       []byte{},
+			samples:    576,
+			media:      []byte{0xff, 0xf3, 0x84, 0xc4},
+		},
+		{
+			name:       "ac-3",
+			frame:      exAudio(flv.ExAudioCodedFrames, "ac-3", payload([]byte{0x0b, 0x77, 0x00, 0x00, 0x50, 0x40}, 400)),
+			streamType: table.StreamTypeAc3,
+			streamID:   table.PrivateStream1,
+			desc:       []byte{0x05, 0x04, 'A', 'C', '-', '3'},
+			samples:    1536,
+			media:      []byte{0x0b, 0x77, 0x00, 0x00, 0x50, 0x40},
+		},
+		{
+			name:       "e-ac-3",
+			frame:      exAudio(flv.ExAudioCodedFrames, "ec-3", payload([]byte{0x0b, 0x77, 0x00, 0xc7, 0x14, 0x80}, 400)),
+			streamType: table.StreamTypeEac3,
+			streamID:   table.PrivateStream1,
+			desc:       []byte{0x05, 0x04, 'E', 'A', 'C', '3'},
+			samples:    512,
+			media:      []byte{0x0b, 0x77, 0x00, 0xc7, 0x14, 0x80},
+		},
+		{
+			name:       "opus",
+			header:     exAudio(flv.ExAudioSeqStart, "Opus", opusHead),
+			frame:      exAudio(flv.ExAudioCodedFrames, "Opus", payload([]byte{0xfc}, 300)),
+			streamType: table.StreamTypePrivateData,
+			streamID:   table.PrivateStream1,
+			desc:       []byte{0x05, 0x04, 'O', 'p', 'u', 's', 0x7f, 0x02, 0x80, 0x01},
+			samples:    960,
+			media:      []byte{0x7f, 0xe0, 0xff, 0x2d, 0xfc},
+		},
+		{
+			name:       "g.711 a-law",
+			frame:      append([]byte{0x72}, payload([]byte{0xd5, 0xd5}, 400)...),
+			streamType: table.StreamTypeG711A,
+			streamID:   0xc0,
+			desc:       []byte{},
+			samples:    400,
+			media:      []byte{0xd5, 0xd5, 0x02, 0x03},
+		},
+		{
+			name:       "g.711 mu-law stereo",
+			frame:      append([]byte{0x83}, payload([]byte{0xff, 0xff}, 320)...),
+			streamType: table.StreamTypeG711U,
+			streamID:   0xc0,
+			desc:       []byte{},
+			samples:    160,
+			media:      []byte{0xff, 0xff, 0x02, 0x03},
+		},
+	}
+
+	for _, tt := range tests {
+		buf := bytes.NewBuffer(nil)
+		m := NewMixer(buf)
+		d := flv.NewDemuxer()
+
+		header := &packet.Packet{Type: packet.PktAudio, Data: tt.frame}
+		if tt.header != nil {
+			header.Data = tt.header
+		}
+		at.Nil(d.Demux(header), tt.name)
+		at.Nil(m.SaveAudioHeader(header), tt.name)
+		at.Nil(m.SetTsHeader(), tt.name)
+
+		p := &packet.Packet{Type: packet.PktAudio, Data: tt.frame}
+		at.Nil(d.Demux(p), tt.name)
+		at.Nil(m.Update(p, 0, 0), tt.name)
+		at.Nil(m.Mux(p), tt.name)
+		at.Equal(int64(tt.samples), m.sync.sampleNum, tt.name)
+
+		// PMT中的流类型和描述符
+		dmx := NewDemuxer(bytes.NewReader(buf.Bytes()))
+		at.Equal(io.EOF, dmx.Read(&packet.Packet{}), tt.name)
+
+		programs := dmx.Programs()
+		at.Len(programs, 1, tt.name)
+		at.Equal(table.PmtStream{StreamType: tt.streamType, PID: defaultAudioPID, Descriptors: tt.desc}, programs[0].Streams[0], tt.name)
+
+		// 纯音频节目使用独立的PCR_PID
+		at.Equal(uint16(defaultPcrPID), programs[0].PcrPID, tt.name)
+
+		// SDT, PAT, PMT, PCR之后为音频PES
+		pcr := buf.Bytes()[3*tsPacketLen:]
+		at.Equal([]byte{0x47, 0x10, 0x00, 0x20, 0xb7, 0x10}, pcr[:6], tt.name)
+
+		pes := buf.Bytes()[4*tsPacketLen+4:]
+		at.Equal(tt.streamID, pes[3], tt.name)
+		at.Equal(tt.media, pes[14:14+len(tt.media)], tt.name)
+	}
+}
+
+func TestMixer_OpusDuration(t *testing.T) {
+	at := assert.New(t)
+
+	exAudio := func(packetType byte, data []byte) []byte {
+		return append(append([]byte{flv.SoundExHeader<<4 | packetType}, "Opus"...), data...)
+	}
+
+	m := NewMixer(bytes.NewBuffer(nil))
+	d := flv.NewDemuxer()
+
+	opusHead := []byte{'O', 'p', 'u', 's', 'H', 'e', 'a', 'd', 0x01, 0x01, 0x38, 0x01, 0x80, 0xbb, 0x00, 0x00, 0x00, 0x00, 0x00}
+	header := &packet.Packet{Type: packet.PktAudio, Data: exAudio(flv.ExAudioSeqStart, opusHead)}
+	at.Nil(d.Demux(header))
+	at.Nil(m.SaveAudioHeader(header))
+	at.Nil(m.SetTsHeader())
+
+	// 每个包的PTS由之前的包的时长累计得到, 与当前包的时长无关; FLV时间戳取整到毫秒
+	tests := []struct {
+		toc []byte
+		ms  uint32
+		pts int64
+	}{
+		{toc: []byte{0xfc}, ms: 0, pts: 0},           // CELT 20ms
+		{toc: []byte{0xe0}, ms: 20, pts: 1800},       // CELT 2.5ms
+		{toc: []byte{0xfb, 0x03}, ms: 22, pts: 2025}, // code 3, 3个20ms的帧
+		{toc: []byte{0xf4}, ms: 82, pts: 7425},       // CELT 10ms
+		{toc: []byte{0x5b, 0x02}, ms: 92, pts: 8325}, // code 3, 2个SILK 60ms的帧
+		{toc: []byte{0xfc}, ms: 212, pts: 19125},     // CELT 20ms
+	}
+	for i, tt := range tests {
+		p := &packet.Packet{Type: packet.PktAudio, TimeStamp: tt.ms, Data: exAudio(flv.ExAudioCodedFrames, append(tt.toc, make([]byte, 100)...))}
+		at.Nil(d.Demux(p))
+		at.Nil(m.Update(p, p.TimeStamp, 0))
+		at.Equal(tt.pts, m.pts, "packet %d", i)
+		at.Nil(m.Mux(p))
+	}
+}
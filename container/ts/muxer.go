@@ -3,6 +3,7 @@ package ts
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 
@@ -15,45 +16,69 @@ const (
 	tsPacketLen      = 188
 )
 
-const (
-	videoPID = 0x100
-	audioPID = 0x101
-)
-
-// Muxer TS复用器
+// Muxer TS复用器, 节目和基本流的PID由 Program 配置
 type Muxer struct {
-	videoCc  byte /* 包递增计数器 */
-	audioCc  byte /* 包递增计数器 */
-	patCc    byte /* 包递增计数器 */
-	pmtCc    byte /* 包递增计数器 */
-	sdt      [tsPacketLen]byte
-	pat      [tsPacketLen]byte
-	pmt      [tsPacketLen]byte
+	tsID     uint16
+	programs []*Program
+
+	psi      *psiWriter /* PSI的封装, 每个PID独立的包递增计数器 */
 	tsPacket [tsPacketLen]byte
 }
 
-// NewMuxer TS复用器
+// NewMuxer TS复用器, 使用默认节目: 节目号1, PMT的PID为0x1001, H264视频0x100, AAC音频0x101
 func NewMuxer() *Muxer {
-	return &Muxer{}
+	return NewMuxerWithPrograms(defaultTsID, newDefaultProgram())
+}
+
+// NewMuxerWithPrograms TS复用器, 可以包含多个节目(MPTS)
+// tsID: transport_stream_id; 第一个节目供 Mux, PMT 和 SDT 使用
+func NewMuxerWithPrograms(tsID uint16, programs ...*Program) *Muxer {
+	return &Muxer{
+		tsID:     tsID,
+		programs: programs,
+		psi:      newPsiWriter(),
+	}
+}
+
+// Programs 所有节目
+func (muxer *Muxer) Programs() []*Program {
+	return muxer.programs
 }
 
 // Mux 复用TS流(使用到: p.Header(FLV信息), p.data(FLV数据),p.Media(音视频数据), p.Timestamp)
 // 视频数据含有B帧时, pts需要在dts的基础上加偏移量; 如果不含B帧, 则pts=dts
+// 数据包写入第一个节目中对应类型的基本流, 多节目时使用 MuxStream
 func (muxer *Muxer) Mux(p *packet.Packet, dts, pts int64, w io.Writer) error {
-	var pid int
-	var header = p.Header
+	if p.Type != packet.PktVideo && p.Type != packet.PktAudio {
+		return fmt.Errorf("support audio and video only,type=%d", p.Type)
+	}
+
+	var s *Stream
+	if len(muxer.programs) > 0 {
+		s = muxer.programs[0].Stream(p.Type)
+	}
+	if s == nil {
+		return fmt.Errorf("no stream for type=%d", p.Type)
+	}
+
+	return muxer.MuxStream(s, p, dts, pts, w)
+}
+
+// MuxStream 将数据包复用到指定的基本流
+func (muxer *Muxer) MuxStream(s *Stream, p *packet.Packet, dts, pts int64, w io.Writer) error {
+	var pid = int(s.PID)
 	var isKeyFrame bool
 
+	if pts < dts {
+		return fmt.Errorf("pts(%d) is earlier than dts(%d)", pts, dts)
+	}
+
 	switch p.Type {
 	case packet.PktVideo:
-		pid = videoPID
-
-		vh := header.(packet.VideoPacketHeader)
-		isKeyFrame = vh.IsKeyFrame()
-	case packet.PktAudio:
-		pid = audioPID
+		isKeyFrame = isKeyPacket(s, p)
+	case packet.PktAudio, packet.PktMetadata:
 	default:
-		return fmt.Errorf("support audio and video only,type=%d", p.Type)
+		return fmt.Errorf("support audio, video and metadata only,type=%d", p.Type)
 	}
 
 	// 生成pes头, 获取头的长度以及pes包总长度
@@ -61,6 +86,11 @@ func (muxer *Muxer) Mux(p *packet.Packet, dts, pts int64, w io.Writer) error {
 	pesHeaderLen := pes.GeneratePesHeader(p.Type, len(p.Media), pts, dts)
 	pesTotalLen := len(p.Media) + pesHeaderLen
 
+	// AC-3, E-AC-3, Opus和ID3元数据使用 private_stream_1
+	if table.IsPrivateStream(s.StreamType) {
+		pes.PesHeader[3] = table.PrivateStream1
+	}
+
 	// 填充ts头
 	pes.TsHeader[1] = byte(pid >> 8)
 	pes.TsHeader[2] = byte(pid)
@@ -79,24 +109,14 @@ func (muxer *Muxer) Mux(p *packet.Packet, dts, pts int64, w io.Writer) error {
 			muxer.tsPacket[1] |= 0x40
 		}
 
-		// 更新音视频计数器
-		switch p.Type {
-		case packet.PktVideo:
-			muxer.videoCc++
-			if muxer.videoCc > 0xf {
-				muxer.videoCc = 0
-			}
-
-			muxer.tsPacket[3] |= muxer.videoCc
-		case packet.PktAudio:
-			muxer.audioCc++
-			if muxer.audioCc > 0xf {
-				muxer.audioCc = 0
-			}
-
-			muxer.tsPacket[3] |= muxer.audioCc
+		// 更新计数器
+		s.cc++
+		if s.cc > 0xf {
+			s.cc = 0
 		}
 
+		muxer.tsPacket[3] |= s.cc
+
 		// 去除包头4个字节, 从第5个字节开始算
 		i := byte(4)
 
@@ -185,136 +205,181 @@ func (muxer *Muxer) Mux(p *packet.Packet, dts, pts int64, w io.Writer) error {
 	return nil
 }
 
-// SDT make service description table
+// isKeyPacket 判断视频包是否是关键帧(关键帧的第一个TS包带有PCR)
+// FLV的帧类型之外, 根据NALU识别随机访问点(H264: IDR, H265: IRAP)
+func isKeyPacket(s *Stream, p *packet.Packet) bool {
+	vh, ok := p.Header.(packet.VideoPacketHeader)
+	if !ok || p.Type != packet.PktVideo {
+		return false
+	}
+
+	return vh.IsKeyFrame() || isRandomAccess(s.StreamType, p.Media)
+}
+
+// PCR 生成只有自适应域(携带PCR)的TS包, PID为节目的PCR_PID
+// PCR_PID与基本流相同时沿用基本流的包递增计数器(没有负载的包不递增)
+func (muxer *Muxer) PCR(prog *Program, pcr int64) []byte {
+	return muxer.pcrPacket(prog, pcr, false)
+}
+
+// DiscontinuityPCR 生成带有 discontinuity_indicator 的PCR包, 表示系统时间基准从该PCR开始跳变
+// 之后的PTS, DTS和PCR都属于新的时间基准
+func (muxer *Muxer) DiscontinuityPCR(prog *Program, pcr int64) []byte {
+	return muxer.pcrPacket(prog, pcr, true)
+}
+
+// pcrPacket 只有自适应域的PCR包
+func (muxer *Muxer) pcrPacket(prog *Program, pcr int64, discontinuity bool) []byte {
+	pid := prog.pmt().PcrPID
+
+	// 独立的PCR_PID上只有不带负载的包, 计数器始终为0
+	var cc byte
+	if s := prog.streamByPID(pid); s != nil {
+		cc = s.cc
+	}
+
+	b := make([]byte, tsPacketLen)
+	b[0] = syncByte
+	b[1] = byte(pid>>8) & 0x1f
+	b[2] = byte(pid)
+	b[3] = 0x20 | cc&0x0f
+
+	// 自适应域长度, PCR_flag
+	b[4] = tsPacketLen - 5
+	b[5] = 0x10
+	if discontinuity {
+		b[5] |= 0x80
+	}
+	table.NewPes().WritePcr(b[6:], pcr)
+	for i := 12; i < tsPacketLen; i++ {
+		b[i] = 0xff
+	}
+
+	return b
+}
+
+// SDT make service description table, desc为第一个节目的业务描述符
+// 表可以跨越多个TS包, section超过最大长度时返回nil
 func (muxer *Muxer) SDT(desc *bytes.Buffer) []byte {
-	sdt := table.NewSdt()
-
-	// 填充TS头
-	i := 0
-	copy(muxer.sdt[i:], sdt.TsHeader)
-	i += len(sdt.TsHeader)
-
-	// 修正长度, 填充SDT头
-	sectionLen := 17 + desc.Len()
-	sdt.SdtHeader[1] |= byte(sectionLen>>8) & 0x0F
-	sdt.SdtHeader[2] = byte(sectionLen)
-	sdt.SdtHeader[14] |= byte((sectionLen-17)>>8) & 0x0F
-	sdt.SdtHeader[15] = byte(sectionLen - 17)
-	copy(muxer.sdt[i:], sdt.SdtHeader)
-	i += len(sdt.SdtHeader)
-
-	// 填充描述信息
-	copy(muxer.sdt[i:], desc.Bytes())
-	i += desc.Len()
-
-	// 计算CRC32
-	crc32Value := GenerateCrc32(muxer.sdt[len(sdt.TsHeader):i])
-	muxer.sdt[i] = byte(crc32Value >> 24)
-	i++
-	muxer.sdt[i] = byte(crc32Value >> 16)
-	i++
-	muxer.sdt[i] = byte(crc32Value >> 8)
-	i++
-	muxer.sdt[i] = byte(crc32Value)
-	i++
-
-	for j := 0; j < tsPacketLen-i; j++ {
-		muxer.sdt[i+j] = 0xff
+	b, err := muxer.sdt(desc)
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
+
+// sdt 生成第一个节目的SDT, section超过最大长度时返回错误
+func (muxer *Muxer) sdt(desc *bytes.Buffer) ([]byte, error) {
+	var serviceID uint16 = defaultProgramNumber
+	if len(muxer.programs) > 0 {
+		serviceID = muxer.programs[0].Number
 	}
 
-	return muxer.sdt[:]
+	section := table.BuildSdt(muxer.tsID, []uint16{serviceID}, [][]byte{desc.Bytes()})
+	return muxer.psi.packets(sdtPID, section)
 }
 
-// PAT make program associate table
+// PAT make program associate table, 包含所有节目
+// 表可以跨越多个TS包, section超过最大长度时返回nil
 func (muxer *Muxer) PAT() []byte {
-	pat := table.NewPat()
+	b, err := muxer.pat()
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
 
-	// 填写包递增计数器, 共4位, 超出则归零
-	if muxer.patCc > 0xf {
-		muxer.patCc = 0
+// pat 生成包含所有节目的PAT, section超过最大长度时返回错误
+func (muxer *Muxer) pat() ([]byte, error) {
+	pat := &table.PatSection{TransportStreamID: muxer.tsID}
+	for _, prog := range muxer.programs {
+		pat.Programs = append(pat.Programs, table.PatProgram{Number: prog.Number, PID: prog.PmtPID})
 	}
-	pat.TsHeader[3] |= muxer.patCc & 0x0f
-	muxer.patCc++
-
-	i := 0
-	copy(muxer.pat[i:], pat.TsHeader)
-	i += len(pat.TsHeader)
-
-	copy(muxer.pat[i:], pat.PatHeader)
-	i += len(pat.PatHeader)
-
-	// 计算CRC32
-	crc32Value := GenerateCrc32(pat.PatHeader)
-	muxer.pat[i] = byte(crc32Value >> 24)
-	i++
-	muxer.pat[i] = byte(crc32Value >> 16)
-	i++
-	muxer.pat[i] = byte(crc32Value >> 8)
-	i++
-	muxer.pat[i] = byte(crc32Value)
-	i++
-
-	for j := 0; j < tsPacketLen-i; j++ {
-		muxer.pat[i+j] = 0xff
+
+	b, err := muxer.psi.packets(patPID, pat.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("too many programs(%d) for one pat section: %v", len(muxer.programs), err)
 	}
 
-	return muxer.pat[:]
+	return b, nil
 }
 
-// PMT make program map table, mediaType: PktVideo or PktAudio
+// PMT make program map table of the first program, mediaType: PktVideo or PktAudio
+// mediaType为空时包含节目中的所有基本流; 表可以跨越多个TS包, section超过最大长度时返回nil
 func (muxer *Muxer) PMT(mediaType ...int) []byte {
-	pmt := table.NewPmt()
-	pro := table.NewProgram()
-
-	// 填充节目信息
-	var programInfo bytes.Buffer
-	for _, v := range mediaType {
-		switch v {
-		case packet.PktVideo:
-			// 视频节目参考时钟(PCR_PID)所在TS分组的PID: 0x00
-			pmt.PmtHeader[9] = 0x00
-			programInfo.Write(pro.Avc)
-		case packet.PktAudio:
-			// 音频节目参考时钟(PCR_PID)所在TS分组的PID: 0x01
-			pmt.PmtHeader[9] = 0x01
-			programInfo.Write(pro.Aac)
+	b, err := muxer.pmt(mediaType...)
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
+
+// pmt 生成第一个节目的PMT, 没有节目或者section超过最大长度时返回错误
+func (muxer *Muxer) pmt(mediaType ...int) ([]byte, error) {
+	if len(muxer.programs) == 0 {
+		return nil, errors.New("no program for pmt")
+	}
+
+	return muxer.programPMT(muxer.programs[0], mediaType...)
+}
+
+// WriteTables 输出SDT(有业务信息时), PAT以及所有节目的PMT
+func (muxer *Muxer) WriteTables(w io.Writer) error {
+	var ids []uint16
+	var descs [][]byte
+	for _, prog := range muxer.programs {
+		if prog.Provider == "" && prog.Service == "" {
+			continue
+		}
+
+		// 业务类型固定为1(数字电视业务)
+		desc := table.NewDescriptor()
+		err := desc.Service(1, prog.Provider, prog.Service)
+		if err != nil {
+			return err
+		}
+
+		ids = append(ids, prog.Number)
+		descs = append(descs, desc.GetBuffer().Bytes())
+	}
+
+	var tables [][]byte
+	if len(ids) > 0 {
+		sdt, err := muxer.psi.packets(sdtPID, table.BuildSdt(muxer.tsID, ids, descs))
+		if err != nil {
+			return err
 		}
+		tables = append(tables, sdt)
 	}
 
-	// section length
-	pmt.PmtHeader[2] = byte(programInfo.Len() + 9 + 4)
+	pat, err := muxer.pat()
+	if err != nil {
+		return err
+	}
+	tables = append(tables, pat)
 
-	// 填写包递增计数器, 共4位, 超出则归零
-	if muxer.pmtCc > 0xf {
-		muxer.pmtCc = 0
+	for _, prog := range muxer.programs {
+		pmt, err := muxer.programPMT(prog)
+		if err != nil {
+			return err
+		}
+		tables = append(tables, pmt)
 	}
-	pmt.TsHeader[3] |= muxer.pmtCc & 0x0f
-	muxer.pmtCc++
-
-	i := 0
-	copy(muxer.pmt[i:], pmt.TsHeader)
-	i += len(pmt.TsHeader)
-
-	copy(muxer.pmt[i:], pmt.PmtHeader)
-	i += len(pmt.PmtHeader)
-
-	copy(muxer.pmt[i:], programInfo.Bytes())
-	i += programInfo.Len()
-
-	// 计算CRC32
-	crc32Value := GenerateCrc32(muxer.pmt[len(pmt.TsHeader):i])
-	muxer.pmt[i] = byte(crc32Value >> 24)
-	i++
-	muxer.pmt[i] = byte(crc32Value >> 16)
-	i++
-	muxer.pmt[i] = byte(crc32Value >> 8)
-	i++
-	muxer.pmt[i] = byte(crc32Value)
-	i++
-
-	for j := 0; j < tsPacketLen-i; j++ {
-		muxer.pmt[i+j] = 0xff
+
+	for _, b := range tables {
+		_, err := w.Write(b)
+		if err != nil {
+			return err
+		}
 	}
 
-	return muxer.pmt[:]
+	return nil
+}
+
+// programPMT 生成节目的PMT
+func (muxer *Muxer) programPMT(prog *Program, mediaType ...int) ([]byte, error) {
+	return muxer.psi.packets(prog.PmtPID, prog.pmt(mediaType...).Bytes())
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"testing"
 
+	"github.com/nextpkg/goav/container/flv"
 	"github.com/nextpkg/goav/container/ts/table"
 	"github.com/nextpkg/goav/packet"
 	"github.com/stretchr/testify/assert"
@@ -174,3 +175,79 @@ func TestMuxer_Table(t *testing.T) {
 		0xff, 0xff, 0xff,
 	}, buf.Bytes())
 }
+
+func TestMuxer_Programs(t *testing.T) {
+	at := assert.New(t)
+
+	// 两个节目, 自定义PID
+	news := NewProgram(0x10, 0x200)
+	news.Provider, news.Service = "partner", "news"
+	newsVideo := news.AddStream(0x210, table.StreamTypeAvc, packet.PktVideo)
+	news.AddStream(0x211, table.StreamTypeAac, packet.PktAudio)
+
+	sports := NewProgram(0x20, 0x300)
+	sports.Provider, sports.Service = "partner", "sports"
+	sports.AddStream(0x310, table.StreamTypeAvc, packet.PktVideo)
+	sportsAudio := sports.AddStream(0x311, table.StreamTypeAac, packet.PktAudio)
+
+	mux := NewMuxerWithPrograms(0x1234, news, sports)
+	buf := bytes.NewBuffer(nil)
+	at.Nil(mux.WriteTables(buf))
+	at.Equal(4*tsPacketLen, buf.Len())
+
+	// 音视频写入不同节目的基本流
+	d := flv.NewDemuxer()
+	video := &packet.Packet{Type: packet.PktVideo, Data: avccFrame(0x17, 0, 0x65, 400)}
+	at.Nil(d.Demux(video))
+	video.Media = append([]byte{0x00, 0x00, 0x00, 0x01}, video.Data[9:]...)
+	at.Nil(mux.MuxStream(newsVideo, video, 0, 0, buf))
+
+	audio := &packet.Packet{Type: packet.PktAudio, Data: []byte{0xaf, 0x01, 0x21}}
+	at.Nil(d.Demux(audio))
+	audio.Media = make([]byte, 200)
+	at.Nil(mux.MuxStream(sportsAudio, audio, 0, 0, buf))
+
+	// 第一个节目中没有对应类型的基本流时报错
+	at.NotNil(NewMuxerWithPrograms(1, NewProgram(1, 0x100)).Mux(audio, 0, 0, buf))
+
+	data := buf.Bytes()
+	at.Equal(uint16(0x210), uint16(data[4*tsPacketLen+1]&0x1f)<<8|uint16(data[4*tsPacketLen+2]))
+
+	// 解析PSI
+	dmx := NewDemuxer(bytes.NewReader(data))
+	p := &packet.Packet{}
+	at.Nil(dmx.Read(p))
+
+	programs := dmx.Programs()
+	at.Len(programs, 2)
+	at.Equal(uint16(0x10), programs[0].ProgramNumber)
+	at.Equal(uint16(0x210), programs[0].PcrPID)
+	at.Equal([]table.PmtStream{
+		{StreamType: table.StreamTypeAvc, PID: 0x210, Descriptors: []byte{}},
+		{StreamType: table.StreamTypeAac, PID: 0x211, Descriptors: []byte{}},
+	}, programs[0].Streams)
+	at.Equal(uint16(0x20), programs[1].ProgramNumber)
+	at.Equal(uint16(0x310), programs[1].PcrPID)
+
+	services := dmx.Services()
+	at.Len(services, 2)
+	at.Equal(table.SdtService{ServiceID: 0x20, ServiceType: 1, ProviderName: "partner", ServiceName: "sports"}, services[1])
+}
+
+func TestMixer_TableOverflow(t *testing.T) {
+	at := assert.New(t)
+
+	// program_info超过section的最大长度
+	prog := NewProgram(1, 0x1001)
+	prog.AddStream(0x100, table.StreamTypeAvc, packet.PktVideo)
+	prog.Descriptors = make([]byte, table.MaxSectionLen)
+
+	mux := NewMuxerWithPrograms(1, prog)
+	at.Nil(mux.PMT())
+
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+	m.SetMuxer(mux)
+	at.NotNil(m.SetTsHeader())
+	at.Equal(0, buf.Len())
+}
@@ -0,0 +1,152 @@
+package ts
+
+import (
+	"github.com/nextpkg/goav/container/ts/table"
+	"github.com/nextpkg/goav/packet"
+)
+
+// 默认的节目配置(NewMuxer使用)
+const (
+	defaultTsID          = 0x0001
+	defaultProgramNumber = 0x0001
+	defaultPmtPID        = 0x1001
+	defaultVideoPID      = 0x0100
+	defaultAudioPID      = 0x0101
+	defaultPcrPID        = 0x1000 // 纯音频节目使用独立的PCR_PID
+	defaultScte35PID     = 0x0102
+	defaultID3PID        = 0x0103
+)
+
+// Stream 节目中的基本流
+type Stream struct {
+	PID        uint16
+	StreamType uint8 // PMT中的stream_type, 例如 table.StreamTypeAvc
+	MediaType  int   // 数据包类型: packet.PktVideo, packet.PktAudio 或者 packet.PktMetadata
+
+	Descriptors []byte // PMT中ES_info的描述符
+
+	cc byte // 包递增计数器
+}
+
+// Program 节目, 对应PAT中的一项以及一个PMT
+type Program struct {
+	Number  uint16 // program_number, 同时作为SDT中的service_id
+	PmtPID  uint16
+	PcrPID  uint16 // 为0时使用第一个视频流, 没有视频流时使用第一个音频流
+	Streams []*Stream
+
+	Descriptors []byte // PMT中program_info的描述符
+
+	// SDT中的业务信息, 都为空时SDT中不包含该节目
+	Provider string
+	Service  string
+}
+
+// NewProgram 节目
+func NewProgram(number, pmtPID uint16) *Program {
+	return &Program{
+		Number: number,
+		PmtPID: pmtPID,
+	}
+}
+
+// AddStream 添加基本流
+func (prog *Program) AddStream(pid uint16, streamType uint8, mediaType int) *Stream {
+	s := &Stream{
+		PID:        pid,
+		StreamType: streamType,
+		MediaType:  mediaType,
+	}
+	prog.Streams = append(prog.Streams, s)
+
+	return s
+}
+
+// Stream 返回第一个指定数据包类型的基本流, 不存在时返回nil
+func (prog *Program) Stream(mediaType int) *Stream {
+	for _, s := range prog.Streams {
+		if s.MediaType == mediaType {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// streamByType 返回第一个指定流类型的基本流, 不存在时返回nil
+func (prog *Program) streamByType(streamType uint8) *Stream {
+	for _, s := range prog.Streams {
+		if s.StreamType == streamType {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// streamByPID 返回指定PID的基本流, 不存在时返回nil
+func (prog *Program) streamByPID(pid uint16) *Stream {
+	for _, s := range prog.Streams {
+		if s.PID == pid {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// pmt 生成PMT的模型, mediaType不为空时只包含指定数据包类型的基本流
+func (prog *Program) pmt(mediaType ...int) *table.PmtSection {
+	pmt := &table.PmtSection{
+		ProgramNumber: prog.Number,
+		PcrPID:        prog.PcrPID,
+		Descriptors:   prog.Descriptors,
+	}
+
+	// 没有指定PCR_PID时, 使用第一个视频流, 没有视频流时使用第一个音频流
+	var pcrVideo bool
+	for _, s := range prog.Streams {
+		if len(mediaType) > 0 && !containsType(mediaType, s.MediaType) {
+			continue
+		}
+
+		pmt.Streams = append(pmt.Streams, table.PmtStream{
+			StreamType:  s.StreamType,
+			PID:         s.PID,
+			Descriptors: s.Descriptors,
+		})
+
+		if s.MediaType == packet.PktMetadata {
+			continue
+		}
+		if prog.PcrPID == 0 && !pcrVideo && (pmt.PcrPID == 0 || s.MediaType == packet.PktVideo) {
+			pmt.PcrPID = s.PID
+			pcrVideo = s.MediaType == packet.PktVideo
+		}
+	}
+
+	if pmt.PcrPID == 0 {
+		pmt.PcrPID = nullPID
+	}
+
+	return pmt
+}
+
+// newDefaultProgram 默认节目: 节目号1, PMT的PID为0x1001, H264视频0x100, AAC音频0x101
+func newDefaultProgram() *Program {
+	prog := NewProgram(defaultProgramNumber, defaultPmtPID)
+	prog.AddStream(defaultVideoPID, table.StreamTypeAvc, packet.PktVideo)
+	prog.AddStream(defaultAudioPID, table.StreamTypeAac, packet.PktAudio)
+
+	return prog
+}
+
+func containsType(types []int, t int) bool {
+	for _, v := range types {
+		if v == t {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,72 @@
+package ts
+
+import (
+	"fmt"
+
+	"github.com/nextpkg/goav/container/ts/table"
+)
+
+// psiWriter 将section封装为TS包, 每个PID使用独立的包递增计数器
+type psiWriter struct {
+	cc map[uint16]byte // PID -> 下一个包递增计数器
+}
+
+// newPsiWriter section封装
+func newPsiWriter() *psiWriter {
+	return &psiWriter{
+		cc: make(map[uint16]byte),
+	}
+}
+
+// packets 将同一个PID上的section依次封装为TS包, 剩余部分填充0xff
+// section可以跨越多个TS包, 上一个section结束的TS包内可以开始下一个section(由指针域指出)
+func (pw *psiWriter) packets(pid uint16, sections ...[]byte) ([]byte, error) {
+	var data []byte
+	var starts []int // 每个section在data中的起始位置
+	for _, section := range sections {
+		if len(section) > table.MaxSectionLen {
+			return nil, fmt.Errorf("section is too long(pid=%#x, len=%d)", pid, len(section))
+		}
+
+		starts = append(starts, len(data))
+		data = append(data, section...)
+	}
+
+	var ret []byte
+	for pos := 0; pos < len(data); {
+		b := make([]byte, tsPacketLen)
+		b[0] = syncByte
+		b[1] = byte(pid>>8) & 0x1f
+		b[2] = byte(pid)
+		b[3] = 0x10 | pw.cc[pid]&0x0f
+		pw.cc[pid] = (pw.cc[pid] + 1) & 0x0f
+
+		for len(starts) > 0 && starts[0] < pos {
+			starts = starts[1:]
+		}
+
+		// 有section在本包内开始时, 写入指针域(pointer_field);
+		// 否则下一个section之前的部分填充0xff, 下一个section从新的TS包开始
+		n := 4
+		end := len(data)
+		if len(starts) > 0 {
+			if starts[0]-pos < tsDefaultDataLen-1 {
+				b[1] |= 0x40
+				b[n] = byte(starts[0] - pos)
+				n++
+			} else {
+				end = starts[0]
+			}
+		}
+
+		m := copy(b[n:], data[pos:end])
+		pos += m
+		for n += m; n < tsPacketLen; n++ {
+			b[n] = 0xff
+		}
+
+		ret = append(ret, b...)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,115 @@
+package ts
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nextpkg/goav/container/ts/table"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// testSection 生成指定长度的PMT section
+func testSection(n int) []byte {
+	body := make([]byte, n-12)
+	for i := range body {
+		body[i] = byte(i)
+	}
+
+	return table.BuildSection(table.TablePmt, uint16(n), body)
+}
+
+func TestPsiWriter_Packets(t *testing.T) {
+	at := assert.New(t)
+
+	pw := newPsiWriter()
+
+	// 一个section跨越3个TS包, 只有第一个TS包有指针域
+	section := testSection(400)
+	b, err := pw.packets(0x100, section)
+	at.Nil(err)
+	at.Len(b, 3*tsPacketLen)
+	at.Equal([]byte{0x47, 0x41, 0x00, 0x10, 0x00}, b[:5])
+	at.Equal([]byte{0x47, 0x01, 0x00, 0x11}, b[tsPacketLen:tsPacketLen+4])
+	at.Equal([]byte{0x47, 0x01, 0x00, 0x12}, b[2*tsPacketLen:2*tsPacketLen+4])
+	at.Equal(byte(0xff), b[3*tsPacketLen-1])
+
+	// 每个PID使用独立的包递增计数器
+	b, err = pw.packets(0x101, testSection(20))
+	at.Nil(err)
+	at.Equal(byte(0x10), b[3])
+
+	// 第二个section在第一个section结束的TS包内开始, 指针域指向它
+	b, err = pw.packets(0x100, testSection(200), testSection(20))
+	at.Nil(err)
+	at.Len(b, 2*tsPacketLen)
+	at.Equal(byte(0x13), b[3])
+	at.Equal([]byte{0x47, 0x41, 0x00, 0x14, 200 - 183}, b[tsPacketLen:tsPacketLen+5])
+
+	// section过长
+	_, err = pw.packets(0x100, make([]byte, table.MaxSectionLen+1))
+	at.NotNil(err)
+}
+
+func TestSectionAssembler(t *testing.T) {
+	at := assert.New(t)
+
+	// 不同长度的section, 包括下一个section正好在TS包末尾开始的情况
+	var sections [][]byte
+	for _, n := range []int{20, 183 - 20, 183, 184, 12, 600, 1024, 100} {
+		sections = append(sections, testSection(n))
+	}
+
+	pw := newPsiWriter()
+	b, err := pw.packets(0x100, sections...)
+	at.Nil(err)
+	at.Equal(0, len(b)%tsPacketLen)
+
+	sa := &table.SectionAssembler{}
+	var got [][]byte
+	for i := 0; i < len(b); i += tsPacketLen {
+		pkt := b[i : i+tsPacketLen]
+		got = append(got, sa.Push(pkt[1]&0x40 != 0, pkt[4:])...)
+	}
+	at.Equal(sections, got)
+
+	// 丢失第一个TS包(其中开始的两个section), 等待下一个section
+	sa = &table.SectionAssembler{}
+	var n int
+	for i := tsPacketLen; i < len(b); i += tsPacketLen {
+		pkt := b[i : i+tsPacketLen]
+		n += len(sa.Push(pkt[1]&0x40 != 0, pkt[4:]))
+	}
+	at.Equal(len(sections)-2, n)
+}
+
+func TestMuxer_LongSdt(t *testing.T) {
+	at := assert.New(t)
+
+	prog := newDefaultProgram()
+	prog.Provider = strings.Repeat("p", 120)
+	prog.Service = strings.Repeat("s", 120)
+
+	mux := NewMuxerWithPrograms(defaultTsID, prog)
+	buf := bytes.NewBuffer(nil)
+	at.Nil(mux.WriteTables(buf))
+
+	// SDT跨越两个TS包, 之后是PAT和PMT
+	at.Len(buf.Bytes(), 4*tsPacketLen)
+
+	dmx := NewDemuxer(bytes.NewReader(buf.Bytes()))
+	at.Equal(io.EOF, dmx.Read(&packet.Packet{}))
+	at.Equal(0, dmx.CRCErrors())
+
+	services := dmx.Services()
+	at.Len(services, 1)
+	at.Equal(prog.Provider, services[0].ProviderName)
+	at.Equal(prog.Service, services[0].ServiceName)
+	at.Len(dmx.Programs(), 1)
+
+	// 名称超过描述符的最大长度
+	prog.Service = strings.Repeat("s", 200)
+	at.NotNil(mux.WriteTables(buf))
+}
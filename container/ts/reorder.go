@@ -0,0 +1,93 @@
+package ts
+
+import "github.com/nextpkg/goav/parser/h264"
+
+// reorder 没有composition time的H264根据slice的POC重建PTS
+// IDR之后的每一帧: PTS = DTS + (POC/POC间隔 - 解码序号 + 重排序帧数) * 帧间隔
+type reorder struct {
+	poc *h264.PocCounter
+
+	started bool  // 已经收到IDR
+	step    int   // 相邻帧的POC间隔, 默认为2, 出现奇数的相对POC时为1
+	base    int   // IDR的POC
+	index   int   // IDR之后的解码序号
+	lastDts int64 // 上一帧的DTS(90kHz), 小于0表示没有
+	gap     int64 // 帧间隔(90kHz), 第一帧之前取自SPS
+}
+
+// newReorder 根据POC重建PTS
+func newReorder() *reorder {
+	return &reorder{
+		poc:     h264.NewPocCounter(),
+		step:    2,
+		lastDts: -1,
+	}
+}
+
+// cts 根据帧(AVCC或者Annex-b格式)的POC计算PTS相对于DTS的偏移(90kHz)
+// 第一个IDR之前, 没有SPS, 不支持的POC类型以及POC与解码顺序相同时为0; 实际的重排序超过SPS中的帧数时可能为负数
+// 帧间隔取相邻DTS之差, 第一帧使用SPS中的帧率(没有时按25帧/秒)
+func (r *reorder) cts(media []byte, dts int64) (int64, error) {
+	if r.lastDts >= 0 && dts > r.lastDts {
+		r.gap = dts - r.lastDts
+	}
+	r.lastDts = dts
+
+	poc, idr, err := r.poc.Frame(media)
+	if err == h264.ErrNoSPS || err == h264.ErrUnsupportedPoc {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	sps := r.poc.SPS()
+	if sps.PicOrderCntType == 2 {
+		return 0, nil
+	}
+
+	// 第一帧还没有DTS的间隔, 使用SPS中的帧率
+	if r.gap == 0 {
+		r.gap = frameInterval(sps)
+	}
+
+	if idr {
+		r.started = true
+		r.base, r.index = poc, 0
+	} else {
+		r.index++
+	}
+	if !r.started {
+		return 0, nil
+	}
+
+	rel := poc - r.base
+	if rel%2 != 0 {
+		r.step = 1
+	}
+
+	return int64(rel/r.step-r.index+sps.ReorderFrames()) * r.gap, nil
+}
+
+// defaultFrameInterval SPS中没有时间信息时使用的帧间隔(90kHz, 25帧/秒)
+const defaultFrameInterval = 40 * avcHZ
+
+// frameInterval SPS中的帧间隔(90kHz), 没有时间信息时为 defaultFrameInterval
+func frameInterval(sps *h264.SPS) int64 {
+	if rate := sps.FrameRate(); rate > 0 {
+		return int64(90000/rate + 0.5)
+	}
+
+	return defaultFrameInterval
+}
+
+// reorderDelay 根据 AVCDecoderConfigurationRecord 中的SPS计算容纳负的composition time需要的PTS延后(90kHz): 重排序帧数 * 帧间隔
+// SPS无法解析时为0
+func reorderDelay(record []byte) int64 {
+	sps, err := h264.ParseConfig(record)
+	if err != nil {
+		return 0
+	}
+
+	return int64(sps.ReorderFrames()) * frameInterval(sps)
+}
@@ -0,0 +1,220 @@
+package ts
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/container/ts/table"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// expGolomb 无符号指数哥伦布编码的位串
+func expGolomb(v int) string {
+	s := strconv.FormatInt(int64(v+1), 2)
+	return strings.Repeat("0", len(s)-1) + s
+}
+
+// bitsToBytes 位串加上 rbsp_trailing_bits 后转换为字节
+func bitsToBytes(bits string) []byte {
+	bits += "1"
+	for len(bits)%8 != 0 {
+		bits += "0"
+	}
+
+	b := make([]byte, len(bits)/8)
+	for i := range b {
+		v, _ := strconv.ParseUint(bits[8*i:8*i+8], 2, 8)
+		b[i] = byte(v)
+	}
+
+	return b
+}
+
+// pocSPS Main profile的SPS: frame_num 4位, pic_order_cnt_type 0, pic_order_cnt_lsb 6位, VUI中 max_num_reorder_frames 为reorder
+func pocSPS(reorder int) []byte {
+	bits := "01001101" + "00000000" + "00011110" + expGolomb(0) // profile_idc, constraint_flags, level_idc, sps_id
+	bits += expGolomb(0) + expGolomb(0) + expGolomb(2)          // log2_max_frame_num_minus4, pic_order_cnt_type, log2_max_pic_order_cnt_lsb_minus4
+	bits += expGolomb(4) + "0" + expGolomb(119) + expGolomb(67) // max_num_ref_frames, gaps, width, height
+	bits += "1" + "1" + "0" + "1"                               // frame_mbs_only, direct_8x8_inference, frame_cropping, vui_parameters_present
+	bits += "00000000" + "1"                                    // VUI: bitstream_restriction_flag
+	bits += "1" + expGolomb(2) + expGolomb(1) + expGolomb(16) + expGolomb(16) + expGolomb(reorder) + expGolomb(4)
+
+	return append([]byte{0x67}, bitsToBytes(bits)...)
+}
+
+// pocFrame Annex-b格式的一帧, slice头中带有POC的低位, 之后是size字节的数据
+func pocFrame(idr bool, frameNum, lsb, size int) []byte {
+	header := byte(0x41)
+	bits := expGolomb(0) + expGolomb(5) + expGolomb(0) + fmt.Sprintf("%04b", frameNum%16)
+	if idr {
+		header = 0x65
+		bits += expGolomb(0)
+	}
+	bits += fmt.Sprintf("%06b", lsb%64)
+
+	b := append([]byte{0x00, 0x00, 0x00, 0x01, header}, bitsToBytes(bits)...)
+	for i := 1; len(b) < size; i++ {
+		b = append(b, byte(i))
+	}
+
+	return b
+}
+
+// videoTimes 视频PES的PTS和DTS
+func videoTimes(at *assert.Assertions, data []byte) (pts, dts []int64) {
+	for i := 0; i+tsPacketLen <= len(data); i += tsPacketLen {
+		b := data[i : i+tsPacketLen]
+		pid := uint16(b[1]&0x1f)<<8 | uint16(b[2])
+		if pid != defaultVideoPID || b[1]&0x40 == 0 {
+			continue
+		}
+
+		payload := b[4:]
+		if b[3]&0x20 != 0 {
+			payload = payload[1+int(payload[0]):]
+		}
+
+		info, err := table.ParsePes(payload)
+		at.Nil(err)
+		pts = append(pts, info.PTS)
+		dts = append(dts, info.DTS)
+	}
+
+	return pts, dts
+}
+
+func TestMixer_PocReorder(t *testing.T) {
+	at := assert.New(t)
+
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+	m.EnablePocReorder(true)
+	d := flv.NewDemuxer()
+
+	avcSeqHdr := &packet.Packet{Type: packet.PktVideo, Data: append([]byte{0x17, 0x00, 0x00, 0x00, 0x00}, avcConfig(pocSPS(1), []byte{0x68, 0xee, 0x3c, 0x80})...)}
+	at.Nil(d.Demux(avcSeqHdr))
+	at.Nil(m.SaveAVCHeader(avcSeqHdr))
+	at.Nil(m.SetTsHeader())
+
+	// 两个GOP, 显示顺序: I0 B1 B2 P3 B4 B5 P6 B7 B8 P9, 解码顺序: I0 P3 B1 B2 P6 B4 B5 P9 B7 B8
+	order := []int{0, 3, 1, 2, 6, 4, 5, 9, 7, 8}
+	var want []int64
+	for i := 0; i < 2*len(order); i++ {
+		n := order[i%len(order)]
+		ts := uint32(i * 40)
+
+		flag := byte(0x27)
+		if n == 0 {
+			flag = 0x17
+		}
+		p := &packet.Packet{Type: packet.PktVideo, TimeStamp: ts, Data: append([]byte{flag, 0x01, 0x00, 0x00, 0x00}, pocFrame(n == 0, i%len(order), 2*n, 400)...)}
+		at.Nil(d.Demux(p))
+		at.Nil(m.Update(p, p.TimeStamp, 0))
+		at.Nil(m.Mux(p))
+
+		// 重排序1帧: PTS = GOP开始的DTS + (显示序号+1) * 帧间隔, 第一帧的帧间隔按25帧/秒
+		want = append(want, int64(i/len(order)*len(order)+n+1)*40*avcHZ)
+	}
+
+	pts, dts := videoTimes(at, buf.Bytes())
+	at.Equal(want, pts)
+	for i := range dts {
+		at.Equal(int64(i*40*avcHZ), dts[i])
+		at.True(pts[i] >= dts[i])
+	}
+
+	// 流中带有composition time时不再重建
+	buf.Reset()
+	p := &packet.Packet{Type: packet.PktVideo, TimeStamp: 800, Data: append([]byte{0x27, 0x01, 0x00, 0x00, 0x50}, pocFrame(false, 1, 6, 400)...)}
+	at.Nil(d.Demux(p))
+	at.Nil(m.Update(p, p.TimeStamp, 0))
+	at.Nil(m.Mux(p))
+
+	pts, _ = videoTimes(at, buf.Bytes())
+	at.Equal([]int64{(800 + 80) * avcHZ}, pts)
+}
+
+func TestMixer_NegativeCts(t *testing.T) {
+	at := assert.New(t)
+
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+	d := flv.NewDemuxer()
+
+	// VUI中重排序1帧, 没有时间信息时按25帧/秒: 第一个视频帧的composition time为0, PTS整体延后40ms
+	avcSeqHdr := &packet.Packet{Type: packet.PktVideo, Data: append([]byte{0x17, 0x00, 0x00, 0x00, 0x00}, avcConfig(pocSPS(1), []byte{0x68, 0xee, 0x3c, 0x80})...)}
+	at.Nil(d.Demux(avcSeqHdr))
+	at.Nil(m.SaveAVCHeader(avcSeqHdr))
+	at.Equal(int64(40*avcHZ), m.seqDelay)
+	aacSeqHdr := &packet.Packet{Type: packet.PktAudio, Data: []byte{0xaf, 0x00, 0x12, 0x10}}
+	at.Nil(d.Demux(aacSeqHdr))
+	at.Nil(m.SaveAACHeader(aacSeqHdr))
+	at.Nil(m.SetTsHeader())
+
+	// composition time直接取自FLV视频头(avcTs为0), B帧的composition time为-40ms
+	b := avccFrame(0x27, 0, 0x01, 300)
+	b[2], b[3], b[4] = 0xff, 0xff, 0xd8
+	frames := []*packet.Packet{
+		{Type: packet.PktVideo, TimeStamp: 0, Data: avccFrame(0x17, 0, 0x65, 400)},
+		{Type: packet.PktVideo, TimeStamp: 40, Data: avccFrame(0x27, 80, 0x41, 300)},
+		{Type: packet.PktVideo, TimeStamp: 80, Data: b},
+		{Type: packet.PktAudio, TimeStamp: 100, Data: append([]byte{0xaf, 0x01}, make([]byte, 200)...)},
+	}
+	for _, p := range frames {
+		at.Nil(d.Demux(p))
+		at.Nil(m.Update(p, p.TimeStamp, 0))
+		at.Equal(int64(p.TimeStamp)*avcHZ, m.dts)
+		at.Nil(m.Mux(p))
+	}
+
+	// 从第一个视频帧开始所有PTS整体延后40ms
+	at.Equal(int64(40*avcHZ), m.ptsOffset)
+	pts, dts := videoTimes(at, buf.Bytes())
+	at.Equal([]int64{40 * avcHZ, 160 * avcHZ, 80 * avcHZ}, pts)
+	at.Equal([]int64{0, 40 * avcHZ, 80 * avcHZ}, dts)
+	at.Equal(int64(140*avcHZ), m.pts)
+
+	// 之后的序列头不再改变延后; 超过延后的负composition time, PTS取DTS
+	avcSeqHdr = &packet.Packet{Type: packet.PktVideo, Data: append([]byte{0x17, 0x00, 0x00, 0x00, 0x00}, avcConfig(pocSPS(3), []byte{0x68, 0xee, 0x3c, 0x80})...)}
+	at.Nil(d.Demux(avcSeqHdr))
+	at.Nil(m.SaveAVCHeader(avcSeqHdr))
+	at.Equal(int64(40*avcHZ), m.ptsOffset)
+
+	p := &packet.Packet{Type: packet.PktVideo, TimeStamp: 120, Data: avccFrame(0x27, 0, 0x01, 300)}
+	p.Data[2], p.Data[3], p.Data[4] = 0xff, 0xff, 0x88
+	at.Nil(d.Demux(p))
+	at.Nil(m.Update(p, p.TimeStamp, 0))
+	at.Equal(int64(120*avcHZ), m.pts)
+
+	// 没有VUI的Baseline profile不需要延后
+	bits := "01000010" + "11000000" + "00011110" + expGolomb(0) + expGolomb(0) + expGolomb(2) // profile_idc, constraint_flags, level_idc, sps_id, log2_max_frame_num_minus4, pic_order_cnt_type
+	bits += expGolomb(1) + "0" + expGolomb(39) + expGolomb(29) + "1100"                       // max_num_ref_frames, gaps, width, height, frame_mbs_only, direct_8x8, cropping, vui
+	m = NewMixer(bytes.NewBuffer(nil))
+	avcSeqHdr = &packet.Packet{Type: packet.PktVideo, Data: append([]byte{0x17, 0x00, 0x00, 0x00, 0x00}, avcConfig(append([]byte{0x67}, bitsToBytes(bits)...), []byte{0x68, 0xee, 0x3c, 0x80})...)}
+	at.Nil(d.Demux(avcSeqHdr))
+	at.Nil(m.SaveAVCHeader(avcSeqHdr))
+	at.Equal(int64(0), m.seqDelay)
+
+	// 第一个视频帧的composition time大于0时, 编码器已经延后了PTS, 不再延后
+	m = NewMixer(bytes.NewBuffer(nil))
+	avcSeqHdr = &packet.Packet{Type: packet.PktVideo, Data: append([]byte{0x17, 0x00, 0x00, 0x00, 0x00}, avcConfig(pocSPS(1), []byte{0x68, 0xee, 0x3c, 0x80})...)}
+	at.Nil(d.Demux(avcSeqHdr))
+	at.Nil(m.SaveAVCHeader(avcSeqHdr))
+	at.Nil(m.SetTsHeader())
+	for i, cts := range []byte{40, 80, 0} {
+		p = &packet.Packet{Type: packet.PktVideo, TimeStamp: uint32(i * 40), Data: avccFrame(0x17, cts, 0x65, 300)}
+		at.Nil(d.Demux(p))
+		at.Nil(m.Update(p, p.TimeStamp, 0))
+		at.Equal(int64(i*40+int(cts))*avcHZ, m.pts)
+	}
+	at.Equal(int64(0), m.ptsOffset)
+
+	// 直接使用Muxer时PTS不能早于DTS
+	err := NewMuxer().Mux(frames[1], 80*avcHZ, 40*avcHZ, bytes.NewBuffer(nil))
+	at.NotNil(err)
+}
@@ -0,0 +1,136 @@
+// Package sampleaes HLS的SAMPLE-AES加密(Apple MPEG-2 Stream Encryption Format for HTTP Live Streaming)
+// 只加密H264的 slice NALU 和AAC帧的一部分数据块, 其余的数据(NALU头, ADTS头等)保持明文
+package sampleaes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+
+	"github.com/nextpkg/goav/parser/h264"
+)
+
+// 加密的参数
+const (
+	naluMinLen   = 48  // 长度不超过48字节的NALU不加密
+	naluLeader   = 32  // NALU开始的明文部分(nal_unit_type 和31字节)
+	naluClearLen = 144 // 每个加密块之后的明文部分
+	aacLeader    = 16  // AAC帧(不含ADTS头)开始的明文部分
+)
+
+// H264的NALU类型
+const (
+	naluTypeSlice = 1
+	naluTypeIdr   = 5
+)
+
+// Encrypter SAMPLE-AES加密, 使用AES-128-CBC, 每个NALU和AAC帧都从分片的IV重新开始; 零值需要先调用 SetKey
+type Encrypter struct {
+	block cipher.Block
+	iv    []byte
+}
+
+// NewEncrypter SAMPLE-AES加密, key和iv都为16字节
+func NewEncrypter(key, iv []byte) (*Encrypter, error) {
+	e := &Encrypter{}
+
+	err := e.SetKey(key, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// SetKey 轮换密钥, 之后的数据使用新的key和iv加密
+func (e *Encrypter) SetKey(key, iv []byte) error {
+	if len(iv) != aes.BlockSize {
+		return errors.New("iv must be 16 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	e.block = block
+	e.iv = append(e.iv[:0], iv...)
+	return nil
+}
+
+// EncryptNalu 加密H264的NALU(不含start code), 返回新的数据, 不修改输入
+// 只加密类型为1和5且长度超过48字节的NALU: 先去除防竞争字节, 前32字节为明文, 之后每16字节加密块后跟最多144字节明文,
+// 最后不足16字节的部分为明文, 加密后重新插入防竞争字节
+func (e *Encrypter) EncryptNalu(nalu []byte) []byte {
+	if len(nalu) == 0 {
+		return nalu
+	}
+
+	switch nalu[0] & 0x1f {
+	case naluTypeSlice, naluTypeIdr:
+	default:
+		return nalu
+	}
+
+	rbsp := h264.Unescape(nalu)
+	if len(rbsp) <= naluMinLen {
+		return nalu
+	}
+
+	enc := cipher.NewCBCEncrypter(e.block, e.iv)
+	for i := naluLeader; i < len(rbsp); {
+		if len(rbsp)-i > aes.BlockSize {
+			enc.CryptBlocks(rbsp[i:i+aes.BlockSize], rbsp[i:i+aes.BlockSize])
+			i += aes.BlockSize
+		}
+
+		n := len(rbsp) - i
+		if n > naluClearLen {
+			n = naluClearLen
+		}
+		i += n
+	}
+
+	return escape(rbsp)
+}
+
+// EncryptAAC 加密不含ADTS头的AAC帧, 返回新的数据, 不修改输入
+// 前16字节为明文, 之后的16字节块依次加密, 最后不足16字节的部分为明文
+func (e *Encrypter) EncryptAAC(frame []byte) []byte {
+	n := len(frame) - aacLeader
+	if n < aes.BlockSize {
+		return frame
+	}
+
+	b := append([]byte(nil), frame...)
+	n -= n % aes.BlockSize
+
+	cipher.NewCBCEncrypter(e.block, e.iv).CryptBlocks(b[aacLeader:aacLeader+n], b[aacLeader:aacLeader+n])
+	return b
+}
+
+// escape 插入防竞争字节: 两个00之后是00, 01, 02或03时插入03, 以00结尾时在最后插入03
+func escape(b []byte) []byte {
+	out := make([]byte, 0, len(b)+len(b)/64)
+
+	zeros := 0
+	for _, c := range b {
+		if zeros >= 2 && c <= 0x03 {
+			out = append(out, 0x03)
+			zeros = 0
+		}
+
+		out = append(out, c)
+		if c == 0x00 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+
+	if len(out) > 0 && out[len(out)-1] == 0x00 {
+		out = append(out, 0x03)
+	}
+
+	return out
+}
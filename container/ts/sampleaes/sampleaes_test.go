@@ -0,0 +1,113 @@
+package sampleaes
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+
+	"github.com/nextpkg/goav/parser/h264"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testKey = []byte("0123456789abcdef")
+	testIV  = []byte("fedcba9876543210")
+)
+
+// decryptNalu 按照加密规则解密NALU, 返回解密后的数据以及加密块在RBSP中的位置
+func decryptNalu(at *assert.Assertions, nalu []byte) ([]byte, []int) {
+	block, err := aes.NewCipher(testKey)
+	at.Nil(err)
+	dec := cipher.NewCBCDecrypter(block, testIV)
+
+	var blocks []int
+	rbsp := h264.Unescape(nalu)
+	for i := naluLeader; i < len(rbsp); {
+		if len(rbsp)-i > aes.BlockSize {
+			dec.CryptBlocks(rbsp[i:i+aes.BlockSize], rbsp[i:i+aes.BlockSize])
+			blocks = append(blocks, i)
+			i += aes.BlockSize
+		}
+		i += naluClearLen
+	}
+
+	return escape(rbsp), blocks
+}
+
+func TestEncrypter_EncryptNalu(t *testing.T) {
+	at := assert.New(t)
+
+	e, err := NewEncrypter(testKey, testIV)
+	at.Nil(err)
+
+	// SEI, SPS和不超过48字节的slice不加密
+	short := append([]byte{0x41}, make([]byte, 47)...)
+	short[10] = 0x03
+	for _, nalu := range [][]byte{{0x06, 0x05, 0x01}, {0x67, 0x4d, 0x00, 0x1e}, short} {
+		at.Equal(nalu, e.EncryptNalu(nalu))
+	}
+
+	// 带有防竞争字节的slice: 32+16+144+16字节, 最后16字节为明文
+	rbsp := []byte{0x65}
+	for i := 1; i < 208; i++ {
+		if i%7 < 2 {
+			rbsp = append(rbsp, 0x00)
+		} else {
+			rbsp = append(rbsp, byte(i%4))
+		}
+	}
+	nalu := escape(rbsp)
+	at.True(len(nalu) > len(rbsp))
+	src := append([]byte(nil), nalu...)
+
+	enc := e.EncryptNalu(nalu)
+	at.Equal(src, nalu)
+	at.NotEqual(nalu, enc)
+	at.Equal(nalu[:naluLeader], enc[:naluLeader])
+
+	// 加密后仍然是合法的NALU
+	for i := 2; i < len(enc); i++ {
+		if enc[i-2] == 0 && enc[i-1] == 0 {
+			at.Equal(byte(0x03), enc[i], "offset %d", i)
+		}
+	}
+
+	dec, blocks := decryptNalu(at, enc)
+	at.Equal(nalu, dec)
+	at.Equal([]int{32}, blocks)
+
+	// 更长的slice每160字节加密一个块
+	rbsp = append(rbsp, make([]byte, 200)...)
+	_, blocks = decryptNalu(at, e.EncryptNalu(escape(rbsp)))
+	at.Equal([]int{32, 192, 352}, blocks)
+}
+
+func TestEncrypter_EncryptAAC(t *testing.T) {
+	at := assert.New(t)
+
+	e, err := NewEncrypter(testKey, testIV)
+	at.Nil(err)
+
+	frame := bytes.Repeat([]byte{0x21}, 16+40)
+	enc := e.EncryptAAC(frame)
+	at.Equal(bytes.Repeat([]byte{0x21}, 16+40), frame)
+
+	// 前16字节和最后8字节为明文
+	at.Equal(frame[:16], enc[:16])
+	at.Equal(frame[48:], enc[48:])
+
+	block, _ := aes.NewCipher(testKey)
+	cipher.NewCBCDecrypter(block, testIV).CryptBlocks(enc[16:48], enc[16:48])
+	at.Equal(frame, enc)
+
+	// 不足一个加密块
+	at.Equal(frame[:31], e.EncryptAAC(frame[:31]))
+
+	// 轮换密钥
+	before := e.EncryptAAC(frame)
+	at.Nil(e.SetKey([]byte("fedcba9876543210"), testIV))
+	at.NotEqual(before, e.EncryptAAC(frame))
+	at.NotNil(e.SetKey(testKey, testIV[:8]))
+	at.NotNil(e.SetKey(testKey[:5], testIV))
+}
@@ -0,0 +1,81 @@
+package ts
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/container/ts/sampleaes"
+	"github.com/nextpkg/goav/container/ts/table"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMixer_SampleAES(t *testing.T) {
+	at := assert.New(t)
+
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+	d := flv.NewDemuxer()
+
+	e, err := sampleaes.NewEncrypter([]byte("0123456789abcdef"), make([]byte, 16))
+	at.Nil(err)
+	m.EnableSampleAES(e)
+
+	sps := []byte{0x67, 0x4d, 0x00, 0x1e, 0x9a, 0x66, 0x02, 0x80}
+	pps := []byte{0x68, 0xee, 0x3c, 0x80}
+	avcSeqHdr := &packet.Packet{Type: packet.PktVideo, Data: append([]byte{0x17, 0x00, 0x00, 0x00, 0x00}, avcConfig(sps, pps)...)}
+	at.Nil(d.Demux(avcSeqHdr))
+	at.Nil(m.SaveAVCHeader(avcSeqHdr))
+
+	aacSeqHdr := &packet.Packet{Type: packet.PktAudio, Data: []byte{0xaf, 0x00, 0x12, 0x10}}
+	at.Nil(d.Demux(aacSeqHdr))
+	at.Nil(m.SaveAACHeader(aacSeqHdr))
+	at.Nil(m.SetTsHeader())
+
+	audio := make([]byte, 202)
+	audio[0], audio[1] = 0xaf, 0x01
+	for i := 2; i < len(audio); i++ {
+		audio[i] = byte(i)
+	}
+
+	video := avccFrame(0x17, 0, 0x65, 250)
+	for _, p := range []*packet.Packet{
+		{Type: packet.PktVideo, TimeStamp: 0, Data: video},
+		{Type: packet.PktAudio, TimeStamp: 0, Data: audio},
+	} {
+		at.Nil(d.Demux(p))
+		q := *p
+		at.Nil(m.Update(&q, q.TimeStamp, 0))
+		at.Nil(m.Mux(&q))
+	}
+	data := buf.Bytes()
+
+	// PMT中的流类型和描述符
+	dmx := NewDemuxer(bytes.NewReader(data))
+	for err == nil {
+		err = dmx.Read(&packet.Packet{})
+	}
+	at.Equal(io.EOF, err)
+
+	streams := dmx.Programs()[0].Streams
+	at.Len(streams, 2)
+	at.Equal(uint8(table.StreamTypeAvcSampleAES), streams[0].StreamType)
+	at.Equal([]byte{0x0f, 0x04, 'z', 'a', 'v', 'c'}, streams[0].Descriptors)
+	at.Equal(uint8(table.StreamTypeAacSampleAES), streams[1].StreamType)
+	at.Equal([]byte{
+		0x0f, 0x04, 'a', 'a', 'c', 'd',
+		0x05, 0x0e, 'a', 'p', 'a', 'd', 'z', 'a', 'a', 'c', 0x00, 0x00, 0x01, 0x02, 0x12, 0x10,
+	}, streams[1].Descriptors)
+
+	// NALU和AAC帧开始的部分为明文, 之后的加密块不再出现
+	nalu := video[9:]
+	at.True(bytes.Contains(data, nalu[:32]))
+	at.False(bytes.Contains(data, nalu[32:48]))
+	at.True(bytes.Contains(data, nalu[48:80]))
+
+	frame := audio[2:]
+	at.True(bytes.Contains(data, frame[:16]))
+	at.False(bytes.Contains(data, frame[16:32]))
+}
@@ -0,0 +1,60 @@
+package ts
+
+import "time"
+
+// 默认的重复间隔
+const (
+	defaultPsiInterval = 100 * time.Millisecond
+	defaultPcrInterval = 40 * time.Millisecond
+)
+
+// schedule PSI(SDT, PAT, PMT)和PCR的发送计划, 以DTS为时钟(90kHz)
+type schedule struct {
+	psiInterval int64 // 为0时只在 SetTsHeader 时发送
+	pcrInterval int64 // 为0时只在视频关键帧上发送
+	lastPsi     int64 // 上一次发送PSI的时间, 小于0时表示尚未确定
+	started     bool  // 已经发送过PSI
+}
+
+// newSchedule PSI和PCR的发送计划
+func newSchedule(psi, pcr time.Duration) *schedule {
+	s := &schedule{}
+	s.setInterval(psi, pcr)
+
+	return s
+}
+
+// setInterval 设置重复间隔
+func (s *schedule) setInterval(psi, pcr time.Duration) {
+	s.psiInterval = psi.Milliseconds() * avcHZ
+	s.pcrInterval = pcr.Milliseconds() * avcHZ
+}
+
+// tablesSent 记录PSI已发送, dts小于0表示时间未知(以下一个数据包的时间为准)
+func (s *schedule) tablesSent(dts int64) {
+	s.started = true
+	s.lastPsi = dts
+}
+
+// psiDue 判断是否需要重复发送PSI
+func (s *schedule) psiDue(dts int64) bool {
+	if !s.started || s.psiInterval <= 0 {
+		return false
+	}
+
+	if s.lastPsi < 0 || dts < s.lastPsi {
+		s.lastPsi = dts
+		return false
+	}
+
+	return dts-s.lastPsi >= s.psiInterval
+}
+
+// pcrDue 判断是否需要单独发送PCR, lastPcr小于0表示还没有发送过PCR
+func (s *schedule) pcrDue(dts, lastPcr int64) bool {
+	if !s.started || s.pcrInterval <= 0 {
+		return false
+	}
+
+	return lastPcr < 0 || dts-lastPcr >= s.pcrInterval
+}
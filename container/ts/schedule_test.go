@@ -0,0 +1,147 @@
+package ts
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/container/ts/table"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedule(t *testing.T) {
+	at := assert.New(t)
+
+	s := newSchedule(defaultPsiInterval, defaultPcrInterval)
+
+	// 发送PSI之前不重复发送
+	at.False(s.psiDue(0))
+	at.False(s.pcrDue(0, -1))
+
+	// 以SetTsHeader之后的第一个数据包为基准
+	s.tablesSent(-1)
+	at.False(s.psiDue(1000 * avcHZ))
+	at.False(s.psiDue(1099 * avcHZ))
+	at.True(s.psiDue(1100 * avcHZ))
+
+	s.tablesSent(1100 * avcHZ)
+	at.False(s.psiDue(1150 * avcHZ))
+
+	// 时间回退时重新确定基准
+	at.False(s.psiDue(0))
+	at.True(s.psiDue(100 * avcHZ))
+
+	at.True(s.pcrDue(0, -1))
+	at.False(s.pcrDue(39*avcHZ, 0))
+	at.True(s.pcrDue(40*avcHZ, 0))
+
+	// 间隔为0时不重复发送
+	s.setInterval(0, 0)
+	at.False(s.psiDue(10000 * avcHZ))
+	at.False(s.pcrDue(10000*avcHZ, 0))
+}
+
+func TestMixer_Schedule(t *testing.T) {
+	at := assert.New(t)
+
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+	d := flv.NewDemuxer()
+
+	aacSeqHdr := &packet.Packet{Type: packet.PktAudio, Data: []byte{0xaf, 0x00, 0x12, 0x10}}
+	at.Nil(d.Demux(aacSeqHdr))
+	at.Nil(m.SaveAACHeader(aacSeqHdr))
+	at.Nil(m.SetTsHeader())
+
+	audio := make([]byte, 202)
+	audio[0], audio[1] = 0xaf, 0x01
+
+	// 1秒钟的音频(44.1kHz, 每帧约23ms)
+	for ts := uint32(0); ts < 1000; ts += 23 {
+		p := &packet.Packet{Type: packet.PktAudio, TimeStamp: ts, Data: append([]byte(nil), audio...)}
+		at.Nil(d.Demux(p))
+		at.Nil(m.Update(p, ts, 0))
+		at.Nil(m.Mux(p))
+	}
+
+	var pats, pcrs []int64
+	var dts int64
+	data := buf.Bytes()
+	for i := 0; i < len(data); i += tsPacketLen {
+		b := data[i : i+tsPacketLen]
+		pid := uint16(b[1]&0x1f)<<8 | uint16(b[2])
+
+		switch {
+		case pid == patPID:
+			pats = append(pats, dts)
+		case pid == defaultPcrPID:
+			// 只有自适应域, PCR_flag
+			at.Equal(byte(0x20), b[3]&0x30)
+			at.Equal(byte(0x10), b[5]&0x10)
+
+			pcr := int64(b[6])<<25 | int64(b[7])<<17 | int64(b[8])<<9 | int64(b[9])<<1 | int64(b[10])>>7
+			pcrs = append(pcrs, pcr)
+		case pid == defaultAudioPID && b[1]&0x40 != 0:
+			payload := b[4:]
+			if b[3]&0x20 != 0 {
+				payload = payload[1+int(payload[0]):]
+			}
+
+			info, err := table.ParsePes(payload)
+			at.Nil(err)
+			dts = info.DTS
+		}
+	}
+
+	// PSI的间隔不超过100ms加上一帧
+	at.True(len(pats) >= 9, "pats=%d", len(pats))
+	for i := 1; i < len(pats); i++ {
+		at.True(pats[i]-pats[i-1] <= (100+24)*avcHZ, "psi gap=%d", pats[i]-pats[i-1])
+	}
+
+	// PCR的间隔不超过40ms加上一帧
+	at.True(len(pcrs) >= 20, "pcrs=%d", len(pcrs))
+	for i := 1; i < len(pcrs); i++ {
+		at.True(pcrs[i]-pcrs[i-1] <= (40+24)*avcHZ, "pcr gap=%d", pcrs[i]-pcrs[i-1])
+	}
+
+	// 重复的PSI和只有PCR的TS包不影响连续计数器
+	dmx := NewDemuxer(bytes.NewReader(data))
+	for {
+		err := dmx.Read(&packet.Packet{})
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+	}
+	at.Equal(0, dmx.ContinuityErrors())
+	at.Equal(0, dmx.CRCErrors())
+	at.Equal(uint16(defaultPcrPID), dmx.Programs()[0].PcrPID)
+
+	// 关闭重复发送
+	buf.Reset()
+	m.SetInterval(0, 0)
+	p := &packet.Packet{Type: packet.PktAudio, TimeStamp: 20000, Data: append([]byte(nil), audio...)}
+	at.Nil(d.Demux(p))
+	at.Nil(m.Update(p, p.TimeStamp, 0))
+	at.Nil(m.Mux(p))
+
+	// 时间戳跳变超过10秒, 数据包之前只有一个带有 discontinuity_indicator 的PCR
+	at.Equal(3*tsPacketLen, buf.Len())
+	b := buf.Bytes()
+	at.Equal(uint16(defaultPcrPID), uint16(b[1]&0x1f)<<8|uint16(b[2]))
+	at.Equal(byte(0x80), b[5]&0x80)
+	for i := tsPacketLen; i < buf.Len(); i += tsPacketLen {
+		at.Equal(uint16(defaultAudioPID), uint16(b[i+1]&0x1f)<<8|uint16(b[i+2]))
+	}
+
+	// 连续的时间戳不再发送PCR
+	buf.Reset()
+	p = &packet.Packet{Type: packet.PktAudio, TimeStamp: 20023, Data: append([]byte(nil), audio...)}
+	at.Nil(d.Demux(p))
+	at.Nil(m.Update(p, p.TimeStamp, 0))
+	at.Nil(m.Mux(p))
+	at.Equal(2*tsPacketLen, buf.Len())
+}
@@ -0,0 +1,129 @@
+package ts
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+
+	"github.com/nextpkg/goav/amf"
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/container/ts/table"
+	"github.com/nextpkg/goav/packet"
+)
+
+// SCTE-35转换为 onCuePoint 时的名称和命令名
+const (
+	cuePointScte35 = "scte35"
+	cueTypeEvent   = "event"
+)
+
+var spliceCommandNames = map[uint8]string{
+	table.SpliceCommandNull:       "splice_null",
+	table.SpliceCommandInsert:     "splice_insert",
+	table.SpliceCommandTimeSignal: "time_signal",
+}
+
+// EnableScte35 在第一个节目中加入SCTE-35的基本流(流类型0x86)以及"CUEI"注册描述符, 需要在 SetTsHeader 之前调用
+// 节目中已有SCTE-35的基本流时使用该基本流
+func (m *Mixer) EnableScte35() error {
+	if len(m.muxer.programs) == 0 {
+		return errors.New("no program for scte-35")
+	}
+
+	prog := m.muxer.programs[0]
+	if prog.streamByType(table.StreamTypeScte35) == nil {
+		prog.AddStream(defaultScte35PID, table.StreamTypeScte35, packet.PktMetadata)
+	}
+
+	desc := table.NewDescriptor()
+	err := desc.Registration("CUEI")
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(prog.Descriptors, desc.GetBuffer().Bytes()) {
+		prog.Descriptors = append(prog.Descriptors, desc.GetBuffer().Bytes()...)
+	}
+
+	m.cache.types.IsMetadata()
+	return nil
+}
+
+// InsertCue 立即输出SCTE-35的splice_info_section, pts(90kHz, 与 Update 的时间一致)为切换时间;
+// pts小于0时使用si中的时间(splice_insert可以立即切换)
+func (m *Mixer) InsertCue(pts int64, si *table.SpliceInfo) error {
+	if len(m.muxer.programs) == 0 {
+		return errors.New("no program for scte-35")
+	}
+
+	s := m.muxer.programs[0].streamByType(table.StreamTypeScte35)
+	if s == nil {
+		return errors.New("scte-35 is not enabled")
+	}
+
+	if pts >= 0 {
+		// 固定码率时PTS整体延后
+		if m.cbr != nil {
+			pts += m.cbr.delay
+		}
+		si.SetSpliceTime(pts)
+	}
+
+	section, err := si.Encode()
+	if err != nil {
+		return err
+	}
+
+	b, err := m.muxer.psi.packets(s.PID, section)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.output().Write(b)
+	return err
+}
+
+// cuePointPacket 将SCTE-35转换为FLV的 onCuePoint 脚本数据包, 没有切换时间时使用ts(ms)
+// 参数中包含base64编码的原始section
+func cuePointPacket(si *table.SpliceInfo, section []byte, ts uint32) (*packet.Packet, error) {
+	if pts := si.SpliceTime(); pts >= 0 {
+		ts = uint32(pts / avcHZ)
+	}
+
+	params := amf.Object{
+		"command": spliceCommandNames[si.CommandType],
+		"data":    base64.StdEncoding.EncodeToString(section),
+	}
+
+	if s := si.Insert; s != nil {
+		params["eventId"] = float64(s.EventID)
+		params["cancel"] = s.Cancel
+		params["outOfNetwork"] = s.OutOfNetwork
+		if s.Duration > 0 {
+			params["duration"] = float64(s.Duration) / (avcHZ * 1000)
+			params["autoReturn"] = s.AutoReturn
+		}
+	}
+
+	if len(si.Segmentations) > 0 {
+		seg := si.Segmentations[0]
+		params["segmentationEventId"] = float64(seg.EventID)
+		params["segmentationTypeId"] = float64(seg.TypeID)
+		if seg.Duration > 0 {
+			params["duration"] = float64(seg.Duration) / (avcHZ * 1000)
+		}
+	}
+
+	cue := &flv.CuePoint{
+		Name:       cuePointScte35,
+		Time:       float64(ts) / 1000,
+		Type:       cueTypeEvent,
+		Parameters: params,
+	}
+
+	data, err := flv.NewScriptData(flv.OnCuePoint, cue.Object()).Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return newPacket(packet.PktMetadata, ts, data, nil)
+}
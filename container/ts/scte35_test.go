@@ -0,0 +1,91 @@
+package ts
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/container/ts/table"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMixer_InsertCue(t *testing.T) {
+	at := assert.New(t)
+
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+	d := flv.NewDemuxer()
+
+	// 没有开启SCTE-35
+	at.NotNil(m.InsertCue(0, table.NewSpliceInsert(1, true, -1, 0)))
+
+	aacSeqHdr := &packet.Packet{Type: packet.PktAudio, Data: []byte{0xaf, 0x00, 0x12, 0x10}}
+	at.Nil(d.Demux(aacSeqHdr))
+	at.Nil(m.SaveAACHeader(aacSeqHdr))
+	at.Nil(m.EnableScte35())
+	at.Nil(m.EnableScte35())
+	at.Nil(m.SetTsHeader())
+
+	audio := make([]byte, 202)
+	audio[0], audio[1] = 0xaf, 0x01
+	p := &packet.Packet{Type: packet.PktAudio, TimeStamp: 0, Data: audio}
+	at.Nil(d.Demux(p))
+	at.Nil(m.Update(p, 0, 0))
+	at.Nil(m.Mux(p))
+
+	// 2秒后切出30秒, 之后使用time_signal立即切回
+	at.Nil(m.InsertCue(2000*avcHZ, table.NewSpliceInsert(7, true, -1, 30*90000)))
+	at.Nil(m.InsertCue(-1, table.NewTimeSignal(32000*avcHZ, table.Segmentation{EventID: 8, TypeID: 0x35})))
+
+	dmx := NewDemuxer(bytes.NewReader(buf.Bytes()))
+	var cues []*flv.CuePoint
+	var times []uint32
+	for {
+		p := &packet.Packet{}
+		err := dmx.Read(p)
+		if err == io.EOF {
+			break
+		}
+		at.Nil(err)
+
+		if p.Type != packet.PktMetadata {
+			continue
+		}
+
+		sh, ok := p.Header.(packet.ScriptPacketHeader)
+		at.True(ok)
+		at.Equal(flv.OnCuePoint, sh.ScriptName())
+
+		cue, ok := sh.ScriptEvent().(*flv.CuePoint)
+		at.True(ok)
+		cues = append(cues, cue)
+		times = append(times, p.TimeStamp)
+	}
+	at.Equal(0, dmx.CRCErrors())
+	at.Equal(0, dmx.ContinuityErrors())
+
+	// PMT中的SCTE-35基本流和注册描述符
+	programs := dmx.Programs()
+	at.Len(programs, 1)
+	at.Equal([]byte{0x05, 0x04, 'C', 'U', 'E', 'I'}, programs[0].Descriptors)
+	at.Len(programs[0].Streams, 2)
+	at.Equal(table.PmtStream{StreamType: table.StreamTypeScte35, PID: defaultScte35PID, Descriptors: []byte{}}, programs[0].Streams[1])
+	at.Equal(uint16(defaultPcrPID), programs[0].PcrPID)
+
+	at.Len(cues, 2)
+	at.Equal([]uint32{2000, 32000}, times)
+
+	at.Equal("scte35", cues[0].Name)
+	at.Equal(2.0, cues[0].Time)
+	at.Equal("splice_insert", cues[0].Parameters["command"])
+	at.Equal(true, cues[0].Parameters["outOfNetwork"])
+	at.Equal(30.0, cues[0].Parameters["duration"])
+	at.Equal(7.0, cues[0].Parameters["eventId"])
+
+	at.Equal("time_signal", cues[1].Parameters["command"])
+	at.Equal(8.0, cues[1].Parameters["segmentationEventId"])
+	at.Equal(float64(0x35), cues[1].Parameters["segmentationTypeId"])
+	at.NotEmpty(cues[1].Parameters["data"])
+}
@@ -2,18 +2,16 @@ package ts
 
 // 音视频频率
 const (
-	// AACSL AAC的频率
-	aacSL = 1024
-
 	// AVCHZ H264的频率
 	avcHZ = 90
 )
 
 // sync 音视频同步
 type sync struct {
-	frameNum int64 // 累计帧数
-	frameDts int64 // 基准时间
-	syncMs   int64 // ms, 同步 |pts-dts|>syncMs 的"pts"和"dts"
+	sampleNum int64 // 基准时间之后已输出的采样数, 每帧的采样数可以不同(例如Opus)
+	frameDts  int64 // 基准时间
+	syncMs    int64 // ms, 同步 |pts-dts|>syncMs 的"pts"和"dts"
+	rate      int   // 累计的采样数对应的采样率
 }
 
 // newSync 音视频时间戳同步
@@ -27,12 +25,13 @@ func newSync(ms int64) *sync {
 }
 
 // SyncAudioTs 音视频同步，根据视频dts时间调整音频时间
-// dts: 传入音频的解码时间戳, 传出音频的播放时间戳, 单位: ms
-// sampleRate: 音频采样率, 单位: HZ
-func (s *sync) syncAudioTs(dts *int64, sampleRate int) {
-	// 根据采样率, 换算音频相对于视频的时间增量
-	tsIncrement := avcHZ * 1000 * aacSL / sampleRate
-	pts := s.frameDts + s.frameNum*int64(tsIncrement)
+// dts: 传入音频的解码时间戳, 传出音频的播放时间戳, 单位: 90kHz
+func (s *sync) syncAudioTs(dts *int64) {
+	// 根据采样率, 将之前的帧累计的采样数换算为相对于基准时间的增量(以视频为单位)
+	pts := s.frameDts
+	if s.rate > 0 {
+		pts += s.sampleNum * avcHZ * 1000 / int64(s.rate)
+	}
 
 	// 计算出pts和dts之间的差值
 	var ptsDtsGap int64
@@ -44,12 +43,25 @@ func (s *sync) syncAudioTs(dts *int64, sampleRate int) {
 
 	// 差值在阈值内，dts=pts
 	if ptsDtsGap <= s.syncMs {
-		s.frameNum++
 		*dts = pts
 		return
 	}
 
 	// 差值在阈值外，dts=dts
-	s.frameNum = 1
+	s.sampleNum = 0
 	s.frameDts = *dts
 }
+
+// advance 累计当前帧的采样数, 在解析出当前帧的采样数和采样率之后调用
+// 采样率变化时, 以之前累计的时长作为新的基准时间
+func (s *sync) advance(samples, sampleRate int) {
+	if sampleRate != s.rate {
+		if s.rate > 0 {
+			s.frameDts += s.sampleNum * avcHZ * 1000 / int64(s.rate)
+		}
+		s.sampleNum = 0
+		s.rate = sampleRate
+	}
+
+	s.sampleNum += int64(samples)
+}
@@ -13,22 +13,56 @@ func TestNewAvSync(t *testing.T) {
 	var dts int64
 
 	dts = 0
-	s.syncAudioTs(&dts, 44100)
+	s.syncAudioTs(&dts)
+	s.advance(1024, 44100)
 	at.Equal(int64(0), dts)
 
 	dts = 2000
-	s.syncAudioTs(&dts, 44100)
+	s.syncAudioTs(&dts)
+	s.advance(1024, 44100)
 	at.Equal(int64(2089), dts)
 
+	// 按累计的采样数换算, 不累积每帧的取整误差
 	dts = 5000
-	s.syncAudioTs(&dts, 44100)
-	at.Equal(int64(4178), dts)
+	s.syncAudioTs(&dts)
+	s.advance(1024, 44100)
+	at.Equal(int64(4179), dts)
 
 	dts = 10000
-	s.syncAudioTs(&dts, 44100)
+	s.syncAudioTs(&dts)
+	s.advance(1024, 44100)
 	at.Equal(int64(10000), dts)
 
 	dts = 12000
-	s.syncAudioTs(&dts, 44100)
+	s.syncAudioTs(&dts)
+	s.advance(1024, 44100)
 	at.Equal(int64(12089), dts)
 }
+
+func TestSync_VariableSamples(t *testing.T) {
+	at := assert.New(t)
+
+	// Opus每个包的时长可以不同: 20ms, 10ms, 60ms, 20ms
+	s := newSync(10)
+	var ms int64
+	for _, samples := range []int{960, 480, 2880, 960} {
+		dts := ms * avcHZ
+		s.syncAudioTs(&dts)
+		s.advance(samples, 48000)
+		at.Equal(ms*avcHZ, dts)
+		ms += int64(samples / 48)
+	}
+
+	// 采样率变化时, 之前累计的时长不按新的采样率换算: 1152个采样@44.1kHz之后是576个采样@22.05kHz
+	s = newSync(10)
+	dts := int64(0)
+	s.syncAudioTs(&dts)
+	s.advance(1152, 44100)
+	dts = 2300
+	s.syncAudioTs(&dts)
+	at.Equal(int64(2351), dts)
+	s.advance(576, 22050)
+	dts = 4700
+	s.syncAudioTs(&dts)
+	at.Equal(int64(4702), dts)
+}
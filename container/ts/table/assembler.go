@@ -0,0 +1,64 @@
+package table
+
+// SectionAssembler 组装跨越多个TS包的section
+type SectionAssembler struct {
+	buf []byte // 正在组装的数据, 为nil时等待下一个section的开始
+}
+
+// Push 输入一个TS包的负载, 返回已完整的section
+func (sa *SectionAssembler) Push(pusi bool, payload []byte) [][]byte {
+	if !pusi {
+		// section的开头已丢失
+		if sa.buf == nil {
+			return nil
+		}
+
+		sa.buf = append(sa.buf, payload...)
+		return sa.sections()
+	}
+
+	// pointer_field之前是上一个section的结尾
+	if len(payload) == 0 || 1+int(payload[0]) > len(payload) {
+		sa.Reset()
+		return nil
+	}
+
+	ptr := int(payload[0])
+	payload = payload[1:]
+
+	var ret [][]byte
+	if sa.buf != nil {
+		sa.buf = append(sa.buf, payload[:ptr]...)
+		ret = sa.sections()
+	}
+
+	sa.buf = append([]byte(nil), payload[ptr:]...)
+	return append(ret, sa.sections()...)
+}
+
+// Reset 丢弃正在组装的section
+func (sa *SectionAssembler) Reset() {
+	sa.buf = nil
+}
+
+// sections 取出缓存中已完整的section
+func (sa *SectionAssembler) sections() [][]byte {
+	var ret [][]byte
+	for len(sa.buf) > 0 {
+		// table_id为0xff时, 之后都是填充字节
+		if sa.buf[0] == 0xff {
+			sa.Reset()
+			break
+		}
+
+		n := SectionLen(sa.buf)
+		if n == 0 || n > len(sa.buf) {
+			break
+		}
+
+		ret = append(ret, sa.buf[:n:n])
+		sa.buf = sa.buf[n:]
+	}
+
+	return ret
+}
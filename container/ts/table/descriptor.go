@@ -3,6 +3,8 @@ package table
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 )
 
 // Descriptor 描述表
@@ -22,8 +24,15 @@ func (d *Descriptor) GetBuffer() *bytes.Buffer {
 	return d.data
 }
 
-// Service serviceType: pmt表的program pid
+// maxDescriptorLen 描述符的最大长度(descriptor_length为8位)
+const maxDescriptorLen = 0xff
+
+// Service serviceType: pmt表的program pid, 名称的总长度不能超过描述符的最大长度
 func (d *Descriptor) Service(serviceType byte, serviceProviderName string, serviceName string) error {
+	if len(serviceProviderName)+len(serviceName)+3 > maxDescriptorLen {
+		return fmt.Errorf("service descriptor is too long(provider=%d, service=%d)", len(serviceProviderName), len(serviceName))
+	}
+
 	serviceProviderNameLen := byte(len(serviceProviderName))
 
 	serviceNameLen := byte(len(serviceName))
@@ -60,6 +69,10 @@ func (d *Descriptor) Service(serviceType byte, serviceProviderName string, servi
 // NetworkName 网络名称
 func (d *Descriptor) NetworkName(name string) error {
 	nameLen := len(name)
+	if nameLen > maxDescriptorLen {
+		return fmt.Errorf("network name is too long(%d)", nameLen)
+	}
+
 	descriptorLen := byte(nameLen)
 
 	_, err := d.data.Write([]byte{0x40, descriptorLen})
@@ -93,3 +106,111 @@ func (d *Descriptor) CountryAvailability(isAvailable bool, countryCode uint32) e
 
 	return nil
 }
+
+// Registration 注册描述符, formatIdentifier为4个字符, 例如"AC-3", "Opus"
+func (d *Descriptor) Registration(formatIdentifier string) error {
+	if len(formatIdentifier) != 4 {
+		return errors.New("format identifier must be 4 characters")
+	}
+
+	_, err := d.data.Write([]byte{0x05, 4})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.data.WriteString(formatIdentifier)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PrivateDataIndicator 私有数据标识描述符, indicator为4个字符, 例如SAMPLE-AES的"zavc", "aacd"
+func (d *Descriptor) PrivateDataIndicator(indicator string) error {
+	if len(indicator) != 4 {
+		return errors.New("private data indicator must be 4 characters")
+	}
+
+	_, err := d.data.Write([]byte{0x0f, 4})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.data.WriteString(indicator)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AudioSetup SAMPLE-AES的音频配置(audio_setup_information), 使用格式标识为"apad"的注册描述符
+// audioType: 4个字符, 例如AAC为"zaac"; setup: 解码配置, AAC为 AudioSpecificConfig
+func (d *Descriptor) AudioSetup(audioType string, priming uint16, setup []byte) error {
+	if len(audioType) != 4 {
+		return errors.New("audio type must be 4 characters")
+	}
+	if len(setup) > maxDescriptorLen-12 {
+		return fmt.Errorf("audio setup data is too long(%d)", len(setup))
+	}
+
+	// format_identifier, audio_type, priming, version: 1, setup_data_length
+	b := []byte{0x05, byte(12 + len(setup)), 'a', 'p', 'a', 'd'}
+	b = append(b, audioType...)
+	b = append(b, byte(priming>>8), byte(priming), 0x01, byte(len(setup)))
+	b = append(b, setup...)
+
+	_, err := d.data.Write(b)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// OpusAudio Opus音频描述符(扩展描述符), channelConfig: 声道配置, 单声道为1, 立体声为2
+func (d *Descriptor) OpusAudio(channelConfig byte) error {
+	// descriptor_tag: 0x7f(extension_descriptor), descriptor_tag_extension: 0x80(opus)
+	_, err := d.data.Write([]byte{0x7f, 2, 0x80, channelConfig})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ID3元数据的格式标识, 用于 metadata_pointer_descriptor 和 metadata_descriptor
+var id3Format = []byte{
+	0xff, 0xff, 'I', 'D', '3', ' ', // metadata_application_format: 0xffff, metadata_application_format_identifier
+	0xff, 'I', 'D', '3', ' ', // metadata_format: 0xff, metadata_format_identifier
+	0x00, // metadata_service_id
+}
+
+// MetadataPointer 节目中ID3元数据的 metadata_pointer_descriptor, programNumber: 元数据所在的节目
+func (d *Descriptor) MetadataPointer(programNumber uint16) error {
+	// 0x1f: metadata_locator_record_flag=0, MPEG_carriage_flags=0(同一个TS), reserved
+	b := append([]byte{0x25, byte(len(id3Format) + 3)}, id3Format...)
+	b = append(b, 0x1f, byte(programNumber>>8), byte(programNumber))
+
+	_, err := d.data.Write(b)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Metadata ID3元数据基本流的 metadata_descriptor
+func (d *Descriptor) Metadata() error {
+	// 0x0f: decoder_config_flags=0, DSM-CC_flag=0, reserved
+	b := append([]byte{0x26, byte(len(id3Format) + 1)}, id3Format...)
+	b = append(b, 0x0f)
+
+	_, err := d.data.Write(b)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
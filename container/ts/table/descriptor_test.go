@@ -1,6 +1,7 @@
 package table
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -42,3 +43,61 @@ func TestDescriptor_CountryAvailability(t *testing.T) {
 		0x49, 0x4, 0x80, 0x0, 0x0, 0x4, 0xd2,
 	}, sdtDesc.GetBuffer().Bytes())
 }
+
+func TestDescriptor_Opus(t *testing.T) {
+	at := assert.New(t)
+
+	desc := NewDescriptor()
+	at.Nil(desc.Registration("Opus"))
+	at.Nil(desc.OpusAudio(2))
+	at.NotNil(desc.Registration("AC3"))
+
+	at.Equal([]byte{
+		0x05, 0x04, 0x4f, 0x70, 0x75, 0x73, 0x7f, 0x02,
+		0x80, 0x02,
+	}, desc.GetBuffer().Bytes())
+}
+
+func TestDescriptor_TooLong(t *testing.T) {
+	at := assert.New(t)
+
+	desc := NewDescriptor()
+	at.NotNil(desc.Service(1, strings.Repeat("p", 200), strings.Repeat("s", 60)))
+	at.NotNil(desc.NetworkName(strings.Repeat("n", 256)))
+	at.Equal(0, desc.GetBuffer().Len())
+}
+
+func TestDescriptor_Metadata(t *testing.T) {
+	at := assert.New(t)
+
+	desc := NewDescriptor()
+	at.Nil(desc.MetadataPointer(1))
+	at.Equal([]byte{
+		0x25, 0x0f, 0xff, 0xff, 0x49, 0x44, 0x33, 0x20,
+		0xff, 0x49, 0x44, 0x33, 0x20, 0x00, 0x1f, 0x00,
+		0x01,
+	}, desc.GetBuffer().Bytes())
+
+	desc = NewDescriptor()
+	at.Nil(desc.Metadata())
+	at.Equal([]byte{
+		0x26, 0x0d, 0xff, 0xff, 0x49, 0x44, 0x33, 0x20,
+		0xff, 0x49, 0x44, 0x33, 0x20, 0x00, 0x0f,
+	}, desc.GetBuffer().Bytes())
+}
+
+func TestDescriptor_SampleAES(t *testing.T) {
+	at := assert.New(t)
+
+	desc := NewDescriptor()
+	at.Nil(desc.PrivateDataIndicator("aacd"))
+	at.Nil(desc.AudioSetup("zaac", 0, []byte{0x12, 0x10}))
+	at.NotNil(desc.PrivateDataIndicator("avc"))
+	at.NotNil(desc.AudioSetup("aac", 0, nil))
+
+	at.Equal([]byte{
+		0x0f, 0x04, 0x61, 0x61, 0x63, 0x64, 0x05, 0x0e,
+		0x61, 0x70, 0x61, 0x64, 0x7a, 0x61, 0x61, 0x63,
+		0x00, 0x00, 0x01, 0x02, 0x12, 0x10,
+	}, desc.GetBuffer().Bytes())
+}
@@ -1,23 +1,79 @@
 package table
 
 // Pat TS的Pat表
+//
+// Deprecated: 使用 PatSection.Bytes 生成PAT
 type Pat struct {
 	TsHeader  []byte
 	PatHeader []byte
 }
 
-// NewPat 新建Pat表
+// NewPat 新建Pat表, PatHeader 为不含CRC32的section
+//
+// Deprecated: 使用 PatSection.Bytes 生成PAT
 func NewPat() *Pat {
+	/*
+		program number: 0x1
+		program mapping table pid: 0x1001
+	*/
+	pat := &PatSection{
+		TransportStreamID: 0x1,
+		Programs:          []PatProgram{{Number: 0x1, PID: 0x1001}},
+	}
+	section := pat.Bytes()
+
 	return &Pat{
 		/*
 			组成: 4字节固定头 + 1字节指针域
 			pid: 0x0000
 		*/
-		TsHeader: []byte{0x47, 0x40, 0x00, 0x10, 0x00},
-		/*
-			program number: 0x1
-			program mapping table pid: 0x1001
-		*/
-		PatHeader: []byte{0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x01},
+		TsHeader:  []byte{0x47, 0x40, 0x00, 0x10, 0x00},
+		PatHeader: section[:len(section)-crcLen],
+	}
+}
+
+// PatProgram PAT中的节目
+type PatProgram struct {
+	Number uint16 // 节目号, 0表示网络信息表(NIT)
+	PID    uint16 // PMT(或者NIT)的PID
+}
+
+// PatSection PAT, 用于解析和生成
+type PatSection struct {
+	TransportStreamID uint16
+	Version           uint8
+	Programs          []PatProgram
+}
+
+// ParsePat 解析PAT(从table_id开始, 不校验CRC32)
+func ParsePat(b []byte) (*PatSection, error) {
+	id, version, body, err := parseSection(b, TablePat)
+	if err != nil {
+		return nil, err
+	}
+
+	pat := &PatSection{
+		TransportStreamID: id,
+		Version:           version,
+	}
+
+	// 每个节目4字节: program_number(16), reserved(3), PID(13)
+	for ; len(body) >= 4; body = body[4:] {
+		pat.Programs = append(pat.Programs, PatProgram{
+			Number: uint16(body[0])<<8 | uint16(body[1]),
+			PID:    uint16(body[2]&0x1f)<<8 | uint16(body[3]),
+		})
 	}
+
+	return pat, nil
+}
+
+// Bytes 生成PAT的section(含CRC32)
+func (pat *PatSection) Bytes() []byte {
+	body := make([]byte, 0, 4*len(pat.Programs))
+	for _, prog := range pat.Programs {
+		body = append(body, byte(prog.Number>>8), byte(prog.Number), 0xe0|byte(prog.PID>>8)&0x1f, byte(prog.PID))
+	}
+
+	return BuildSection(TablePat, pat.TransportStreamID, body)
 }
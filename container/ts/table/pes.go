@@ -1,6 +1,8 @@
 package table
 
 import (
+	"errors"
+
 	"github.com/nextpkg/goav/packet"
 )
 
@@ -9,6 +11,9 @@ const (
 	audioSID = 0xc0
 )
 
+// PrivateStream1 private_stream_1的stream_id, 用于AC-3, E-AC-3, Opus和ID3元数据等
+const PrivateStream1 = 0xbd
+
 // Pes Ts的Pes表
 type Pes struct {
 	TsHeader  []byte
@@ -33,22 +38,28 @@ func (pe *Pes) GeneratePesHeader(mt int, mediaDataLen int, pts, dts int64) int {
 		pe.PesHeader[3] = videoSID
 	case packet.PktAudio:
 		pe.PesHeader[3] = audioSID
+	case packet.PktMetadata:
+		// ID3元数据需要设置 data_alignment_indicator
+		pe.PesHeader[3] = PrivateStream1
+		pe.PesHeader[6] |= 0x04
 	default:
 		return 0
 	}
 
-	// pts
-	ptsB5 := pe.encodeTs(pe.PesHeader[7], pts)
-	copy(pe.PesHeader[9:], ptsB5[:])
+	// pts和dts相同时只写pts; 同时存在时pts的前缀为'0011', dts的前缀为'0001'
 	if mt == packet.PktVideo && pts != dts {
 		pe.PesHeader[7] |= 0x40
 		pe.PesHeader[8] += 0x05
 
 		// dts
-		dtsB5 := pe.encodeTs(pe.PesHeader[7], dts)
+		dtsB5 := pe.encodeTs(0x40, dts)
 		copy(pe.PesHeader[14:], dtsB5[:])
 	}
 
+	// pts
+	ptsB5 := pe.encodeTs(pe.PesHeader[7], pts)
+	copy(pe.PesHeader[9:], ptsB5[:])
+
 	// pes数据包的长度
 	pesDataLen := int(pe.PesHeader[8])
 	size := 3 + pesDataLen + mediaDataLen
@@ -62,12 +73,16 @@ func (pe *Pes) GeneratePesHeader(mt int, mediaDataLen int, pts, dts int64) int {
 	return 6 + 3 + pesDataLen
 }
 
-// 33位时间戳编码成40位时间戳
+// 时间戳和PCR的回绕: PTS, DTS和PCR base为33位(90kHz), PCR extension为27MHz下的余数(0~299)
+const (
+	TsWrap  = 1 << 33
+	PcrWrap = TsWrap * 300
+)
+
+// 33位时间戳编码成40位时间戳, 超出33位的时间戳按2^33取模(负数同样回绕)
 func (pe *Pes) encodeTs(flag byte, ts int64) [5]byte {
 	var val uint16
-	if ts > 0x1ffffffff {
-		ts -= 0x1ffffffff
-	}
+	ts &= TsWrap - 1
 
 	var u33 [5]byte
 
@@ -100,12 +115,111 @@ func (pe *Pes) WriteStuff(src []byte, remainBytes byte) {
 	}
 }
 
-// WritePcr 向buf中写入pcr(buf至少应有6字节长度)
+// WritePcr 向buf中写入pcr(90kHz, extension为0), buf至少应有6字节长度
 func (pe *Pes) WritePcr(buf []byte, pcr int64) {
-	buf[0] = byte(pcr >> 25)
-	buf[1] = byte(pcr >> 17)
-	buf[2] = byte(pcr >> 9)
-	buf[3] = byte(pcr >> 1)
-	buf[4] = byte(((pcr & 0x1) << 7) | 0x7e)
-	buf[5] = 0x00
+	pe.WritePcr27M(buf, pcr*300)
+}
+
+// WritePcr27M 向buf中写入27MHz的pcr, 按 PcrWrap 取模后分为33位的base(pcr/300)和9位的extension(pcr%300)
+// buf至少应有6字节长度
+func (pe *Pes) WritePcr27M(buf []byte, pcr int64) {
+	pcr %= PcrWrap
+	if pcr < 0 {
+		pcr += PcrWrap
+	}
+
+	base, ext := pcr/300, pcr%300
+	buf[0] = byte(base >> 25)
+	buf[1] = byte(base >> 17)
+	buf[2] = byte(base >> 9)
+	buf[3] = byte(base >> 1)
+	buf[4] = byte((base&0x1)<<7 | 0x7e | ext>>8)
+	buf[5] = byte(ext)
+}
+
+// 没有可选头的PES流
+const (
+	programStreamMap = 0xbc
+	paddingStream    = 0xbe
+	privateStream2   = 0xbf
+	ecmStream        = 0xf0
+	emmStream        = 0xf1
+	dsmccStream      = 0xf2
+	h2221TypeEStream = 0xf8
+	programStreamDir = 0xff
+)
+
+const (
+	pesStartCodePrefix = 0x000001
+	pesFixedHdrLen     = 6 // packet_start_code_prefix, stream_id, PES_packet_length
+	pesOptionalHdrLen  = 3 // 标识位和PES_header_data_length
+	pesTimestampLen    = 5
+)
+
+// PesInfo 解析后的PES头
+type PesInfo struct {
+	StreamID  uint8
+	PacketLen int   // PES_packet_length, 0表示长度不限(一般是视频)
+	HeaderLen int   // PES头的总长度, 之后是负载
+	HasPTS    bool  // 是否有PTS
+	PTS       int64 // 90kHz
+	DTS       int64 // 90kHz, 没有DTS时等于PTS
+}
+
+// ParsePes 解析PES头(b从packet_start_code_prefix开始)
+func ParsePes(b []byte) (*PesInfo, error) {
+	if len(b) < pesFixedHdrLen {
+		return nil, errors.New("incomplete pes header")
+	}
+
+	if int(b[0])<<16|int(b[1])<<8|int(b[2]) != pesStartCodePrefix {
+		return nil, errors.New("invalid pes start code")
+	}
+
+	info := &PesInfo{
+		StreamID:  b[3],
+		PacketLen: int(b[4])<<8 | int(b[5]),
+		HeaderLen: pesFixedHdrLen,
+	}
+
+	switch info.StreamID {
+	case programStreamMap, paddingStream, privateStream2, ecmStream, emmStream, programStreamDir, dsmccStream, h2221TypeEStream:
+		return info, nil
+	}
+
+	if len(b) < pesFixedHdrLen+pesOptionalHdrLen {
+		return nil, errors.New("incomplete pes optional header")
+	}
+
+	// [7]PTS_DTS_flags, [8]PES_header_data_length
+	flags := b[7] >> 6
+	info.HeaderLen += pesOptionalHdrLen + int(b[8])
+	if len(b) < info.HeaderLen {
+		return nil, errors.New("incomplete pes optional fields")
+	}
+
+	opt := b[pesFixedHdrLen+pesOptionalHdrLen : info.HeaderLen]
+	if flags&0x2 != 0 {
+		if len(opt) < pesTimestampLen {
+			return nil, errors.New("incomplete pes pts")
+		}
+
+		info.HasPTS = true
+		info.PTS = decodeTs(opt)
+		info.DTS = info.PTS
+	}
+	if flags == 0x3 {
+		if len(opt) < 2*pesTimestampLen {
+			return nil, errors.New("incomplete pes dts")
+		}
+
+		info.DTS = decodeTs(opt[pesTimestampLen:])
+	}
+
+	return info, nil
+}
+
+// 40位时间戳解码成33位时间戳(encodeTs的逆运算)
+func decodeTs(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
 }
@@ -19,4 +19,73 @@ func TestPes_GeneratePesHeader(t *testing.T) {
 		0x0, 0x0, 0x0,
 	}, pes.PesHeader)
 	at.Equal([]byte{0x47, 0x0, 0x0, 0x10}, pes.TsHeader)
+
+	// ID3元数据: private_stream_1, data_alignment_indicator
+	pes = NewPes()
+	at.Equal(14, pes.GeneratePesHeader(packet.PktMetadata, 32, 90000, 90000))
+	at.Equal([]byte{0xbd, 0x0, 0x28, 0x84}, pes.PesHeader[3:7])
+}
+
+func TestParsePes(t *testing.T) {
+	at := assert.New(t)
+
+	pes := NewPes()
+	n := pes.GeneratePesHeader(packet.PktVideo, 1024, 0x1ffffff00, 0x123456789)
+
+	info, err := ParsePes(pes.PesHeader[:n])
+	at.Nil(err)
+	at.Equal(uint8(0xe0), info.StreamID)
+	at.Equal(n, info.HeaderLen)
+	at.Equal(n-6+1024, info.PacketLen)
+	at.True(info.HasPTS)
+	at.Equal(int64(0x1ffffff00), info.PTS)
+	at.Equal(int64(0x123456789), info.DTS)
+
+	// PTS和DTS同时存在时的前缀
+	at.Equal(byte(0x3), pes.PesHeader[9]>>4)
+	at.Equal(byte(0x1), pes.PesHeader[14]>>4)
+
+	// 不完整的PES头
+	_, err = ParsePes(pes.PesHeader[:n-1])
+	at.NotNil(err)
+}
+
+func TestPes_Wrap(t *testing.T) {
+	at := assert.New(t)
+
+	// 超过33位和负数的时间戳按2^33取模
+	pes := NewPes()
+	n := pes.GeneratePesHeader(packet.PktVideo, 1024, TsWrap+3600, -3600)
+	info, err := ParsePes(pes.PesHeader[:n])
+	at.Nil(err)
+	at.Equal(int64(3600), info.PTS)
+	at.Equal(int64(TsWrap-3600), info.DTS)
+
+	// 27MHz的PCR: base和extension
+	readPcr := func(b []byte) (int64, int64) {
+		base := int64(b[0])<<25 | int64(b[1])<<17 | int64(b[2])<<9 | int64(b[3])<<1 | int64(b[4])>>7
+		return base, int64(b[4]&0x01)<<8 | int64(b[5])
+	}
+
+	buf := make([]byte, 6)
+	pes.WritePcr27M(buf, 0x1ffffffff*300+299)
+	base, ext := readPcr(buf)
+	at.Equal(int64(0x1ffffffff), base)
+	at.Equal(int64(299), ext)
+	at.Equal(byte(0x7e), buf[4]&0x7e)
+
+	pes.WritePcr27M(buf, PcrWrap+90000*300+1)
+	base, ext = readPcr(buf)
+	at.Equal(int64(90000), base)
+	at.Equal(int64(1), ext)
+
+	pes.WritePcr27M(buf, -300)
+	base, ext = readPcr(buf)
+	at.Equal(int64(TsWrap-1), base)
+	at.Equal(int64(0), ext)
+
+	pes.WritePcr(buf, TsWrap+1)
+	base, ext = readPcr(buf)
+	at.Equal(int64(1), base)
+	at.Equal(int64(0), ext)
 }
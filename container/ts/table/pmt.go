@@ -1,22 +1,124 @@
 package table
 
+import (
+	"bytes"
+	"errors"
+)
+
 // Pmt Ts的Pmt表
+//
+// Deprecated: 使用 PmtSection.Bytes 生成PMT
 type Pmt struct {
 	TsHeader  []byte
 	PmtHeader []byte
 }
 
-// NewPmt 新建Pmt表
+// NewPmt 新建Pmt表, PmtHeader 为不含基本流和CRC32的section, section_length需要按基本流重新填写
+//
+// Deprecated: 使用 PmtSection.Bytes 生成PMT
 func NewPmt() *Pmt {
+	/*
+		program number: 0x1
+		PCR_PID: 0x100
+	*/
+	pmt := &PmtSection{
+		ProgramNumber: 0x1,
+		PcrPID:        0x100,
+	}
+	section := pmt.Bytes()
+
 	return &Pmt{
 		/*
 			组成: 4字节固定头 + 1字节指针域
 			pid: 0x1001
 		*/
-		TsHeader: []byte{0x47, 0x50, 0x01, 0x10, 0x00},
-		/*
-			program number: 0x1
-		*/
-		PmtHeader: []byte{0x02, 0xb0, 0xff, 0x00, 0x01, 0xc1, 0x00, 0x00, 0xe1, 0x00, 0xf0, 0x00},
+		TsHeader:  []byte{0x47, 0x50, 0x01, 0x10, 0x00},
+		PmtHeader: section[:len(section)-crcLen],
+	}
+}
+
+// PmtStream PMT中的基本流
+type PmtStream struct {
+	StreamType  uint8
+	PID         uint16
+	Descriptors []byte // ES_info中的描述符
+}
+
+// PmtSection PMT, 用于解析和生成
+type PmtSection struct {
+	ProgramNumber uint16
+	Version       uint8
+	PcrPID        uint16
+	Descriptors   []byte // program_info中的描述符
+	Streams       []PmtStream
+}
+
+// ParsePmt 解析PMT(从table_id开始, 不校验CRC32)
+func ParsePmt(b []byte) (*PmtSection, error) {
+	id, version, body, err := parseSection(b, TablePmt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) < 4 {
+		return nil, errors.New("incomplete pmt header")
+	}
+
+	pmt := &PmtSection{
+		ProgramNumber: id,
+		Version:       version,
+		PcrPID:        uint16(body[0]&0x1f)<<8 | uint16(body[1]),
 	}
+
+	// 节目描述符
+	n := int(body[2]&0x0f)<<8 | int(body[3])
+	body = body[4:]
+	if len(body) < n {
+		return nil, errors.New("incomplete pmt program info")
+	}
+	pmt.Descriptors = body[:n]
+	body = body[n:]
+
+	// 每个基本流: stream_type(8), reserved(3), PID(13), reserved(4), ES_info_length(12), 描述符
+	for len(body) >= 5 {
+		n = int(body[3]&0x0f)<<8 | int(body[4])
+		if len(body) < 5+n {
+			return nil, errors.New("incomplete pmt es info")
+		}
+
+		pmt.Streams = append(pmt.Streams, PmtStream{
+			StreamType:  body[0],
+			PID:         uint16(body[1]&0x1f)<<8 | uint16(body[2]),
+			Descriptors: body[5 : 5+n],
+		})
+		body = body[5+n:]
+	}
+
+	return pmt, nil
+}
+
+// Bytes 生成PMT中基本流的一项
+func (s *PmtStream) Bytes() []byte {
+	// stream_type(8), reserved(3), elementary_PID(13), reserved(4), ES_info_length(12)
+	b := make([]byte, 0, 5+len(s.Descriptors))
+	b = append(b, s.StreamType, 0xe0|byte(s.PID>>8)&0x1f, byte(s.PID))
+	b = append(b, 0xf0|byte(len(s.Descriptors)>>8)&0x0f, byte(len(s.Descriptors)))
+
+	return append(b, s.Descriptors...)
+}
+
+// Bytes 生成PMT的section(含CRC32)
+func (pmt *PmtSection) Bytes() []byte {
+	var body bytes.Buffer
+
+	// reserved(3), PCR_PID(13), reserved(4), program_info_length(12)
+	body.Write([]byte{0xe0 | byte(pmt.PcrPID>>8)&0x1f, byte(pmt.PcrPID)})
+	body.Write([]byte{0xf0 | byte(len(pmt.Descriptors)>>8)&0x0f, byte(len(pmt.Descriptors))})
+	body.Write(pmt.Descriptors)
+
+	for i := range pmt.Streams {
+		body.Write(pmt.Streams[i].Bytes())
+	}
+
+	return BuildSection(TablePmt, pmt.ProgramNumber, body.Bytes())
 }
@@ -1,23 +1,60 @@
 package table
 
+// Stream type
+const (
+	StreamTypeMpeg1Audio  = 0x03 // ISO/IEC 11172-3 Audio
+	StreamTypeMpeg2Audio  = 0x04 // ISO/IEC 13818-3 Audio
+	StreamTypePrivateData = 0x06 // ITU-T Rec. H.222.0 | ISO/IEC 13818-1 PES packets containing private data(Opus)
+	StreamTypeAac         = 0x0f // ISO/IEC 13818-7 Audio with ADTS transport syntax
+	StreamTypeMetadata    = 0x15 // Metadata carried in PES packets(ID3)
+	StreamTypeAvc         = 0x1b // ITU-T Rec. H.264 | ISO/IEC 14496-10 Video
+	StreamTypeHevc        = 0x24 // ITU-T Rec. H.265 | ISO/IEC 23008-2 Video
+	StreamTypeAc3         = 0x81 // ATSC A/52 AC-3 Audio
+	StreamTypeScte35      = 0x86 // SCTE-35 splice_info_section
+	StreamTypeEac3        = 0x87 // ATSC A/52 Annex G E-AC-3 Audio
+	StreamTypeG711A       = 0x90 // G.711 A-law(GB28181)
+	StreamTypeG711U       = 0x91 // G.711 µ-law(GB28181)
+
+	StreamTypeAacSampleAES = 0xcf // SAMPLE-AES加密的ADTS AAC(Apple HLS)
+	StreamTypeAvcSampleAES = 0xdb // SAMPLE-AES加密的H264(Apple HLS)
+)
+
+// IsPrivateStream 判断流类型的PES是否使用 private_stream_1
+func IsPrivateStream(streamType uint8) bool {
+	switch streamType {
+	case StreamTypePrivateData, StreamTypeMetadata, StreamTypeAc3, StreamTypeEac3:
+		return true
+	}
+
+	return false
+}
+
 // Program Ts的节目表
+//
+// Deprecated: 使用 PmtSection.Bytes 生成PMT
 type Program struct {
 	Avc []byte
 	Aac []byte
 }
 
 // NewProgram 新建节目表
+//
+// Deprecated: 使用 PmtSection.Bytes 生成PMT
 func NewProgram() *Program {
+	/*
+		stream type: h.264(AVC video stream as defined in ITU-T Rec. H.264 | ISO/IEC 14496-10 Video (h.264))
+		stream type pid: 0x100
+	*/
+	avc := &PmtStream{StreamType: StreamTypeAvc, PID: 0x100}
+
+	/*
+		stream type: aac(ISO/IEC 13818-7 Audio with ADTS transport syntax)
+		stream type pid: 0x101
+	*/
+	aac := &PmtStream{StreamType: StreamTypeAac, PID: 0x101}
+
 	return &Program{
-		/*
-			stream type: h.264(AVC video stream as defined in ITU-T Rec. H.264 | ISO/IEC 14496-10 Video (h.264))
-			stream type pid: 0x100
-		*/
-		Avc: []byte{0x1b, 0xe1, 0x00, 0xf0, 0x00},
-		/*
-			stream type: aac(ISO/IEC 13818-7 Audio with ADTS transport syntax)
-			stream type pid: 0x101
-		*/
-		Aac: []byte{0x0f, 0xe1, 0x01, 0xf0, 0x00},
+		Avc: avc.Bytes(),
+		Aac: aac.Bytes(),
 	}
 }
@@ -0,0 +1,460 @@
+package table
+
+import (
+	"errors"
+	"fmt"
+)
+
+// splice_command_type
+const (
+	SpliceCommandNull       = 0x00
+	SpliceCommandInsert     = 0x05
+	SpliceCommandTimeSignal = 0x06
+)
+
+// SCTE-35中的固定值
+const (
+	scte35Identifier          = 0x43554549 // "CUEI"
+	segmentationDescriptorTag = 0x02
+	defaultTier               = 0x0fff
+	maxTimestamp              = 1<<33 - 1
+)
+
+// SpliceInsert splice_insert(), 只支持节目级切换(program_splice_flag为1)
+type SpliceInsert struct {
+	EventID        uint32
+	Cancel         bool  // splice_event_cancel_indicator, 为true时没有其它字段
+	OutOfNetwork   bool  // true: 切出到广告, false: 切回节目
+	Immediate      bool  // splice_immediate_flag, 为true时没有切换时间
+	PTS            int64 // 切换时间(90kHz), 小于0表示未指定
+	Duration       int64 // break_duration(90kHz), 为0时不带
+	AutoReturn     bool
+	ProgramID      uint16 // unique_program_id
+	AvailNum       uint8
+	AvailsExpected uint8
+}
+
+// Segmentation segmentation_descriptor(), 只支持节目级分段(program_segmentation_flag为1)
+type Segmentation struct {
+	EventID  uint32
+	Cancel   bool  // segmentation_event_cancel_indicator, 为true时没有其它字段
+	Duration int64 // segmentation_duration(90kHz), 为0时不带
+	UpidType uint8
+	Upid     []byte
+	TypeID   uint8 // segmentation_type_id, 例如0x34: Provider Placement Opportunity Start
+	Num      uint8 // segment_num
+	Expected uint8 // segments_expected
+}
+
+// SpliceInfo splice_info_section(SCTE-35), 用于解析和生成, 不支持加密
+type SpliceInfo struct {
+	PtsAdjustment int64  // 加到所有切换时间上的偏移(90kHz)
+	Tier          uint16 // 12位, 默认0xfff
+	CommandType   uint8
+	Insert        *SpliceInsert  // CommandType为 SpliceCommandInsert 时有效
+	PTS           int64          // CommandType为 SpliceCommandTimeSignal 时的时间(90kHz), 小于0表示未指定
+	Segmentations []Segmentation // 描述符中的segmentation_descriptor
+}
+
+// NewSpliceInsert 生成splice_insert, pts小于0时立即切换; duration为0时不带break_duration
+func NewSpliceInsert(eventID uint32, outOfNetwork bool, pts, duration int64) *SpliceInfo {
+	return &SpliceInfo{
+		Tier:        defaultTier,
+		CommandType: SpliceCommandInsert,
+		PTS:         -1,
+		Insert: &SpliceInsert{
+			EventID:      eventID,
+			OutOfNetwork: outOfNetwork,
+			Immediate:    pts < 0,
+			PTS:          pts,
+			Duration:     duration,
+			AutoReturn:   duration > 0,
+		},
+	}
+}
+
+// NewTimeSignal 生成time_signal, 通常与segmentation_descriptor一起使用
+func NewTimeSignal(pts int64, segmentations ...Segmentation) *SpliceInfo {
+	return &SpliceInfo{
+		Tier:          defaultTier,
+		CommandType:   SpliceCommandTimeSignal,
+		PTS:           pts,
+		Segmentations: segmentations,
+	}
+}
+
+// SpliceTime 返回切换时间(已加上pts_adjustment), 没有时间时返回-1
+func (si *SpliceInfo) SpliceTime() int64 {
+	pts := si.PTS
+	if si.CommandType == SpliceCommandInsert && si.Insert != nil && !si.Insert.Immediate && !si.Insert.Cancel {
+		pts = si.Insert.PTS
+	}
+	if pts < 0 {
+		return -1
+	}
+
+	return (pts + si.PtsAdjustment) & maxTimestamp
+}
+
+// SetSpliceTime 设置切换时间(splice_insert或者time_signal)
+func (si *SpliceInfo) SetSpliceTime(pts int64) {
+	if si.CommandType == SpliceCommandInsert && si.Insert != nil {
+		si.Insert.Immediate = false
+		si.Insert.PTS = pts
+		return
+	}
+
+	si.PTS = pts
+}
+
+// Encode 生成splice_info_section(从table_id开始, 包含CRC32)
+func (si *SpliceInfo) Encode() ([]byte, error) {
+	var cmd []byte
+	switch si.CommandType {
+	case SpliceCommandNull:
+	case SpliceCommandInsert:
+		if si.Insert == nil {
+			return nil, errors.New("splice insert command without splice_insert")
+		}
+		cmd = si.Insert.encode()
+	case SpliceCommandTimeSignal:
+		cmd = encodeSpliceTime(si.PTS)
+	default:
+		return nil, fmt.Errorf("unsupported splice command type %#x", si.CommandType)
+	}
+
+	var desc []byte
+	for _, seg := range si.Segmentations {
+		b, err := seg.encode()
+		if err != nil {
+			return nil, err
+		}
+		desc = append(desc, b...)
+	}
+
+	// protocol_version(8), encrypted_packet(1), encryption_algorithm(6), pts_adjustment(33), cw_index(8),
+	// tier(12), splice_command_length(12), splice_command_type(8), 命令, descriptor_loop_length(16), 描述符
+	n := 13 + len(cmd) + len(desc) + crcLen
+	if 3+n > MaxSectionLen {
+		return nil, fmt.Errorf("splice info section is too long(%d)", 3+n)
+	}
+
+	adj := si.PtsAdjustment & maxTimestamp
+	b := make([]byte, 0, 3+n)
+	// section_syntax_indicator(1), private_indicator(1), sap_type(2): 3(未指定), section_length(12)
+	b = append(b, TableSpliceInfo, 0x30|byte(n>>8)&0x0f, byte(n))
+	b = append(b, 0x00, byte(adj>>32)&0x01, byte(adj>>24), byte(adj>>16), byte(adj>>8), byte(adj), 0xff)
+	b = append(b, byte(si.Tier>>4), byte(si.Tier<<4)|byte(len(cmd)>>8)&0x0f, byte(len(cmd)), si.CommandType)
+	b = append(b, cmd...)
+	b = append(b, byte(len(desc)>>8), byte(len(desc)))
+	b = append(b, desc...)
+
+	crc := Crc32(b)
+	return append(b, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc)), nil
+}
+
+// encode 生成splice_insert()
+func (s *SpliceInsert) encode() []byte {
+	b := []byte{byte(s.EventID >> 24), byte(s.EventID >> 16), byte(s.EventID >> 8), byte(s.EventID), 0x7f}
+	if s.Cancel {
+		b[4] |= 0x80
+		return b
+	}
+
+	// out_of_network_indicator(1), program_splice_flag(1), duration_flag(1), splice_immediate_flag(1), reserved(4)
+	flags := byte(0x4f)
+	if s.OutOfNetwork {
+		flags |= 0x80
+	}
+	if s.Duration > 0 {
+		flags |= 0x20
+	}
+	if s.Immediate {
+		flags |= 0x10
+	}
+	b = append(b, flags)
+
+	if !s.Immediate {
+		b = append(b, encodeSpliceTime(s.PTS)...)
+	}
+
+	// break_duration(): auto_return(1), reserved(6), duration(33)
+	if s.Duration > 0 {
+		d := s.Duration & maxTimestamp
+		flag := byte(0x7e)
+		if s.AutoReturn {
+			flag |= 0x80
+		}
+		b = append(b, flag|byte(d>>32)&0x01, byte(d>>24), byte(d>>16), byte(d>>8), byte(d))
+	}
+
+	return append(b, byte(s.ProgramID>>8), byte(s.ProgramID), s.AvailNum, s.AvailsExpected)
+}
+
+// encodeSpliceTime 生成splice_time(), pts小于0时time_specified_flag为0
+func encodeSpliceTime(pts int64) []byte {
+	if pts < 0 {
+		return []byte{0x7f}
+	}
+
+	pts &= maxTimestamp
+	return []byte{0xfe | byte(pts>>32)&0x01, byte(pts >> 24), byte(pts >> 16), byte(pts >> 8), byte(pts)}
+}
+
+// encode 生成segmentation_descriptor()
+func (seg *Segmentation) encode() ([]byte, error) {
+	id := uint32(scte35Identifier)
+	b := []byte{segmentationDescriptorTag, 0, byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id),
+		byte(seg.EventID >> 24), byte(seg.EventID >> 16), byte(seg.EventID >> 8), byte(seg.EventID), 0x7f}
+
+	if seg.Cancel {
+		b[10] |= 0x80
+	} else {
+		// program_segmentation_flag(1), segmentation_duration_flag(1), delivery_not_restricted_flag(1), reserved(5)
+		flags := byte(0xbf)
+		if seg.Duration > 0 {
+			flags |= 0x40
+		}
+		b = append(b, flags)
+
+		if seg.Duration > 0 {
+			d := seg.Duration
+			b = append(b, byte(d>>32), byte(d>>24), byte(d>>16), byte(d>>8), byte(d))
+		}
+
+		if len(seg.Upid) > 0xff {
+			return nil, fmt.Errorf("segmentation upid is too long(%d)", len(seg.Upid))
+		}
+		b = append(b, seg.UpidType, byte(len(seg.Upid)))
+		b = append(b, seg.Upid...)
+		b = append(b, seg.TypeID, seg.Num, seg.Expected)
+	}
+
+	if len(b)-2 > 0xff {
+		return nil, fmt.Errorf("segmentation descriptor is too long(%d)", len(b)-2)
+	}
+	b[1] = byte(len(b) - 2)
+
+	return b, nil
+}
+
+// ParseSpliceInfo 解析splice_info_section(从table_id开始, 不校验CRC32), 不支持加密的section
+func ParseSpliceInfo(b []byte) (*SpliceInfo, error) {
+	if len(b) < 3 || b[0] != TableSpliceInfo {
+		return nil, errors.New("not a splice info section")
+	}
+
+	n := SectionLen(b)
+	if n < 3+13+crcLen || len(b) < n {
+		return nil, fmt.Errorf("incomplete splice info section(len=%d)", len(b))
+	}
+	body := b[3 : n-crcLen]
+
+	if body[1]&0x80 != 0 {
+		return nil, errors.New("encrypted splice info section is not supported")
+	}
+
+	si := &SpliceInfo{
+		PtsAdjustment: int64(body[1]&0x01)<<32 | int64(body[2])<<24 | int64(body[3])<<16 | int64(body[4])<<8 | int64(body[5]),
+		Tier:          uint16(body[7])<<4 | uint16(body[8]>>4),
+		CommandType:   body[10],
+		PTS:           -1,
+	}
+
+	cmdLen := int(body[8]&0x0f)<<8 | int(body[9])
+	body = body[11:]
+	if len(body) < cmdLen {
+		return nil, errors.New("incomplete splice command")
+	}
+
+	var err error
+	cmd := body[:cmdLen]
+	switch si.CommandType {
+	case SpliceCommandInsert:
+		si.Insert, err = parseSpliceInsert(cmd)
+	case SpliceCommandTimeSignal:
+		si.PTS, _, err = parseSpliceTime(cmd)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// descriptor_loop_length(16), 描述符
+	body = body[cmdLen:]
+	if len(body) < 2 {
+		return nil, errors.New("incomplete splice descriptor loop")
+	}
+	n = int(body[0])<<8 | int(body[1])
+	if len(body) < 2+n {
+		return nil, errors.New("incomplete splice descriptors")
+	}
+
+	for desc := body[2 : 2+n]; len(desc) >= 2; {
+		tag, l := desc[0], int(desc[1])
+		if len(desc) < 2+l {
+			return nil, errors.New("incomplete splice descriptor")
+		}
+
+		if tag == segmentationDescriptorTag {
+			seg, err := parseSegmentation(desc[2 : 2+l])
+			if err != nil {
+				return nil, err
+			}
+			si.Segmentations = append(si.Segmentations, *seg)
+		}
+		desc = desc[2+l:]
+	}
+
+	return si, nil
+}
+
+// parseSpliceInsert 解析splice_insert(), 分量级切换时使用第一个分量的时间
+func parseSpliceInsert(b []byte) (*SpliceInsert, error) {
+	if len(b) < 5 {
+		return nil, errors.New("incomplete splice insert")
+	}
+
+	s := &SpliceInsert{
+		EventID: uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]),
+		Cancel:  b[4]&0x80 != 0,
+		PTS:     -1,
+	}
+	if s.Cancel {
+		return s, nil
+	}
+
+	if len(b) < 6 {
+		return nil, errors.New("incomplete splice insert flags")
+	}
+	s.OutOfNetwork = b[5]&0x80 != 0
+	program := b[5]&0x40 != 0
+	duration := b[5]&0x20 != 0
+	s.Immediate = b[5]&0x10 != 0
+	b = b[6:]
+
+	switch {
+	case program && !s.Immediate:
+		pts, n, err := parseSpliceTime(b)
+		if err != nil {
+			return nil, err
+		}
+		s.PTS = pts
+		b = b[n:]
+	case !program:
+		// component_count(8), 每个分量: component_tag(8), splice_time()
+		if len(b) < 1 {
+			return nil, errors.New("incomplete splice insert components")
+		}
+		count := int(b[0])
+		b = b[1:]
+		for i := 0; i < count; i++ {
+			if len(b) < 1 {
+				return nil, errors.New("incomplete splice insert component")
+			}
+			b = b[1:]
+
+			if s.Immediate {
+				continue
+			}
+
+			pts, n, err := parseSpliceTime(b)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				s.PTS = pts
+			}
+			b = b[n:]
+		}
+	}
+
+	if duration {
+		if len(b) < 5 {
+			return nil, errors.New("incomplete break duration")
+		}
+		s.AutoReturn = b[0]&0x80 != 0
+		s.Duration = int64(b[0]&0x01)<<32 | int64(b[1])<<24 | int64(b[2])<<16 | int64(b[3])<<8 | int64(b[4])
+		b = b[5:]
+	}
+
+	if len(b) < 4 {
+		return nil, errors.New("incomplete splice insert program id")
+	}
+	s.ProgramID = uint16(b[0])<<8 | uint16(b[1])
+	s.AvailNum = b[2]
+	s.AvailsExpected = b[3]
+
+	return s, nil
+}
+
+// parseSpliceTime 解析splice_time(), 返回时间(未指定时为-1)和长度
+func parseSpliceTime(b []byte) (int64, int, error) {
+	if len(b) < 1 {
+		return 0, 0, errors.New("incomplete splice time")
+	}
+
+	if b[0]&0x80 == 0 {
+		return -1, 1, nil
+	}
+
+	if len(b) < 5 {
+		return 0, 0, errors.New("incomplete splice time")
+	}
+
+	return int64(b[0]&0x01)<<32 | int64(b[1])<<24 | int64(b[2])<<16 | int64(b[3])<<8 | int64(b[4]), 5, nil
+}
+
+// parseSegmentation 解析segmentation_descriptor()(descriptor_length之后的数据)
+func parseSegmentation(b []byte) (*Segmentation, error) {
+	if len(b) < 9 {
+		return nil, errors.New("incomplete segmentation descriptor")
+	}
+
+	if uint32(b[0])<<24|uint32(b[1])<<16|uint32(b[2])<<8|uint32(b[3]) != scte35Identifier {
+		return nil, errors.New("unexpected segmentation descriptor identifier")
+	}
+
+	seg := &Segmentation{
+		EventID: uint32(b[4])<<24 | uint32(b[5])<<16 | uint32(b[6])<<8 | uint32(b[7]),
+		Cancel:  b[8]&0x80 != 0,
+	}
+	if seg.Cancel {
+		return seg, nil
+	}
+
+	if len(b) < 10 {
+		return nil, errors.New("incomplete segmentation flags")
+	}
+	program := b[9]&0x80 != 0
+	duration := b[9]&0x40 != 0
+	b = b[10:]
+
+	// 分量级分段: component_count(8), 每个分量: component_tag(8), reserved(7), pts_offset(33)
+	if !program {
+		if len(b) < 1 || len(b) < 1+6*int(b[0]) {
+			return nil, errors.New("incomplete segmentation components")
+		}
+		b = b[1+6*int(b[0]):]
+	}
+
+	if duration {
+		if len(b) < 5 {
+			return nil, errors.New("incomplete segmentation duration")
+		}
+		seg.Duration = int64(b[0])<<32 | int64(b[1])<<24 | int64(b[2])<<16 | int64(b[3])<<8 | int64(b[4])
+		b = b[5:]
+	}
+
+	if len(b) < 2 || len(b) < 2+int(b[1])+3 {
+		return nil, errors.New("incomplete segmentation upid")
+	}
+	seg.UpidType = b[0]
+	seg.Upid = b[2 : 2+int(b[1])]
+	b = b[2+int(b[1]):]
+
+	seg.TypeID = b[0]
+	seg.Num = b[1]
+	seg.Expected = b[2]
+
+	return seg, nil
+}
@@ -0,0 +1,85 @@
+package table
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSpliceInfo(t *testing.T) {
+	at := assert.New(t)
+
+	// SCTE-35 示例: time_signal, Provider Placement Opportunity Start
+	b, err := base64.StdEncoding.DecodeString("/DA0AAAAAAAA///wBQb+cr0AUAAeAhxDVUVJSAAAjn/PAAGlmbAICAAAAAAsoKGKNAIAmsnRfg==")
+	at.Nil(err)
+	at.Equal(uint32(0), Crc32(b))
+
+	si, err := ParseSpliceInfo(b)
+	at.Nil(err)
+	at.Equal(uint16(0xfff), si.Tier)
+	at.Equal(uint8(SpliceCommandTimeSignal), si.CommandType)
+	at.Equal(int64(0x072bd0050), si.PTS)
+	at.Equal(int64(0x072bd0050), si.SpliceTime())
+	at.Equal([]Segmentation{{
+		EventID:  0x4800008e,
+		Duration: 0x0001a599b0,
+		UpidType: 0x08,
+		Upid:     []byte{0x00, 0x00, 0x00, 0x00, 0x2c, 0xa0, 0xa1, 0x8a},
+		TypeID:   0x34,
+		Num:      2,
+		Expected: 0,
+	}}, si.Segmentations)
+
+	// 不完整的section
+	_, err = ParseSpliceInfo(b[:10])
+	at.NotNil(err)
+}
+
+func TestSpliceInfo_Encode(t *testing.T) {
+	at := assert.New(t)
+
+	// splice_insert: 切出30秒
+	si := NewSpliceInsert(0x1234, true, 900000, 30*90000)
+	si.PtsAdjustment = 1 << 32
+	si.Insert.ProgramID = 1
+
+	b, err := si.Encode()
+	at.Nil(err)
+	at.Equal([]byte{0xfc, 0x30}, b[:2])
+	at.Equal(SectionLen(b), len(b))
+	at.Equal(uint32(0), Crc32(b))
+
+	got, err := ParseSpliceInfo(b)
+	at.Nil(err)
+	at.Equal(si, got)
+	at.Equal(int64(900000+1<<32), got.SpliceTime())
+
+	// 立即切回
+	si = NewSpliceInsert(0x1234, false, -1, 0)
+	b, err = si.Encode()
+	at.Nil(err)
+
+	got, err = ParseSpliceInfo(b)
+	at.Nil(err)
+	at.True(got.Insert.Immediate)
+	at.Equal(int64(-1), got.SpliceTime())
+
+	// 设置时间后不再立即切换
+	si.SetSpliceTime(1000)
+	at.Equal(int64(1000), si.SpliceTime())
+
+	// time_signal和segmentation_descriptor
+	si = NewTimeSignal(123456, Segmentation{EventID: 1, Duration: 60 * 90000, UpidType: 0x09, Upid: []byte("ad"), TypeID: 0x30, Num: 1, Expected: 1}, Segmentation{EventID: 2, Cancel: true})
+	b, err = si.Encode()
+	at.Nil(err)
+
+	got, err = ParseSpliceInfo(b)
+	at.Nil(err)
+	at.Equal(si, got)
+
+	// 不支持的命令
+	si.CommandType = 0xff
+	_, err = si.Encode()
+	at.NotNil(err)
+}
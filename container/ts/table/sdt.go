@@ -1,23 +1,144 @@
 package table
 
+import (
+	"bytes"
+	"errors"
+)
+
+// service_descriptor的tag
+const serviceDescriptorTag = 0x48
+
+// 生成SDT时使用的固定值
+const (
+	originalNetworkID = 0xff01
+	runningStatus     = 0x04 // running
+)
+
 // Sdt Ts的Sdt表
+//
+// Deprecated: 使用 BuildSdt 生成SDT
 type Sdt struct {
 	TsHeader  []byte
 	SdtHeader []byte
 }
 
-// NewSdt 新建Sdt表
+// NewSdt 新建Sdt表, SdtHeader 为不含描述符和CRC32的section, section_length和descriptors_loop_length需要重新填写
+//
+// Deprecated: 使用 BuildSdt 生成SDT
 func NewSdt() *Sdt {
+	/*
+		transport stream id: 0x1
+		service id: 0x1
+	*/
+	section := BuildSdt(0x1, []uint16{0x1}, [][]byte{nil})
+
 	return &Sdt{
 		/*
 			组成: 4字节固定头 + 1字节指针域
 			pid: 0x0011
 		*/
-		TsHeader: []byte{0x47, 0x40, 0x11, 0x10, 0x00},
-		/*
-			transport stream id: 0x1
-		*/
-		SdtHeader: []byte{0x42, 0xF0, 0x00, 0x00, 0x01, 0xC1, 0x00, 0x00, 0xFF, 0x01,
-			0xFF, 0x00, 0x01, 0xFC, 0x80, 0x00},
+		TsHeader:  []byte{0x47, 0x40, 0x11, 0x10, 0x00},
+		SdtHeader: section[:len(section)-crcLen],
+	}
+}
+
+// BuildSdt 生成SDT的section(含CRC32), descs为每个业务的描述符(与serviceIDs一一对应)
+func BuildSdt(tsID uint16, serviceIDs []uint16, descs [][]byte) []byte {
+	var body bytes.Buffer
+
+	// original_network_id(16), reserved_future_use(8)
+	body.Write([]byte{byte(originalNetworkID >> 8), byte(originalNetworkID & 0xff), 0xff})
+
+	// service_id(16), reserved(6), EIT_schedule_flag(1), EIT_present_following_flag(1),
+	// running_status(3), free_CA_mode(1), descriptors_loop_length(12)
+	for i, id := range serviceIDs {
+		n := len(descs[i])
+		body.Write([]byte{byte(id >> 8), byte(id), 0xfc, runningStatus<<5 | byte(n>>8)&0x0f, byte(n)})
+		body.Write(descs[i])
+	}
+
+	return BuildSection(TableSdt, tsID, body.Bytes())
+}
+
+// SdtService SDT中的业务
+type SdtService struct {
+	ServiceID    uint16
+	ServiceType  uint8
+	ProviderName string
+	ServiceName  string
+}
+
+// SdtSection 解析后的SDT
+type SdtSection struct {
+	TransportStreamID uint16
+	Version           uint8
+	OriginalNetwork   uint16
+	Services          []SdtService
+}
+
+// ParseSdt 解析SDT(从table_id开始, 不校验CRC32), 业务名称取自 service_descriptor
+func ParseSdt(b []byte) (*SdtSection, error) {
+	id, version, body, err := parseSection(b, TableSdt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) < 3 {
+		return nil, errors.New("incomplete sdt header")
+	}
+
+	sdt := &SdtSection{
+		TransportStreamID: id,
+		Version:           version,
+		OriginalNetwork:   uint16(body[0])<<8 | uint16(body[1]),
+	}
+	body = body[3:]
+
+	// 每个业务: service_id(16), reserved(6), EIT标识(2), running_status(3), free_CA_mode(1), descriptors_loop_length(12)
+	for len(body) >= 5 {
+		n := int(body[3]&0x0f)<<8 | int(body[4])
+		if len(body) < 5+n {
+			return nil, errors.New("incomplete sdt service")
+		}
+
+		s := SdtService{
+			ServiceID: uint16(body[0])<<8 | uint16(body[1]),
+		}
+		parseServiceDescriptor(&s, body[5:5+n])
+
+		sdt.Services = append(sdt.Services, s)
+		body = body[5+n:]
+	}
+
+	return sdt, nil
+}
+
+// parseServiceDescriptor 从描述符中找出 service_descriptor, 填充业务类型和名称
+func parseServiceDescriptor(s *SdtService, b []byte) {
+	for len(b) >= 2 {
+		tag, n := b[0], int(b[1])
+		if len(b) < 2+n {
+			return
+		}
+
+		d := b[2 : 2+n]
+		b = b[2+n:]
+		if tag != serviceDescriptorTag || len(d) < 2 {
+			continue
+		}
+
+		// service_type(8), 服务提供者名称长度(8), 名称, 服务名称长度(8), 名称
+		s.ServiceType = d[0]
+		pn := int(d[1])
+		if len(d) < 3+pn {
+			return
+		}
+		s.ProviderName = string(d[2 : 2+pn])
+
+		sn := int(d[2+pn])
+		if len(d) < 3+pn+sn {
+			return
+		}
+		s.ServiceName = string(d[3+pn : 3+pn+sn])
 	}
 }
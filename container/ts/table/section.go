@@ -0,0 +1,78 @@
+package table
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Table ID
+const (
+	TablePat = 0x00
+	TablePmt = 0x02
+	TableSdt = 0x42
+
+	TableSpliceInfo = 0xfc // SCTE-35
+)
+
+// MaxSectionLen PSI和SI中section的最大长度(包括3字节公共头), section_length不超过1021
+const MaxSectionLen = 1024
+
+// 长格式section的固定头长度(table_id到last_section_number), 以及CRC32的长度
+const (
+	sectionHdrLen = 8
+	crcLen        = 4
+)
+
+// SectionLen 返回section的总长度(3字节公共头 + section_length), 数据不足3字节时返回0
+func SectionLen(b []byte) int {
+	if len(b) < 3 {
+		return 0
+	}
+
+	return 3 + (int(b[1]&0x0f)<<8 | int(b[2]))
+}
+
+// BuildSection 生成长格式section(版本号, section_number和last_section_number为0), 末尾为CRC32
+// id: table_id_extension(transport_stream_id或program_number), body: last_section_number之后, CRC32之前的数据
+func BuildSection(tableID byte, id uint16, body []byte) []byte {
+	// section_syntax_indicator(1), '0'(1), reserved(2); SDT中第2位为reserved_future_use
+	flags := byte(0xb0)
+	if tableID == TableSdt {
+		flags = 0xf0
+	}
+
+	n := sectionHdrLen - 3 + len(body) + crcLen
+	b := make([]byte, 0, 3+n)
+	b = append(b, tableID, flags|byte(n>>8)&0x0f, byte(n))
+	// reserved(2), version_number(5), current_next_indicator(1), section_number, last_section_number
+	b = append(b, byte(id>>8), byte(id), 0xc1, 0x00, 0x00)
+	b = append(b, body...)
+
+	crc := Crc32(b)
+	return append(b, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+// parseSection 解析长格式section的公共头, 返回table_id_extension(transport_stream_id或program_number),
+// 版本号以及公共头之后, CRC32之前的数据
+func parseSection(b []byte, tableID byte) (uint16, uint8, []byte, error) {
+	if len(b) < 3 {
+		return 0, 0, nil, errors.New("incomplete section header")
+	}
+
+	if b[0] != tableID {
+		return 0, 0, nil, fmt.Errorf("unexpected table id %#x, want %#x", b[0], tableID)
+	}
+
+	n := SectionLen(b)
+	if n < sectionHdrLen+crcLen {
+		return 0, 0, nil, fmt.Errorf("invalid section length %d", n-3)
+	}
+	if len(b) < n {
+		return 0, 0, nil, fmt.Errorf("incomplete section, got %d bytes, want %d", len(b), n)
+	}
+
+	id := uint16(b[3])<<8 | uint16(b[4])
+	version := (b[5] >> 1) & 0x1f
+
+	return id, version, b[sectionHdrLen : n-crcLen], nil
+}
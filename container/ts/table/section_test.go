@@ -0,0 +1,45 @@
+package table
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildSection(t *testing.T) {
+	at := assert.New(t)
+
+	pat := &PatSection{TransportStreamID: 0x1234, Programs: []PatProgram{{Number: 1, PID: 0x1001}, {Number: 2, PID: 0x1002}}}
+	b := pat.Bytes()
+	at.Equal(len(b), SectionLen(b))
+	at.Equal(uint32(0), Crc32(b))
+
+	got, err := ParsePat(b)
+	at.Nil(err)
+	at.Equal(pat, got)
+
+	pmt := &PmtSection{
+		ProgramNumber: 1,
+		PcrPID:        0x100,
+		Descriptors:   []byte{0x05, 0x00},
+		Streams:       []PmtStream{{StreamType: StreamTypeAvc, PID: 0x100, Descriptors: []byte{}}},
+	}
+	got2, err := ParsePmt(pmt.Bytes())
+	at.Nil(err)
+	at.Equal(pmt, got2)
+
+	sdt, err := ParseSdt(BuildSdt(1, []uint16{1}, [][]byte{{serviceDescriptorTag, 3, 0x01, 0x00, 0x00}}))
+	at.Nil(err)
+	at.Equal(uint16(originalNetworkID), sdt.OriginalNetwork)
+	at.Equal([]SdtService{{ServiceID: 1, ServiceType: 1}}, sdt.Services)
+}
+
+func TestLegacyTables(t *testing.T) {
+	at := assert.New(t)
+
+	at.Equal([]byte{0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x01}, NewPat().PatHeader)
+	at.Equal([]byte{0x00, 0x01, 0xc1, 0x00, 0x00, 0xe1, 0x00, 0xf0, 0x00}, NewPmt().PmtHeader[3:])
+	at.Equal([]byte{0x1b, 0xe1, 0x00, 0xf0, 0x00}, NewProgram().Avc)
+	at.Equal([]byte{0x0f, 0xe1, 0x01, 0xf0, 0x00}, NewProgram().Aac)
+	at.Equal([]byte{0x00, 0x01, 0xc1, 0x00, 0x00, 0xff, 0x01, 0xff, 0x00, 0x01, 0xfc, 0x80, 0x00}, NewSdt().SdtHeader[3:])
+}
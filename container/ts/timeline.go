@@ -0,0 +1,108 @@
+package ts
+
+import (
+	"time"
+
+	"github.com/nextpkg/goav/container/ts/table"
+)
+
+const (
+	// defaultTimelineJump 同类型的相邻音视频时间戳增大超过该值时视为时间线不连续
+	// 稀疏的音频, 暂停或者幻灯片式的视频以及可变帧率的录屏经常有数秒的间隔, 不能当作跳变
+	defaultTimelineJump = 10 * time.Second
+
+	// timelineRewind 同类型的相邻音视频时间戳回退超过该值时视为时间线不连续
+	timelineRewind = 1000
+)
+
+// timeline 将FLV的32位毫秒时间戳扩展为64位(跨过2^32回绕), 并检测时间线的跳变
+// 音频和视频分别检测, 音视频交错的偏差不会被当作跳变
+type timeline struct {
+	last    int64 // 上一个音视频时间戳(ms, 已扩展)
+	started bool
+	jump    int64 // 时间戳增大的阈值(ms), 小于等于0时只检测回退
+
+	lastOf [2]int64 // 按包类型(视频,音频)记录的上一个时间戳(ms, 已扩展)
+	gotOf  [2]bool
+}
+
+// newTimeline 时间线, jump: 时间戳增大的阈值
+func newTimeline(jump time.Duration) *timeline {
+	return &timeline{
+		jump: jump.Milliseconds(),
+	}
+}
+
+// isJump 与同类型上一个时间戳的差值是否是跳变
+func (t *timeline) isJump(delta int64) bool {
+	if delta < 0 {
+		return -delta > timelineRewind
+	}
+
+	return t.jump > 0 && delta > t.jump
+}
+
+// extend 扩展时间戳, 取与上一个时间戳距离最近的回绕周期
+func (t *timeline) extend(ts uint32) int64 {
+	if !t.started {
+		return int64(ts)
+	}
+
+	ms := t.last&^0xffffffff | int64(ts)
+	switch {
+	case ms-t.last > 1<<31:
+		ms -= 1 << 32
+	case t.last-ms > 1<<31:
+		ms += 1 << 32
+	}
+
+	return ms
+}
+
+// update 记录音视频(typ为 packet.PktVideo 或 packet.PktAudio)的时间戳,
+// 返回扩展后的时间戳和相对于同类型上一个时间戳的跳变(ms, 没有跳变时为0)
+// 跳变后其它类型的上一个时间戳随之平移, 同一次跳变只报告一次
+func (t *timeline) update(typ int, ts uint32) (int64, int64) {
+	ms := t.extend(ts)
+
+	var jump int64
+	last := t.lastOf[typ]
+	if t.gotOf[typ] && t.isJump(ms-last) {
+		jump = ms - last
+		for i := range t.lastOf {
+			if i != typ {
+				t.lastOf[i] += jump
+			}
+		}
+	}
+
+	t.last = ms
+	t.started = true
+	t.lastOf[typ] = ms
+	t.gotOf[typ] = true
+
+	return ms, jump
+}
+
+// unwrapTs 将33位的PTS/DTS(90kHz)扩展为64位, 取与上一个时间戳(已扩展)距离最近的回绕周期
+func unwrapTs(last, ts int64) int64 {
+	v := last&^(table.TsWrap-1) | ts&(table.TsWrap-1)
+	switch {
+	case v-last > table.TsWrap/2 && v >= table.TsWrap:
+		v -= table.TsWrap
+	case last-v > table.TsWrap/2:
+		v += table.TsWrap
+	}
+
+	return v
+}
+
+// tsDelta 33位时间戳之差(a-b), 按2^33回绕后取距离最近的有符号值
+func tsDelta(a, b int64) int64 {
+	d := (a - b) & (table.TsWrap - 1)
+	if d >= table.TsWrap/2 {
+		d -= table.TsWrap
+	}
+
+	return d
+}
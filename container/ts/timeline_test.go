@@ -0,0 +1,226 @@
+package ts
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/container/ts/table"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeline(t *testing.T) {
+	at := assert.New(t)
+
+	tl := newTimeline(time.Second)
+
+	ms, jump := tl.update(packet.PktAudio, 0xffffff00)
+	at.Equal(int64(0xffffff00), ms)
+	at.Equal(int64(0), jump)
+
+	// 跨过2^32回绕
+	ms, jump = tl.update(packet.PktAudio, 0x10)
+	at.Equal(int64(1<<32+0x10), ms)
+	at.Equal(int64(0), jump)
+
+	// 回绕之前的元数据不影响时间线
+	at.Equal(int64(0xfffffff0), tl.extend(0xfffffff0))
+	at.Equal(int64(1<<32+0x10), tl.last)
+
+	// 前后跳变
+	ms, jump = tl.update(packet.PktAudio, 0x10+5000)
+	at.Equal(int64(1<<32+0x10+5000), ms)
+	at.Equal(int64(5000), jump)
+
+	ms, jump = tl.update(packet.PktAudio, 0x10+4500)
+	at.Equal(int64(1<<32+0x10+4500), ms)
+	at.Equal(int64(0), jump)
+
+	ms, jump = tl.update(packet.PktAudio, 0x10)
+	at.Equal(int64(1<<32+0x10), ms)
+	at.Equal(int64(-4500), jump)
+
+	// 阈值为0时只检测回退
+	tl = newTimeline(0)
+	_, jump = tl.update(packet.PktVideo, 0)
+	at.Equal(int64(0), jump)
+	_, jump = tl.update(packet.PktVideo, 60000)
+	at.Equal(int64(0), jump)
+	_, jump = tl.update(packet.PktVideo, 59500)
+	at.Equal(int64(0), jump)
+	_, jump = tl.update(packet.PktVideo, 1000)
+	at.Equal(int64(-58500), jump)
+}
+
+func TestTimeline_Interleave(t *testing.T) {
+	at := assert.New(t)
+
+	tl := newTimeline(time.Second)
+
+	// 音频比视频落后1.5秒, 音视频交替时不是跳变
+	for ts := uint32(0); ts < 3000; ts += 40 {
+		_, jump := tl.update(packet.PktVideo, ts+1500)
+		at.Equal(int64(0), jump)
+		_, jump = tl.update(packet.PktAudio, ts)
+		at.Equal(int64(0), jump)
+	}
+
+	// 编码器重启, 音频和视频都回到0附近, 同一次跳变只报告一次
+	_, jump := tl.update(packet.PktVideo, 1500)
+	at.Equal(int64(1500-(2960+1500)), jump)
+	_, jump = tl.update(packet.PktAudio, 0)
+	at.Equal(int64(0), jump)
+	_, jump = tl.update(packet.PktVideo, 1540)
+	at.Equal(int64(0), jump)
+}
+
+func TestUnwrapTs(t *testing.T) {
+	at := assert.New(t)
+
+	at.Equal(int64(table.TsWrap+100), unwrapTs(table.TsWrap-100, 100))
+	at.Equal(int64(table.TsWrap-100), unwrapTs(table.TsWrap+100, table.TsWrap-100))
+	at.Equal(int64(3*table.TsWrap+5), unwrapTs(3*table.TsWrap+1, 5))
+
+	// 开始时不会扩展为负数
+	at.Equal(int64(table.TsWrap-100), unwrapTs(100, table.TsWrap-100))
+
+	at.Equal(int64(200), tsDelta(100, table.TsWrap-100))
+	at.Equal(int64(-200), tsDelta(table.TsWrap-100, 100))
+}
+
+func TestMixer_Timeline(t *testing.T) {
+	at := assert.New(t)
+
+	buf := bytes.NewBuffer(nil)
+	m := NewMixer(buf)
+	d := flv.NewDemuxer()
+
+	aacSeqHdr := &packet.Packet{Type: packet.PktAudio, Data: []byte{0xaf, 0x00, 0x12, 0x10}}
+	at.Nil(d.Demux(aacSeqHdr))
+	at.Nil(m.SaveAACHeader(aacSeqHdr))
+	at.Nil(m.SetTsHeader())
+
+	run := func(from, to int64) {
+		for ts := from; ts < to; ts += 23 {
+			audio := make([]byte, 202)
+			audio[0], audio[1] = 0xaf, 0x01
+			p := &packet.Packet{Type: packet.PktAudio, TimeStamp: uint32(ts), Data: audio}
+			at.Nil(d.Demux(p))
+			at.Nil(m.Update(p, uint32(ts), 0))
+			at.Nil(m.Mux(p))
+		}
+	}
+
+	// 毫秒时间戳乘以90超过32位(约13.25小时), 之后跳到2^32毫秒附近并回绕, 最后回到较小的时间戳
+	run(47721000, 47723000)
+	run(1<<32-1000, 1<<32+1000)
+	run(500000, 501000)
+
+	var dts []int64
+	var discontinuities []int
+	pending := int64(-1)
+	data := buf.Bytes()
+	for i := 0; i < len(data); i += tsPacketLen {
+		b := data[i : i+tsPacketLen]
+		pid := uint16(b[1]&0x1f)<<8 | uint16(b[2])
+
+		switch {
+		case pid == defaultPcrPID && b[5]&0x80 != 0:
+			at.Equal(byte(0x10), b[5]&0x10)
+			discontinuities = append(discontinuities, len(dts))
+			pending = int64(b[6])<<25 | int64(b[7])<<17 | int64(b[8])<<9 | int64(b[9])<<1 | int64(b[10])>>7
+		case pid == defaultAudioPID && b[1]&0x40 != 0:
+			payload := b[4:]
+			if b[3]&0x20 != 0 {
+				payload = payload[1+int(payload[0]):]
+			}
+
+			info, err := table.ParsePes(payload)
+			at.Nil(err)
+			dts = append(dts, info.DTS)
+
+			// 带有 discontinuity_indicator 的PCR等于下一个数据包的DTS
+			if pending >= 0 {
+				at.Equal(pending, info.DTS)
+				pending = -1
+			}
+		}
+	}
+
+	// 时间线只在两次跳变处不连续, 其它位置的DTS按2^33回绕后连续递增
+	at.Equal([]int{87, 87 + 87}, discontinuities)
+	at.Equal(int64(47721000*avcHZ%table.TsWrap), dts[0])
+	at.Equal(int64((1<<32-1000)*avcHZ%table.TsWrap), dts[87])
+	at.Equal(int64(500000*avcHZ), dts[87+87])
+	for i := 1; i < len(dts); i++ {
+		if i == 87 || i == 87+87 {
+			continue
+		}
+
+		gap := (dts[i] - dts[i-1] + table.TsWrap) % table.TsWrap
+		at.True(gap > 0 && gap <= 30*avcHZ, "dts gap=%d at %d", gap, i)
+	}
+}
+
+func TestMixer_TimelineGap(t *testing.T) {
+	at := assert.New(t)
+
+	for _, jump := range []time.Duration{0, time.Second} {
+		buf := bytes.NewBuffer(nil)
+		m := NewMixer(buf)
+		if jump > 0 {
+			m.SetTimelineJump(jump)
+		}
+		d := flv.NewDemuxer()
+
+		aacSeqHdr := &packet.Packet{Type: packet.PktAudio, Data: []byte{0xaf, 0x00, 0x12, 0x10}}
+		at.Nil(d.Demux(aacSeqHdr))
+		at.Nil(m.SaveAACHeader(aacSeqHdr))
+		at.Nil(m.SetTsHeader())
+
+		// 稀疏的音频: 两个包之间间隔2.5秒
+		timestamps := []uint32{1000, 1023, 3523, 3546}
+		for _, ts := range timestamps {
+			audio := make([]byte, 202)
+			audio[0], audio[1] = 0xaf, 0x01
+			p := &packet.Packet{Type: packet.PktAudio, TimeStamp: ts, Data: audio}
+			at.Nil(d.Demux(p))
+			at.Nil(m.Update(p, ts, 0))
+			at.Nil(m.Mux(p))
+		}
+
+		var dts []int64
+		var discontinuities int
+		data := buf.Bytes()
+		for i := 0; i < len(data); i += tsPacketLen {
+			b := data[i : i+tsPacketLen]
+			pid := uint16(b[1]&0x1f)<<8 | uint16(b[2])
+
+			switch {
+			case pid == defaultPcrPID && b[5]&0x80 != 0:
+				discontinuities++
+			case pid == defaultAudioPID && b[1]&0x40 != 0:
+				payload := b[4:]
+				if b[3]&0x20 != 0 {
+					payload = payload[1+int(payload[0]):]
+				}
+
+				info, err := table.ParsePes(payload)
+				at.Nil(err)
+				dts = append(dts, info.DTS)
+			}
+		}
+
+		// 默认阈值下间隔原样保留, 不是时间线跳变; 阈值为1秒时是跳变
+		if jump == 0 {
+			at.Equal(0, discontinuities)
+		} else {
+			at.Equal(1, discontinuities)
+		}
+		at.Len(dts, len(timestamps))
+		at.Equal(int64(3523*avcHZ), dts[2], "jump=%v", jump)
+		at.Equal(int64(1000*avcHZ), dts[0], "jump=%v", jump)
+	}
+}
@@ -1,6 +1,7 @@
 package gop
 
 import (
+	"github.com/nextpkg/goav/amf"
 	"github.com/nextpkg/goav/packet"
 )
 
@@ -44,8 +45,8 @@ func (c *Cache) WriteAudio(p *packet.Packet) error {
 	// 缓存音频序列头
 	ah := p.Header.(packet.AudioPacketHeader)
 
-	// aac
-	if ah.IsSoundAAC() && ah.IsAACSeqHdr() {
+	// aac, 或者扩展音频头的序列头
+	if ah.IsSoundSeqHdr() {
 		c.AudioSeqHdr.Write(p)
 	}
 
@@ -67,6 +68,10 @@ func (c *Cache) Write(p *packet.Packet) error {
 			return err
 		}
 	case packet.PktMetadata:
+		// 只缓存onMetaData, 其他脚本事件(onCuePoint等)只在当时有效
+		if sh, ok := p.Header.(packet.ScriptPacketHeader); ok && sh.ScriptName() != amf.OnMetaData {
+			return nil
+		}
 		c.Metadata.Write(p)
 	}
 
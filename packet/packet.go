@@ -36,7 +36,21 @@ type AudioPacketHeader interface {
 	AACType() uint8
 	IsSoundAAC() bool
 	IsSoundMP3() bool
+	IsSoundOpus() bool
+	IsSoundAC3() bool
+	IsSoundEAC3() bool
+	IsSoundG711ALaw() bool
+	IsSoundG711MuLaw() bool
+	IsSoundPassthrough() bool
 	IsAACSeqHdr() bool
+	IsSoundSeqHdr() bool
+	IsExHeader() bool
+	FourCC() uint32
+	IsMultitrack() bool
+	TrackID() uint8
+	SampleRate() int
+	SampleSize() int
+	Channels() int
 }
 
 // VideoPacketHeader FLV视频帧描述接口
@@ -46,11 +60,23 @@ type VideoPacketHeader interface {
 	IsInterFrame() bool
 	IsSeqHdr() bool
 	IsEndOfSeq() bool
+	IsExHeader() bool
 	IsCodecAvc() bool
+	IsCodecHevc() bool
+	IsCodecAv1() bool
+	IsCodecVp9() bool
 	CodecID() uint8
+	FourCC() uint32
 	CompositionTime() int32
 }
 
+// ScriptPacketHeader FLV脚本数据(onMetaData, onCuePoint等)描述接口
+type ScriptPacketHeader interface {
+	Header
+	ScriptName() string
+	ScriptEvent() interface{}
+}
+
 // Reader 通用读接口
 type Reader interface {
 	Read(*Packet) error
@@ -27,6 +27,11 @@ func (mt *Types) IsAudio() {
 	mt.types |= 0x2
 }
 
+// IsMetadata 标记为元数据(例如TS中的SCTE-35和ID3)
+func (mt *Types) IsMetadata() {
+	mt.types |= 0x4
+}
+
 // ToSlice 将缓存的媒体元素类型转换为包类型
 func (mt *Types) ToSlice() (types []int) {
 	if mt.types&0x1 == 1 {
@@ -35,6 +40,9 @@ func (mt *Types) ToSlice() (types []int) {
 	if mt.types&0x2 == 2 {
 		types = append(types, PktAudio)
 	}
+	if mt.types&0x4 == 4 {
+		types = append(types, PktMetadata)
+	}
 
 	return types
 }
@@ -13,6 +13,8 @@ type Parser struct {
 	gotSpecific bool
 	adtsHeader  []byte
 	cfgInfo     *mpegCfgInfo
+
+	encrypt func(frame []byte) []byte // SAMPLE-AES加密, 为nil时不加密
 }
 
 // NewParser aac解析器
@@ -24,6 +26,11 @@ func NewParser() *Parser {
 	}
 }
 
+// SetEncrypter 设置音频帧的加密函数(SAMPLE-AES), 输入不含ADTS头, 为nil时不加密
+func (p *Parser) SetEncrypter(encrypt func(frame []byte) []byte) {
+	p.encrypt = encrypt
+}
+
 // Parse 根据包类型提取和填充数据
 func (p *Parser) Parse(b []byte, types uint8, w io.Writer) error {
 	if len(b) == 0 {
@@ -35,6 +42,11 @@ func (p *Parser) Parse(b []byte, types uint8, w io.Writer) error {
 	case flv.AacSeqHdr:
 		return p.specificInfo(b)
 	case flv.AacRaw:
+		// [ADTS格式]直接写入已带有ADTS头的数据(例如从TS流中解析出的音频)
+		if p.isADTS(b) {
+			return p.writeADTS(b, w)
+		}
+
 		return p.addADTSToFrame(b, w)
 	}
 
@@ -52,6 +64,50 @@ func (p *Parser) SampleRate() int {
 	return rate
 }
 
+// 判断数据是否以ADTS头开始(syncword: 0xFFF, layer: 0)
+func (p *Parser) isADTS(src []byte) bool {
+	return len(src) >= adtsHeaderLen && src[0] == 0xff && src[1]&0xf6 == 0xf0
+}
+
+// [ADTS格式]写入数据, 需要加密时逐帧加密(ADTS头保持明文)
+func (p *Parser) writeADTS(src []byte, w io.Writer) error {
+	if p.encrypt == nil {
+		_, err := w.Write(src)
+		return err
+	}
+
+	for len(src) > 0 {
+		if !p.isADTS(src) {
+			return errors.New("invalid adts frame")
+		}
+
+		// protection_absent=0时, adts头带有2字节的crc
+		headerLen := adtsHeaderLen
+		if src[1]&0x01 == 0 {
+			headerLen += 2
+		}
+
+		frameLen := int(src[3]&0x03)<<11 | int(src[4])<<3 | int(src[5])>>5
+		if frameLen < headerLen || frameLen > len(src) {
+			return fmt.Errorf("invalid adts frame length(%d)", frameLen)
+		}
+
+		_, err := w.Write(src[:headerLen])
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(p.encrypt(src[headerLen:frameLen]))
+		if err != nil {
+			return err
+		}
+
+		src = src[frameLen:]
+	}
+
+	return nil
+}
+
 // 从aac sequence header 中提取specific config信息, 填充到 p.cfgInfo 中
 // audio specific config
 func (p *Parser) specificInfo(src []byte) error {
@@ -131,8 +187,13 @@ func (p *Parser) addADTSToFrame(src []byte, w io.Writer) error {
 		return err
 	}
 
-	// 填充body
-	_, err = w.Write(src)
+	// 填充body, 加密后长度不变
+	body := src
+	if p.encrypt != nil {
+		body = p.encrypt(src)
+	}
+
+	_, err = w.Write(body)
 	if err != nil {
 		return err
 	}
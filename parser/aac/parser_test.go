@@ -21,4 +21,37 @@ func TestAac(t *testing.T) {
 	err = d.Parse(audio, Raw, w)
 	at.Equal(nil, err)
 	at.Equal([]byte{0xff, 0xf1, 0x50, 0x80, 0x02, 0x1f, 0xfc, 0x21, 0x00, 0x49, 0x90, 0x02, 0x19, 0x00, 0x23, 0x80}, w.Bytes())
+
+	// 已带有ADTS头的数据直接写入
+	adts := w.Bytes()
+	w = bytes.NewBuffer(nil)
+	err = d.Parse(adts, Raw, w)
+	at.Equal(nil, err)
+	at.Equal(adts, w.Bytes())
+}
+
+func TestAac_Encrypter(t *testing.T) {
+	at := assert.New(t)
+	d := NewParser()
+	d.SetEncrypter(func(frame []byte) []byte {
+		return bytes.Repeat([]byte{0xee}, len(frame))
+	})
+	w := bytes.NewBuffer(nil)
+
+	at.Nil(d.Parse([]byte{0x12, 0x10}, SeqHdr, w))
+
+	// ADTS头保持明文
+	audio := []byte{0x21, 0x00, 0x49, 0x90}
+	at.Nil(d.Parse(audio, Raw, w))
+	at.Equal([]byte{0xff, 0xf1, 0x50, 0x80, 0x01, 0x7f, 0xfc, 0xee, 0xee, 0xee, 0xee}, w.Bytes())
+	at.Equal([]byte{0x21, 0x00, 0x49, 0x90}, audio)
+
+	// 带有ADTS头的数据逐帧加密
+	adts := []byte{0xff, 0xf1, 0x50, 0x80, 0x01, 0x1f, 0xfc, 0x01, 0xff, 0xf1, 0x50, 0x80, 0x01, 0x3f, 0xfc, 0x02, 0x03}
+	w.Reset()
+	at.Nil(d.Parse(adts, Raw, w))
+	at.Equal([]byte{0xff, 0xf1, 0x50, 0x80, 0x01, 0x1f, 0xfc, 0xee, 0xff, 0xf1, 0x50, 0x80, 0x01, 0x3f, 0xfc, 0xee, 0xee}, w.Bytes())
+
+	w.Reset()
+	at.NotNil(d.Parse(adts[:12], Raw, w))
 }
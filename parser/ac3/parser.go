@@ -0,0 +1,84 @@
+package ac3
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	syncWord  = 0x0b77
+	headerLen = 6
+	ac3Blocks = 6 // AC-3每个同步帧固定6个音频块
+	blockLen  = 256
+)
+
+// fscod对应的采样率
+var sampleRates = []int{48000, 44100, 32000}
+
+// E-AC-3: numblkscod对应的音频块数
+var eac3Blocks = []int{1, 2, 3, 6}
+
+// Parser AC-3/E-AC-3解析器, 同步帧可以直接写入TS, 只记录采样率和每帧的采样数
+type Parser struct {
+	sampleRate int
+	samples    int
+}
+
+// NewParser AC-3/E-AC-3解析器
+func NewParser() *Parser {
+	return &Parser{
+		sampleRate: 48000,
+		samples:    ac3Blocks * blockLen,
+	}
+}
+
+// Parse 解析同步帧头, 并将数据原样写入w中
+func (p *Parser) Parse(src []byte, w io.Writer) error {
+	if len(src) < headerLen || w == nil {
+		return errors.New("incomplete ac-3 data or nil writer")
+	}
+
+	if int(src[0])<<8|int(src[1]) != syncWord {
+		return errors.New("invalid ac-3 sync word")
+	}
+
+	// [5]bsid(5): 0~10为AC-3, 11~16为E-AC-3
+	fscod := src[4] >> 6
+	if src[5]>>3 <= 10 {
+		// [4]fscod(2), frmsizecod(6)
+		if int(fscod) >= len(sampleRates) {
+			return errors.New("invalid ac-3 sample rate code")
+		}
+
+		p.sampleRate = sampleRates[fscod]
+		p.samples = ac3Blocks * blockLen
+	} else {
+		// [4]fscod(2), fscod2或者numblkscod(2), acmod(3), lfeon(1)
+		code := (src[4] >> 4) & 0x3
+		if fscod == 0x3 {
+			if int(code) >= len(sampleRates) {
+				return errors.New("invalid e-ac-3 sample rate code")
+			}
+
+			// 降低采样率, 固定6个音频块
+			p.sampleRate = sampleRates[code] / 2
+			p.samples = ac3Blocks * blockLen
+		} else {
+			p.sampleRate = sampleRates[fscod]
+			p.samples = eac3Blocks[code] * blockLen
+		}
+	}
+
+	_, err := w.Write(src)
+	return err
+}
+
+// SampleRate 采样率
+func (p *Parser) SampleRate() int {
+	return p.sampleRate
+}
+
+// FrameSamples 每个同步帧的采样数
+func (p *Parser) FrameSamples() int {
+	return p.samples
+}
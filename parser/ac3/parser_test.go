@@ -0,0 +1,34 @@
+package ac3
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParser_Parse(t *testing.T) {
+	at := assert.New(t)
+
+	p := NewParser()
+	w := bytes.NewBuffer(nil)
+
+	// AC-3, 44.1kHz
+	frame := []byte{0x0b, 0x77, 0x00, 0x00, 0x50, 0x40, 0x01}
+	at.Nil(p.Parse(frame, w))
+	at.Equal(frame, w.Bytes())
+	at.Equal(44100, p.SampleRate())
+	at.Equal(1536, p.FrameSamples())
+
+	// E-AC-3, fscod2: 16kHz
+	at.Nil(p.Parse([]byte{0x0b, 0x77, 0x00, 0x00, 0xe0, 0x80}, w))
+	at.Equal(16000, p.SampleRate())
+	at.Equal(1536, p.FrameSamples())
+
+	// E-AC-3, 32kHz, 2个音频块
+	at.Nil(p.Parse([]byte{0x0b, 0x77, 0x00, 0x00, 0x90, 0x80}, w))
+	at.Equal(32000, p.SampleRate())
+	at.Equal(512, p.FrameSamples())
+
+	at.NotNil(p.Parse([]byte{0x0b, 0x78, 0x00, 0x00, 0x50, 0x40}, w))
+}
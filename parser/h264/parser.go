@@ -11,6 +11,8 @@ import (
 type Parser struct {
 	specificInfo []byte        /* {0: sps, 1: pps}, 均包含start code */
 	spsPps       *bytes.Buffer /* sps和pps共用, 均包含start code */
+
+	encrypt func(nalu []byte) []byte /* SAMPLE-AES加密, 为nil时不加密 */
 }
 
 // NewParser 初始化h264解析器(pps/sps)
@@ -20,6 +22,11 @@ func NewParser() *Parser {
 	}
 }
 
+// SetEncrypter 设置NALU的加密函数(SAMPLE-AES), 输入不含start code, 为nil时不加密
+func (p *Parser) SetEncrypter(encrypt func(nalu []byte) []byte) {
+	p.encrypt = encrypt
+}
+
 // Parse 将H264打包格式转换为 Annex-b 的网络流格式, 写入w中
 func (p *Parser) Parse(b []byte, isSeqHdr bool, w io.Writer) error {
 	if len(b) == 0 || w == nil {
@@ -33,12 +40,7 @@ func (p *Parser) Parse(b []byte, isSeqHdr bool, w io.Writer) error {
 
 	// [Annex-b格式]直接写入以Nalu开头的数据
 	if p.isStartAtNaluHeader(b) {
-		_, err := w.Write(b)
-		if err != nil {
-			return err
-		}
-
-		return nil
+		return p.writeAnnexb(b, w)
 	}
 
 	// [AVCC格式]转换为Annex-b格式并写入数据
@@ -103,6 +105,57 @@ func (p *Parser) isStartAtNaluHeader(src []byte) bool {
 	return src[0] == 0x00 && src[1] == 0x00 && src[2] == 0x00 && src[3] == 0x01
 }
 
+// [Annex-b格式]写入数据, 需要加密时逐个NALU加密
+func (p *Parser) writeAnnexb(src []byte, w io.Writer) error {
+	if p.encrypt == nil {
+		_, err := w.Write(src)
+		return err
+	}
+
+	for _, nalu := range splitAnnexb(src) {
+		_, err := w.Write(startCode)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(p.encrypt(nalu))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// [Annex-b格式]按照start code(00 00 01)切分NALU, 去除NALU之后的0(4字节start code的开头)
+func splitAnnexb(b []byte) [][]byte {
+	var nalus [][]byte
+
+	start := -1
+	for i := 0; i+2 < len(b); i++ {
+		if b[i] != 0x00 || b[i+1] != 0x00 || b[i+2] != 0x01 {
+			continue
+		}
+
+		if start >= 0 {
+			end := i
+			for end > start && b[end-1] == 0x00 {
+				end--
+			}
+			nalus = append(nalus, b[start:end])
+		}
+
+		i += 2
+		start = i + 1
+	}
+
+	if start >= 0 && start < len(b) {
+		nalus = append(nalus, b[start:])
+	}
+
+	return nalus
+}
+
 // [AVCC格式] 提取NALU的长度
 func (p *Parser) naluSize(src []byte) (int, error) {
 	if len(src) < naluBytesLen {
@@ -195,8 +248,13 @@ func (p *Parser) getAnnexbH264(src []byte, w io.Writer) error {
 				return err
 			}
 
-			// 写入 sei 数据
-			_, err = w.Write(src[index : index+nalLen])
+			// 写入 slice 或者 sei 数据, 由加密函数决定是否加密
+			nalu := src[index : index+nalLen]
+			if p.encrypt != nil {
+				nalu = p.encrypt(nalu)
+			}
+
+			_, err = w.Write(nalu)
 			if err != nil {
 				return err
 			}
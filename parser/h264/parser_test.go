@@ -94,3 +94,38 @@ func TestH264Mp4DemuxException2(t *testing.T) {
 
 	at.NotNil(d.Parse(nalu, false, w))
 }
+
+// 加密函数用于NALU的数据, 不含start code
+func TestH264Encrypter(t *testing.T) {
+	at := assert.New(t)
+
+	d := NewParser()
+	var got [][]byte
+	d.SetEncrypter(func(nalu []byte) []byte {
+		got = append(got, nalu)
+
+		// 小写字母转换为大写
+		b := append([]byte(nil), nalu...)
+		for i := 1; i < len(b); i++ {
+			if b[i] >= 'a' && b[i] <= 'z' {
+				b[i] -= 'a' - 'A'
+			}
+		}
+		return b
+	})
+
+	// AVCC: SEI和slice都交给加密函数
+	w := bytes.NewBuffer(nil)
+	avcc := []byte{0x00, 0x00, 0x00, 0x03, 0x06, 'a', 'b', 0x00, 0x00, 0x00, 0x03, 0x41, 'c', 'd'}
+	at.Nil(d.Parse(avcc, false, w))
+	at.Equal([][]byte{{0x06, 'a', 'b'}, {0x41, 'c', 'd'}}, got)
+	at.Equal([]byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xf0, 0x00, 0x00, 0x00, 0x01, 0x06, 'A', 'B', 0x00, 0x00, 0x00, 0x01, 0x41, 'C', 'D'}, w.Bytes())
+
+	// Annex-b: 3字节和4字节的start code
+	got = nil
+	w.Reset()
+	annexb := []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xf0, 0x00, 0x00, 0x01, 0x65, 'e', 'f', 0x00, 0x00, 0x00, 0x01, 0x41, 'g'}
+	at.Nil(d.Parse(annexb, false, w))
+	at.Equal([][]byte{{0x09, 0xf0}, {0x65, 'e', 'f'}, {0x41, 'g'}}, got)
+	at.Equal([]byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xf0, 0x00, 0x00, 0x00, 0x01, 0x65, 'E', 'F', 0x00, 0x00, 0x00, 0x01, 0x41, 'G'}, w.Bytes())
+}
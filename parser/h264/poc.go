@@ -0,0 +1,169 @@
+package h264
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoSPS 还没有收到SPS, 无法解析slice头
+var ErrNoSPS = errors.New("no sps for slice")
+
+// ErrUnsupportedPoc 不支持的POC类型(pic_order_cnt_type为1)
+var ErrUnsupportedPoc = errors.New("unsupported pic_order_cnt_type")
+
+// PocCounter 按照解码顺序计算每帧的POC(图像显示顺序), 支持 pic_order_cnt_type 0和2
+// 场编码时以第一个场的POC作为帧的POC
+type PocCounter struct {
+	sps *SPS
+
+	// pic_order_cnt_type 0: 上一个参考帧的POC高位和低位
+	prevMsb, prevLsb int
+
+	// pic_order_cnt_type 2: 上一帧的frame_num和偏移
+	prevFrameNum, frameNumOffset int
+}
+
+// NewPocCounter POC计算
+func NewPocCounter() *PocCounter {
+	return &PocCounter{}
+}
+
+// SPS 当前使用的SPS, 还没有时为nil
+func (c *PocCounter) SPS() *SPS {
+	return c.sps
+}
+
+// SetConfig 从 AVCDecoderConfigurationRecord(FLV的AVC序列头)中读取第一个SPS
+func (c *PocCounter) SetConfig(record []byte) error {
+	sps, err := ParseConfig(record)
+	if err != nil {
+		return err
+	}
+
+	c.sps = sps
+	return nil
+}
+
+// ParseConfig 解析 AVCDecoderConfigurationRecord 中的第一个SPS
+func ParseConfig(record []byte) (*SPS, error) {
+	if len(record) < 8 || record[5]&0x1f == 0 {
+		return nil, errors.New("no sps in avc decoder configuration record")
+	}
+
+	n := int(record[6])<<8 | int(record[7])
+	if len(record) < 8+n {
+		return nil, errors.New("incomplete sps data")
+	}
+
+	return ParseSPS(record[8 : 8+n])
+}
+
+// Frame 计算一帧(AVCC格式或者以start code开始的Annex-b格式)的POC, 并返回是否为IDR
+// 帧中带有的SPS替换当前的SPS; 以帧中第一个slice计算
+func (c *PocCounter) Frame(b []byte) (int, bool, error) {
+	nalus, err := splitFrame(b)
+	if err != nil {
+		return 0, false, err
+	}
+
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+
+		switch nalu[0] & 0x1f {
+		case naluTypeSps:
+			sps, err := ParseSPS(nalu)
+			if err != nil {
+				return 0, false, err
+			}
+			c.sps = sps
+		case naluTypeSlice, naluTypeIdr:
+			if c.sps == nil {
+				return 0, false, ErrNoSPS
+			}
+
+			s, err := ParseSlice(nalu, c.sps)
+			if err != nil {
+				return 0, false, err
+			}
+
+			poc, err := c.poc(s)
+			return poc, s.Idr, err
+		}
+	}
+
+	return 0, false, errors.New("no slice in frame")
+}
+
+// poc 按照解码顺序计算slice所在图像的POC(H.264 8.2.1)
+func (c *PocCounter) poc(s *Slice) (int, error) {
+	switch c.sps.PicOrderCntType {
+	case 0:
+		if s.Idr {
+			c.prevMsb, c.prevLsb = 0, 0
+		}
+
+		maxLsb := 1 << uint(c.sps.Log2MaxPocLsb)
+		msb := c.prevMsb
+		switch {
+		case s.PicOrderCntLsb < c.prevLsb && c.prevLsb-s.PicOrderCntLsb >= maxLsb/2:
+			msb += maxLsb
+		case s.PicOrderCntLsb > c.prevLsb && s.PicOrderCntLsb-c.prevLsb > maxLsb/2:
+			msb -= maxLsb
+		}
+
+		if s.Reference {
+			c.prevMsb, c.prevLsb = msb, s.PicOrderCntLsb
+		}
+
+		return msb + s.PicOrderCntLsb, nil
+	case 2:
+		switch {
+		case s.Idr:
+			c.frameNumOffset = 0
+		case c.prevFrameNum > s.FrameNum:
+			c.frameNumOffset += 1 << uint(c.sps.Log2MaxFrameNum)
+		}
+		c.prevFrameNum = s.FrameNum
+
+		if s.Idr {
+			return 0, nil
+		}
+
+		poc := 2 * (c.frameNumOffset + s.FrameNum)
+		if !s.Reference {
+			poc--
+		}
+
+		return poc, nil
+	}
+
+	return 0, ErrUnsupportedPoc
+}
+
+// splitFrame 切分一帧中的NALU, 以start code开始时按照Annex-b格式, 否则按照4字节长度的AVCC格式
+func splitFrame(b []byte) ([][]byte, error) {
+	p := Parser{}
+	if p.isStartAtNaluHeader(b) {
+		return splitAnnexb(b), nil
+	}
+
+	var nalus [][]byte
+	for len(b) > 0 {
+		size, err := p.naluSize(b)
+		if err != nil {
+			return nil, err
+		}
+
+		b = b[naluBytesLen:]
+		if size <= 0 || size > len(b) {
+			return nil, fmt.Errorf("invalid nalu size %d", size)
+		}
+
+		nalus = append(nalus, b[:size])
+		b = b[size:]
+	}
+
+	return nalus, nil
+}
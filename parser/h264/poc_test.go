@@ -0,0 +1,298 @@
+package h264
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bitWriter 按位写入, 用于构造SPS和slice头
+type bitWriter struct {
+	b []byte
+	n int
+}
+
+func (w *bitWriter) bits(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.b = append(w.b, 0)
+		}
+		w.b[len(w.b)-1] |= byte((v>>uint(i))&1) << uint(7-w.n%8)
+		w.n++
+	}
+}
+
+func (w *bitWriter) ue(v int) {
+	v++
+	n := 0
+	for x := v; x > 1; x >>= 1 {
+		n++
+	}
+	w.bits(0, n)
+	w.bits(v, n+1)
+}
+
+func (w *bitWriter) se(v int) {
+	if v > 0 {
+		w.ue(2*v - 1)
+	} else {
+		w.ue(-2 * v)
+	}
+}
+
+// bytes 写入 rbsp_trailing_bits, 并加入防竞争字节
+func (w *bitWriter) bytes() []byte {
+	w.bits(1, 1)
+	var out []byte
+	zeros := 0
+	for _, c := range w.b {
+		if zeros >= 2 && c <= 0x03 {
+			out = append(out, 0x03)
+			zeros = 0
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+
+	return out
+}
+
+// testSPS High profile的SPS, pocType为0时 pic_order_cnt_lsb 为6位; reorder小于0时没有VUI
+func testSPS(pocType, reorder int) []byte {
+	w := &bitWriter{}
+	w.bits(100, 8) // profile_idc
+	w.bits(0, 8)
+	w.bits(31, 8) // level_idc
+	w.ue(0)       // seq_parameter_set_id
+	w.ue(1)       // chroma_format_idc
+	w.ue(0)
+	w.ue(0)
+	w.bits(0, 1)
+	w.bits(1, 1) // seq_scaling_matrix_present_flag
+	for i := 0; i < 8; i++ {
+		w.bits(i&1, 1)
+		if i&1 == 1 {
+			size := 16
+			if i >= 6 {
+				size = 64
+			}
+			for j := 0; j < size; j++ {
+				w.se(1)
+			}
+		}
+	}
+	w.ue(0) // log2_max_frame_num_minus4
+	w.ue(pocType)
+	if pocType == 0 {
+		w.ue(2) // log2_max_pic_order_cnt_lsb_minus4
+	}
+	w.ue(4)      // max_num_ref_frames
+	w.bits(0, 1) // gaps_in_frame_num_value_allowed_flag
+	w.ue(119)
+	w.ue(67)
+	w.bits(1, 1) // frame_mbs_only_flag
+	w.bits(1, 1) // direct_8x8_inference_flag
+	w.bits(1, 1) // frame_cropping_flag
+	w.ue(0)
+	w.ue(0)
+	w.ue(0)
+	w.ue(4)
+
+	w.bits(boolBit(reorder >= 0), 1)
+	if reorder >= 0 {
+		w.bits(1, 1) // aspect_ratio_info_present_flag
+		w.bits(255, 8)
+		w.bits(1, 16)
+		w.bits(1, 16)
+		w.bits(0, 1) // overscan_info_present_flag
+		w.bits(1, 1) // video_signal_type_present_flag
+		w.bits(5, 3)
+		w.bits(0, 1)
+		w.bits(1, 1)
+		w.bits(1, 8)
+		w.bits(1, 8)
+		w.bits(1, 8)
+		w.bits(0, 1) // chroma_loc_info_present_flag
+		w.bits(1, 1) // timing_info_present_flag
+		w.bits(1, 32)
+		w.bits(50, 32)
+		w.bits(0, 1)
+		w.bits(1, 1) // nal_hrd_parameters_present_flag
+		w.ue(0)
+		w.bits(0, 8)
+		w.ue(100)
+		w.ue(200)
+		w.bits(0, 1)
+		w.bits(0, 20)
+		w.bits(0, 1) // vcl_hrd_parameters_present_flag
+		w.bits(0, 1) // low_delay_hrd_flag
+		w.bits(0, 1) // pic_struct_present_flag
+		w.bits(1, 1) // bitstream_restriction_flag
+		w.bits(1, 1)
+		w.ue(2)
+		w.ue(1)
+		w.ue(16)
+		w.ue(16)
+		w.ue(reorder)
+		w.ue(4)
+	}
+
+	return append([]byte{0x67}, w.bytes()...)
+}
+
+func boolBit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// testSlice slice头(SPS为 testSPS), 后面跟随若干字节的slice数据
+func testSlice(idr, ref bool, frameNum, lsb int) []byte {
+	header := byte(0x01)
+	if idr {
+		header = 0x05
+	}
+	if ref {
+		header |= 0x60
+	}
+
+	w := &bitWriter{}
+	w.ue(0) // first_mb_in_slice
+	w.ue(7) // slice_type
+	w.ue(0) // pic_parameter_set_id
+	w.bits(frameNum, 4)
+	if idr {
+		w.ue(0) // idr_pic_id
+	}
+	w.bits(lsb, 6)
+
+	return append(append([]byte{header}, w.bytes()...), 0x00, 0x00, 0x00, 0x01, 0x88)
+}
+
+func TestParseSPS(t *testing.T) {
+	at := assert.New(t)
+
+	sps, err := ParseSPS(testSPS(0, 2))
+	at.Nil(err)
+	at.Equal(uint8(100), sps.ProfileIdc)
+	at.Equal(uint8(31), sps.LevelIdc)
+	at.Equal(4, sps.Log2MaxFrameNum)
+	at.Equal(0, sps.PicOrderCntType)
+	at.Equal(6, sps.Log2MaxPocLsb)
+	at.True(sps.FrameMbsOnly)
+	at.Equal(2, sps.MaxNumReorderFrames)
+
+	// 没有VUI
+	sps, err = ParseSPS(testSPS(2, -1))
+	at.Nil(err)
+	at.Equal(2, sps.PicOrderCntType)
+	at.Equal(-1, sps.MaxNumReorderFrames)
+
+	// 重排序帧数和帧率: VUI中的值, 没有VUI时按照level 3.1的MaxDpbMbs和120x68个宏块推算
+	sps, err = ParseSPS(testSPS(0, 2))
+	at.Nil(err)
+	at.Equal(2, sps.ReorderFrames())
+	at.Equal(float64(25), sps.FrameRate())
+	sps, err = ParseSPS(testSPS(0, -1))
+	at.Nil(err)
+	at.Equal(120, sps.PicWidthInMbs)
+	at.Equal(68, sps.FrameHeightInMbs)
+	at.Equal(2, sps.ReorderFrames())
+	at.Equal(float64(0), sps.FrameRate())
+	sps.LevelIdc = 51
+	at.Equal(16, sps.ReorderFrames())
+	sps.ConstraintSet3 = true
+	at.Equal(0, sps.ReorderFrames())
+	sps.ProfileIdc, sps.ConstraintSet3 = 66, false
+	at.Equal(0, sps.ReorderFrames())
+
+	// 不完整的SPS
+	b := testSPS(0, 2)
+	_, err = ParseSPS(b[:len(b)-8])
+	at.NotNil(err)
+	_, err = ParseSPS([]byte{0x68, 0x00, 0x00, 0x00})
+	at.NotNil(err)
+}
+
+func TestPocCounter(t *testing.T) {
+	at := assert.New(t)
+
+	// 没有SPS
+	c := NewPocCounter()
+	_, _, err := c.Frame(append([]byte{0x00, 0x00, 0x00, 0x01}, testSlice(true, true, 0, 0)...))
+	at.Equal(ErrNoSPS, err)
+
+	// 序列头中的SPS
+	sps := testSPS(0, 1)
+	record := append([]byte{0x01, 0x64, 0x00, 0x1f, 0xff, 0xe1, 0x00, byte(len(sps))}, sps...)
+	record = append(record, 0x01, 0x00, 0x02, 0x68, 0xee)
+	at.Nil(c.SetConfig(record))
+	at.Equal(1, c.SPS().MaxNumReorderFrames)
+
+	// AVCC格式, 解码顺序: I0 P6 B2 B4 P12 B8 B10..., B帧不是参考帧, 低位(6位)回绕后POC继续递增
+	avcc := func(nalu []byte) []byte {
+		n := len(nalu)
+		return append([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}, nalu...)
+	}
+
+	poc, idr, err := c.Frame(avcc(testSlice(true, true, 0, 0)))
+	at.Nil(err)
+	at.True(idr)
+	at.Equal(0, poc)
+
+	var pocs []int
+	for i := 0; i < 8; i++ {
+		base := i * 6
+		for _, s := range []struct {
+			ref bool
+			poc int
+		}{{true, base + 6}, {false, base + 2}, {false, base + 4}} {
+			poc, idr, err = c.Frame(avcc(testSlice(false, s.ref, i+1, s.poc%64)))
+			at.Nil(err)
+			at.False(idr)
+			pocs = append(pocs, poc)
+		}
+	}
+	at.Equal([]int{6, 2, 4, 12, 8, 10, 18, 14, 16, 24, 20, 22, 30, 26, 28, 36, 32, 34, 42, 38, 40, 48, 44, 46}, pocs)
+
+	// Annex-b格式, 帧中的SPS替换当前的SPS, IDR重置POC
+	frame := []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xf0, 0x00, 0x00, 0x00, 0x01}
+	frame = append(frame, testSPS(2, -1)...)
+	frame = append(frame, 0x00, 0x00, 0x00, 0x01)
+	frame = append(frame, testSlice(true, true, 0, 0)...)
+	poc, idr, err = c.Frame(frame)
+	at.Nil(err)
+	at.True(idr)
+	at.Equal(0, poc)
+	at.Equal(2, c.SPS().PicOrderCntType)
+
+	// pic_order_cnt_type 2: POC与解码顺序相同, frame_num回绕后继续递增
+	pocs = pocs[:0]
+	for i := 1; i < 20; i++ {
+		w := &bitWriter{}
+		w.ue(0)
+		w.ue(5)
+		w.ue(0)
+		w.bits(i%16, 4)
+		poc, _, err = c.Frame(avcc(append([]byte{0x41}, w.bytes()...)))
+		at.Nil(err)
+		pocs = append(pocs, poc)
+	}
+	for i, poc := range pocs {
+		at.Equal(2*(i+1), poc)
+	}
+
+	// 不支持的POC类型, 没有slice
+	c.sps.PicOrderCntType = 1
+	_, _, err = c.Frame(avcc(testSlice(false, true, 1, 0)))
+	at.Equal(ErrUnsupportedPoc, err)
+	_, _, err = c.Frame(avcc([]byte{0x06, 0x05}))
+	at.NotNil(err)
+	_, _, err = c.Frame([]byte{0x00, 0x00, 0x00, 0x10, 0x65})
+	at.NotNil(err)
+}
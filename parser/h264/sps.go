@@ -0,0 +1,424 @@
+package h264
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SPS 序列参数集中计算POC和重排序需要的字段
+type SPS struct {
+	ProfileIdc              uint8
+	LevelIdc                uint8
+	ID                      int
+	SeparateColourPlane     bool
+	Log2MaxFrameNum         int  // frame_num的位数
+	PicOrderCntType         int  // 0: 直接携带POC的低位, 1: 按照参考帧周期推算, 2: POC与解码顺序相同
+	Log2MaxPocLsb           int  // pic_order_cnt_lsb的位数(PicOrderCntType为0时)
+	DeltaPicOrderAlwaysZero bool // PicOrderCntType为1时
+	FrameMbsOnly            bool
+	ConstraintSet3          bool
+	MaxNumRefFrames         int
+	PicWidthInMbs           int
+	FrameHeightInMbs        int
+	NumUnitsInTick          int // VUI中的时间信息, 没有时为0
+	TimeScale               int
+	MaxNumReorderFrames     int // 显示顺序先于解码顺序的最大帧数, VUI中没有时为-1
+}
+
+// 各个level的MaxDpbMbs(H.264 表A-1), level 1b的level_idc为9(或者11且constraint_set3_flag为1)
+var maxDpbMbs = map[uint8]int{
+	9: 396, 10: 396, 11: 900, 12: 2376, 13: 2376, 20: 2376, 21: 4752, 22: 8100,
+	30: 8100, 31: 18000, 32: 20480, 40: 32768, 41: 32768, 42: 34816,
+	50: 110400, 51: 184320, 52: 184320, 60: 696320, 61: 696320, 62: 696320,
+}
+
+// 不支持B帧的profile: Baseline, 以及constraint_set3_flag为1时的Intra profile
+var intraProfiles = map[uint8]bool{44: true, 86: true, 100: true, 110: true, 122: true, 244: true}
+
+// ReorderFrames 重排序帧数: 优先使用VUI中的 max_num_reorder_frames
+// 没有时按照H.264 E.2.1取 max_dec_frame_buffering 的默认值 MaxDpbFrames(由level和图像大小推算), Baseline和Intra profile为0
+func (sps *SPS) ReorderFrames() int {
+	if sps.MaxNumReorderFrames >= 0 {
+		return sps.MaxNumReorderFrames
+	}
+	if sps.ProfileIdc == 66 || (sps.ConstraintSet3 && intraProfiles[sps.ProfileIdc]) {
+		return 0
+	}
+
+	mbs := maxDpbMbs[sps.LevelIdc]
+	if sps.LevelIdc == 11 && sps.ConstraintSet3 {
+		mbs = maxDpbMbs[9]
+	}
+	if mbs == 0 || sps.PicWidthInMbs*sps.FrameHeightInMbs == 0 {
+		return sps.MaxNumRefFrames
+	}
+
+	n := mbs / (sps.PicWidthInMbs * sps.FrameHeightInMbs)
+	if n > 16 {
+		n = 16
+	}
+
+	return n
+}
+
+// FrameRate VUI时间信息中的帧率(time_scale / (2 * num_units_in_tick)), 没有时为0
+func (sps *SPS) FrameRate() float64 {
+	if sps.NumUnitsInTick == 0 {
+		return 0
+	}
+
+	return float64(sps.TimeScale) / float64(2*sps.NumUnitsInTick)
+}
+
+// 带有 chroma_format_idc 等扩展字段的profile
+var highProfiles = map[uint8]bool{
+	100: true, 110: true, 122: true, 244: true, 44: true, 83: true, 86: true,
+	118: true, 128: true, 138: true, 139: true, 134: true, 135: true,
+}
+
+// ParseSPS 解析SPS的NALU(含NALU头, 不含start code)
+func ParseSPS(nalu []byte) (*SPS, error) {
+	if len(nalu) < 4 || nalu[0]&0x1f != naluTypeSps {
+		return nil, errors.New("not a sps nalu")
+	}
+
+	r := NewBitReader(Unescape(nalu[1:]))
+	sps := &SPS{MaxNumReorderFrames: -1}
+
+	sps.ProfileIdc = uint8(r.Bits(8))
+	sps.ConstraintSet3 = r.Bits(8)&0x10 != 0 // constraint_set_flags, reserved_zero_2bits
+	sps.LevelIdc = uint8(r.Bits(8))
+	sps.ID = r.UE()
+
+	if highProfiles[sps.ProfileIdc] {
+		chromaFormatIdc := r.UE()
+		if chromaFormatIdc == 3 {
+			sps.SeparateColourPlane = r.Flag()
+		}
+		r.UE()    // bit_depth_luma_minus8
+		r.UE()    // bit_depth_chroma_minus8
+		r.Skip(1) // qpprime_y_zero_transform_bypass_flag
+
+		// seq_scaling_matrix_present_flag
+		if r.Flag() {
+			n := 8
+			if chromaFormatIdc == 3 {
+				n = 12
+			}
+			for i := 0; i < n; i++ {
+				if !r.Flag() {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				r.scalingList(size)
+			}
+		}
+	}
+
+	sps.Log2MaxFrameNum = r.UE() + 4
+	sps.PicOrderCntType = r.UE()
+	switch sps.PicOrderCntType {
+	case 0:
+		sps.Log2MaxPocLsb = r.UE() + 4
+	case 1:
+		sps.DeltaPicOrderAlwaysZero = r.Flag()
+		r.SE() // offset_for_non_ref_pic
+		r.SE() // offset_for_top_to_bottom_field
+		n := r.UE()
+		for i := 0; i < n && r.err == nil; i++ {
+			r.SE() // offset_for_ref_frame
+		}
+	case 2:
+	default:
+		return nil, fmt.Errorf("invalid pic_order_cnt_type %d", sps.PicOrderCntType)
+	}
+
+	sps.MaxNumRefFrames = r.UE()
+	r.Skip(1) // gaps_in_frame_num_value_allowed_flag
+	sps.PicWidthInMbs = r.UE() + 1
+	heightInMapUnits := r.UE() + 1
+	sps.FrameMbsOnly = r.Flag()
+	sps.FrameHeightInMbs = heightInMapUnits
+	if !sps.FrameMbsOnly {
+		sps.FrameHeightInMbs *= 2
+	}
+	if !sps.FrameMbsOnly {
+		r.Skip(1) // mb_adaptive_frame_field_flag
+	}
+	r.Skip(1) // direct_8x8_inference_flag
+
+	// frame_cropping_flag
+	if r.Flag() {
+		for i := 0; i < 4; i++ {
+			r.UE()
+		}
+	}
+
+	// vui_parameters_present_flag
+	if r.Flag() {
+		sps.parseVUI(r)
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("incomplete sps: %v", r.err)
+	}
+	if sps.Log2MaxFrameNum > 16 || sps.Log2MaxPocLsb > 16 {
+		return nil, errors.New("invalid sps")
+	}
+
+	return sps, nil
+}
+
+// parseVUI 从VUI中读取时间信息和 max_num_reorder_frames
+func (sps *SPS) parseVUI(r *BitReader) {
+	// aspect_ratio_info_present_flag
+	if r.Flag() && r.Bits(8) == 255 {
+		r.Skip(32) // sar_width, sar_height
+	}
+
+	// overscan_info_present_flag
+	if r.Flag() {
+		r.Skip(1)
+	}
+
+	// video_signal_type_present_flag
+	if r.Flag() {
+		r.Skip(4) // video_format, video_full_range_flag
+		if r.Flag() {
+			r.Skip(24) // colour_primaries, transfer_characteristics, matrix_coefficients
+		}
+	}
+
+	// chroma_loc_info_present_flag
+	if r.Flag() {
+		r.UE()
+		r.UE()
+	}
+
+	// timing_info_present_flag
+	if r.Flag() {
+		unitsInTick, timeScale := r.Bits(32), r.Bits(32)
+		r.Skip(1) // fixed_frame_rate_flag
+		if r.err == nil {
+			sps.NumUnitsInTick, sps.TimeScale = unitsInTick, timeScale
+		}
+	}
+
+	nalHrd := r.Flag()
+	if nalHrd {
+		r.hrd()
+	}
+	vclHrd := r.Flag()
+	if vclHrd {
+		r.hrd()
+	}
+	if nalHrd || vclHrd {
+		r.Skip(1) // low_delay_hrd_flag
+	}
+	r.Skip(1) // pic_struct_present_flag
+
+	// bitstream_restriction_flag
+	if r.Flag() {
+		r.Skip(1) // motion_vectors_over_pic_boundaries_flag
+		r.UE()    // max_bytes_per_pic_denom
+		r.UE()    // max_bits_per_mb_denom
+		r.UE()    // log2_max_mv_length_horizontal
+		r.UE()    // log2_max_mv_length_vertical
+		reorder := r.UE()
+		if r.err == nil {
+			sps.MaxNumReorderFrames = reorder
+		}
+	}
+}
+
+// Slice slice头中计算POC需要的字段
+type Slice struct {
+	Idr            bool
+	Reference      bool // nal_ref_idc不为0
+	FrameNum       int
+	FieldPic       bool
+	BottomField    bool
+	PicOrderCntLsb int
+}
+
+// ParseSlice 根据SPS解析slice头(含NALU头, 不含start code)
+func ParseSlice(nalu []byte, sps *SPS) (*Slice, error) {
+	if len(nalu) < 2 {
+		return nil, errors.New("incomplete slice")
+	}
+
+	t := nalu[0] & 0x1f
+	if t != naluTypeSlice && t != naluTypeIdr {
+		return nil, fmt.Errorf("not a slice nalu, type=%d", t)
+	}
+
+	// slice头不超过几十个字节, 只转换开头的部分
+	b := nalu[1:]
+	if len(b) > 64 {
+		b = b[:64]
+	}
+
+	r := NewBitReader(Unescape(b))
+	s := &Slice{
+		Idr:       t == naluTypeIdr,
+		Reference: nalu[0]&0x60 != 0,
+	}
+
+	r.UE() // first_mb_in_slice
+	r.UE() // slice_type
+	r.UE() // pic_parameter_set_id
+	if sps.SeparateColourPlane {
+		r.Skip(2) // colour_plane_id
+	}
+	s.FrameNum = r.Bits(sps.Log2MaxFrameNum)
+	if !sps.FrameMbsOnly {
+		s.FieldPic = r.Flag()
+		if s.FieldPic {
+			s.BottomField = r.Flag()
+		}
+	}
+	if s.Idr {
+		r.UE() // idr_pic_id
+	}
+	if sps.PicOrderCntType == 0 {
+		s.PicOrderCntLsb = r.Bits(sps.Log2MaxPocLsb)
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("incomplete slice header: %v", r.err)
+	}
+
+	return s, nil
+}
+
+// Unescape 去除防竞争字节(00 00 03中的03), 得到RBSP; H.264和H.265相同
+func Unescape(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	zeros := 0
+	for _, c := range b {
+		if zeros >= 2 && c == 0x03 {
+			zeros = 0
+			continue
+		}
+
+		if c == 0x00 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+
+	return out
+}
+
+// errBitsEOF 读取超过了数据的末尾
+var errBitsEOF = errors.New("unexpected end of bits")
+
+// BitReader 按位读取RBSP, 包括指数哥伦布编码; 出错后的读取都返回0, 第一个错误由 Err 返回
+type BitReader struct {
+	b   []byte
+	pos int // 已读取的位数
+	err error
+}
+
+// NewBitReader 按位读取(已经去除防竞争字节的)数据
+func NewBitReader(b []byte) *BitReader {
+	return &BitReader{b: b}
+}
+
+// Err 读取过程中的第一个错误
+func (r *BitReader) Err() error {
+	return r.err
+}
+
+// Bits 读取n(不超过32)位
+func (r *BitReader) Bits(n int) int {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos+n > len(r.b)*8 {
+		r.err = errBitsEOF
+		return 0
+	}
+
+	v := 0
+	for i := 0; i < n; i++ {
+		v = v<<1 | int(r.b[r.pos>>3]>>(7-uint(r.pos&7))&1)
+		r.pos++
+	}
+
+	return v
+}
+
+// Skip 跳过n位
+func (r *BitReader) Skip(n int) {
+	if r.err != nil {
+		return
+	}
+	if r.pos+n > len(r.b)*8 {
+		r.err = errBitsEOF
+		return
+	}
+
+	r.pos += n
+}
+
+// Flag 读取1位
+func (r *BitReader) Flag() bool {
+	return r.Bits(1) == 1
+}
+
+// UE 无符号指数哥伦布编码
+func (r *BitReader) UE() int {
+	zeros := 0
+	for r.err == nil && !r.Flag() {
+		zeros++
+		if zeros > 31 {
+			r.err = errors.New("invalid exp-golomb code")
+		}
+	}
+	if r.err != nil {
+		return 0
+	}
+
+	return 1<<uint(zeros) - 1 + r.Bits(zeros)
+}
+
+// SE 有符号指数哥伦布编码
+func (r *BitReader) SE() int {
+	v := r.UE()
+	if v&1 == 1 {
+		return (v + 1) / 2
+	}
+
+	return -v / 2
+}
+
+// scalingList 跳过 scaling_list
+func (r *BitReader) scalingList(size int) {
+	last, next := 8, 8
+	for i := 0; i < size && r.err == nil; i++ {
+		if next != 0 {
+			next = (last + r.SE() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// hrd 跳过 hrd_parameters
+func (r *BitReader) hrd() {
+	n := r.UE() + 1 // cpb_cnt_minus1
+	r.Skip(8)       // bit_rate_scale, cpb_size_scale
+	for i := 0; i < n && r.err == nil; i++ {
+		r.UE()    // bit_rate_value_minus1
+		r.UE()    // cpb_size_value_minus1
+		r.Skip(1) // cbr_flag
+	}
+	r.Skip(20) // initial_cpb_removal_delay_length_minus1, cpb_removal_delay_length_minus1, dpb_output_delay_length_minus1, time_offset_length
+}
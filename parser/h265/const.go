@@ -0,0 +1,21 @@
+package h265
+
+// nalu 类型
+const (
+	naluTypeBlaWLp    byte = 16 // IRAP: BLA_W_LP
+	naluTypeRsvIrap23 byte = 23 // IRAP: RSV_IRAP_VCL23, IRAP的最大值
+	naluTypeVps       byte = 32 // video_parameter_set_rbsp( )
+	naluTypeSps       byte = 33 // seq_parameter_set_rbsp( )
+	naluTypePps       byte = 34 // pic_parameter_set_rbsp( )
+	naluTypeAud       byte = 35 // access_unit_delimiter_rbsp( )
+)
+
+const (
+	hvccHeaderLen int = 23 // HEVCDecoderConfigurationRecord 固定头(包括numOfArrays)的长度
+	maxSpsPpsLen  int = 2 * 1024
+)
+
+var startCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+// 访问单元分隔符: nal_unit_type=35, nuh_temporal_id_plus1=1, pic_type=2(I/P/B)
+var naluAud = []byte{0x00, 0x00, 0x00, 0x01, 0x46, 0x01, 0x50}
@@ -0,0 +1,175 @@
+package h265
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Parser H265解析器
+type Parser struct {
+	specificInfo []byte        /* 序列头中的VPS, SPS和PPS, 均包含start code */
+	paramSets    *bytes.Buffer /* 视频包中的VPS, SPS和PPS, 均包含start code */
+	naluLen      int           /* NALU长度字段的字节数 */
+}
+
+// NewParser 初始化h265解析器(vps/sps/pps)
+func NewParser() *Parser {
+	return &Parser{
+		paramSets: bytes.NewBuffer(make([]byte, 0, maxSpsPpsLen)),
+		naluLen:   4,
+	}
+}
+
+// Parse 将H265打包格式(HVCC)转换为 Annex-b 的网络流格式, 写入w中
+func (p *Parser) Parse(b []byte, isSeqHdr bool, w io.Writer) error {
+	if len(b) == 0 || w == nil {
+		return errors.New("no data to parse or nil writer")
+	}
+
+	// [HVCC格式]如果是序列头, 则解析出VPS, SPS和PPS
+	if isSeqHdr {
+		return p.parseSpecificInfo(b)
+	}
+
+	// [Annex-b格式]直接写入以Nalu开头的数据
+	if p.isStartAtNaluHeader(b) {
+		_, err := w.Write(b)
+		return err
+	}
+
+	// [HVCC格式]转换为Annex-b格式并写入数据
+	return p.getAnnexbH265(b, w)
+}
+
+// IsIRAP 判断NALU类型是否是随机访问点(IRAP: BLA, IDR, CRA)
+func IsIRAP(naluType byte) bool {
+	return naluType >= naluTypeBlaWLp && naluType <= naluTypeRsvIrap23
+}
+
+// NaluType 返回NALU头中的nal_unit_type
+func NaluType(header byte) byte {
+	return (header >> 1) & 0x3f
+}
+
+// [HVCC格式]解析 HEVCDecoderConfigurationRecord, 向specificInfo填充所有参数集
+func (p *Parser) parseSpecificInfo(src []byte) error {
+	if len(src) < hvccHeaderLen {
+		return fmt.Errorf("incomplete data, len(src)<%d", hvccHeaderLen)
+	}
+
+	var info []byte
+
+	// [21]lengthSizeMinusOne: 低2位
+	p.naluLen = int(src[21]&0x03) + 1
+
+	// [22]numOfArrays, 每组: array_completeness(1), reserved(1), NAL_unit_type(6), numNalus(16), {nalUnitLength(16), nalUnit}
+	num := int(src[22])
+	b := src[hvccHeaderLen:]
+	for i := 0; i < num; i++ {
+		if len(b) < 3 {
+			return errors.New("incomplete hvcc array")
+		}
+
+		n := int(b[1])<<8 | int(b[2])
+		b = b[3:]
+		for j := 0; j < n; j++ {
+			if len(b) < 2 {
+				return errors.New("incomplete hvcc nalu length")
+			}
+
+			size := int(b[0])<<8 | int(b[1])
+			if len(b[2:]) < size || size <= 0 {
+				return errors.New("incomplete hvcc nalu")
+			}
+
+			info = append(info, startCode...)
+			info = append(info, b[2:2+size]...)
+			b = b[2+size:]
+		}
+	}
+
+	p.specificInfo = info
+	return nil
+}
+
+// 判断数据是否是以NALU头开始, Annex-b格式以NALU头开始
+func (p *Parser) isStartAtNaluHeader(src []byte) bool {
+	if len(src) < 4 {
+		return false
+	}
+
+	return src[0] == 0x00 && src[1] == 0x00 && src[2] == 0x00 && src[3] == 0x01
+}
+
+// [HVCC->Annex-b]将以 HVCC 作为打包格式转换为以 Annex-b 作为打包格式的H265数据写入w中
+// 数据前插入访问单元分隔符, 第一个IRAP之前插入参数集(优先使用视频包中的参数集)
+func (p *Parser) getAnnexbH265(src []byte, w io.Writer) error {
+	if len(src) < p.naluLen {
+		return errors.New("incomplete h265 header")
+	}
+
+	// 写入访问单元分隔符
+	_, err := w.Write(naluAud)
+	if err != nil {
+		return err
+	}
+
+	hasWriteParamSets := false
+	p.paramSets.Reset()
+
+	for len(src) > 0 {
+		// 取出nalu的size
+		if len(src) < p.naluLen {
+			return errors.New("[hvcc]incomplete nalu data")
+		}
+
+		var nalLen int
+		for _, v := range src[:p.naluLen] {
+			nalLen = nalLen<<8 | int(v)
+		}
+		src = src[p.naluLen:]
+
+		if nalLen < 2 || len(src) < nalLen {
+			return errors.New("invalid nalu body")
+		}
+
+		nalu := src[:nalLen]
+		src = src[nalLen:]
+
+		switch nalType := NaluType(nalu[0]); {
+		case nalType == naluTypeAud:
+			// 已经写入了访问单元分隔符
+		case nalType == naluTypeVps || nalType == naluTypeSps || nalType == naluTypePps:
+			p.paramSets.Write(startCode)
+			p.paramSets.Write(nalu)
+		default:
+			if IsIRAP(nalType) && !hasWriteParamSets {
+				hasWriteParamSets = true
+
+				info := p.specificInfo
+				if p.paramSets.Len() > 0 {
+					info = p.paramSets.Bytes()
+				}
+
+				_, err = w.Write(info)
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err = w.Write(startCode)
+			if err != nil {
+				return err
+			}
+
+			_, err = w.Write(nalu)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
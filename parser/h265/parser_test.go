@@ -0,0 +1,90 @@
+package h265
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	vps = []byte{0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x5a, 0x95, 0x98, 0x09}
+	sps = []byte{0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x5a, 0xa0, 0x05, 0x02, 0x01, 0xe1, 0x65, 0x95, 0x9a, 0x49, 0x32, 0xbc, 0x05, 0xa8}
+	pps = []byte{0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40}
+)
+
+// hvcc 生成只包含参数集的 HEVCDecoderConfigurationRecord
+func hvcc() []byte {
+	b := make([]byte, hvccHeaderLen)
+	b[0] = 0x01
+	b[21] = 0x0f
+	b[22] = 3
+	for i, nalu := range [][]byte{vps, sps, pps} {
+		b = append(b, 0x80|byte(32+i), 0x00, 0x01, byte(len(nalu)>>8), byte(len(nalu)))
+		b = append(b, nalu...)
+	}
+
+	return b
+}
+
+func join(nalus ...[]byte) []byte {
+	var b []byte
+	for _, nalu := range nalus {
+		b = append(b, startCode...)
+		b = append(b, nalu...)
+	}
+
+	return b
+}
+
+// 解复用序列头测试
+func TestH265SeqDemux(t *testing.T) {
+	at := assert.New(t)
+
+	d := NewParser()
+	w := bytes.NewBuffer(nil)
+
+	at.Nil(d.Parse(hvcc(), true, w))
+	at.Equal(join(vps, sps, pps), d.specificInfo)
+	at.Equal(0, w.Len())
+
+	at.NotNil(d.Parse(hvcc()[:10], true, w))
+}
+
+// HVCC转换为Annex-b测试
+func TestH265HvccDemux(t *testing.T) {
+	at := assert.New(t)
+
+	d := NewParser()
+	at.Nil(d.Parse(hvcc(), true, bytes.NewBuffer(nil)))
+
+	// IDR_W_RADL(19): 插入访问单元分隔符和参数集
+	idr := []byte{0x26, 0x01, 0xaf, 0x09}
+	w := bytes.NewBuffer(nil)
+	at.Nil(d.Parse([]byte{0x00, 0x00, 0x00, 0x04, 0x26, 0x01, 0xaf, 0x09}, false, w))
+	at.Equal(append(append([]byte(nil), naluAud...), join(vps, sps, pps, idr)...), w.Bytes())
+
+	// TRAIL_R(1): 只插入访问单元分隔符, 原有的分隔符被丢弃
+	trail := []byte{0x02, 0x01, 0xd0, 0x10}
+	w.Reset()
+	at.Nil(d.Parse([]byte{0x00, 0x00, 0x00, 0x03, 0x46, 0x01, 0x50, 0x00, 0x00, 0x00, 0x04, 0x02, 0x01, 0xd0, 0x10}, false, w))
+	at.Equal(append(append([]byte(nil), naluAud...), join(trail)...), w.Bytes())
+
+	// Annex-b直接写入
+	w.Reset()
+	at.Nil(d.Parse(join(trail), false, w))
+	at.Equal(join(trail), w.Bytes())
+
+	// NALU长度错误
+	at.NotNil(d.Parse([]byte{0x00, 0x00, 0x00, 0x10, 0x02, 0x01}, false, w))
+}
+
+func TestIsIRAP(t *testing.T) {
+	at := assert.New(t)
+
+	at.Equal(byte(19), NaluType(0x26))
+	at.True(IsIRAP(NaluType(0x26)))
+	at.True(IsIRAP(21))
+	at.False(IsIRAP(1))
+	at.False(IsIRAP(32))
+}
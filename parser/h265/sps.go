@@ -0,0 +1,87 @@
+package h265
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nextpkg/goav/parser/h264"
+)
+
+// SPS 序列参数集中生成 HEVCDecoderConfigurationRecord 需要的字段
+type SPS struct {
+	MaxSubLayers      int    // sps_max_sub_layers_minus1 + 1
+	TemporalIDNesting bool   // sps_temporal_id_nesting_flag
+	ProfileTierLevel  []byte // general_profile_space 到 general_level_idc 的12个字节
+	ChromaFormatIdc   int    // 0: 单色, 1: 4:2:0, 2: 4:2:2, 3: 4:4:4
+	BitDepthLuma      int
+	BitDepthChroma    int
+}
+
+// ParseSPS 解析SPS的NALU(含2字节的NALU头, 不含start code), 读取到色度格式和位深度为止
+func ParseSPS(nalu []byte) (*SPS, error) {
+	if len(nalu) < 2 || NaluType(nalu[0]) != naluTypeSps {
+		return nil, errors.New("not a sps nalu")
+	}
+
+	b := h264.Unescape(nalu[2:])
+	if len(b) < 13 {
+		return nil, errors.New("incomplete sps")
+	}
+
+	sps := &SPS{
+		MaxSubLayers:      int(b[0]>>1&0x07) + 1,
+		TemporalIDNesting: b[0]&0x01 != 0,
+		ProfileTierLevel:  b[1:13],
+	}
+
+	// sps_video_parameter_set_id(4), sps_max_sub_layers_minus1(3), sps_temporal_id_nesting_flag(1), general_profile_tier_level(96)
+	r := h264.NewBitReader(b)
+	r.Skip(104)
+
+	// sub_layer_profile_present_flag, sub_layer_level_present_flag; 不足8个子层时补齐 reserved_zero_2bits
+	n := sps.MaxSubLayers - 1
+	profilePresent := make([]bool, n)
+	levelPresent := make([]bool, n)
+	for i := 0; i < n; i++ {
+		profilePresent[i] = r.Flag()
+		levelPresent[i] = r.Flag()
+	}
+	if n > 0 {
+		r.Skip(2 * (8 - n))
+	}
+	for i := 0; i < n; i++ {
+		if profilePresent[i] {
+			r.Skip(88)
+		}
+		if levelPresent[i] {
+			r.Skip(8) // sub_layer_level_idc
+		}
+	}
+
+	r.UE() // sps_seq_parameter_set_id
+	sps.ChromaFormatIdc = r.UE()
+	if sps.ChromaFormatIdc == 3 {
+		r.Skip(1) // separate_colour_plane_flag
+	}
+	r.UE() // pic_width_in_luma_samples
+	r.UE() // pic_height_in_luma_samples
+
+	// conformance_window_flag
+	if r.Flag() {
+		for i := 0; i < 4; i++ {
+			r.UE()
+		}
+	}
+
+	sps.BitDepthLuma = r.UE() + 8
+	sps.BitDepthChroma = r.UE() + 8
+
+	if r.Err() != nil {
+		return nil, fmt.Errorf("incomplete sps: %v", r.Err())
+	}
+	if sps.ChromaFormatIdc > 3 || sps.BitDepthLuma > 16 || sps.BitDepthChroma > 16 {
+		return nil, errors.New("invalid sps")
+	}
+
+	return sps, nil
+}
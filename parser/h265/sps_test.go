@@ -0,0 +1,65 @@
+package h265
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ue 无符号指数哥伦布编码的位串
+func ue(v int) string {
+	s := strconv.FormatInt(int64(v+1), 2)
+	return strings.Repeat("0", len(s)-1) + s
+}
+
+// spsNalu 位串加上 rbsp_trailing_bits 和SPS的NALU头
+func spsNalu(bits string) []byte {
+	bits += "1"
+	for len(bits)%8 != 0 {
+		bits += "0"
+	}
+
+	b := []byte{0x42, 0x01}
+	for i := 0; i < len(bits); i += 8 {
+		v, _ := strconv.ParseUint(bits[i:i+8], 2, 8)
+		b = append(b, byte(v))
+	}
+
+	return b
+}
+
+func TestParseSPS(t *testing.T) {
+	at := assert.New(t)
+
+	// Main, 4:2:0, 8bit
+	s, err := ParseSPS(sps)
+	at.Nil(err)
+	at.Equal(1, s.MaxSubLayers)
+	at.True(s.TemporalIDNesting)
+	at.Equal([]byte{0x01, 0x60, 0x00, 0x00, 0x00, 0x90, 0x00, 0x00, 0x00, 0x00, 0x00, 0x5a}, s.ProfileTierLevel)
+	at.Equal(1, s.ChromaFormatIdc)
+	at.Equal(8, s.BitDepthLuma)
+	at.Equal(8, s.BitDepthChroma)
+
+	// RExt, 2个子层(带有子层的profile和level), 4:2:2, 亮度10bit, 色度12bit, 带有裁剪窗口
+	ptl := "00" + "0" + "00100" + "00001000" + strings.Repeat("0", 24) + "1" + strings.Repeat("0", 47) + "01011101"
+	bits := "0000" + "001" + "0" + ptl + "11" + strings.Repeat("00", 7) + strings.Repeat("0", 88) + "01011010"
+	bits += ue(0) + ue(2) + ue(1920) + ue(1080) + "1" + ue(0) + ue(0) + ue(0) + ue(4) + ue(2) + ue(4)
+	s, err = ParseSPS(spsNalu(bits))
+	at.Nil(err)
+	at.Equal(2, s.MaxSubLayers)
+	at.False(s.TemporalIDNesting)
+	at.Equal(byte(0x04), s.ProfileTierLevel[0])
+	at.Equal(byte(93), s.ProfileTierLevel[11])
+	at.Equal(2, s.ChromaFormatIdc)
+	at.Equal(10, s.BitDepthLuma)
+	at.Equal(12, s.BitDepthChroma)
+
+	// 不完整的SPS, 不是SPS
+	_, err = ParseSPS(sps[:20])
+	at.NotNil(err)
+	_, err = ParseSPS(pps)
+	at.NotNil(err)
+}
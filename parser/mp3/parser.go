@@ -4,15 +4,26 @@ import (
 	"errors"
 )
 
+// MPEG版本(帧头中的version_id)
+const (
+	mpeg25 = 0
+	mpeg2  = 2
+	mpeg1  = 3
+)
+
 // Parser mp3解析器
 type Parser struct {
 	samplingFrequency int
+	version           byte
+	layer             byte
 }
 
 // NewParser mp3解析器
 func NewParser() *Parser {
 	return &Parser{
 		samplingFrequency: 44100,
+		version:           mpeg1,
+		layer:             3,
 	}
 }
 
@@ -21,6 +32,7 @@ func NewParser() *Parser {
 // '01' 48 kHz
 // '10' 32 kHz
 // '11' reserved
+// MPEG-2的采样率减半, MPEG-2.5的采样率为MPEG-1的四分之一
 var mp3Rates = []int{44100, 48000, 32000}
 
 // Parse 解析mp3数据
@@ -29,6 +41,29 @@ func (p *Parser) Parse(src []byte) error {
 		return errors.New("incomplete mp3 data, len(src)<3")
 	}
 
+	// 以帧同步(11位)开始时解析完整的帧头: version_id(2), layer(2), protection_bit(1),
+	// bitrate_index(4), sampling_frequency(2), padding_bit(1), private_bit(1)
+	if src[0] == 0xff && src[1]&0xe0 == 0xe0 {
+		version := (src[1] >> 3) & 0x3
+		layer := 4 - (src[1]>>1)&0x3
+		index := (src[2] >> 2) & 0x3
+		if version == 1 || layer == 4 || int(index) >= len(mp3Rates) {
+			return errors.New("invalid mp3 frame header")
+		}
+
+		p.version = version
+		p.layer = layer
+		p.samplingFrequency = mp3Rates[index]
+		switch version {
+		case mpeg2:
+			p.samplingFrequency /= 2
+		case mpeg25:
+			p.samplingFrequency /= 4
+		}
+
+		return nil
+	}
+
 	// 提取出采样率
 	index := (src[2] >> 1) & 0x3
 	if int(index) < len(mp3Rates) {
@@ -43,3 +78,20 @@ func (p *Parser) Parse(src []byte) error {
 func (p *Parser) SampleRate() int {
 	return p.samplingFrequency
 }
+
+// IsMPEG1 是否是MPEG-1音频(否则为MPEG-2或者MPEG-2.5的低采样率扩展)
+func (p *Parser) IsMPEG1() bool {
+	return p.version == mpeg1
+}
+
+// FrameSamples 每帧的采样数: Layer I为384, Layer II为1152, Layer III在MPEG-1中为1152, 其它为576
+func (p *Parser) FrameSamples() int {
+	switch {
+	case p.layer == 1:
+		return 384
+	case p.layer == 3 && p.version != mpeg1:
+		return 576
+	}
+
+	return 1152
+}
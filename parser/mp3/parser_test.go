@@ -14,3 +14,23 @@ func TestParser_SampleRate(t *testing.T) {
 
 	at.Equal(32000, p.SampleRate())
 }
+
+func TestParser_FrameHeader(t *testing.T) {
+	at := assert.New(t)
+
+	// MPEG-1 Layer III, 128kbps, 44.1kHz
+	p := NewParser()
+	at.Nil(p.Parse([]byte{0xff, 0xfb, 0x90, 0x64}))
+	at.Equal(44100, p.SampleRate())
+	at.True(p.IsMPEG1())
+	at.Equal(1152, p.FrameSamples())
+
+	// MPEG-2 Layer III, 24kHz
+	at.Nil(p.Parse([]byte{0xff, 0xf3, 0x84, 0xc4}))
+	at.Equal(24000, p.SampleRate())
+	at.False(p.IsMPEG1())
+	at.Equal(576, p.FrameSamples())
+
+	// 保留的版本号
+	at.NotNil(p.Parse([]byte{0xff, 0xeb, 0x90, 0x64}))
+}
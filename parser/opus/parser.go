@@ -0,0 +1,115 @@
+package opus
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	sampleRate     = 48000
+	opusHeadLen    = 19 // OpusHead的最小长度
+	opusHeadMagic  = "OpusHead"
+	defaultChannel = 2
+)
+
+// Parser Opus解析器, 将Opus数据包封装为TS中的访问单元(opus_control_header + 数据包)
+type Parser struct {
+	channels int
+	samples  int
+}
+
+// NewParser Opus解析器
+func NewParser() *Parser {
+	return &Parser{
+		channels: defaultChannel,
+		samples:  960,
+	}
+}
+
+// Parse isSeqHdr为true时解析OpusHead(只记录声道数), 否则在数据包前加上 opus_control_header 后写入w中
+func (p *Parser) Parse(src []byte, isSeqHdr bool, w io.Writer) error {
+	if len(src) == 0 || w == nil {
+		return errors.New("no data to parse or nil writer")
+	}
+
+	if isSeqHdr {
+		return p.parseOpusHead(src)
+	}
+
+	p.samples = packetSamples(src)
+
+	// control_header_prefix(11): 0x3ff, start_trim_flag(1), end_trim_flag(1), control_extension_flag(1), reserved(2)
+	hdr := []byte{0x7f, 0xe0}
+
+	// au_size: 每个0xff表示255字节, 最后一个字节小于255
+	n := len(src)
+	for ; n >= 0xff; n -= 0xff {
+		hdr = append(hdr, 0xff)
+	}
+	hdr = append(hdr, byte(n))
+
+	_, err := w.Write(hdr)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(src)
+	return err
+}
+
+// parseOpusHead 解析OpusHead: magic(8), version(1), channel_count(1), pre_skip(2), 采样率(4), 增益(2), mapping_family(1)
+func (p *Parser) parseOpusHead(src []byte) error {
+	if len(src) < opusHeadLen || string(src[:8]) != opusHeadMagic {
+		return errors.New("invalid opus head")
+	}
+
+	p.channels = int(src[9])
+	return nil
+}
+
+// packetSamples 根据TOC计算数据包的采样数(48kHz)
+func packetSamples(src []byte) int {
+	toc := src[0]
+
+	// 每帧的采样数(48kHz)
+	var size int
+	switch config := toc >> 3; {
+	case config < 12:
+		// SILK: 10, 20, 40, 60ms
+		size = []int{480, 960, 1920, 2880}[config&0x3]
+	case config < 16:
+		// Hybrid: 10, 20ms
+		size = []int{480, 960}[config&0x1]
+	default:
+		// CELT: 2.5, 5, 10, 20ms
+		size = []int{120, 240, 480, 960}[config&0x3]
+	}
+
+	// 帧数
+	switch toc & 0x3 {
+	case 0:
+		return size
+	case 1, 2:
+		return 2 * size
+	}
+
+	if len(src) < 2 {
+		return size
+	}
+	return int(src[1]&0x3f) * size
+}
+
+// SampleRate Opus的采样率固定为48kHz
+func (p *Parser) SampleRate() int {
+	return sampleRate
+}
+
+// Channels 声道数
+func (p *Parser) Channels() int {
+	return p.channels
+}
+
+// FrameSamples 最近一个数据包的采样数
+func (p *Parser) FrameSamples() int {
+	return p.samples
+}
@@ -0,0 +1,41 @@
+package opus
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParser_Parse(t *testing.T) {
+	at := assert.New(t)
+
+	p := NewParser()
+	w := bytes.NewBuffer(nil)
+
+	// OpusHead: 单声道
+	head := []byte{'O', 'p', 'u', 's', 'H', 'e', 'a', 'd', 0x01, 0x01, 0x38, 0x01, 0x80, 0xbb, 0x00, 0x00, 0x00, 0x00, 0x00}
+	at.Nil(p.Parse(head, true, w))
+	at.Equal(1, p.Channels())
+	at.Equal(0, w.Len())
+	at.NotNil(p.Parse(head[:8], true, w))
+
+	// 数据包长度大于255时, au_size使用多个字节
+	pkt := make([]byte, 300)
+	pkt[0] = 0x78 // SILK 20ms
+	at.Nil(p.Parse(pkt, false, w))
+	at.Equal([]byte{0x7f, 0xe0, 0xff, 0x2d}, w.Bytes()[:4])
+	at.Equal(pkt, w.Bytes()[4:])
+	at.Equal(48000, p.SampleRate())
+}
+
+func TestPacketSamples(t *testing.T) {
+	at := assert.New(t)
+
+	// CELT 20ms, 1帧
+	at.Equal(960, packetSamples([]byte{0xfc}))
+	// CELT 2.5ms, 2帧
+	at.Equal(240, packetSamples([]byte{0x81}))
+	// SILK 60ms, 3帧
+	at.Equal(8640, packetSamples([]byte{0x1b, 0x03}))
+}
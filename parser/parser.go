@@ -7,15 +7,40 @@ import (
 
 	"github.com/nextpkg/goav/packet"
 	"github.com/nextpkg/goav/parser/aac"
+	"github.com/nextpkg/goav/parser/ac3"
 	"github.com/nextpkg/goav/parser/h264"
+	"github.com/nextpkg/goav/parser/h265"
 	"github.com/nextpkg/goav/parser/mp3"
+	"github.com/nextpkg/goav/parser/opus"
+	"github.com/nextpkg/goav/parser/raw"
 )
 
+// 没有帧长信息的音频每帧按1024个采样计算
+const defaultFrameSamples = 1024
+
+// SampleEncrypter SAMPLE-AES加密, 返回加密后的数据, 不修改输入
+type SampleEncrypter interface {
+	EncryptNalu(nalu []byte) []byte // H264的NALU, 不含start code
+	EncryptAAC(frame []byte) []byte // AAC帧, 不含ADTS头
+}
+
 // CodecParser 解析器
 type CodecParser struct {
 	aac  *aac.Parser
 	mp3  *mp3.Parser
+	raw  *raw.Parser
+	opus *opus.Parser
+	ac3  *ac3.Parser
 	h264 *h264.Parser
+	h265 *h265.Parser
+
+	// 最近一次解析的音频解析器
+	audio interface {
+		SampleRate() int
+	}
+
+	// SAMPLE-AES加密, 只用于H264和AAC
+	sample SampleEncrypter
 }
 
 // NewCodecParser [音频/视频]新建解析器
@@ -23,6 +48,37 @@ func NewCodecParser() *CodecParser {
 	return &CodecParser{}
 }
 
+// SetSampleEncrypter 设置SAMPLE-AES加密(H264和AAC), 为nil时不加密
+func (c *CodecParser) SetSampleEncrypter(e SampleEncrypter) {
+	c.sample = e
+	if c.h264 != nil {
+		c.setH264Encrypter()
+	}
+	if c.aac != nil {
+		c.setAACEncrypter()
+	}
+}
+
+// setH264Encrypter 设置H264解析器的加密函数
+func (c *CodecParser) setH264Encrypter() {
+	if c.sample == nil {
+		c.h264.SetEncrypter(nil)
+		return
+	}
+
+	c.h264.SetEncrypter(c.sample.EncryptNalu)
+}
+
+// setAACEncrypter 设置AAC解析器的加密函数
+func (c *CodecParser) setAACEncrypter() {
+	if c.sample == nil {
+		c.aac.SetEncrypter(nil)
+		return
+	}
+
+	c.aac.SetEncrypter(c.sample.EncryptAAC)
+}
+
 // Parse [音频/视频]解码（转换flv中的媒体流的格式）
 func (c *CodecParser) Parse(p *packet.Packet, w io.Writer) error {
 	if p.Header == nil || p.Media == nil {
@@ -38,13 +94,27 @@ func (c *CodecParser) Parse(p *packet.Packet, w io.Writer) error {
 			// 初始化一个h264解析器
 			if c.h264 == nil {
 				c.h264 = h264.NewParser()
+				c.setH264Encrypter()
 			}
 
 			// 将H264打包格式转换为 Annex-b 的网络流格式, 写入w中
 			return c.h264.Parse(p.Media, vh.IsSeqHdr(), w)
 		}
 
+		if vh.IsCodecHevc() {
+			// 初始化一个h265解析器
+			if c.h265 == nil {
+				c.h265 = h265.NewParser()
+			}
+
+			// 将H265打包格式转换为 Annex-b 的网络流格式, 写入w中
+			return c.h265.Parse(p.Media, vh.IsSeqHdr(), w)
+		}
+
 		// 默认返回错误
+		if vh.IsExHeader() {
+			return fmt.Errorf("unexpected video fourcc: %#x", vh.FourCC())
+		}
 		return fmt.Errorf("unexpected video codec number: %d", vh.CodecID())
 	case packet.PktAudio:
 		// 根据音频编码器做不同处理
@@ -52,7 +122,9 @@ func (c *CodecParser) Parse(p *packet.Packet, w io.Writer) error {
 		if ah.IsSoundAAC() {
 			if c.aac == nil {
 				c.aac = aac.NewParser()
+				c.setAACEncrypter()
 			}
+			c.audio = c.aac
 
 			return c.aac.Parse(p.Media, ah.AACType(), w)
 		}
@@ -60,8 +132,54 @@ func (c *CodecParser) Parse(p *packet.Packet, w io.Writer) error {
 			if c.mp3 == nil {
 				c.mp3 = mp3.NewParser()
 			}
+			c.audio = c.mp3
+
+			// MP3帧自带帧头, 解析采样率后原样写入
+			err := c.mp3.Parse(p.Media)
+			if err != nil {
+				return err
+			}
+
+			_, err = w.Write(p.Media)
+			return err
+		}
+		if ah.IsSoundPassthrough() {
+			if c.raw == nil {
+				c.raw = raw.NewParser()
+			}
+			c.audio = c.raw
+
+			// G.711每个声道的采样为1个字节, 可以由数据长度得到帧长
+			sampleBytes := 0
+			if ah.IsSoundG711ALaw() || ah.IsSoundG711MuLaw() {
+				sampleBytes = ah.Channels()
+			}
+			return c.raw.Parse(p.Media, ah.SampleRate(), sampleBytes, w)
+		}
+
+		if ah.IsSoundOpus() {
+			if c.opus == nil {
+				c.opus = opus.NewParser()
+			}
+			c.audio = c.opus
 
-			return c.mp3.Parse(p.Media)
+			return c.opus.Parse(p.Media, ah.IsSoundSeqHdr(), w)
+		}
+		if ah.IsSoundAC3() || ah.IsSoundEAC3() {
+			if c.ac3 == nil {
+				c.ac3 = ac3.NewParser()
+			}
+			c.audio = c.ac3
+
+			// 同步帧可以直接写入, 序列头不需要输出
+			if ah.IsSoundSeqHdr() {
+				return nil
+			}
+			return c.ac3.Parse(p.Media, w)
+		}
+
+		if ah.IsExHeader() {
+			return fmt.Errorf("unexpected audio fourcc: %#x", ah.FourCC())
 		}
 
 		// 默认返回错误
@@ -74,13 +192,22 @@ func (c *CodecParser) Parse(p *packet.Packet, w io.Writer) error {
 
 // SampleRate [音频]采样率
 func (c *CodecParser) SampleRate() (int, error) {
-	if c.aac == nil && c.mp3 == nil {
-		return 0, errors.New("unexpected audio codec, support aac or mp3 only")
+	if c.audio == nil {
+		return 0, errors.New("unexpected audio codec, support aac, mp3, opus, ac-3 or raw audio only")
 	}
 
-	if c.aac != nil {
-		return c.aac.SampleRate(), nil
+	return c.audio.SampleRate(), nil
+}
+
+// FrameSamples [音频]最近一帧的采样数, AAC和G.711以外的原始音频按1024计算
+func (c *CodecParser) FrameSamples() (int, error) {
+	if c.audio == nil {
+		return 0, errors.New("unexpected audio codec, support aac, mp3, opus, ac-3 or raw audio only")
+	}
+
+	if v, ok := c.audio.(interface{ FrameSamples() int }); ok {
+		return v.FrameSamples(), nil
 	}
 
-	return c.mp3.SampleRate(), nil
+	return defaultFrameSamples, nil
 }
@@ -157,3 +157,65 @@ func TestDemuxer_Demux(t *testing.T) {
 	at.Nil(err)
 	at.Equal(44100, n)
 }
+
+func TestCodecParser_Passthrough(t *testing.T) {
+	at := assert.New(t)
+	d := flv.NewDemuxer()
+	parse := NewCodecParser()
+	buffer := bytes.NewBuffer(nil)
+
+	// G.711 A-law, 8kHz, 16bit, mono
+	p := packet.Packet{
+		Type: packet.PktAudio,
+		Data: []byte{
+			0x72, 0xd5, 0xd5, 0x55, 0x54,
+		},
+	}
+
+	at.Nil(d.Demux(&p))
+	at.Nil(parse.Parse(&p, buffer))
+	at.Equal([]byte{0xd5, 0xd5, 0x55, 0x54}, buffer.Bytes())
+
+	n, err := parse.SampleRate()
+	at.Nil(err)
+	at.Equal(8000, n)
+}
+
+func TestCodecParser_ExAudio(t *testing.T) {
+	at := assert.New(t)
+	d := flv.NewDemuxer()
+	parse := NewCodecParser()
+	buffer := bytes.NewBuffer(nil)
+
+	// MP3帧原样写入
+	p := packet.Packet{Type: packet.PktAudio, Data: []byte{0x2f, 0xff, 0xfb, 0x90, 0x64, 0x01}}
+	at.Nil(d.Demux(&p))
+	at.Nil(parse.Parse(&p, buffer))
+	at.Equal([]byte{0xff, 0xfb, 0x90, 0x64, 0x01}, buffer.Bytes())
+
+	n, err := parse.FrameSamples()
+	at.Nil(err)
+	at.Equal(1152, n)
+
+	// Opus: 加上 opus_control_header
+	buffer.Reset()
+	p = packet.Packet{Type: packet.PktAudio, Data: []byte{flv.SoundExHeader<<4 | flv.ExAudioCodedFrames, 'O', 'p', 'u', 's', 0xfc, 0x01}}
+	at.Nil(d.Demux(&p))
+	at.Nil(parse.Parse(&p, buffer))
+	at.Equal([]byte{0x7f, 0xe0, 0x02, 0xfc, 0x01}, buffer.Bytes())
+
+	n, err = parse.SampleRate()
+	at.Nil(err)
+	at.Equal(48000, n)
+
+	// E-AC-3: 48kHz, 6个音频块
+	buffer.Reset()
+	p = packet.Packet{Type: packet.PktAudio, Data: []byte{flv.SoundExHeader<<4 | flv.ExAudioCodedFrames, 'e', 'c', '-', '3', 0x0b, 0x77, 0x00, 0x00, 0x3f, 0x80}}
+	at.Nil(d.Demux(&p))
+	at.Nil(parse.Parse(&p, buffer))
+	at.Equal([]byte{0x0b, 0x77, 0x00, 0x00, 0x3f, 0x80}, buffer.Bytes())
+
+	n, err = parse.FrameSamples()
+	at.Nil(err)
+	at.Equal(1536, n)
+}
@@ -0,0 +1,57 @@
+package raw
+
+import (
+	"errors"
+	"io"
+)
+
+// 没有帧长信息的原始音频每帧按1024个采样计算
+const defaultFrameSamples = 1024
+
+// Parser 原始音频解析器(PCM, ADPCM, G.711, Speex, Nellymoser), 数据不需要转换, 直接透传
+type Parser struct {
+	sampleRate   int
+	frameSamples int
+}
+
+// NewParser 原始音频解析器
+func NewParser() *Parser {
+	return &Parser{
+		sampleRate:   8000,
+		frameSamples: defaultFrameSamples,
+	}
+}
+
+// Parse 记录采样率和帧长, 并将音频数据原样写入w中
+// sampleBytes为每个采样(所有声道)的字节数, 例如G.711为声道数; 为0时帧长按1024个采样计算
+func (p *Parser) Parse(src []byte, sampleRate, sampleBytes int, w io.Writer) error {
+	if len(src) == 0 || w == nil {
+		return errors.New("no data to parse or nil writer")
+	}
+
+	if sampleRate > 0 {
+		p.sampleRate = sampleRate
+	}
+
+	p.frameSamples = defaultFrameSamples
+	if sampleBytes > 0 {
+		p.frameSamples = len(src) / sampleBytes
+	}
+
+	_, err := w.Write(src)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SampleRate 原始音频采样率
+func (p *Parser) SampleRate() int {
+	return p.sampleRate
+}
+
+// FrameSamples 最近一帧的采样数
+func (p *Parser) FrameSamples() int {
+	return p.frameSamples
+}
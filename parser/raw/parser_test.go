@@ -0,0 +1,24 @@
+package raw
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParser_Parse(t *testing.T) {
+	at := assert.New(t)
+
+	p := NewParser()
+	w := bytes.NewBuffer(nil)
+	at.NotNil(p.Parse(nil, 8000, 0, w))
+	at.Nil(p.Parse([]byte{0xd5, 0xd5, 0x55}, 16000, 0, w))
+	at.Equal([]byte{0xd5, 0xd5, 0x55}, w.Bytes())
+	at.Equal(16000, p.SampleRate())
+	at.Equal(1024, p.FrameSamples())
+
+	// G.711立体声, 每个采样2个字节
+	at.Nil(p.Parse(make([]byte, 320), 8000, 2, w))
+	at.Equal(160, p.FrameSamples())
+}
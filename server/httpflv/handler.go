@@ -0,0 +1,345 @@
+// Package httpflv 基于 gop.Cache 和 flv.Mixer 的 HTTP-FLV 直播分发
+package httpflv
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/gop"
+	"github.com/nextpkg/goav/packet"
+)
+
+// 每个观众的默认发送队列长度和写超时
+const (
+	defaultQueueLen     = 1024
+	defaultWriteTimeout = 10 * time.Second
+)
+
+// subscriber 观众
+type subscriber struct {
+	queue  chan *packet.Packet
+	closed bool // 由Handler.mu保护
+}
+
+// collector 收集缓存中的数据包
+type collector struct {
+	packets []*packet.Packet
+}
+
+// Write 收集数据包
+func (c *collector) Write(p *packet.Packet) error {
+	c.packets = append(c.packets, p)
+	return nil
+}
+
+// Handler HTTP-FLV 直播处理器
+// 发布者通过 Write 写入已解复用的数据包(p.Header不能为空), 观众通过 ServeHTTP 拉流
+type Handler struct {
+	mu           sync.Mutex
+	cache        *gop.Cache
+	subs         map[*subscriber]struct{}
+	queueLen     int
+	writeTimeout time.Duration
+}
+
+// NewHandler HTTP-FLV 直播处理器, gopNum为缓存的GOP个数, queueLen为每个观众的发送队列长度
+func NewHandler(gopNum, queueLen int) *Handler {
+	if queueLen <= 0 {
+		queueLen = defaultQueueLen
+	}
+
+	return &Handler{
+		cache:        gop.NewCache(gopNum),
+		subs:         make(map[*subscriber]struct{}),
+		queueLen:     queueLen,
+		writeTimeout: defaultWriteTimeout,
+	}
+}
+
+// SetWriteTimeout 设置每次向观众写入数据的超时, 超时的观众被断开; 小于等于0时不设置超时
+// 需要 http.ResponseWriter 支持 SetWriteDeadline(Go 1.20开始标准库的实现都支持)
+func (h *Handler) SetWriteTimeout(d time.Duration) {
+	h.writeTimeout = d
+}
+
+// Write 发布数据包: 写入GOP缓存并分发给所有观众
+// 观众的发送队列已满时直接断开该观众, 不会阻塞发布者;
+// 无法缓存的数据包(例如第一个关键帧之前的帧)只影响新观众的首屏, 不作为发布者的错误返回
+func (h *Handler) Write(p *packet.Packet) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subs {
+		select {
+		case s.queue <- p:
+		default:
+			// 慢速观众
+			h.remove(s)
+		}
+	}
+
+	_ = h.cache.Write(p)
+
+	return nil
+}
+
+// Close 结束发布, 断开所有观众
+func (h *Handler) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subs {
+		h.remove(s)
+	}
+}
+
+// Subscribers 当前观众数
+func (h *Handler) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subs)
+}
+
+// remove 移除观众, 需要持有h.mu
+func (h *Handler) remove(s *subscriber) {
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	close(s.queue)
+	delete(h.subs, s)
+}
+
+// subscribe 注册观众, 同时取出缓存的元数据, 序列头和GOP, 保证缓存与实时数据之间不丢包也不重复
+func (h *Handler) subscribe() (s *subscriber, md, video, audio, gops []*packet.Packet) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var mdC, videoC, audioC, gopC collector
+
+	// collector 不会返回错误
+	_ = h.cache.Metadata.SendTo(&mdC)
+	_ = h.cache.VideoSeqHdr.SendTo(&videoC)
+	_ = h.cache.AudioSeqHdr.SendTo(&audioC)
+	_ = h.cache.Gop.SendTo(&gopC)
+
+	s = &subscriber{
+		queue: make(chan *packet.Packet, h.queueLen),
+	}
+	h.subs[s] = struct{}{}
+
+	return s, mdC.packets, videoC.packets, audioC.packets, gopC.packets
+}
+
+// unsubscribe 注销观众
+func (h *Handler) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(s)
+}
+
+// ServeHTTP 向观众输出FLV流: FLV头, 元数据, 序列头, 缓存的GOP, 然后是实时数据
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s, md, video, audio, gops := h.subscribe()
+	defer h.unsubscribe(s)
+
+	w.Header().Set("Content-Type", "video/x-flv")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	v := newViewer(w)
+
+	h.setWriteDeadline(w)
+	err := v.start(md, video, audio, gops)
+	if err != nil {
+		return
+	}
+
+	for {
+		select {
+		case p, ok := <-s.queue:
+			if !ok {
+				// 慢速观众或者发布结束
+				return
+			}
+
+			h.setWriteDeadline(w)
+			err = v.write(p)
+			if err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeDeadliner 支持写超时的 http.ResponseWriter
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// setWriteDeadline 设置下一次写入的超时, 依次检查包装的 http.ResponseWriter, 都不支持时忽略
+func (h *Handler) setWriteDeadline(w http.ResponseWriter) {
+	if h.writeTimeout <= 0 {
+		return
+	}
+
+	for {
+		switch rw := w.(type) {
+		case writeDeadliner:
+			_ = rw.SetWriteDeadline(time.Now().Add(h.writeTimeout))
+			return
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return
+		}
+	}
+}
+
+// viewer 单个观众的FLV输出
+type viewer struct {
+	w       http.ResponseWriter
+	mixer   *flv.Mixer
+	norm    *flv.Normalizer // 时间戳规整, 发布端的32位时间戳会回绕
+	base    int64           // 时间戳基准, 使每个观众都从0开始
+	gotBase bool
+	gotKey  bool // 是否已输出视频关键帧
+	video   bool // 是否有视频
+}
+
+// newViewer 单个观众的FLV输出
+func newViewer(w http.ResponseWriter) *viewer {
+	return &viewer{
+		w:     w,
+		mixer: flv.NewMixer(w),
+		norm:  flv.NewNormalizer(),
+	}
+}
+
+// start 输出FLV头以及缓存的数据
+func (v *viewer) start(md, video, audio, gops []*packet.Packet) error {
+	for _, p := range md {
+		// 只有onMetaData写入FLV头, 其他以及无法解析的脚本数据随数据转发
+		s, err := flv.ParsePacketScript(p)
+		if err != nil {
+			continue
+		}
+
+		obj := s.Object()
+		if s.Name != flv.OnMetaData || obj == nil {
+			continue
+		}
+
+		err = v.mixer.SaveMetadata(obj)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range video {
+		v.video = true
+
+		err := v.mixer.SaveAVCHeader(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range audio {
+		err := v.mixer.SaveAACHeader(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	// FLV头, 元数据以及序列头
+	err := v.mixer.SetFlvHeader()
+	if err != nil {
+		return err
+	}
+
+	// 缓存的GOP
+	for _, p := range gops {
+		err = v.mux(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	v.flush()
+
+	return nil
+}
+
+// write 输出实时数据包
+func (v *viewer) write(p *packet.Packet) error {
+	err := v.mux(p)
+	if err != nil {
+		return err
+	}
+
+	v.flush()
+
+	return nil
+}
+
+// mux 重新计算时间戳后输出数据包; 有视频时, 在第一个关键帧之前的数据都丢弃
+func (v *viewer) mux(p *packet.Packet) error {
+	switch p.Type {
+	case packet.PktVideo:
+		v.video = true
+
+		vh, ok := p.Header.(packet.VideoPacketHeader)
+		if !ok {
+			return errors.New("video packet without header")
+		}
+
+		// 序列头的时间戳为0
+		if vh.IsSeqHdr() {
+			return v.mixer.Mux(p, 0)
+		}
+
+		if !v.gotKey {
+			if !vh.IsKeyFrame() {
+				return nil
+			}
+			v.gotKey = true
+		}
+	case packet.PktAudio:
+		if v.video && !v.gotKey {
+			return nil
+		}
+	case packet.PktMetadata:
+		// 复用时会修改p.Data, 使用副本避免影响其他观众
+		q := *p
+		return v.mixer.Mux(&q, 0)
+	}
+
+	dts := v.norm.Normalize(p).DTS
+	if !v.gotBase {
+		v.gotBase = true
+		v.base = dts
+	}
+
+	var pts uint32
+	if dts > v.base {
+		pts = uint32(dts - v.base)
+	}
+
+	return v.mixer.Mux(p, pts)
+}
+
+// flush 将数据推送给观众
+func (v *viewer) flush() {
+	if f, ok := v.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,128 @@
+package httpflv
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nextpkg/goav/container/flv"
+	"github.com/nextpkg/goav/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// newPacket 生成已解复用的数据包
+func newPacket(t *testing.T, mt int, ts uint32, data ...byte) *packet.Packet {
+	p := &packet.Packet{
+		Type:      mt,
+		TimeStamp: ts,
+		Data:      data,
+	}
+	assert.Nil(t, flv.NewDemuxer().Demux(p))
+
+	return p
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	at := assert.New(t)
+
+	h := NewHandler(1, 0)
+
+	// 第一个关键帧之前的帧无法缓存, 不返回给发布者
+	at.Nil(h.Write(newPacket(t, packet.PktVideo, 4960, 0x27, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x41)))
+
+	// 发布序列头和一个GOP
+	at.Nil(h.Write(newPacket(t, packet.PktVideo, 0, 0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x4d, 0x00, 0x1e)))
+	at.Nil(h.Write(newPacket(t, packet.PktAudio, 0, 0xaf, 0x00, 0x12, 0x10)))
+	at.Nil(h.Write(newPacket(t, packet.PktVideo, 5000, 0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x65)))
+	at.Nil(h.Write(newPacket(t, packet.PktVideo, 5040, 0x27, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x41)))
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	at.Nil(err)
+	defer resp.Body.Close()
+	at.Equal("video/x-flv", resp.Header.Get("Content-Type"))
+
+	r := flv.NewReader(resp.Body)
+
+	// 序列头
+	var p packet.Packet
+	at.Nil(r.Read(&p))
+	at.True(r.HasVideo())
+	at.True(r.HasAudio())
+	at.True(p.Header.(packet.VideoPacketHeader).IsSeqHdr())
+	at.Nil(r.Read(&p))
+	at.True(p.Header.(packet.AudioPacketHeader).IsSoundSeqHdr())
+
+	// 缓存的GOP, 时间戳从0开始
+	at.Nil(r.Read(&p))
+	at.True(p.Header.(packet.VideoPacketHeader).IsKeyFrame())
+	at.Equal(uint32(0), p.TimeStamp)
+	at.Nil(r.Read(&p))
+	at.Equal(uint32(40), p.TimeStamp)
+
+	// 实时数据
+	at.Nil(h.Write(newPacket(t, packet.PktAudio, 5060, 0xaf, 0x01, 0x21)))
+	at.Nil(r.Read(&p))
+	at.Equal(packet.PktAudio, p.Type)
+	at.Equal(uint32(60), p.TimeStamp)
+	at.Equal([]byte{0x21}, p.Media)
+
+	// 发布结束
+	h.Close()
+	at.NotNil(r.Read(&p))
+}
+
+func TestHandler_SlowSubscriber(t *testing.T) {
+	at := assert.New(t)
+
+	h := NewHandler(1, 2)
+	s, _, _, _, _ := h.subscribe()
+	at.Equal(1, h.Subscribers())
+
+	// 观众不读取数据, 队列满后被断开, 发布者不会阻塞
+	for i := 0; i < 3; i++ {
+		at.Nil(h.Write(newPacket(t, packet.PktAudio, uint32(i*20), 0xaf, 0x01, 0x21)))
+	}
+	at.Equal(0, h.Subscribers())
+
+	n := 0
+	for range s.queue {
+		n++
+	}
+	at.Equal(2, n)
+}
+
+func TestHandler_WriteTimeout(t *testing.T) {
+	at := assert.New(t)
+
+	// 发送队列足够长, 观众只能因为写超时被断开
+	h := NewHandler(1, 1<<20)
+	h.SetWriteTimeout(100 * time.Millisecond)
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	// 观众发出请求后不再读取
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	at.Nil(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n"))
+	at.Nil(err)
+
+	for i := 0; i < 100 && h.Subscribers() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	at.Equal(1, h.Subscribers())
+
+	frame := append([]byte{0x17, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x65}, make([]byte, 1<<16)...)
+	deadline := time.Now().Add(5 * time.Second)
+	for ts := uint32(0); h.Subscribers() > 0 && time.Now().Before(deadline); ts += 40 {
+		at.Nil(h.Write(newPacket(t, packet.PktVideo, ts, frame...)))
+		time.Sleep(time.Millisecond)
+	}
+	at.Equal(0, h.Subscribers())
+}